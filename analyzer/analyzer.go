@@ -17,7 +17,7 @@ package analyzer
 import (
 	"fmt"
 	"golem/ast"
-	"sort"
+	g "golem/core"
 )
 
 type Analyzer interface {
@@ -25,28 +25,63 @@ type Analyzer interface {
 	Module() *ast.FnExpr
 	Analyze() []error
 	scope() *scope
+	topLevel() map[string]*ast.Variable
 }
 
 type analyzer struct {
 	mod       *ast.FnExpr
+	builtins  g.BuiltinManager
 	rootScope *scope
+	topScope  *scope
 	curScope  *scope
 	loops     []ast.Loop
 	structs   []*ast.StructExpr
 	errors    []error
 }
 
-func NewAnalyzer(mod *ast.FnExpr) Analyzer {
+func NewAnalyzer(mod *ast.FnExpr, builtins g.BuiltinManager) Analyzer {
 
 	rootScope := newFuncScope(nil)
 
-	return &analyzer{mod, rootScope, rootScope, []ast.Loop{}, []*ast.StructExpr{}, nil}
+	return &analyzer{mod, builtins, rootScope, nil, rootScope, []ast.Loop{}, []*ast.StructExpr{}, nil}
+}
+
+// NewSessionAnalyzer creates an Analyzer for a module that continues on
+// from a module that has already been analyzed, the way that each input to
+// a REPL does.  The module can refer to every top-level variable that is
+// visible at the end of the previous module, and its own local variables
+// are numbered after the previous module's.
+func NewSessionAnalyzer(mod *ast.FnExpr, builtins g.BuiltinManager, prev Analyzer) Analyzer {
+
+	rootScope := newFuncScope(nil)
+	for sym, v := range prev.topLevel() {
+		rootScope.defs[sym] = v
+	}
+	rootScope.funcScope.numLocals = prev.Module().NumLocals
+	rootScope.funcScope.localNames = append([]string{}, prev.Module().LocalNames...)
+
+	return &analyzer{mod, builtins, rootScope, nil, rootScope, []ast.Loop{}, []*ast.StructExpr{}, nil}
 }
 
 func (a *analyzer) scope() *scope {
 	return a.rootScope
 }
 
+// the variables that are visible at the end of the module
+func (a *analyzer) topLevel() map[string]*ast.Variable {
+
+	defs := make(map[string]*ast.Variable)
+	for sym, v := range a.rootScope.defs {
+		defs[sym] = v
+	}
+	if a.topScope != nil {
+		for sym, v := range a.topScope.defs {
+			defs[sym] = v
+		}
+	}
+	return defs
+}
+
 func (a *analyzer) Analyze() []error {
 
 	// visit module block
@@ -55,6 +90,7 @@ func (a *analyzer) Analyze() []error {
 	// save NumLocals
 	fscope := a.curScope.funcScope
 	a.mod.NumLocals = fscope.numLocals
+	a.mod.LocalNames = fscope.localNames
 
 	// sanity check for captures
 	if len(fscope.captures) > 0 {
@@ -62,6 +98,7 @@ func (a *analyzer) Analyze() []error {
 	}
 	a.mod.NumCaptures = 0
 	a.mod.ParentCaptures = nil
+	a.mod.CaptureNames = nil
 
 	// done
 	return a.errors
@@ -86,6 +123,9 @@ func (a *analyzer) Visit(node ast.Node) {
 	case *ast.Let:
 		a.visitDecls(t.Decls, false)
 
+	case *ast.Import:
+		a.defineIdent(t.Ident, true)
+
 	case *ast.Assignment:
 		a.visitAssignment(t)
 
@@ -110,12 +150,12 @@ func (a *analyzer) Visit(node ast.Node) {
 
 	case *ast.Break:
 		if len(a.loops) == 0 {
-			a.errors = append(a.errors, &aerror{"'break' outside of loop"})
+			a.addError(BREAK_OUTSIDE_LOOP, "", t)
 		}
 
 	case *ast.Continue:
 		if len(a.loops) == 0 {
-			a.errors = append(a.errors, &aerror{"'continue' outside of loop"})
+			a.addError(CONTINUE_OUTSIDE_LOOP, "", t)
 		}
 
 	case *ast.StructExpr:
@@ -157,16 +197,30 @@ func (a *analyzer) visitTry(t *ast.Try) {
 func (a *analyzer) defineIdent(ident *ast.IdentExpr, isConst bool) {
 	sym := ident.Symbol.Text
 	if _, ok := a.curScope.get(sym); ok {
-		a.errors = append(a.errors,
-			&aerror{fmt.Sprintf("Symbol '%s' is already defined", sym)})
+		a.addError(ALREADY_DEFINED, sym, ident)
 	} else {
 		ident.Variable = a.curScope.put(sym, isConst)
 	}
 }
 
+// Params may shadow variables in the enclosing scopes,
+// but not each other.
+func (a *analyzer) defineParam(ident *ast.IdentExpr) {
+	sym := ident.Symbol.Text
+	if v, ok := a.curScope.defs[sym]; ok {
+		a.addError(ALREADY_DEFINED, sym, ident)
+		ident.Variable = v
+	} else {
+		ident.Variable = a.curScope.put(sym, false)
+	}
+}
+
 func (a *analyzer) visitBlock(blk *ast.Block) {
 
 	a.curScope = newBlockScope(a.curScope)
+	if blk == a.mod.Body {
+		a.topScope = a.curScope
+	}
 
 	// visit named funcs identifiers
 	for _, n := range blk.Nodes {
@@ -213,9 +267,14 @@ func (a *analyzer) visitFunc(fn *ast.FnExpr) {
 	// push scope
 	a.curScope = newFuncScope(a.curScope)
 
-	// visit child nodes
-	for _, f := range fn.FormalParams {
-		f.Variable = a.curScope.put(f.Symbol.Text, false)
+	// visit child nodes.  The default values of the optional params
+	// are evaluated in the function's scope, so they can refer to the
+	// params that precede them.
+	for j, f := range fn.FormalParams {
+		if d := fn.Default(j); d != nil {
+			a.Visit(d)
+		}
+		a.defineParam(f)
 	}
 	a.visitBlock(fn.Body)
 
@@ -224,6 +283,8 @@ func (a *analyzer) visitFunc(fn *ast.FnExpr) {
 	fn.NumLocals = fscope.numLocals
 	fn.NumCaptures = len(fscope.captures)
 	fn.ParentCaptures = a.makeParentCaptures()
+	fn.LocalNames = fscope.localNames
+	fn.CaptureNames = a.makeCaptureNames()
 
 	// pop scope
 	a.curScope = a.curScope.parent
@@ -234,19 +295,21 @@ func (a *analyzer) makeParentCaptures() []*ast.Variable {
 	fscope := a.curScope.funcScope
 	pc := fscope.parentCaptures
 
-	// Sort the keys so that the list comes out the same every time.
-	keys := make([]string, len(pc))
-	i := 0
-	for k, _ := range pc {
-		keys[i] = k
-		i++
+	// The list is in the same order as the captures' indices, since
+	// that is the order in which the captures are pushed onto the function.
+	result := make([]*ast.Variable, len(pc))
+	for sym, v := range pc {
+		result[fscope.captures[sym].Index] = v
 	}
-	sort.Strings(keys)
+	return result
+}
 
-	// make an array out of the values
-	result := make([]*ast.Variable, 0, len(pc))
-	for _, k := range keys {
-		result = append(result, pc[k])
+func (a *analyzer) makeCaptureNames() []string {
+
+	captures := a.curScope.funcScope.captures
+	result := make([]string, len(captures))
+	for sym, v := range captures {
+		result[v.Index] = sym
 	}
 	return result
 }
@@ -297,13 +360,13 @@ func (a *analyzer) doVisitAssignIdent(ident *ast.IdentExpr) {
 	sym := ident.Symbol.Text
 	if v, ok := a.curScope.get(sym); ok {
 		if v.IsConst {
-			a.errors = append(a.errors,
-				&aerror{fmt.Sprintf("Symbol '%s' is constant", sym)})
+			a.addError(ASSIGN_TO_CONST, sym, ident)
 		}
 		ident.Variable = v
+	} else if a.builtins.Contains(sym) {
+		a.addError(ASSIGN_TO_CONST, sym, ident)
 	} else {
-		a.errors = append(a.errors,
-			&aerror{fmt.Sprintf("Symbol '%s' is not defined", sym)})
+		a.addError(NOT_DEFINED, sym, ident)
 	}
 }
 
@@ -313,9 +376,10 @@ func (a *analyzer) visitIdentExpr(ident *ast.IdentExpr) {
 
 	if v, ok := a.curScope.get(sym); ok {
 		ident.Variable = v
+	} else if a.builtins.Contains(sym) {
+		ident.Variable = &ast.Variable{a.builtins.IndexOf(sym), true, false, true, nil}
 	} else {
-		a.errors = append(a.errors,
-			&aerror{fmt.Sprintf("Symbol '%s' is not defined", sym)})
+		a.addError(NOT_DEFINED, sym, ident)
 	}
 }
 
@@ -333,19 +397,91 @@ func (a *analyzer) visitThisExpr(this *ast.ThisExpr) {
 
 	n := len(a.structs)
 	if n == 0 {
-		a.errors = append(a.errors, &aerror{"'this' outside of loop"})
+		a.addError(THIS_OUTSIDE_STRUCT, "", this)
 	} else {
 		this.Variable = a.curScope.this()
 	}
 }
 
+func (a *analyzer) addError(kind ErrorKind, symbol string, node ast.Node) {
+	a.errors = append(a.errors,
+		&Error{kind, symbol, node.Begin(), node.End()})
+}
+
 //--------------------------------------------------------------
-// aerror
+// Error
 
-type aerror struct {
-	msg string
+type ErrorKind int
+
+const (
+	NOT_DEFINED ErrorKind = iota
+	ALREADY_DEFINED
+	ASSIGN_TO_CONST
+	BREAK_OUTSIDE_LOOP
+	CONTINUE_OUTSIDE_LOOP
+	THIS_OUTSIDE_STRUCT
+)
+
+func (k ErrorKind) String() string {
+
+	switch k {
+
+	case NOT_DEFINED:
+		return "NotDefined"
+
+	case ALREADY_DEFINED:
+		return "AlreadyDefined"
+
+	case ASSIGN_TO_CONST:
+		return "AssignToConst"
+
+	case BREAK_OUTSIDE_LOOP:
+		return "BreakOutsideLoop"
+
+	case CONTINUE_OUTSIDE_LOOP:
+		return "ContinueOutsideLoop"
+
+	case THIS_OUTSIDE_STRUCT:
+		return "ThisOutsideStruct"
+
+	default:
+		panic("unreachable")
+	}
 }
 
-func (e *aerror) Error() string {
-	return e.msg
+// Error is a semantic error in a module.  Begin and End are the range of
+// the offending node.  Symbol is the name of the offending symbol, for the
+// kinds of error that have one.
+type Error struct {
+	Kind   ErrorKind
+	Symbol string
+	Begin  ast.Pos
+	End    ast.Pos
+}
+
+func (e *Error) Error() string {
+
+	switch e.Kind {
+
+	case NOT_DEFINED:
+		return fmt.Sprintf("Symbol '%s' is not defined", e.Symbol)
+
+	case ALREADY_DEFINED:
+		return fmt.Sprintf("Symbol '%s' is already defined", e.Symbol)
+
+	case ASSIGN_TO_CONST:
+		return fmt.Sprintf("Symbol '%s' is constant", e.Symbol)
+
+	case BREAK_OUTSIDE_LOOP:
+		return "'break' outside of loop"
+
+	case CONTINUE_OUTSIDE_LOOP:
+		return "'continue' outside of loop"
+
+	case THIS_OUTSIDE_STRUCT:
+		return "'this' outside of struct"
+
+	default:
+		panic("unreachable")
+	}
 }
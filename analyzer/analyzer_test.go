@@ -17,6 +17,7 @@ package analyzer
 import (
 	"fmt"
 	"golem/ast"
+	g "golem/core"
 	"golem/parser"
 	"golem/scanner"
 	"testing"
@@ -57,7 +58,7 @@ func newAnalyzer(source string) Analyzer {
 	if err != nil {
 		panic("analyzer_test: could not parse")
 	}
-	return NewAnalyzer(mod)
+	return NewAnalyzer(mod, g.NewBuiltinManager(g.StandardBuiltins))
 }
 
 func TestFlat(t *testing.T) {
@@ -91,6 +92,9 @@ FnExpr(numLocals:2 numCaptures:0 parentCaptures:[])
 
 	errors = newAnalyzer("a = a;").Analyze()
 	fail(t, errors, "[Symbol 'a' is not defined Symbol 'a' is not defined]")
+
+	errors = newAnalyzer("let f = fn(a, a) { return a; };").Analyze()
+	fail(t, errors, "[Symbol 'a' is already defined]")
 }
 
 func TestNested(t *testing.T) {
@@ -372,10 +376,65 @@ FnExpr(numLocals:2 numCaptures:0 parentCaptures:[])
 `)
 }
 
+func TestDefaults(t *testing.T) {
+
+	source := `
+let a = 1;
+let b = fn(x, y = x + a, rest...) {
+    return rest;
+};`
+
+	anl := newAnalyzer(source)
+	errors := anl.Analyze()
+
+	ok(t, anl, errors, `
+FnExpr(numLocals:2 numCaptures:0 parentCaptures:[])
+.   Block
+.   .   Let
+.   .   .   IdentExpr(a,(0,false,false))
+.   .   .   BasicExpr(INT,"1")
+.   .   Let
+.   .   .   IdentExpr(b,(1,false,false))
+.   .   .   FnExpr(numLocals:3 numCaptures:1 parentCaptures:[(0,false,false)])
+.   .   .   .   IdentExpr(x,(0,false,false))
+.   .   .   .   IdentExpr(y,(1,false,false))
+.   .   .   .   IdentExpr(rest,(2,false,false))
+.   .   .   .   BinaryExpr("+")
+.   .   .   .   .   IdentExpr(x,(0,false,false))
+.   .   .   .   .   IdentExpr(a,(0,false,true))
+.   .   .   .   Block
+.   .   .   .   .   Return
+.   .   .   .   .   .   IdentExpr(rest,(2,false,false))
+`)
+}
+
+func TestNamedParams(t *testing.T) {
+
+	source := `
+let a = 1;
+a(2, b: a, c: 3);`
+
+	anl := newAnalyzer(source)
+	errors := anl.Analyze()
+
+	ok(t, anl, errors, `
+FnExpr(numLocals:1 numCaptures:0 parentCaptures:[])
+.   Block
+.   .   Let
+.   .   .   IdentExpr(a,(0,false,false))
+.   .   .   BasicExpr(INT,"1")
+.   .   InvokeExpr([b, c])
+.   .   .   IdentExpr(a,(0,false,false))
+.   .   .   BasicExpr(INT,"2")
+.   .   .   IdentExpr(a,(0,false,false))
+.   .   .   BasicExpr(INT,"3")
+`)
+}
+
 func TestStruct(t *testing.T) {
 
 	errors := newAnalyzer("this;").Analyze()
-	fail(t, errors, "['this' outside of loop]")
+	fail(t, errors, "['this' outside of struct]")
 
 	source := `
 struct{ };
@@ -695,3 +754,149 @@ FnExpr(numLocals:2 numCaptures:0 parentCaptures:[])
 	errors = newAnalyzer("fn a() {} const a = 1;").Analyze()
 	fail(t, errors, "[Symbol 'a' is already defined]")
 }
+
+func TestBuiltin(t *testing.T) {
+
+	source := `
+let a = len;
+fn b(str) {
+    return print(str);
+}
+`
+	anl := newAnalyzer(source)
+	errors := anl.Analyze()
+
+	ok(t, anl, errors, `
+FnExpr(numLocals:2 numCaptures:0 parentCaptures:[])
+.   Block
+.   .   Let
+.   .   .   IdentExpr(a,(1,false,false))
+.   .   .   IdentExpr(len,(3,builtin))
+.   .   NamedFn
+.   .   .   IdentExpr(b,(0,true,false))
+.   .   .   FnExpr(numLocals:1 numCaptures:0 parentCaptures:[])
+.   .   .   .   IdentExpr(str,(0,false,false))
+.   .   .   .   Block
+.   .   .   .   .   Return
+.   .   .   .   .   .   InvokeExpr
+.   .   .   .   .   .   .   IdentExpr(print,(0,builtin))
+.   .   .   .   .   .   .   IdentExpr(str,(0,false,false))
+`)
+
+	errors = newAnalyzer("print = 1;").Analyze()
+	fail(t, errors, "[Symbol 'print' is constant]")
+
+	errors = newAnalyzer("printf(1);").Analyze()
+	fail(t, errors, "[Symbol 'printf' is not defined]")
+}
+
+func TestImport(t *testing.T) {
+
+	source := `
+import foo;
+import bar as baz;
+let a = foo.x + baz.y;
+`
+	anl := newAnalyzer(source)
+	errors := anl.Analyze()
+
+	ok(t, anl, errors, `
+FnExpr(numLocals:3 numCaptures:0 parentCaptures:[])
+.   Block
+.   .   Import(foo)
+.   .   .   IdentExpr(foo,(0,true,false))
+.   .   Import(bar)
+.   .   .   IdentExpr(baz,(1,true,false))
+.   .   Let
+.   .   .   IdentExpr(a,(2,false,false))
+.   .   .   BinaryExpr("+")
+.   .   .   .   FieldExpr(x)
+.   .   .   .   .   IdentExpr(foo,(0,true,false))
+.   .   .   .   FieldExpr(y)
+.   .   .   .   .   IdentExpr(baz,(1,true,false))
+`)
+
+	errors = newAnalyzer("import foo; foo = 1;").Analyze()
+	fail(t, errors, "[Symbol 'foo' is constant]")
+
+	errors = newAnalyzer("let foo = 1; import foo;").Analyze()
+	fail(t, errors, "[Symbol 'foo' is already defined]")
+}
+
+func TestErrors(t *testing.T) {
+
+	errors := newAnalyzer(`
+let abc = 1;
+const abc = 2;
+xyz = 3;
+const k = 4;
+k++;
+break;
+`).Analyze()
+
+	expect := []*Error{
+		{ALREADY_DEFINED, "abc", ast.Pos{3, 7}, ast.Pos{3, 9}},
+		{NOT_DEFINED, "xyz", ast.Pos{4, 1}, ast.Pos{4, 3}},
+		{ASSIGN_TO_CONST, "k", ast.Pos{6, 1}, ast.Pos{6, 1}},
+		{BREAK_OUTSIDE_LOOP, "", ast.Pos{7, 1}, ast.Pos{7, 6}}}
+
+	if len(errors) != len(expect) {
+		t.Fatal(errors)
+	}
+	for i, e := range errors {
+		if *e.(*Error) != *expect[i] {
+			t.Error(e, " != ", expect[i])
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+
+	builtins := g.NewBuiltinManager(g.StandardBuiltins)
+	prev := newAnalyzer("let a = 1; if a { let b = 2; } fn f() { return a; }")
+	errors := prev.Analyze()
+	if len(errors) != 0 {
+		t.Fatal(errors)
+	}
+
+	mod, err := parser.NewParser(scanner.NewScanner("const c = a; f = 3; b;")).ParseModule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	anl := NewSessionAnalyzer(mod, builtins, prev)
+	errors = anl.Analyze()
+	fail(t, errors, "[Symbol 'f' is constant Symbol 'b' is not defined]")
+
+	mod, err = parser.NewParser(scanner.NewScanner("const c = a; fn() { return f; };")).ParseModule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	anl = NewSessionAnalyzer(mod, builtins, prev)
+	errors = anl.Analyze()
+	ok(t, anl, errors, `
+FnExpr(numLocals:4 numCaptures:0 parentCaptures:[])
+.   Block
+.   .   Const
+.   .   .   IdentExpr(c,(3,true,false))
+.   .   .   IdentExpr(a,(1,false,false))
+.   .   FnExpr(numLocals:0 numCaptures:1 parentCaptures:[(0,true,false)])
+.   .   .   Block
+.   .   .   .   Return
+.   .   .   .   .   IdentExpr(f,(0,true,true))
+`)
+
+	// the session can continue on from there
+	mod, err = parser.NewParser(scanner.NewScanner("c + a;")).ParseModule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := NewSessionAnalyzer(mod, builtins, anl)
+	errors = next.Analyze()
+	ok(t, next, errors, `
+FnExpr(numLocals:4 numCaptures:0 parentCaptures:[])
+.   Block
+.   .   BinaryExpr("+")
+.   .   .   IdentExpr(c,(3,true,false))
+.   .   .   IdentExpr(a,(1,false,false))
+`)
+}
@@ -50,6 +50,7 @@ type scope struct {
 
 type funcScope struct {
 	numLocals      int
+	localNames     []string
 	captures       map[string]*ast.Variable
 	parentCaptures map[string]*ast.Variable
 }
@@ -63,6 +64,7 @@ func newFuncScope(parent *scope) *scope {
 		parent, funcType,
 		&funcScope{
 			0,
+			[]string{},
 			make(map[string]*ast.Variable),
 			make(map[string]*ast.Variable)},
 		nil)
@@ -142,7 +144,7 @@ func (s *scope) put(sym string, isConst bool) *ast.Variable {
 	if ok {
 		panic("symbol is already defined")
 	}
-	v := &ast.Variable{incrementNumLocals(s), isConst, false}
+	v := &ast.Variable{incrementNumLocals(s, sym), isConst, false, false, nil}
 	s.defs[sym] = v
 	return v
 }
@@ -159,8 +161,8 @@ func (s *scope) this() *ast.Variable {
 	// define a 'this' variable on the structScope, if its not already defined
 	v, ok := os.defs["this"]
 	if !ok {
-		idx := incrementNumLocals(os)
-		v = &ast.Variable{idx, true, false}
+		idx := incrementNumLocals(os, "this")
+		v = &ast.Variable{idx, true, false, false, nil}
 		os.defs["this"] = v
 		os.structScope.stc.LocalThisIndex = idx
 	}
@@ -175,13 +177,15 @@ func (s *scope) this() *ast.Variable {
 	return v
 }
 
-// Increment the number of local variables in the nearest parent funcScope.
-func incrementNumLocals(s *scope) int {
+// Increment the number of local variables in the nearest parent funcScope,
+// and record the name of the new variable.
+func incrementNumLocals(s *scope, sym string) int {
 
 	for {
 		if s.scopeType == funcType {
 			idx := s.funcScope.numLocals
 			s.funcScope.numLocals += 1
+			s.funcScope.localNames = append(s.funcScope.localNames, sym)
 			if s.funcScope.numLocals >= (2 << 16) {
 				panic("TODO wide index")
 			}
@@ -234,8 +238,10 @@ func capture(sym string, stack []*scope) *ast.Variable {
 		return v
 	}
 
-	// look for functions between the beginning and end, exclusive
-	for a := n - 2; a >= 1; a-- {
+	// Look for functions between the beginning, inclusive, and the end,
+	// exclusive.  The beginning is a function when the default value of
+	// a param refers to a variable.
+	for a := n - 2; a >= 0; a-- {
 		if stack[a].scopeType == funcType {
 			s := stack[a]
 
@@ -244,7 +250,7 @@ func capture(sym string, stack []*scope) *ast.Variable {
 			s.funcScope.parentCaptures[sym] = v
 
 			// capture the variable in this scope
-			v = &ast.Variable{idx, v.IsConst, true}
+			v = &ast.Variable{idx, v.IsConst, true, false, v}
 			s.funcScope.captures[sym] = v
 		}
 	}
@@ -71,14 +71,14 @@ func TestGetPut(test *testing.T) {
 
 	testGetMissing(test, s, "a")
 	s.put("a", true)
-	testGetOk(test, s, "a", &ast.Variable{0, true, false})
+	testGetOk(test, s, "a", &ast.Variable{0, true, false, false, nil})
 
 	t := newBlockScope(s)
-	testGetOk(test, t, "a", &ast.Variable{0, true, false})
+	testGetOk(test, t, "a", &ast.Variable{0, true, false, false, nil})
 
 	testGetMissing(test, t, "b")
 	t.put("b", false)
-	testGetOk(test, t, "b", &ast.Variable{1, false, false})
+	testGetOk(test, t, "b", &ast.Variable{1, false, false, false, nil})
 
 	testGetMissing(test, s, "b")
 }
@@ -110,6 +110,12 @@ Func  defs:{c: (0,false,false)} captures:{a: (0,false,true)} parentCaptures:{a:
 Block defs:{b: (1,false,false)}
 Func  defs:{a: (0,false,false)} captures:{} parentCaptures:{} numLocals:2
 `)
+
+	// each capture refers to the variable that it captured
+	v, _ := s5.get("a")
+	if v.Captured != s2.funcScope.captures["a"] || v.Captured.Captured != s0.defs["a"] {
+		test.Error("Captured is wrong")
+	}
 }
 
 func TestPlainStructScope(test *testing.T) {
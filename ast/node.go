@@ -170,6 +170,15 @@ type (
 		Semicolon  *Token
 	}
 
+	// Ident is the constant that the module is bound to.  It has the
+	// same symbol as the module's name, unless an alias was given.
+	Import struct {
+		Token     *Token
+		Name      *Token
+		Ident     *IdentExpr
+		Semicolon *Token
+	}
+
 	//---------------------
 	// expression
 
@@ -210,26 +219,33 @@ type (
 		Variable *Variable
 	}
 
-	BuiltinExpr struct {
-		Fn *Token
-	}
-
 	FnExpr struct {
 		Token        *Token
 		FormalParams []*IdentExpr
-		Body         *Block
+		// the default values of the optional params, which follow
+		// the required params, and precede the variadic param, if any
+		Defaults []Expr
+		Variadic bool
+		Body     *Block
 
 		// set by analyzer
 		NumLocals      int
 		NumCaptures    int
 		ParentCaptures []*Variable
+
+		// the names of the local variables and captures, by index
+		LocalNames   []string
+		CaptureNames []string
 	}
 
+	// The named params follow the positional ones, e.g. 'f(x, timeout: 5)'.
 	InvokeExpr struct {
-		Operand Expr
-		LParen  *Token
-		Params  []Expr
-		RParen  *Token
+		Operand     Expr
+		LParen      *Token
+		Params      []Expr
+		Names       []*Token
+		NamedParams []Expr
+		RParen      *Token
 	}
 
 	ListExpr struct {
@@ -335,6 +351,7 @@ func (*Return) stmtMarker()   {}
 func (*Throw) stmtMarker()    {}
 func (*Try) stmtMarker()      {}
 func (*Spawn) stmtMarker()    {}
+func (*Import) stmtMarker()   {}
 
 func (*While) loopMarker() {}
 func (*For) loopMarker()   {}
@@ -346,7 +363,6 @@ func (*UnaryExpr) exprMarker()     {}
 func (*PostfixExpr) exprMarker()   {}
 func (*BasicExpr) exprMarker()     {}
 func (*IdentExpr) exprMarker()     {}
-func (*BuiltinExpr) exprMarker()   {}
 func (*FnExpr) exprMarker()        {}
 func (*InvokeExpr) exprMarker()    {}
 func (*ListExpr) exprMarker()      {}
@@ -362,10 +378,9 @@ func (*SliceExpr) exprMarker()     {}
 func (*SliceFromExpr) exprMarker() {}
 func (*SliceToExpr) exprMarker()   {}
 
-func (*IdentExpr) assignableMarker()   {}
-func (*BuiltinExpr) assignableMarker() {}
-func (*FieldExpr) assignableMarker()   {}
-func (*IndexExpr) assignableMarker()   {}
+func (*IdentExpr) assignableMarker() {}
+func (*FieldExpr) assignableMarker() {}
+func (*IndexExpr) assignableMarker() {}
 
 //--------------------------------------------------------------
 // Begin, End
@@ -436,6 +451,9 @@ func (n *Try) End() Pos {
 func (n *Spawn) Begin() Pos { return n.Token.Position }
 func (n *Spawn) End() Pos   { return n.Semicolon.Position }
 
+func (n *Import) Begin() Pos { return n.Token.Position }
+func (n *Import) End() Pos   { return n.Semicolon.Position }
+
 func (n *Assignment) Begin() Pos { return n.Assignee.Begin() }
 func (n *Assignment) End() Pos   { return n.Val.End() }
 
@@ -465,13 +483,6 @@ func (n *IdentExpr) End() Pos {
 		n.Symbol.Position.Col + len(n.Symbol.Text) - 1}
 }
 
-func (n *BuiltinExpr) Begin() Pos { return n.Fn.Position }
-func (n *BuiltinExpr) End() Pos {
-	return Pos{
-		n.Fn.Position.Line,
-		n.Fn.Position.Col + len(n.Fn.Text) - 1}
-}
-
 func (n *FnExpr) Begin() Pos { return n.Token.Position }
 func (n *FnExpr) End() Pos   { return n.Body.End() }
 
@@ -556,7 +567,7 @@ func (nf *NamedFn) String() string {
 	}
 	buf.WriteString("fn ")
 	buf.WriteString(nf.Ident.String())
-	buf.WriteString(identsString(nf.Func.FormalParams))
+	buf.WriteString(paramsString(nf.Func))
 	buf.WriteString(" ")
 	buf.WriteString(nf.Func.Body.String())
 	return buf.String()
@@ -696,6 +707,14 @@ func (sp *Spawn) String() string {
 	return fmt.Sprintf("spawn %v;", sp.Invocation)
 }
 
+func (imp *Import) String() string {
+	if imp.Ident.Symbol == imp.Name {
+		return fmt.Sprintf("import %s;", imp.Name.Text)
+	} else {
+		return fmt.Sprintf("import %s as %v;", imp.Name.Text, imp.Ident)
+	}
+}
+
 func (trn *TernaryExpr) String() string {
 	return fmt.Sprintf("(%v ? %v : %v)", trn.Cond, trn.Then, trn.Else)
 }
@@ -725,21 +744,59 @@ func (ident *IdentExpr) String() string {
 	return ident.Symbol.Text
 }
 
-func (blt *BuiltinExpr) String() string {
-	return blt.Fn.Text
-}
-
 func (fn *FnExpr) String() string {
 	var buf bytes.Buffer
 
 	buf.WriteString("fn")
-	buf.WriteString(identsString(fn.FormalParams))
+	buf.WriteString(paramsString(fn))
 	buf.WriteString(" ")
 	buf.WriteString(fn.Body.String())
 
 	return buf.String()
 }
 
+// NumRequiredParams returns the number of params that do not have
+// a default value, and are not variadic.
+func (fn *FnExpr) NumRequiredParams() int {
+	n := len(fn.FormalParams) - len(fn.Defaults)
+	if fn.Variadic {
+		n--
+	}
+	return n
+}
+
+// Default returns the default value of the param at the given index,
+// or nil if the param is not optional.
+func (fn *FnExpr) Default(index int) Expr {
+	j := index - fn.NumRequiredParams()
+	if j < 0 || j >= len(fn.Defaults) {
+		return nil
+	}
+	return fn.Defaults[j]
+}
+
+func paramsString(fn *FnExpr) string {
+	var buf bytes.Buffer
+
+	buf.WriteString("(")
+	for idx, p := range fn.FormalParams {
+		if idx > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(p.String())
+		if d := fn.Default(idx); d != nil {
+			buf.WriteString(" = ")
+			buf.WriteString(d.String())
+		}
+	}
+	if fn.Variadic {
+		buf.WriteString("...")
+	}
+	buf.WriteString(")")
+
+	return buf.String()
+}
+
 func identsString(idents []*IdentExpr) string {
 	var buf bytes.Buffer
 
@@ -765,6 +822,14 @@ func (inv *InvokeExpr) String() string {
 		}
 		buf.WriteString(p.String())
 	}
+	for idx, n := range inv.Names {
+		if idx > 0 || len(inv.Params) > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(n.Text)
+		buf.WriteString(": ")
+		buf.WriteString(inv.NamedParams[idx].String())
+	}
 	buf.WriteString(")")
 	return buf.String()
 }
@@ -911,14 +976,21 @@ func writeNodes(nodes []Node, buf *bytes.Buffer) {
 //--------------------------------------------------------------
 // A Variable points to a Ref.  Variables are defined either
 // as formal params for a Function, or via Let or Const, or via
-// the capture mechanism.
+// the capture mechanism.  A builtin Variable points instead to
+// an entry in the BuiltinManager.  A capture Variable refers to
+// the Variable in the enclosing function that was Captured.
 
 type Variable struct {
 	Index     int
 	IsConst   bool
 	IsCapture bool
+	IsBuiltin bool
+	Captured  *Variable
 }
 
 func (v *Variable) String() string {
+	if v.IsBuiltin {
+		return fmt.Sprintf("(%d,builtin)", v.Index)
+	}
 	return fmt.Sprintf("(%d,%v,%v)", v.Index, v.IsConst, v.IsCapture)
 }
@@ -41,6 +41,7 @@ const (
 	badKind
 
 	EOF
+	COMMENT
 
 	PLUS
 	DBL_PLUS
@@ -59,6 +60,7 @@ const (
 	COLON
 	COMMA
 	DOT
+	ELLIPSIS
 	HOOK
 
 	EQ
@@ -137,15 +139,6 @@ const (
 	PUB
 	MODULE
 	IMPORT
-
-	FN_PRINT
-	FN_PRINTLN
-	FN_STR
-	FN_LEN
-	FN_RANGE
-	FN_ASSERT
-	FN_MERGE
-	FN_CHAN
 )
 
 func (t TokenKind) String() string {
@@ -156,6 +149,8 @@ func (t TokenKind) String() string {
 		return "UNEXPECTED_EOF"
 	case EOF:
 		return "EOF"
+	case COMMENT:
+		return "COMMENT"
 
 	case PLUS:
 		return "PLUS"
@@ -196,6 +191,8 @@ func (t TokenKind) String() string {
 		return "COMMA"
 	case DOT:
 		return "DOT"
+	case ELLIPSIS:
+		return "ELLIPSIS"
 	case HOOK:
 		return "HOOK"
 
@@ -302,23 +299,6 @@ func (t TokenKind) String() string {
 	case IMPORT:
 		return "IMPORT"
 
-	case FN_PRINT:
-		return "FN_PRINT"
-	case FN_PRINTLN:
-		return "FN_PRINTLN"
-	case FN_STR:
-		return "FN_STR"
-	case FN_LEN:
-		return "FN_LEN"
-	case FN_RANGE:
-		return "FN_RANGE"
-	case FN_ASSERT:
-		return "FN_ASSERT"
-	case FN_MERGE:
-		return "FN_MERGE"
-	case FN_CHAN:
-		return "FN_CHAN"
-
 	default:
 		panic("unreachable")
 	}
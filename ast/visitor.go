@@ -138,6 +138,10 @@ func (sp *Spawn) Traverse(v Visitor) {
 	v.Visit(sp.Invocation)
 }
 
+func (imp *Import) Traverse(v Visitor) {
+	v.Visit(imp.Ident)
+}
+
 func (blk *Block) Traverse(v Visitor) {
 	for _, n := range blk.Nodes {
 		v.Visit(n)
@@ -169,13 +173,13 @@ func (basic *BasicExpr) Traverse(v Visitor) {
 func (ident *IdentExpr) Traverse(v Visitor) {
 }
 
-func (ident *BuiltinExpr) Traverse(v Visitor) {
-}
-
 func (fn *FnExpr) Traverse(v Visitor) {
 	for _, n := range fn.FormalParams {
 		v.Visit(n)
 	}
+	for _, n := range fn.Defaults {
+		v.Visit(n)
+	}
 	v.Visit(fn.Body)
 }
 
@@ -184,6 +188,9 @@ func (inv *InvokeExpr) Traverse(v Visitor) {
 	for _, n := range inv.Params {
 		v.Visit(n)
 	}
+	for _, n := range inv.NamedParams {
+		v.Visit(n)
+	}
 }
 
 func (ls *ListExpr) Traverse(v Visitor) {
@@ -301,6 +308,8 @@ func (p *dump) Visit(node Node) {
 		p.buf.WriteString("Try\n")
 	case *Spawn:
 		p.buf.WriteString("Spawn\n")
+	case *Import:
+		p.buf.WriteString(fmt.Sprintf("Import(%s)\n", t.Name.Text))
 
 	case *BinaryExpr:
 		p.buf.WriteString(fmt.Sprintf("BinaryExpr(%q)\n", t.Op.Text))
@@ -320,9 +329,11 @@ func (p *dump) Visit(node Node) {
 		p.buf.WriteString(varsString(t.ParentCaptures))
 		p.buf.WriteString(")\n")
 	case *InvokeExpr:
-		p.buf.WriteString("InvokeExpr\n")
-	case *BuiltinExpr:
-		p.buf.WriteString(fmt.Sprintf("BuiltinExpr(%q)\n", t.Fn.Text))
+		if len(t.Names) > 0 {
+			p.buf.WriteString(fmt.Sprintf("InvokeExpr(%v)\n", tokensString(t.Names)))
+		} else {
+			p.buf.WriteString("InvokeExpr\n")
+		}
 
 	case *StructExpr:
 		p.buf.WriteString(fmt.Sprintf("StructExpr(%v,%d)\n", tokensString(t.Keys), t.LocalThisIndex))
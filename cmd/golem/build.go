@@ -0,0 +1,66 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"golem"
+	g "golem/core"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// build compiles a source file, and writes the resulting module
+// in the '.glmc' format.
+func build(args []string) {
+
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	output := flags.String("o", "", "output file (default: the input file with a .glmc extension)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		exitError(usage)
+	}
+	filename := flags.Arg(0)
+	if *output == "" {
+		*output = strings.TrimSuffix(filename, ".glm") + ".glmc"
+	}
+
+	// compile
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		exitError(err.Error())
+	}
+	rt := golem.NewRuntime()
+	mod, err := rt.Compile(filename, string(buf))
+	if err != nil {
+		exitDiagnostics(rt, err)
+	}
+
+	// write
+	f, err := os.Create(*output)
+	if err != nil {
+		exitError(err.Error())
+	}
+	err = g.WriteModule(f, mod)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(*output)
+		exitError(err.Error())
+	}
+}
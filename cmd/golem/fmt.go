@@ -0,0 +1,80 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	golemfmt "golem/format"
+	"io/ioutil"
+	"os"
+)
+
+// fmtFiles formats source files.  The result is written to stdout, unless
+// -w is given, in which case the files are rewritten in place.  With -check,
+// the files that are not formatted are listed, and nothing is written.
+// The exit status is 1 if a file could not be formatted, or if -check
+// found a file that is not formatted.
+func fmtFiles(args []string) {
+
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the result to the source file")
+	check := flags.Bool("check", false, "list the files that are not formatted")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 || (*write && *check) {
+		exitError(usage)
+	}
+
+	failed := false
+	for _, filename := range flags.Args() {
+
+		buf, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			failed = true
+			continue
+		}
+		src := string(buf)
+
+		result, err := golemfmt.Source(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filename, err.Error())
+			failed = true
+			continue
+		}
+
+		switch {
+		case *check:
+			if result != src {
+				fmt.Println(filename)
+				failed = true
+			}
+		case *write:
+			if result != src {
+				if err := ioutil.WriteFile(filename, []byte(result), 0644); err != nil {
+					fmt.Fprintln(os.Stderr, err.Error())
+					failed = true
+				}
+			}
+		default:
+			fmt.Print(result)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
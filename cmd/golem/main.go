@@ -0,0 +1,397 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"golem"
+	g "golem/core"
+	"golem/coverage"
+	"golem/debug"
+	"golem/interpreter"
+	"golem/lsp"
+	"golem/profile"
+	"golem/repl"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const usage = `Usage:
+    golem [options] <file> [args...]            run a source file or compiled module
+    golem [options] build [-o output] <file>    compile a source file to a module
+    golem debug <file> [args...]                debug a program from the command line
+    golem debug -dap                            run a debug adapter on stdin and stdout
+    golem fmt [-w | -check] <files...>          format source files
+    golem lsp                                   run a language server on stdin and stdout
+    golem repl                                  start an interactive session
+    golem test [-run regexp] [-timeout d] [-v] [paths...]
+                                                run the tests in *_test.glm files
+
+Options:
+    --format=text|json    how errors are reported (default: text)
+    --profile=<file>      profile the program, and write the profile to <file>
+    --coverage=<file>     record which lines run, and write a report to <file>
+    --trace=<file>        write every opcode, call, return, throw and spawn to <file>
+
+Imported modules are found in the directory that contains <file>,
+and then in each of the directories listed in GOLEMPATH.
+
+With --format=json, every error is written to stderr as a JSON object
+on a line of its own, with the fields "kind", "message", "file",
+"range" and "stack".
+
+With --profile, the opcodes that each function and line execute, and the
+time that they take, are written to stderr when the program finishes.
+The profile that is written to <file> can be read by 'go tool pprof'.
+
+With --coverage, the percentage of the lines of each file that were run
+is written to stderr when the program finishes.  If <file> ends in .html,
+the report shows the source code, with the lines that did and did not run
+highlighted.  Otherwise it is an LCOV tracefile, and the coverage that is
+already in it is merged with that of the program, so that the coverage of
+several runs can be combined.
+
+With --trace, each event is written to <file> as a JSON object on a line
+of its own, so that the traces of different runs can be compared.
+
+'golem fmt' writes the formatted files to stdout.  With -w, the files
+are rewritten instead, and with -check, the files that are not formatted
+are listed, and the exit status is 1 if there are any.
+
+'golem repl' saves the inputs that are entered in ~/.golem_history.
+Enter :help at the prompt for a list of commands.
+
+'golem test' runs the top-level functions whose names begin with 'test'
+in the *_test.glm files that the paths name, or that are in the current
+directory.  A path that ends in '/...' includes the subdirectories too.
+Each test runs with its own copy of the modules, and fails if it throws
+an error, or runs for longer than -timeout (default: 1m).  With -run,
+only the tests whose names match the regular expression are run.  The
+exit status is 1 if any test fails.
+
+'golem debug' stops at the first line of the program.  Enter help at
+the prompt for a list of commands.  With -dap, it speaks the Debug
+Adapter Protocol, so that editors can debug programs.
+`
+
+// how errors are reported
+var format = "text"
+
+// where the profile is written, if the program is profiled
+var profileFile = ""
+
+// where the coverage report is written, if coverage is recorded
+var coverageFile = ""
+
+// where the trace is written, if the program is traced
+var traceFile = ""
+
+func main() {
+
+	flags := flag.NewFlagSet("golem", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	flags.StringVar(&format, "format", "text", "")
+	flags.StringVar(&profileFile, "profile", "", "")
+	flags.StringVar(&coverageFile, "coverage", "", "")
+	flags.StringVar(&traceFile, "trace", "", "")
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			fmt.Print(usage)
+			return
+		}
+		exitError(err.Error() + "\n" + usage)
+	}
+	if format != "text" && format != "json" {
+		exitError(fmt.Sprintf("invalid format '%s'\n%s", format, usage))
+	}
+
+	args := flags.Args()
+	if len(args) < 1 {
+		exitError(usage)
+	}
+
+	switch args[0] {
+	case "build":
+		build(args[1:])
+	case "debug":
+		debugProgram(args[1:])
+	case "fmt":
+		fmtFiles(args[1:])
+	case "lsp":
+		if err := lsp.NewServer(g.StandardBuiltins).Serve(os.Stdin, os.Stdout); err != nil {
+			exitError(err.Error())
+		}
+	case "repl":
+		runRepl()
+	case "test":
+		testFiles(args[1:])
+	case "help":
+		fmt.Print(usage)
+	default:
+		run(args[0], args[1:])
+	}
+}
+
+func run(filename string, osArgs []string) {
+	rt := newRuntime(filename)
+	mod := load(rt, filename)
+
+	debuggers := []interpreter.Debugger{}
+	var prof *profile.Profiler
+	if profileFile != "" {
+		prof = profile.New()
+		debuggers = append(debuggers, prof)
+	}
+	var cover *coverage.Coverage
+	if coverageFile != "" {
+		cover = readCoverage()
+		debuggers = append(debuggers, cover)
+	}
+	switch len(debuggers) {
+	case 0:
+	case 1:
+		rt.Debugger = debuggers[0]
+	default:
+		rt.Debugger = interpreter.Debuggers(debuggers...)
+	}
+	var trace *os.File
+	var traceWriter *bufio.Writer
+	var tracer *interpreter.JSONTracer
+	if traceFile != "" {
+		var err error
+		if trace, err = os.Create(traceFile); err != nil {
+			exitError(err.Error())
+		}
+		traceWriter = bufio.NewWriter(trace)
+		tracer = interpreter.NewJSONTracer(traceWriter)
+		rt.Tracer = tracer
+	}
+
+	err := rt.RunMain(mod, osArgs)
+	if tracer != nil {
+		werr := tracer.Err()
+		if werr == nil {
+			werr = traceWriter.Flush()
+		}
+		trace.Close()
+		if werr != nil {
+			exitError(werr.Error())
+		}
+	}
+	if prof != nil {
+		writeProfile(prof)
+	}
+	if cover != nil {
+		writeCoverage(rt, cover)
+	}
+	if err != nil {
+		exitDiagnostics(rt, err)
+	}
+}
+
+func writeProfile(prof *profile.Profiler) {
+	prof.Stop()
+	prof.WriteText(os.Stderr)
+
+	f, err := os.Create(profileFile)
+	if err != nil {
+		exitError(err.Error())
+	}
+	defer f.Close()
+	if err := prof.WritePprof(f); err != nil {
+		exitError(err.Error())
+	}
+}
+
+// readCoverage creates a Coverage, and merges the LCOV tracefile that the
+// report is written to into it, if there is one.
+func readCoverage() *coverage.Coverage {
+	cover := coverage.New()
+	if strings.HasSuffix(coverageFile, ".html") {
+		return cover
+	}
+
+	f, err := os.Open(coverageFile)
+	if os.IsNotExist(err) {
+		return cover
+	}
+	if err != nil {
+		exitError(err.Error())
+	}
+	defer f.Close()
+	if err := cover.ReadLCOV(f); err != nil {
+		exitError(fmt.Sprintf("%s: %s", coverageFile, err.Error()))
+	}
+	return cover
+}
+
+func writeCoverage(rt *golem.Runtime, cover *coverage.Coverage) {
+	cover.WriteSummary(os.Stderr)
+
+	f, err := os.Create(coverageFile)
+	if err != nil {
+		exitError(err.Error())
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(coverageFile, ".html") {
+		err = cover.WriteHTML(f, func(file string) (string, bool) {
+			if src, ok := rt.Source(file); ok {
+				return src, true
+			}
+			buf, err := ioutil.ReadFile(file)
+			return string(buf), err == nil
+		})
+	} else {
+		err = cover.WriteLCOV(f)
+	}
+	if err != nil {
+		exitError(err.Error())
+	}
+}
+
+// newRuntime creates a Runtime for a program.  Modules are imported from
+// the directory that contains the program, and then from GOLEMPATH.
+func newRuntime(filename string) *golem.Runtime {
+	rt := golem.NewRuntime()
+	rt.Path = append([]string{filepath.Dir(filename)}, golemPath()...)
+	return rt
+}
+
+// load compiles a source file, or loads a module that has already
+// been compiled.
+func load(rt *golem.Runtime, filename string) *g.BytecodeModule {
+
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		exitError(err.Error())
+	}
+
+	var mod *g.BytecodeModule
+	if bytes.HasPrefix(buf, []byte(g.GlmcMagic)) {
+		mod, err = rt.Load(bytes.NewReader(buf))
+	} else {
+		mod, err = rt.Compile(filename, string(buf))
+	}
+	if err != nil {
+		exitDiagnostics(rt, err)
+	}
+	return mod
+}
+
+// debugProgram runs a program under the debugger.
+func debugProgram(args []string) {
+
+	if len(args) == 1 && args[0] == "-dap" {
+		// the program's output is sent to the editor in 'output' events
+		out := os.Stdout
+		r, w, err := os.Pipe()
+		if err != nil {
+			exitError(err.Error())
+		}
+		os.Stdout = w
+
+		srv := debug.NewDAPServer(newRuntime)
+		srv.Output = r
+		if err := srv.Serve(os.Stdin, out); err != nil {
+			exitError(err.Error())
+		}
+		return
+	}
+
+	if len(args) < 1 || strings.HasPrefix(args[0], "-") {
+		exitError(usage)
+	}
+	filename := args[0]
+	rt := newRuntime(filename)
+	d := debug.New(rt, debug.NewConsole(os.Stdin, os.Stdout, rt.Source))
+	d.StopOnEntry = true
+
+	err := rt.RunMain(load(rt, filename), args[1:])
+	if rte, ok := err.(*golem.RuntimeError); ok && rte.Err.Kind() == g.CANCELLED {
+		// the program was stopped with 'quit'
+		return
+	}
+	if err != nil {
+		exitDiagnostics(rt, err)
+	}
+}
+
+// runRepl starts a REPL on stdin and stdout.  Modules are imported from the
+// current directory, and then from GOLEMPATH.
+func runRepl() {
+
+	rt := golem.NewRuntime()
+	rt.Path = append([]string{"."}, golemPath()...)
+
+	r := repl.New(rt)
+	r.Color = useColor(os.Stdout)
+	if home, err := os.UserHomeDir(); err == nil {
+		r.HistoryFile = filepath.Join(home, ".golem_history")
+	}
+	if err := r.Run(os.Stdin, os.Stdout); err != nil {
+		exitError(err.Error())
+	}
+}
+
+// the directories listed in the GOLEMPATH environment variable
+func golemPath() []string {
+	dirs := []string{}
+	for _, dir := range filepath.SplitList(os.Getenv("GOLEMPATH")) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func exitError(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+	os.Exit(1)
+}
+
+// Print the diagnostics for an error that was returned by the runtime,
+// and exit.  In text format, the offending source code is shown, and colour
+// is used if stderr is a terminal, unless the NO_COLOR environment
+// variable is set.  In json format, each diagnostic is written on its own line.
+func exitDiagnostics(rt *golem.Runtime, err error) {
+	diags := golem.Diagnostics(err)
+	if format == "json" {
+		enc := json.NewEncoder(os.Stderr)
+		for _, d := range diags {
+			enc.Encode(d)
+		}
+	} else {
+		r := &golem.Renderer{useColor(os.Stderr), rt.Source}
+		for _, d := range diags {
+			r.Render(os.Stderr, d)
+		}
+	}
+	os.Exit(1)
+}
+
+func useColor(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"golem"
+	"golem/test"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// testFiles runs the tests in the test files that the arguments name, or
+// in the current directory if there are none.  The failures are reported
+// with their stack traces.  The exit status is 1 if a test failed, or if
+// a test file could not be run.
+func testFiles(args []string) {
+
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	run := flags.String("run", "", "run only the tests whose names match a regular expression")
+	timeout := flags.Duration("timeout", time.Minute, "the time that each test may run for")
+	verbose := flags.Bool("v", false, "report the tests that pass too")
+	flags.Parse(args)
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := test.FindFiles(paths)
+	if err != nil {
+		exitError(err.Error())
+	}
+	if len(files) == 0 {
+		fmt.Println("no test files")
+		return
+	}
+
+	runner := test.NewRunner(newRuntime)
+	runner.Timeout = *timeout
+	if *run != "" {
+		if runner.Match, err = regexp.Compile(*run); err != nil {
+			exitError(fmt.Sprintf("invalid -run: %s", err.Error()))
+		}
+	}
+
+	failed := false
+	for _, file := range files {
+
+		start := time.Now()
+		passed := true
+		err := runner.RunFile(file, func(res *test.Result) {
+			if res.Passed() {
+				if *verbose {
+					fmt.Printf("--- PASS: %s (%.3fs)\n", res.Name, res.Duration.Seconds())
+				}
+				return
+			}
+			passed = false
+			fmt.Printf("--- FAIL: %s (%.3fs)\n", res.Name, res.Duration.Seconds())
+			reportFailure(res.Err)
+		})
+		if err != nil {
+			passed = false
+			reportFailure(err)
+		}
+
+		status := "ok"
+		if !passed {
+			status = "FAIL"
+			failed = true
+		}
+		fmt.Printf("%-4s  %s  %.3fs\n", status, file, time.Since(start).Seconds())
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// Report why a test failed, indented beneath it.
+func reportFailure(err error) {
+	diags := golem.Diagnostics(err)
+	if format == "json" {
+		enc := json.NewEncoder(os.Stderr)
+		for _, d := range diags {
+			enc.Encode(d)
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	r := &golem.Renderer{useColor(os.Stdout), func(file string) (string, bool) {
+		src, err := ioutil.ReadFile(file)
+		return string(src), err == nil
+	}}
+	for _, d := range diags {
+		r.Render(&buf, d)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		fmt.Printf("    %s\n", line)
+	}
+}
@@ -35,18 +35,22 @@ type compiler struct {
 	handlers []g.ExceptionHandler
 
 	funcs      []*ast.FnExpr
+	funcNames  map[*ast.FnExpr]string
 	templates  []*g.Template
 	structDefs [][]*g.StructEntryDef
+	builtins   []string
 	idx        int
 }
 
 func NewCompiler(anl analyzer.Analyzer) Compiler {
 
 	funcs := []*ast.FnExpr{anl.Module()}
+	funcNames := map[*ast.FnExpr]string{anl.Module(): "<module>"}
 	templates := []*g.Template{}
 	structDefs := [][]*g.StructEntryDef{}
 
-	return &compiler{g.EmptyHashMap(), nil, nil, nil, funcs, templates, structDefs, 0}
+	return &compiler{g.EmptyHashMap(), nil, nil, nil,
+		funcs, funcNames, templates, structDefs, []string{}, 0}
 }
 
 func (c *compiler) Compile() *g.BytecodeModule {
@@ -60,14 +64,16 @@ func (c *compiler) Compile() *g.BytecodeModule {
 	}
 
 	// done
-	mod := &g.BytecodeModule{makePoolSlice(c.pool), nil, c.structDefs, c.templates, nil}
-	mod.Contents = c.makeModuleContents(mod)
+	mod := &g.BytecodeModule{
+		"", makePoolSlice(c.pool), nil, c.structDefs, c.templates,
+		c.makeModuleExports(), c.builtins, nil}
+	mod.Contents = g.MakeModuleContents(mod)
 	return mod
 }
 
-func (c *compiler) makeModuleContents(mod *g.BytecodeModule) g.Struct {
+func (c *compiler) makeModuleExports() []*g.ModuleExport {
 
-	entries := []*g.StructEntry{}
+	exports := []*g.ModuleExport{}
 	nodes := c.funcs[0].Body.Nodes
 	for _, n := range nodes {
 		switch t := n.(type) {
@@ -75,66 +81,46 @@ func (c *compiler) makeModuleContents(mod *g.BytecodeModule) g.Struct {
 			if t.IsPub {
 				for _, d := range t.Decls {
 					vbl := d.Ident.Variable
-					entries = append(entries, c.makeModuleProperty(
-						mod, d.Ident.Symbol.Text, vbl.Index, vbl.IsConst))
+					exports = append(exports, &g.ModuleExport{
+						d.Ident.Symbol.Text, vbl.Index, vbl.IsConst})
 				}
 			}
 		case *ast.Const:
 			if t.IsPub {
 				for _, d := range t.Decls {
 					vbl := d.Ident.Variable
-					entries = append(entries, c.makeModuleProperty(
-						mod, d.Ident.Symbol.Text, vbl.Index, vbl.IsConst))
+					exports = append(exports, &g.ModuleExport{
+						d.Ident.Symbol.Text, vbl.Index, vbl.IsConst})
 				}
 			}
 		case *ast.NamedFn:
 			if t.IsPub {
 				vbl := t.Ident.Variable
-				entries = append(entries, c.makeModuleProperty(
-					mod, t.Ident.Symbol.Text, vbl.Index, vbl.IsConst))
+				exports = append(exports, &g.ModuleExport{
+					t.Ident.Symbol.Text, vbl.Index, vbl.IsConst})
 			}
 		}
 	}
 
-	stc, err := g.NewStruct(entries)
-	g.Assert(err == nil, "invalid module contents")
-	return stc
+	return exports
 }
 
-func (c *compiler) makeModuleProperty(
-	mod *g.BytecodeModule,
-	key string,
-	refIndex int,
-	isConst bool) *g.StructEntry {
+func (c *compiler) compileFunc(fe *ast.FnExpr) *g.Template {
 
-	getter := g.NewNativeFunc(
-		func(values []g.Value) (g.Value, g.Error) {
-			if len(values) != 0 {
-				return nil, g.ArityMismatchError("0", len(values))
-			}
-			return mod.Refs[refIndex].Val, nil
-		})
-
-	var setter g.NativeFunc = nil
-	if !isConst {
-		setter = g.NewNativeFunc(
-			func(values []g.Value) (g.Value, g.Error) {
-				if len(values) != 1 {
-					return nil, g.ArityMismatchError("1", len(values))
-				}
-				mod.Refs[refIndex].Val = values[0]
-				return g.NULL, nil
-			})
+	// functions that are not named get a synthesized name
+	name, ok := c.funcNames[fe]
+	if !ok {
+		name = "<lambda>"
 	}
 
-	prop := g.NewTuple([]g.Value{getter, setter})
-	return &g.StructEntry{key, isConst, true, prop}
-}
-
-func (c *compiler) compileFunc(fe *ast.FnExpr) *g.Template {
-
 	arity := len(fe.FormalParams)
-	tpl := &g.Template{arity, fe.NumCaptures, fe.NumLocals, nil, nil, nil}
+	maxArity := arity
+	if fe.Variadic {
+		maxArity = -1
+	}
+	tpl := &g.Template{name, arity, fe.NumRequiredParams(), maxArity,
+		fe.NumCaptures, fe.NumLocals, nil, nil, nil, 0,
+		fe.LocalNames, fe.CaptureNames}
 
 	c.opc = []byte{}
 	c.lnum = []g.LineNumberEntry{}
@@ -146,6 +132,23 @@ func (c *compiler) compileFunc(fe *ast.FnExpr) *g.Template {
 	c.Visit(fe.Body)
 	c.push(ast.Pos{}, g.RETURN)
 
+	// An invocation that omits some of the optional params begins at the
+	// prologue, which pops a flag for each of them, and evaluates the default
+	// value of the ones that were omitted.  The prologue then does what the
+	// first opcode does, and jumps past it, so that an invocation is always
+	// just beginning when it is at the prologue, or at 0.
+	if len(fe.Defaults) > 0 {
+		tpl.Prologue = len(c.opc)
+		for j, d := range fe.Defaults {
+			skip := c.pushIndex(ast.Pos{}, g.JUMP_FALSE, 0)
+			c.Visit(d)
+			c.assignIdent(fe.FormalParams[tpl.MinArity+j])
+			c.setJump(skip, c.opcLen())
+		}
+		c.push(ast.Pos{}, g.LOAD_NULL)
+		c.pushIndex(ast.Pos{}, g.JUMP, 1)
+	}
+
 	tpl.OpCodes = c.opc
 	tpl.LineNumberTable = c.lnum
 	tpl.ExceptionHandlers = c.handlers
@@ -168,6 +171,9 @@ func (c *compiler) Visit(node ast.Node) {
 	case *ast.NamedFn:
 		c.visitNamedFn(t)
 
+	case *ast.Import:
+		c.visitImport(t)
+
 	case *ast.Assignment:
 		c.visitAssignment(t)
 
@@ -216,9 +222,6 @@ func (c *compiler) Visit(node ast.Node) {
 	case *ast.IdentExpr:
 		c.visitIdentExpr(t)
 
-	case *ast.BuiltinExpr:
-		c.visitBuiltinExpr(t)
-
 	case *ast.FnExpr:
 		c.visitFunc(t)
 
@@ -295,6 +298,7 @@ func (c *compiler) visitDecls(decls []*ast.Decl) {
 		if d.Val == nil {
 			c.push(d.Ident.Begin(), g.LOAD_NULL)
 		} else {
+			c.nameFunc(d.Val, d.Ident.Symbol.Text)
 			c.Visit(d.Val)
 		}
 
@@ -312,8 +316,17 @@ func (c *compiler) assignIdent(ident *ast.IdentExpr) {
 	}
 }
 
+// A function that is assigned to a variable or a field
+// when it is declared is named after the variable or field.
+func (c *compiler) nameFunc(expr ast.Expr, name string) {
+	if fe, ok := expr.(*ast.FnExpr); ok {
+		c.funcNames[fe] = name
+	}
+}
+
 func (c *compiler) visitNamedFn(nf *ast.NamedFn) {
 
+	c.nameFunc(nf.Func, nf.Ident.Symbol.Text)
 	c.Visit(nf.Func)
 
 	v := nf.Ident.Variable
@@ -321,6 +334,18 @@ func (c *compiler) visitNamedFn(nf *ast.NamedFn) {
 	c.pushIndex(nf.Ident.Begin(), g.STORE_LOCAL, v.Index)
 }
 
+func (c *compiler) visitImport(imp *ast.Import) {
+
+	c.pushIndex(
+		imp.Begin(),
+		g.IMPORT,
+		poolIndex(c.pool, g.MakeStr(imp.Name.Text)))
+
+	v := imp.Ident.Variable
+	g.Assert(!v.IsCapture, "invalid import")
+	c.pushIndex(imp.Ident.Begin(), g.STORE_LOCAL, v.Index)
+}
+
 func (c *compiler) visitAssignment(asn *ast.Assignment) {
 
 	switch t := asn.Assignee.(type) {
@@ -652,9 +677,6 @@ func (c *compiler) visitTry(t *ast.Try) {
 		// compile the catch
 		c.Visit(t.CatchBlock)
 
-		// pop the exception
-		c.push(t.CatchBlock.End(), g.POP)
-
 		// add a DONE to mark the end of the catch block
 		c.push(t.CatchBlock.End(), g.DONE)
 
@@ -688,7 +710,7 @@ func (c *compiler) visitTry(t *ast.Try) {
 
 func (c *compiler) visitThrow(t *ast.Throw) {
 	c.Visit(t.Val)
-	c.push(t.End(), g.THROW)
+	c.push(t.Token.Position, g.THROW)
 }
 
 func (c *compiler) visitBinaryExpr(b *ast.BinaryExpr) {
@@ -884,38 +906,19 @@ func (c *compiler) visitBasicExpr(basic *ast.BasicExpr) {
 
 func (c *compiler) visitIdentExpr(ident *ast.IdentExpr) {
 	v := ident.Variable
-	if v.IsCapture {
+	if v.IsBuiltin {
+		for len(c.builtins) <= v.Index {
+			c.builtins = append(c.builtins, "")
+		}
+		c.builtins[v.Index] = ident.Symbol.Text
+		c.pushIndex(ident.Begin(), g.LOAD_BUILTIN, v.Index)
+	} else if v.IsCapture {
 		c.pushIndex(ident.Begin(), g.LOAD_CAPTURE, v.Index)
 	} else {
 		c.pushIndex(ident.Begin(), g.LOAD_LOCAL, v.Index)
 	}
 }
 
-func (c *compiler) visitBuiltinExpr(blt *ast.BuiltinExpr) {
-
-	switch blt.Fn.Kind {
-	case ast.FN_PRINT:
-		c.pushIndex(blt.Fn.Position, g.LOAD_BUILTIN, g.PRINT)
-	case ast.FN_PRINTLN:
-		c.pushIndex(blt.Fn.Position, g.LOAD_BUILTIN, g.PRINTLN)
-	case ast.FN_STR:
-		c.pushIndex(blt.Fn.Position, g.LOAD_BUILTIN, g.STR)
-	case ast.FN_LEN:
-		c.pushIndex(blt.Fn.Position, g.LOAD_BUILTIN, g.LEN)
-	case ast.FN_RANGE:
-		c.pushIndex(blt.Fn.Position, g.LOAD_BUILTIN, g.RANGE)
-	case ast.FN_ASSERT:
-		c.pushIndex(blt.Fn.Position, g.LOAD_BUILTIN, g.ASSERT)
-	case ast.FN_MERGE:
-		c.pushIndex(blt.Fn.Position, g.LOAD_BUILTIN, g.MERGE)
-	case ast.FN_CHAN:
-		c.pushIndex(blt.Fn.Position, g.LOAD_BUILTIN, g.CHAN)
-
-	default:
-		panic("unknown builtin function")
-	}
-}
-
 func (c *compiler) visitFunc(fe *ast.FnExpr) {
 
 	c.pushIndex(fe.Begin(), g.NEW_FUNC, len(c.funcs))
@@ -931,47 +934,64 @@ func (c *compiler) visitFunc(fe *ast.FnExpr) {
 }
 
 func (c *compiler) visitInvoke(inv *ast.InvokeExpr) {
-
-	c.Visit(inv.Operand)
-	for _, n := range inv.Params {
-		c.Visit(n)
-	}
-	c.pushIndex(inv.Begin(), g.INVOKE, len(inv.Params))
+	c.invocation(inv, g.INVOKE, g.INVOKE_NAMED)
 }
 
 func (c *compiler) visitSpawn(spawn *ast.Spawn) {
+	c.invocation(spawn.Invocation, g.SPAWN, g.SPAWN_NAMED)
+}
+
+// The named params of an invocation are passed in a struct,
+// which follows the positional params on the stack.
+func (c *compiler) invocation(inv *ast.InvokeExpr, opc byte, namedOpc byte) {
 
-	inv := spawn.Invocation
 	c.Visit(inv.Operand)
 	for _, n := range inv.Params {
 		c.Visit(n)
 	}
-	c.pushIndex(inv.Begin(), g.SPAWN, len(inv.Params))
+	if len(inv.Names) == 0 {
+		c.pushIndex(inv.Begin(), opc, len(inv.Params))
+		return
+	}
+
+	c.newStruct(inv.LParen.Position, inv.Names)
+	c.initFields(inv.Names, inv.NamedParams)
+	c.pushIndex(inv.Begin(), namedOpc, len(inv.Params))
 }
 
 func (c *compiler) visitStructExpr(stc *ast.StructExpr) {
 
-	// create def and entries
+	c.newStruct(stc.Begin(), stc.Keys)
+
+	// if the struct is referenced by a 'this', then store local
+	if stc.LocalThisIndex != -1 {
+		c.push(stc.Begin(), g.DUP)
+		c.pushIndex(stc.Begin(), g.STORE_LOCAL, stc.LocalThisIndex)
+	}
+
+	c.initFields(stc.Keys, stc.Values)
+}
+
+// create a def for a struct with the given keys, and a new struct
+func (c *compiler) newStruct(pos ast.Pos, keys []*ast.Token) {
+
 	def := []*g.StructEntryDef{}
-	for _, k := range stc.Keys {
+	for _, k := range keys {
 		def = append(def, &g.StructEntryDef{k.Text, false, false})
 	}
 	defIdx := len(c.structDefs)
 	c.structDefs = append(c.structDefs, def)
 
-	// create new struct
-	c.pushIndex(stc.Begin(), g.NEW_STRUCT, defIdx)
+	c.pushIndex(pos, g.NEW_STRUCT, defIdx)
+}
 
-	// if the struct is referenced by a 'this', then store local
-	if stc.LocalThisIndex != -1 {
-		c.push(stc.Begin(), g.DUP)
-		c.pushIndex(stc.Begin(), g.STORE_LOCAL, stc.LocalThisIndex)
-	}
+// init each value of the struct that is on top of the stack
+func (c *compiler) initFields(keys []*ast.Token, values []ast.Expr) {
 
-	// init each value
-	for i, k := range stc.Keys {
-		v := stc.Values[i]
+	for i, k := range keys {
+		v := values[i]
 		c.push(k.Position, g.DUP)
+		c.nameFunc(v, k.Text)
 		c.Visit(v)
 		c.pushIndex(
 			v.Begin(),
@@ -1079,8 +1099,10 @@ func (c *compiler) push(pos ast.Pos, bytes ...byte) int {
 	}
 
 	ln := len(c.lnum)
-	if (ln == 0) || (pos.Line != c.lnum[ln-1].LineNum) {
-		c.lnum = append(c.lnum, g.LineNumberEntry{n, pos.Line})
+	if (ln == 0) ||
+		(pos.Line != c.lnum[ln-1].LineNum) ||
+		(pos.Col != c.lnum[ln-1].ColNum) {
+		c.lnum = append(c.lnum, g.LineNumberEntry{n, pos.Line, pos.Col})
 	}
 
 	return n
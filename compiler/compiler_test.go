@@ -46,6 +46,10 @@ func ok(t *testing.T, mod *g.BytecodeModule, expect *g.BytecodeModule) {
 		mt := mod.Templates[i]
 		et := expect.Templates[i]
 
+		if mt.Name != et.Name {
+			t.Error("Name: ", mt.Name, " != ", et.Name)
+		}
+
 		if (mt.Arity != et.Arity) || (mt.NumCaptures != et.NumCaptures) || (mt.NumLocals != et.NumLocals) {
 			t.Error(mod, " != ", expect)
 		}
@@ -72,7 +76,7 @@ func newAnalyzer(source string) analyzer.Analyzer {
 		panic(err)
 	}
 
-	anl := analyzer.NewAnalyzer(mod)
+	anl := analyzer.NewAnalyzer(mod, g.NewBuiltinManager(g.StandardBuiltins))
 	errors := anl.Analyze()
 	if len(errors) > 0 {
 		panic(err)
@@ -89,6 +93,7 @@ func TestExpression(t *testing.T) {
 
 	mod := NewCompiler(newAnalyzer("-2 + -1 + -0 + 0 + 1 + 2;")).Compile()
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{
 			g.MakeInt(int64(-2)),
 			g.MakeInt(int64(2))},
@@ -96,7 +101,8 @@ func TestExpression(t *testing.T) {
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 0,
+				"<module>",
+				0, 0, 0, 0, 0,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_CONST, 0, 0,
@@ -112,13 +118,24 @@ func TestExpression(t *testing.T) {
 					g.PLUS,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{16, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 1},
+					{4, 1, 6},
+					{5, 1, 4},
+					{6, 1, 11},
+					{7, 1, 9},
+					{8, 1, 16},
+					{9, 1, 14},
+					{10, 1, 20},
+					{11, 1, 18},
+					{12, 1, 24},
+					{15, 1, 22},
+					{16, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 
 	mod = NewCompiler(newAnalyzer("(2 + 3) * -4 / 10;")).Compile()
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{
 			g.MakeInt(int64(2)),
 			g.MakeInt(int64(3)),
@@ -128,7 +145,8 @@ func TestExpression(t *testing.T) {
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 0,
+				"<module>",
+				0, 0, 0, 0, 0,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_CONST, 0, 0,
@@ -140,19 +158,27 @@ func TestExpression(t *testing.T) {
 					g.DIV,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{16, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 2},
+					{4, 1, 6},
+					{7, 1, 4},
+					{8, 1, 11},
+					{11, 1, 9},
+					{12, 1, 16},
+					{15, 1, 14},
+					{16, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 
 	mod = NewCompiler(newAnalyzer("null / true + \nfalse;")).Compile()
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{},
 		nil,
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 0,
+				"<module>",
+				0, 0, 0, 0, 0,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_NULL,
@@ -162,15 +188,18 @@ func TestExpression(t *testing.T) {
 					g.PLUS,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{4, 2},
-					{5, 1},
-					{6, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 1},
+					{2, 1, 8},
+					{3, 1, 6},
+					{4, 2, 1},
+					{5, 1, 13},
+					{6, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 
 	mod = NewCompiler(newAnalyzer("'a' * 1.23e4;")).Compile()
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{
 			g.MakeStr("a"),
 			g.MakeFloat(float64(12300))},
@@ -178,7 +207,8 @@ func TestExpression(t *testing.T) {
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 0,
+				"<module>",
+				0, 0, 0, 0, 0,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_CONST, 0, 0,
@@ -186,20 +216,24 @@ func TestExpression(t *testing.T) {
 					g.MUL,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{8, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 1},
+					{4, 1, 7},
+					{7, 1, 5},
+					{8, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 
 	mod = NewCompiler(newAnalyzer("'a' == true;")).Compile()
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{
 			g.MakeStr("a")},
 		nil,
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 0,
+				"<module>",
+				0, 0, 0, 0, 0,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_CONST, 0, 0,
@@ -207,56 +241,71 @@ func TestExpression(t *testing.T) {
 					g.EQ,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{6, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 1},
+					{4, 1, 8},
+					{5, 1, 5},
+					{6, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 
 	mod = NewCompiler(newAnalyzer("true != false;")).Compile()
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{},
 		nil,
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 0,
+				"<module>",
+				0, 0, 0, 0, 0,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_TRUE, g.LOAD_FALSE, g.NE,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{4, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 1},
+					{2, 1, 9},
+					{3, 1, 6},
+					{4, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 
 	mod = NewCompiler(newAnalyzer("true > false; true >= false;")).Compile()
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{},
 		nil,
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 0,
+				"<module>",
+				0, 0, 0, 0, 0,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_TRUE, g.LOAD_FALSE, g.GT,
 					g.LOAD_TRUE, g.LOAD_FALSE, g.GTE,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{7, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 1},
+					{2, 1, 8},
+					{3, 1, 6},
+					{4, 1, 15},
+					{5, 1, 23},
+					{6, 1, 20},
+					{7, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 
 	mod = NewCompiler(newAnalyzer("true < false; true <= false; true <=> false;")).Compile()
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{},
 		nil,
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 0,
+				"<module>",
+				0, 0, 0, 0, 0,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_TRUE, g.LOAD_FALSE, g.LT,
@@ -264,13 +313,22 @@ func TestExpression(t *testing.T) {
 					g.LOAD_TRUE, g.LOAD_FALSE, g.CMP,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{10, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 1},
+					{2, 1, 8},
+					{3, 1, 6},
+					{4, 1, 15},
+					{5, 1, 23},
+					{6, 1, 20},
+					{7, 1, 30},
+					{8, 1, 39},
+					{9, 1, 35},
+					{10, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 
 	mod = NewCompiler(newAnalyzer("let a = 2 && 3;")).Compile()
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{
 			g.MakeInt(int64(2)),
 			g.MakeInt(int64(3))},
@@ -278,7 +336,8 @@ func TestExpression(t *testing.T) {
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 1,
+				"<module>",
+				0, 0, 0, 0, 1,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_CONST, 0, 0,
@@ -291,13 +350,16 @@ func TestExpression(t *testing.T) {
 					g.STORE_LOCAL, 0, 0,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{21, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 9},
+					{7, 1, 14},
+					{18, 1, 5},
+					{21, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 
 	mod = NewCompiler(newAnalyzer("let a = 2 || 3;")).Compile()
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{
 			g.MakeInt(int64(2)),
 			g.MakeInt(int64(3))},
@@ -305,7 +367,8 @@ func TestExpression(t *testing.T) {
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 1,
+				"<module>",
+				0, 0, 0, 0, 1,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_CONST, 0, 0,
@@ -318,16 +381,19 @@ func TestExpression(t *testing.T) {
 					g.STORE_LOCAL, 0, 0,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{21, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 9},
+					{7, 1, 14},
+					{18, 1, 5},
+					{21, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 }
 
 func TestAssignment(t *testing.T) {
 
 	mod := NewCompiler(newAnalyzer("let a = 1;\nconst b = \n2;a = 3;")).Compile()
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{
 			g.MakeInt(2),
 			g.MakeInt(3)},
@@ -335,7 +401,8 @@ func TestAssignment(t *testing.T) {
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 2,
+				"<module>",
+				0, 0, 0, 0, 2,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_ONE,
@@ -347,13 +414,16 @@ func TestAssignment(t *testing.T) {
 					g.STORE_LOCAL, 0, 0,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{5, 3},
-					{8, 2},
-					{11, 3},
-					{18, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 9},
+					{2, 1, 5},
+					{5, 3, 1},
+					{8, 2, 7},
+					{11, 3, 7},
+					{14, 3, 5},
+					{15, 3, 3},
+					{18, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 }
 
 func TestShift(t *testing.T) {
@@ -377,6 +447,7 @@ func TestIf(t *testing.T) {
 	anl := newAnalyzer(source)
 	mod := NewCompiler(anl).Compile()
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{
 			g.MakeInt(3),
 			g.MakeInt(2),
@@ -385,7 +456,8 @@ func TestIf(t *testing.T) {
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 1,
+				"<module>",
+				0, 0, 0, 0, 1,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_CONST, 0, 0,
@@ -396,10 +468,15 @@ func TestIf(t *testing.T) {
 					g.STORE_LOCAL, 0, 0,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{17, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 5},
+					{4, 1, 10},
+					{7, 1, 7},
+					{8, 1, 10},
+					{11, 1, 23},
+					{14, 1, 19},
+					{17, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 
 	source = `let a = 1;
 		if (false) {
@@ -412,6 +489,7 @@ func TestIf(t *testing.T) {
 	anl = newAnalyzer(source)
 	mod = NewCompiler(anl).Compile()
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{
 			g.MakeInt(2),
 			g.MakeInt(3),
@@ -420,7 +498,8 @@ func TestIf(t *testing.T) {
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 4,
+				"<module>",
+				0, 0, 0, 0, 4,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_ONE,
@@ -436,15 +515,20 @@ func TestIf(t *testing.T) {
 					g.STORE_LOCAL, 0, 3,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{5, 2},
-					{9, 3},
-					{15, 4},
-					{18, 5},
-					{24, 7},
-					{30, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 9},
+					{2, 1, 5},
+					{5, 2, 7},
+					{6, 2, 11},
+					{9, 3, 15},
+					{12, 3, 11},
+					{15, 4, 10},
+					{18, 5, 15},
+					{21, 5, 11},
+					{24, 7, 11},
+					{27, 7, 7},
+					{30, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 }
 
 func TestWhile(t *testing.T) {
@@ -452,13 +536,15 @@ func TestWhile(t *testing.T) {
 	source := "let a = 1; while (0 < 1) { let b = 2; }"
 	mod := NewCompiler(newAnalyzer(source)).Compile()
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{
 			g.MakeInt(2)},
 		nil,
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 2,
+				"<module>",
+				0, 0, 0, 0, 2,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_ONE,
@@ -472,14 +558,23 @@ func TestWhile(t *testing.T) {
 					g.JUMP, 0, 5,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{20, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 9},
+					{2, 1, 5},
+					{5, 1, 19},
+					{6, 1, 23},
+					{7, 1, 21},
+					{8, 1, 23},
+					{11, 1, 36},
+					{14, 1, 32},
+					{17, 1, 39},
+					{20, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 
 	source = "let a = 'z'; while (0 < 1) \n{ break; continue; let b = 2; } let c = 3;"
 	mod = NewCompiler(newAnalyzer(source)).Compile()
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{
 			g.MakeStr("z"),
 			g.MakeInt(2),
@@ -488,7 +583,8 @@ func TestWhile(t *testing.T) {
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 3,
+				"<module>",
+				0, 0, 0, 0, 3,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_CONST, 0, 0,
@@ -506,11 +602,22 @@ func TestWhile(t *testing.T) {
 					g.STORE_LOCAL, 0, 2,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{13, 2},
-					{34, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 9},
+					{4, 1, 5},
+					{7, 1, 21},
+					{8, 1, 25},
+					{9, 1, 23},
+					{10, 1, 25},
+					{13, 2, 3},
+					{16, 2, 10},
+					{19, 2, 28},
+					{22, 2, 24},
+					{25, 2, 31},
+					{28, 2, 41},
+					{31, 2, 37},
+					{34, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 }
 
 func TestReturn(t *testing.T) {
@@ -520,27 +627,30 @@ func TestReturn(t *testing.T) {
 	mod := NewCompiler(anl).Compile()
 
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{},
 		nil,
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 0,
+				"<module>",
+				0, 0, 0, 0, 0,
 				[]byte{
 					g.LOAD_NULL,
 					g.RETURN,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{2, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 1},
+					{2, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 
 	source = "let a = 1; return a \n- 2; a = 3;"
 	anl = newAnalyzer(source)
 	mod = NewCompiler(anl).Compile()
 
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{
 			g.MakeInt(2),
 			g.MakeInt(3)},
@@ -548,7 +658,8 @@ func TestReturn(t *testing.T) {
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 1,
+				"<module>",
+				0, 0, 0, 0, 1,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_ONE,
@@ -562,13 +673,18 @@ func TestReturn(t *testing.T) {
 					g.STORE_LOCAL, 0, 0,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 1},
-					{8, 2},
-					{12, 1},
-					{13, 2},
-					{20, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 1, 9},
+					{2, 1, 5},
+					{5, 1, 19},
+					{8, 2, 3},
+					{11, 2, 1},
+					{12, 1, 12},
+					{13, 2, 10},
+					{16, 2, 8},
+					{17, 2, 6},
+					{20, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 }
 
 func TestFunc(t *testing.T) {
@@ -592,13 +708,14 @@ let b = fn(x) {
 	//fmt.Println(mod)
 
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{
 			g.MakeInt(42),
 			g.MakeInt(7)},
 		nil,
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
-			&g.Template{0, 0, 2,
+			&g.Template{"<module>", 0, 0, 0, 0, 2,
 				[]byte{
 					g.LOAD_NULL,
 					g.NEW_FUNC, 0, 1,
@@ -607,22 +724,24 @@ let b = fn(x) {
 					g.STORE_LOCAL, 0, 1,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 2},
-					{7, 3},
-					{13, 0}},
-				nil},
-			&g.Template{0, 0, 0,
+					{0, 0, 0},
+					{1, 2, 9},
+					{4, 2, 5},
+					{7, 3, 9},
+					{10, 3, 5},
+					{13, 0, 0}},
+				nil, 0, nil, nil},
+			&g.Template{"a", 0, 0, 0, 0, 0,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_CONST, 0, 0,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 2},
-					{4, 0}},
-				nil},
-			&g.Template{1, 0, 2,
+					{0, 0, 0},
+					{1, 2, 16},
+					{4, 0, 0}},
+				nil, 0, nil, nil},
+			&g.Template{"b", 1, 1, 1, 0, 2,
 				[]byte{
 					g.LOAD_NULL,
 					g.NEW_FUNC, 0, 3,
@@ -636,12 +755,19 @@ let b = fn(x) {
 					g.PLUS,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 4},
-					{7, 7},
-					{24, 0}},
-				nil},
-			&g.Template{1, 0, 1,
+					{0, 0, 0},
+					{1, 4, 13},
+					{4, 4, 9},
+					{7, 7, 5},
+					{10, 7, 9},
+					{13, 7, 7},
+					{14, 7, 13},
+					{17, 7, 15},
+					{20, 7, 13},
+					{23, 7, 11},
+					{24, 0, 0}},
+				nil, 0, nil, nil},
+			&g.Template{"c", 1, 1, 1, 0, 1,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_LOCAL, 0, 0,
@@ -649,10 +775,12 @@ let b = fn(x) {
 					g.MUL,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 5},
-					{8, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 5, 9},
+					{4, 5, 13},
+					{7, 5, 11},
+					{8, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 
 	source = `
 let a = fn() { };
@@ -672,6 +800,7 @@ c(2, 3);
 	//fmt.Println(mod)
 
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{
 			g.MakeInt(2),
 			g.MakeInt(3),
@@ -679,7 +808,7 @@ c(2, 3);
 		nil,
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
-			&g.Template{0, 0, 3,
+			&g.Template{"<module>", 0, 0, 0, 0, 3,
 				[]byte{
 					g.LOAD_NULL,
 					g.NEW_FUNC, 0, 1,
@@ -699,36 +828,44 @@ c(2, 3);
 					g.INVOKE, 0, 2,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 2},
-					{7, 3},
-					{13, 4},
-					{19, 5},
-					{25, 6},
-					{32, 7},
-					{44, 0}},
-				nil},
-
-			&g.Template{0, 0, 0,
+					{0, 0, 0},
+					{1, 2, 9},
+					{4, 2, 5},
+					{7, 3, 9},
+					{10, 3, 5},
+					{13, 4, 9},
+					{16, 4, 5},
+					{19, 5, 1},
+					{25, 6, 1},
+					{28, 6, 3},
+					{29, 6, 1},
+					{32, 7, 1},
+					{35, 7, 3},
+					{38, 7, 6},
+					{41, 7, 1},
+					{44, 0, 0}},
+				nil, 0, nil, nil},
+
+			&g.Template{"a", 0, 0, 0, 0, 0,
 				[]byte{
 					g.LOAD_NULL,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0}},
-				nil},
+					{0, 0, 0}},
+				nil, 0, nil, nil},
 
-			&g.Template{1, 0, 1,
+			&g.Template{"b", 1, 1, 1, 0, 1,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_LOCAL, 0, 0,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 3},
-					{4, 0}},
-				nil},
+					{0, 0, 0},
+					{1, 3, 17},
+					{4, 0, 0}},
+				nil, 0, nil, nil},
 
-			&g.Template{2, 0, 3,
+			&g.Template{"c", 2, 2, 2, 0, 3,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_CONST, 0, 2,
@@ -740,10 +877,16 @@ c(2, 3);
 					g.MUL,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 4},
-					{18, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 4, 28},
+					{4, 4, 24},
+					{7, 4, 31},
+					{10, 4, 35},
+					{13, 4, 33},
+					{14, 4, 39},
+					{17, 4, 37},
+					{18, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 }
 
 func TestCapture(t *testing.T) {
@@ -760,22 +903,24 @@ const accumGen = fn(n) {
 	mod := NewCompiler(anl).Compile()
 
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{},
 		nil,
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
-			&g.Template{0, 0, 1,
+			&g.Template{"<module>", 0, 0, 0, 0, 1,
 				[]byte{
 					g.LOAD_NULL,
 					g.NEW_FUNC, 0, 1,
 					g.STORE_LOCAL, 0, 0,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 2},
-					{7, 0}},
-				nil},
-			&g.Template{1, 0, 1,
+					{0, 0, 0},
+					{1, 2, 18},
+					{4, 2, 7},
+					{7, 0, 0}},
+				nil, 0, nil, nil},
+			&g.Template{"accumGen", 1, 1, 1, 0, 1,
 				[]byte{
 					g.LOAD_NULL,
 					g.NEW_FUNC, 0, 2,
@@ -783,11 +928,12 @@ const accumGen = fn(n) {
 					g.RETURN,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 3},
-					{8, 0}},
-				nil},
-			&g.Template{1, 1, 1,
+					{0, 0, 0},
+					{1, 3, 12},
+					{7, 3, 5},
+					{8, 0, 0}},
+				nil, 0, nil, nil},
+			&g.Template{"<lambda>", 1, 1, 1, 1, 1,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_CAPTURE, 0, 0,
@@ -799,11 +945,16 @@ const accumGen = fn(n) {
 					g.RETURN,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 4},
-					{12, 5},
-					{16, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 4, 13},
+					{4, 4, 17},
+					{7, 4, 15},
+					{8, 4, 11},
+					{9, 4, 9},
+					{12, 5, 16},
+					{15, 5, 9},
+					{16, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 
 	source = `
 let z = 2;
@@ -823,12 +974,13 @@ const accumGen = fn(n) {
 	//fmt.Println(mod)
 
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{
 			g.MakeInt(2)},
 		nil,
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
-			&g.Template{0, 0, 2,
+			&g.Template{"<module>", 0, 0, 0, 0, 2,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_CONST, 0, 0,
@@ -838,12 +990,14 @@ const accumGen = fn(n) {
 					g.STORE_LOCAL, 0, 1,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 2},
-					{7, 3},
-					{16, 0}},
-				nil},
-			&g.Template{1, 1, 1,
+					{0, 0, 0},
+					{1, 2, 9},
+					{4, 2, 5},
+					{7, 3, 18},
+					{13, 3, 7},
+					{16, 0, 0}},
+				nil, 0, nil, nil},
+			&g.Template{"accumGen", 1, 1, 1, 1, 1,
 				[]byte{
 					g.LOAD_NULL,
 					g.NEW_FUNC, 0, 2,
@@ -852,11 +1006,12 @@ const accumGen = fn(n) {
 					g.RETURN,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 4},
-					{11, 0}},
-				nil},
-			&g.Template{1, 2, 1,
+					{0, 0, 0},
+					{1, 4, 12},
+					{10, 4, 5},
+					{11, 0, 0}},
+				nil, 0, nil, nil},
+			&g.Template{"<lambda>", 1, 1, 1, 2, 1,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_CAPTURE, 0, 0,
@@ -870,11 +1025,18 @@ const accumGen = fn(n) {
 					g.RETURN,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 5},
-					{16, 6},
-					{20, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 5, 13},
+					{4, 5, 17},
+					{7, 5, 15},
+					{8, 5, 21},
+					{11, 5, 19},
+					{12, 5, 11},
+					{13, 5, 9},
+					{16, 6, 16},
+					{19, 6, 9},
+					{20, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 }
 
 func TestPostfix(t *testing.T) {
@@ -894,6 +1056,7 @@ let d = b--;
 	//fmt.Println(mod)
 
 	ok(t, mod, &g.BytecodeModule{
+		"",
 		[]g.Basic{
 			g.MakeInt(int64(10)),
 			g.MakeInt(int64(20))},
@@ -901,7 +1064,8 @@ let d = b--;
 		[][]*g.StructEntryDef{},
 		[]*g.Template{
 			&g.Template{
-				0, 0, 4,
+				"<module>",
+				0, 0, 0, 0, 4,
 				[]byte{
 					g.LOAD_NULL,
 					g.LOAD_CONST, 0, 0,
@@ -922,13 +1086,21 @@ let d = b--;
 					g.STORE_LOCAL, 0, 3,
 					g.RETURN},
 				[]g.LineNumberEntry{
-					{0, 0},
-					{1, 2},
-					{7, 3},
-					{13, 4},
-					{25, 5},
-					{37, 0}},
-				nil}}, contents()})
+					{0, 0, 0},
+					{1, 2, 9},
+					{4, 2, 5},
+					{7, 3, 9},
+					{10, 3, 5},
+					{13, 4, 9},
+					{17, 4, 10},
+					{19, 4, 9},
+					{22, 4, 5},
+					{25, 5, 9},
+					{29, 5, 10},
+					{31, 5, 9},
+					{34, 5, 5},
+					{37, 0, 0}},
+				nil, 0, nil, nil}}, nil, nil, contents()})
 }
 
 func TestPool(t *testing.T) {
@@ -985,3 +1157,120 @@ try {
 	//	fmt.Printf("%s\n", ast.Dump(anl.Module()))
 	//	fmt.Println(mod)
 }
+
+func TestImport(t *testing.T) {
+
+	source := `
+import foo;
+import bar as baz;
+`
+	anl := newAnalyzer(source)
+	mod := NewCompiler(anl).Compile()
+
+	assert(t, reflect.DeepEqual(
+		mod.Pool,
+		[]g.Basic{g.MakeStr("foo"), g.MakeStr("bar")}))
+	assert(t, reflect.DeepEqual(
+		mod.Templates[0].OpCodes,
+		[]byte{
+			g.LOAD_NULL,
+			g.IMPORT, 0, 0,
+			g.STORE_LOCAL, 0, 0,
+			g.IMPORT, 0, 1,
+			g.STORE_LOCAL, 0, 1,
+			g.RETURN}))
+}
+
+func TestFuncNames(t *testing.T) {
+
+	source := `
+fn a() {}
+pub fn b() {}
+let c = fn() {};
+const d = || => 1, e = 2;
+let s = struct { f: fn() {}, g: 3 };
+(|x| => x)(1);
+`
+	mod := NewCompiler(newAnalyzer(source)).Compile()
+	names := []string{}
+	for _, tpl := range mod.Templates {
+		names = append(names, tpl.Name)
+	}
+	assert(t, reflect.DeepEqual(names, []string{
+		"<module>", "a", "b", "c", "d", "f", "<lambda>"}))
+}
+
+func TestVariableNames(t *testing.T) {
+
+	source := `
+let a = 1;
+fn f(x) {
+    for y in [x] {
+        return fn() { return a + x + y; };
+    }
+}
+let s = struct { z: this };
+`
+	mod := NewCompiler(newAnalyzer(source)).Compile()
+	names := [][]string{}
+	for _, tpl := range mod.Templates {
+		names = append(names, tpl.LocalNames, tpl.CaptureNames)
+	}
+	assert(t, reflect.DeepEqual(names, [][]string{
+		{"f", "a", "this", "s"}, nil,
+		{"x", "y", "#synthetic0"}, {"a"},
+		{}, {"a", "x", "y"}}))
+}
+
+func TestBuiltinNames(t *testing.T) {
+
+	mod := NewCompiler(newAnalyzer("println(len([]), len);")).Compile()
+	assert(t, reflect.DeepEqual(mod.Builtins, []string{"", "println", "", "len"}))
+
+	mod = NewCompiler(newAnalyzer("let a = 1;")).Compile()
+	assert(t, reflect.DeepEqual(mod.Builtins, []string{}))
+}
+
+func TestDefaults(t *testing.T) {
+
+	source := `
+let f = fn(x, y = 2, z = x, rest...) { return y; };`
+
+	mod := NewCompiler(newAnalyzer(source)).Compile()
+	tpl := mod.Templates[1]
+	assert(t, tpl.Arity == 4 && tpl.MinArity == 1 && tpl.MaxArity == -1)
+	assert(t, tpl.Prologue == 6)
+	assert(t, reflect.DeepEqual(tpl.OpCodes, []byte{
+		g.LOAD_NULL,
+		g.LOAD_LOCAL, 0, 1,
+		g.RETURN,
+		g.RETURN,
+		g.JUMP_FALSE, 0, 15,
+		g.LOAD_CONST, 0, 0,
+		g.STORE_LOCAL, 0, 1,
+		g.JUMP_FALSE, 0, 24,
+		g.LOAD_LOCAL, 0, 0,
+		g.STORE_LOCAL, 0, 2,
+		g.LOAD_NULL,
+		g.JUMP, 0, 1}))
+}
+
+func TestNamedParams(t *testing.T) {
+
+	mod := NewCompiler(newAnalyzer("let a = 0; a(1, b: 2);")).Compile()
+	assert(t, reflect.DeepEqual(mod.Pool, []g.Basic{g.MakeInt(2), g.MakeStr("b")}))
+	assert(t, reflect.DeepEqual(mod.StructDefs, [][]*g.StructEntryDef{{{"b", false, false}}}))
+	assert(t, reflect.DeepEqual(mod.Templates[0].OpCodes, []byte{
+		g.LOAD_NULL,
+		g.LOAD_ZERO,
+		g.STORE_LOCAL, 0, 0,
+		g.LOAD_LOCAL, 0, 0,
+		g.LOAD_ONE,
+		g.NEW_STRUCT, 0, 0,
+		g.DUP,
+		g.LOAD_CONST, 0, 0,
+		g.INIT_FIELD, 0, 1,
+		g.POP,
+		g.INVOKE_NAMED, 0, 1,
+		g.RETURN}))
+}
@@ -477,7 +477,7 @@ func structFuncField(t *testing.T, stc Struct, name Str) NativeFunc {
 
 func structInvokeFunc(t *testing.T, stc Struct, name Str) Value {
 	f := structFuncField(t, stc, name)
-	v, err := f.Invoke([]Value{})
+	v, err := f.Invoke(nil, []Value{})
 	assert(t, err == nil)
 
 	return v
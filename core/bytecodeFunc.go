@@ -23,20 +23,23 @@ import (
 type BytecodeFunc interface {
 	Func
 
+	Module() *BytecodeModule
 	Template() *Template
 	GetCapture(int) *Ref
 	PushCapture(*Ref)
 }
 
 type bytecodeFunc struct {
+	module   *BytecodeModule
 	template *Template
 	captures []*Ref
 }
 
-// Called via NEW_FUNC opcode at runtime
-func NewBytecodeFunc(template *Template) BytecodeFunc {
+// Called via NEW_FUNC opcode at runtime.  The module is the one
+// that the template belongs to.
+func NewBytecodeFunc(module *BytecodeModule, template *Template) BytecodeFunc {
 	captures := make([]*Ref, 0, template.NumCaptures)
-	return &bytecodeFunc{template, captures}
+	return &bytecodeFunc{module, template, captures}
 }
 
 func (f *bytecodeFunc) funcMarker() {}
@@ -80,6 +83,10 @@ func (f *bytecodeFunc) Plus(v Value) (Value, Error) {
 	}
 }
 
+func (f *bytecodeFunc) Module() *BytecodeModule {
+	return f.module
+}
+
 func (f *bytecodeFunc) Template() *Template {
 	return f.template
 }
@@ -99,18 +106,104 @@ func (f *bytecodeFunc) PushCapture(ref *Ref) {
 // instance.  Templates are created at compile time, and
 // are immutable at run time.
 type Template struct {
-	Arity             int
+	Name string
+
+	// Arity is the number of params, including the optional and variadic
+	// ones.  MinArity is the number of required params, and MaxArity is
+	// the number of params that are not variadic, or -1 if the last param
+	// is variadic.
+	Arity    int
+	MinArity int
+	MaxArity int
+
 	NumCaptures       int
 	NumLocals         int
 	OpCodes           []byte
 	LineNumberTable   []LineNumberEntry
 	ExceptionHandlers []ExceptionHandler
+
+	// The instruction pointer at which invocations that omit optional
+	// params begin, so that the default values of those params are
+	// evaluated first.  The prologue expects a Bool on the stack for each
+	// optional param, the first one on top, that is true if the param was
+	// omitted.  Other invocations begin at 0.  Execution never returns to
+	// the prologue, or to 0, once it has begun.
+	Prologue int
+
+	// The names of the local variables and captures, by index, so that
+	// debuggers can show them.  They are empty if the names are not known.
+	// The names of variables that the compiler creates begin with '#'.
+	LocalNames   []string
+	CaptureNames []string
+}
+
+// IsVariadic returns whether the surplus params of an invocation are
+// collected into a List.
+func (t *Template) IsVariadic() bool {
+	return t.MaxArity == -1
+}
+
+// NumOptional returns the number of optional params.
+func (t *Template) NumOptional() int {
+	if t.IsVariadic() {
+		return t.Arity - t.MinArity - 1
+	}
+	return t.Arity - t.MinArity
+}
+
+// IsEntry returns whether an invocation begins at the given instruction
+// pointer, so that an opcode there is the first one that it executes.
+func (t *Template) IsEntry(instPtr int) bool {
+	return instPtr == 0 || (instPtr == t.Prologue && t.NumOptional() > 0)
+}
+
+// ParamIndex returns the index of the param that has the given name, or -1
+// if there is no such param.  A variadic param cannot be found by name, and
+// neither can any other param if the names of the locals are not known.
+func (t *Template) ParamIndex(name string) int {
+	n := t.Arity
+	if t.IsVariadic() {
+		n--
+	}
+	if len(t.LocalNames) < n {
+		return -1
+	}
+	for j, ln := range t.LocalNames[:n] {
+		if ln == name {
+			return j
+		}
+	}
+	return -1
 }
 
-// LineNumberEntry tracks which sequence of opcodes are on a given line
+// CheckArity returns an ArityMismatch error if a function cannot be invoked
+// with the given number of params.
+func (t *Template) CheckArity(numParams int) Error {
+
+	if numParams >= t.MinArity && (t.IsVariadic() || numParams <= t.MaxArity) {
+		return nil
+	}
+
+	var expected string
+	switch {
+	case t.IsVariadic():
+		expected = fmt.Sprintf("at least %d", t.MinArity)
+	case t.MinArity == t.MaxArity:
+		expected = fmt.Sprintf("%d", t.MinArity)
+	case t.MinArity+1 == t.MaxArity:
+		expected = fmt.Sprintf("%d or %d", t.MinArity, t.MaxArity)
+	default:
+		expected = fmt.Sprintf("%d to %d", t.MinArity, t.MaxArity)
+	}
+	return ArityMismatchError(expected, numParams)
+}
+
+// LineNumberEntry tracks which sequence of opcodes begin at
+// a given line and column
 type LineNumberEntry struct {
 	Index   int
 	LineNum int
+	ColNum  int
 }
 
 // ExceptionHandler contains the instruction pointers for catch and finally
@@ -121,16 +214,25 @@ type ExceptionHandler struct {
 	Finally int
 }
 
-// Return the line number for the opcode at the ven instruction pointer
+// Return the line number for the opcode at the given instruction pointer
 func (t *Template) LineNumber(instPtr int) int {
+	return t.lineNumberEntry(instPtr).LineNum
+}
+
+// Return the column number for the opcode at the given instruction pointer
+func (t *Template) ColNumber(instPtr int) int {
+	return t.lineNumberEntry(instPtr).ColNum
+}
+
+func (t *Template) lineNumberEntry(instPtr int) LineNumberEntry {
 
 	table := t.LineNumberTable
 	n := len(table) - 1
 
 	for i := 0; i < n; i++ {
 		if (instPtr >= table[i].Index) && (instPtr < table[i+1].Index) {
-			return table[i].LineNum
+			return table[i]
 		}
 	}
-	return table[n].LineNum
+	return table[n]
 }
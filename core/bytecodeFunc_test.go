@@ -21,8 +21,8 @@ import (
 
 func TestBytecodeFunc(t *testing.T) {
 
-	a := NewBytecodeFunc(&Template{})
-	b := NewBytecodeFunc(&Template{})
+	a := NewBytecodeFunc(nil, &Template{})
+	b := NewBytecodeFunc(nil, &Template{})
 
 	okType(t, a, TFUNC)
 	okType(t, b, TFUNC)
@@ -35,14 +35,14 @@ func TestBytecodeFunc(t *testing.T) {
 
 func TestLineNumber(t *testing.T) {
 
-	tp := &Template{0, 0, 0, nil,
+	tp := &Template{"f", 0, 0, 0, 0, 0, nil,
 		[]LineNumberEntry{
-			{0, 0},
-			{1, 2},
-			{11, 3},
-			{20, 4},
-			{29, 0}},
-		nil}
+			{0, 0, 0},
+			{1, 2, 1},
+			{11, 3, 5},
+			{20, 4, 1},
+			{29, 0, 0}},
+		nil, 0, nil, nil}
 
 	assert(t, tp.LineNumber(0) == 0)
 	assert(t, tp.LineNumber(1) == 2)
@@ -52,4 +52,45 @@ func TestLineNumber(t *testing.T) {
 	assert(t, tp.LineNumber(20) == 4)
 	assert(t, tp.LineNumber(28) == 4)
 	assert(t, tp.LineNumber(29) == 0)
+
+	assert(t, tp.ColNumber(0) == 0)
+	assert(t, tp.ColNumber(10) == 1)
+	assert(t, tp.ColNumber(11) == 5)
+	assert(t, tp.ColNumber(19) == 5)
+}
+
+func TestCheckArity(t *testing.T) {
+
+	arity := func(min int, max int) *Template {
+		tpl := &Template{}
+		tpl.MinArity, tpl.MaxArity = min, max
+		return tpl
+	}
+
+	assert(t, arity(1, 1).CheckArity(1) == nil)
+	assert(t, arity(1, 1).CheckArity(2).Error() == "ArityMismatch: Expected 1 params, got 2")
+	assert(t, arity(1, 2).CheckArity(2) == nil)
+	assert(t, arity(1, 2).CheckArity(0).Error() == "ArityMismatch: Expected 1 or 2 params, got 0")
+	assert(t, arity(0, 3).CheckArity(4).Error() == "ArityMismatch: Expected 0 to 3 params, got 4")
+	assert(t, arity(2, -1).CheckArity(5) == nil)
+	assert(t, arity(2, -1).CheckArity(1).Error() == "ArityMismatch: Expected at least 2 params, got 1")
+
+	tpl := arity(1, 3)
+	tpl.Arity, tpl.Prologue = 3, 6
+	assert(t, tpl.NumOptional() == 2)
+	assert(t, tpl.IsEntry(0) && tpl.IsEntry(6))
+	assert(t, !tpl.IsEntry(1) && !tpl.IsEntry(9))
+}
+
+func TestParamIndex(t *testing.T) {
+
+	tpl := &Template{}
+	tpl.Arity, tpl.MinArity, tpl.MaxArity = 3, 1, -1
+	assert(t, tpl.ParamIndex("a") == -1)
+
+	tpl.LocalNames = []string{"a", "b", "rest", "x"}
+	assert(t, tpl.ParamIndex("a") == 0)
+	assert(t, tpl.ParamIndex("b") == 1)
+	assert(t, tpl.ParamIndex("rest") == -1)
+	assert(t, tpl.ParamIndex("x") == -1)
 }
@@ -75,7 +75,7 @@ func (ch *channel) GetField(key Str) (Value, Error) {
 
 	case "send":
 		return &intrinsicFunc{ch, "send", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 1 {
 					return nil, ArityMismatchError("1", len(values))
 				}
@@ -86,7 +86,7 @@ func (ch *channel) GetField(key Str) (Value, Error) {
 
 	case "recv":
 		return &intrinsicFunc{ch, "recv", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 0 {
 					return nil, ArityMismatchError("0", len(values))
 				}
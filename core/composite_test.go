@@ -165,7 +165,7 @@ func TestNativeProp(t *testing.T) {
 	var propValue Value = ZERO
 
 	getter := &nativeFunc{
-		func(values []Value) (Value, Error) {
+		func(ev Eval, values []Value) (Value, Error) {
 			if len(values) != 0 {
 				return nil, ArityMismatchError("0", len(values))
 			}
@@ -173,7 +173,7 @@ func TestNativeProp(t *testing.T) {
 		}}
 
 	setter := &nativeFunc{
-		func(values []Value) (Value, Error) {
+		func(ev Eval, values []Value) (Value, Error) {
 			if len(values) != 1 {
 				return nil, ArityMismatchError("1", len(values))
 			}
@@ -658,3 +658,58 @@ func TestSetIterator(t *testing.T) {
 	}
 	ok(t, s, nil, MakeStr("bac"))
 }
+
+func TestDiff(t *testing.T) {
+
+	okDiff := func(actual Value, expected Value, lines ...string) {
+		if lines == nil {
+			lines = []string{}
+		}
+		if diff := Diff(actual, expected); !reflect.DeepEqual(diff, lines) {
+			t.Error(diff, " != ", lines)
+		}
+	}
+
+	okDiff(ONE, ONE)
+	okDiff(ONE, MakeInt(2), "1, expected 2")
+	okDiff(ONE, MakeStr("1"), "1, expected 1")
+
+	// lists and tuples
+	okDiff(
+		NewList([]Value{ONE, MakeInt(2), MakeInt(3)}),
+		NewList([]Value{ONE, MakeInt(4)}),
+		"[1]: 2, expected 4",
+		"[2]: unexpected 3")
+	okDiff(
+		NewTuple([]Value{ONE, NewList([]Value{})}),
+		NewTuple([]Value{ONE, NewList([]Value{ZERO})}),
+		"[1][0]: missing, expected 0")
+	okDiff(
+		NewList([]Value{ONE}),
+		NewTuple([]Value{ONE, ONE}),
+		"[ 1 ], expected (1, 1)")
+
+	// dicts
+	okDiff(
+		NewDict([]*HEntry{
+			{MakeStr("a"), ONE},
+			{MakeStr("b"), MakeInt(2)}}),
+		NewDict([]*HEntry{
+			{MakeStr("a"), ZERO},
+			{ONE, MakeInt(2)}}),
+		"[1]: missing, expected 2",
+		"['a']: 1, expected 0",
+		"['b']: unexpected 2")
+
+	// structs
+	okDiff(
+		newStruct([]*StructEntry{
+			{"a", true, false, ONE},
+			{"b", true, false, NewList([]Value{ONE})}}),
+		newStruct([]*StructEntry{
+			{"b", true, false, NewList([]Value{ZERO})},
+			{"c", true, false, ONE}}),
+		".a: unexpected 1",
+		".b[0]: 1, expected 0",
+		".c: missing, expected 1")
+}
@@ -0,0 +1,584 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+//---------------------------------------------------------------
+// Conversion between Go values and Golem values.
+//
+// Go structs are converted to and from Golem Structs.  Only exported
+// fields are converted.  A field's name can be changed with a 'golem'
+// tag, and a field tagged with `golem:"-"` is skipped:
+//
+//     type User struct {
+//         Name  string `golem:"name"`
+//         Email string `golem:"email"`
+//         Token string `golem:"-"`
+//     }
+
+var (
+	valueType   = reflect.TypeOf((*Value)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// FromGo converts a Go value into a Golem value.  Bools, numbers and strings
+// become basic values, slices and arrays become Lists, maps become Dicts,
+// structs become Structs, and funcs become NativeFuncs (see FromGoFunc).
+// Nil pointers, slices, maps, funcs and interfaces become null.  Values that
+// are already Golem values are returned as-is.  The value must not contain
+// any cycles.
+func FromGo(v interface{}) (Value, Error) {
+	if v == nil {
+		return NULL, nil
+	}
+	return fromGo(reflect.ValueOf(v))
+}
+
+func fromGo(rv reflect.Value) (Value, Error) {
+
+	if rv.Type().Implements(valueType) {
+		if (rv.Kind() == reflect.Interface || rv.Kind() == reflect.Ptr) && rv.IsNil() {
+			return NULL, nil
+		}
+		return rv.Interface().(Value), nil
+	}
+
+	switch rv.Kind() {
+
+	case reflect.Bool:
+		return MakeBool(rv.Bool()), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return MakeInt(rv.Int()), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		n := rv.Uint()
+		if n > (1<<63)-1 {
+			return nil, InvalidArgumentError(
+				fmt.Sprintf("Go value %d is too large for 'Int'", n))
+		}
+		return MakeInt(int64(n)), nil
+
+	case reflect.Float32, reflect.Float64:
+		return MakeFloat(rv.Float()), nil
+
+	case reflect.String:
+		return MakeStr(rv.String()), nil
+
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return NULL, nil
+		}
+		return fromGo(rv.Elem())
+
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return NULL, nil
+		}
+		values := make([]Value, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			val, err := fromGo(rv.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			values[i] = val
+		}
+		return NewList(values), nil
+
+	case reflect.Map:
+		if rv.IsNil() {
+			return NULL, nil
+		}
+		hm := EmptyHashMap()
+		for _, key := range rv.MapKeys() {
+			k, err := fromGo(key)
+			if err != nil {
+				return nil, err
+			}
+			v, err := fromGo(rv.MapIndex(key))
+			if err != nil {
+				return nil, err
+			}
+			if err = hm.Put(k, v); err != nil {
+				return nil, err
+			}
+		}
+		return &dict{hm}, nil
+
+	case reflect.Struct:
+		fields := structFields(rv.Type())
+		entries := make([]*StructEntry, len(fields))
+		for i, f := range fields {
+			val, err := fromGo(rv.Field(f.index))
+			if err != nil {
+				return nil, err
+			}
+			entries[i] = &StructEntry{f.name, false, false, val}
+		}
+		return NewStruct(entries)
+
+	case reflect.Func:
+		if rv.IsNil() {
+			return NULL, nil
+		}
+		return FromGoFunc(rv.Interface())
+
+	default:
+		return nil, TypeMismatchError(
+			fmt.Sprintf("Cannot convert Go type '%s'", rv.Type()))
+	}
+}
+
+// ToGo converts a Golem value into a Go value, and stores the result in
+// the value that ptr points to.  The conversion is driven by the type
+// of the Go value, using the same mapping as FromGo.  When the Go value
+// is an empty interface, the natural Go type is chosen: Lists, Tuples and
+// Sets become []interface{}, Dicts become map[interface{}]interface{},
+// and Structs become map[string]interface{}.
+func ToGo(val Value, ptr interface{}) Error {
+
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return InvalidArgumentError("Expected a non-nil pointer")
+	}
+	return toGo(val, rv.Elem())
+}
+
+func toGo(val Value, rv reflect.Value) Error {
+
+	t := rv.Type()
+
+	// golem values are stored directly
+	if t.Kind() == reflect.Interface && t.NumMethod() > 0 {
+		if reflect.TypeOf(val).AssignableTo(t) {
+			rv.Set(reflect.ValueOf(val))
+			return nil
+		}
+		return cannotConvert(val, t)
+	}
+
+	// null
+	if val == NULL {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			rv.Set(reflect.Zero(t))
+			return nil
+		default:
+			return cannotConvert(val, t)
+		}
+	}
+
+	switch t.Kind() {
+
+	case reflect.Interface:
+		natural, err := naturalGo(val)
+		if err != nil {
+			return err
+		}
+		if natural == nil {
+			rv.Set(reflect.Zero(t))
+		} else {
+			rv.Set(reflect.ValueOf(natural))
+		}
+		return nil
+
+	case reflect.Ptr:
+		elem := reflect.New(t.Elem())
+		if err := toGo(val, elem.Elem()); err != nil {
+			return err
+		}
+		rv.Set(elem)
+		return nil
+
+	case reflect.Bool:
+		if b, ok := val.(Bool); ok {
+			rv.SetBool(b.BoolVal())
+			return nil
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := val.(Int); ok {
+			if rv.OverflowInt(i.IntVal()) {
+				return overflow(val, t)
+			}
+			rv.SetInt(i.IntVal())
+			return nil
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		if i, ok := val.(Int); ok {
+			if i.IntVal() < 0 || rv.OverflowUint(uint64(i.IntVal())) {
+				return overflow(val, t)
+			}
+			rv.SetUint(uint64(i.IntVal()))
+			return nil
+		}
+
+	case reflect.Float32, reflect.Float64:
+		if n, ok := val.(Number); ok {
+			rv.SetFloat(n.FloatVal())
+			return nil
+		}
+
+	case reflect.String:
+		if s, ok := val.(Str); ok {
+			rv.SetString(s.String())
+			return nil
+		}
+
+	case reflect.Slice:
+		values, ok := sequenceValues(val)
+		if !ok {
+			return cannotConvert(val, t)
+		}
+		slice := reflect.MakeSlice(t, len(values), len(values))
+		for i, v := range values {
+			if err := toGo(v, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(slice)
+		return nil
+
+	case reflect.Array:
+		values, ok := sequenceValues(val)
+		if !ok {
+			return cannotConvert(val, t)
+		}
+		if len(values) != t.Len() {
+			return InvalidArgumentError(fmt.Sprintf(
+				"Cannot convert %s of length %d to Go type '%s'",
+				val.TypeOf(), len(values), t))
+		}
+		for i, v := range values {
+			if err := toGo(v, rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		m := reflect.MakeMap(t)
+		switch tv := val.(type) {
+		case *dict:
+			itr := tv.hashMap.Iterator()
+			for itr.Next() {
+				e := itr.Get()
+				if err := putGo(m, e.Key, e.Value); err != nil {
+					return err
+				}
+			}
+		case *_struct:
+			for _, key := range tv.Keys() {
+				v, err := tv.GetField(MakeStr(key))
+				if err != nil {
+					return err
+				}
+				if err := putGo(m, MakeStr(key), v); err != nil {
+					return err
+				}
+			}
+		default:
+			return cannotConvert(val, t)
+		}
+		rv.Set(m)
+		return nil
+
+	case reflect.Struct:
+		stc, ok := val.(Struct)
+		if !ok {
+			return cannotConvert(val, t)
+		}
+		for _, f := range structFields(t) {
+			has, err := stc.Has(MakeStr(f.name))
+			if err != nil {
+				return err
+			}
+			if !has.BoolVal() {
+				continue
+			}
+			v, err := stc.GetField(MakeStr(f.name))
+			if err != nil {
+				return err
+			}
+			if err := toGo(v, rv.Field(f.index)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return cannotConvert(val, t)
+}
+
+// Convert a Golem value into the Go value that best represents it.
+func naturalGo(val Value) (interface{}, Error) {
+
+	switch t := val.(type) {
+
+	case Bool:
+		return t.BoolVal(), nil
+	case Int:
+		return t.IntVal(), nil
+	case Float:
+		return t.FloatVal(), nil
+	case Str:
+		return t.String(), nil
+
+	case *list, tuple, *set:
+		var result []interface{}
+		return toNatural(val, &result)
+
+	case *dict:
+		var result map[interface{}]interface{}
+		return toNatural(val, &result)
+
+	case *_struct:
+		var result map[string]interface{}
+		return toNatural(val, &result)
+
+	default:
+		if val == NULL {
+			return nil, nil
+		}
+		return nil, TypeMismatchError(
+			fmt.Sprintf("Cannot convert %s to a Go value", val.TypeOf()))
+	}
+}
+
+func toNatural(val Value, ptr interface{}) (interface{}, Error) {
+	rv := reflect.ValueOf(ptr).Elem()
+	if err := toGo(val, rv); err != nil {
+		return nil, err
+	}
+	return rv.Interface(), nil
+}
+
+// Convert a key and value, and put them in a Go map.
+func putGo(m reflect.Value, key Value, val Value) Error {
+
+	t := m.Type()
+	k := reflect.New(t.Key()).Elem()
+	if err := toGo(key, k); err != nil {
+		return err
+	}
+	if !k.Type().Comparable() ||
+		(k.Kind() == reflect.Interface && !k.IsNil() && !k.Elem().Type().Comparable()) {
+		return TypeMismatchError(
+			fmt.Sprintf("Cannot use %s as a Go map key", key.TypeOf()))
+	}
+
+	v := reflect.New(t.Elem()).Elem()
+	if err := toGo(val, v); err != nil {
+		return err
+	}
+
+	m.SetMapIndex(k, v)
+	return nil
+}
+
+// The values of a Golem value that can be converted to a Go slice.
+func sequenceValues(val Value) ([]Value, bool) {
+
+	switch t := val.(type) {
+	case *list:
+		return t.array, true
+	case tuple:
+		return []Value(t), true
+	case *set:
+		values := []Value{}
+		itr := t.hashMap.Iterator()
+		for itr.Next() {
+			values = append(values, itr.Get().Key)
+		}
+		return values, true
+	default:
+		return nil, false
+	}
+}
+
+func cannotConvert(val Value, t reflect.Type) Error {
+	return TypeMismatchError(
+		fmt.Sprintf("Cannot convert %s to Go type '%s'", val.TypeOf(), t))
+}
+
+func overflow(val Value, t reflect.Type) Error {
+	return InvalidArgumentError(
+		fmt.Sprintf("Value %s overflows Go type '%s'", val.ToStr(), t))
+}
+
+//---------------------------------------------------------------
+// Go functions
+
+// contextual is implemented by an Eval that is running under a context.
+type contextual interface {
+	Context() context.Context
+}
+
+// FromGoFunc wraps a Go function as a NativeFunc.  The arguments are
+// converted with ToGo, and the results with FromGo.  A function with
+// no results returns null, and a function with several results returns
+// a Tuple.  If the last result is an error, then a non-nil error is thrown
+// as a Golem Error.  If the first parameter is a context.Context, then
+// it is supplied by the caller rather than by the script.
+func FromGoFunc(fn interface{}) (NativeFunc, Error) {
+
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		return nil, InvalidArgumentError("Expected a Go func")
+	}
+	t := rv.Type()
+
+	// context
+	hasCtx := t.NumIn() > 0 && t.In(0) == contextType
+	first := 0
+	if hasCtx {
+		first = 1
+	}
+
+	// arity
+	numParams := t.NumIn() - first
+	minArity := numParams
+	if t.IsVariadic() {
+		minArity--
+	}
+
+	// results
+	numOut := t.NumOut()
+	hasErr := numOut > 0 && t.Out(numOut-1) == errorType
+	if hasErr {
+		numOut--
+	}
+
+	return &nativeFunc{func(ev Eval, values []Value) (Value, Error) {
+
+		// check arity
+		if t.IsVariadic() {
+			if len(values) < minArity {
+				return nil, ArityMismatchError(
+					fmt.Sprintf("at least %d", minArity), len(values))
+			}
+		} else if len(values) != numParams {
+			return nil, ArityMismatchError(
+				fmt.Sprintf("%d", numParams), len(values))
+		}
+
+		// convert the arguments
+		args := make([]reflect.Value, first+len(values))
+		if hasCtx {
+			ctx := context.Background()
+			if c, ok := ev.(contextual); ok {
+				ctx = c.Context()
+			}
+			args[0] = reflect.ValueOf(&ctx).Elem()
+		}
+		for i, v := range values {
+			var pt reflect.Type
+			if t.IsVariadic() && i >= minArity {
+				pt = t.In(t.NumIn() - 1).Elem()
+			} else {
+				pt = t.In(first + i)
+			}
+
+			arg := reflect.New(pt).Elem()
+			if err := toGo(v, arg); err != nil {
+				return nil, argumentError(i, err)
+			}
+			args[first+i] = arg
+		}
+
+		// call
+		out := rv.Call(args)
+
+		// convert the results
+		if hasErr && !out[numOut].IsNil() {
+			err := out[numOut].Interface().(error)
+			if gerr, ok := err.(Error); ok {
+				return nil, gerr
+			}
+			return nil, makeError(GENERIC, err.Error())
+		}
+
+		switch numOut {
+		case 0:
+			return NULL, nil
+		case 1:
+			return fromGo(out[0])
+		default:
+			results := make([]Value, numOut)
+			for i := 0; i < numOut; i++ {
+				val, err := fromGo(out[i])
+				if err != nil {
+					return nil, err
+				}
+				results[i] = val
+			}
+			return NewTuple(results), nil
+		}
+	}}, nil
+}
+
+// Report which argument could not be converted.
+func argumentError(index int, err Error) Error {
+
+	msg, merr := err.Struct().GetField(MakeStr("msg"))
+	if merr != nil {
+		return err
+	}
+	return makeError(err.Kind(),
+		fmt.Sprintf("Argument %d: %s", index+1, msg.ToStr()))
+}
+
+//---------------------------------------------------------------
+// struct fields
+
+type goField struct {
+	name  string
+	index int
+}
+
+// The exported fields of a Go struct type, named by their 'golem' tag.
+func structFields(t reflect.Type) []goField {
+
+	fields := []goField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("golem"); ok {
+			tag = strings.Split(tag, ",")[0]
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, goField{name, i})
+	}
+	return fields
+}
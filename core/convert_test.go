@@ -0,0 +1,330 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type address struct {
+	City string `golem:"city"`
+	Zip  *int   `golem:"zip"`
+}
+
+type user struct {
+	Name    string         `golem:"name"`
+	Age     uint8          `golem:"age"`
+	Score   float64        `golem:"score"`
+	Tags    []string       `golem:"tags"`
+	Address address        `golem:"address"`
+	Props   map[string]int `golem:"props"`
+	Admin   bool
+	Token   string `golem:"-"`
+	secret  string
+}
+
+func TestFromGo(t *testing.T) {
+
+	v, err := FromGo(nil)
+	ok(t, v, err, NULL)
+
+	v, err = FromGo(true)
+	ok(t, v, err, TRUE)
+
+	v, err = FromGo(int8(-3))
+	ok(t, v, err, MakeInt(-3))
+
+	v, err = FromGo(uint(7))
+	ok(t, v, err, MakeInt(7))
+
+	v, err = FromGo(float32(0.5))
+	ok(t, v, err, MakeFloat(0.5))
+
+	v, err = FromGo("abc")
+	ok(t, v, err, MakeStr("abc"))
+
+	v, err = FromGo([]interface{}{1, "a", nil, []int{2}})
+	ok(t, v, err, NewList([]Value{
+		ONE, MakeStr("a"), NULL, NewList([]Value{MakeInt(2)})}))
+
+	v, err = FromGo([2]bool{true, false})
+	ok(t, v, err, NewList([]Value{TRUE, FALSE}))
+
+	v, err = FromGo(map[string]int{"a": 1})
+	assert(t, err == nil)
+	assert(t, v.Eq(NewDict([]*HEntry{{MakeStr("a"), ONE}})).BoolVal())
+
+	n := 5
+	v, err = FromGo(&n)
+	ok(t, v, err, MakeInt(5))
+
+	v, err = FromGo((*int)(nil))
+	ok(t, v, err, NULL)
+
+	v, err = FromGo(MakeStr("xyz"))
+	ok(t, v, err, MakeStr("xyz"))
+
+	zip := 12345
+	v, err = FromGo(user{
+		"Bob", 42, 1.5, []string{"x"},
+		address{"Paris", &zip},
+		map[string]int{"k": 2},
+		true, "tok", "shh"})
+	assert(t, err == nil)
+	stc := v.(Struct)
+	assert(t, len(stc.Keys()) == 7)
+	for key, expect := range map[string]string{
+		"name":  "Bob",
+		"age":   "42",
+		"score": "1.5",
+		"tags":  "[ x ]",
+		"props": "dict { k: 2 }",
+		"Admin": "true"} {
+
+		field, err := stc.GetField(MakeStr(key))
+		assert(t, err == nil)
+		assert(t, field.ToStr().String() == expect)
+	}
+	addr, err := stc.GetField(MakeStr("address"))
+	assert(t, err == nil)
+	city, err := addr.GetField(MakeStr("city"))
+	ok(t, city, err, MakeStr("Paris"))
+	z, err := addr.GetField(MakeStr("zip"))
+	ok(t, z, err, MakeInt(12345))
+
+	v, err = FromGo(uint64(1 << 63))
+	fail(t, v, err, "InvalidArgument: Go value 9223372036854775808 is too large for 'Int'")
+
+	v, err = FromGo(make(chan int))
+	fail(t, v, err, "TypeMismatch: Cannot convert Go type 'chan int'")
+
+	v, err = FromGo([]interface{}{make(chan int)})
+	fail(t, v, err, "TypeMismatch: Cannot convert Go type 'chan int'")
+}
+
+func TestToGo(t *testing.T) {
+
+	var b bool
+	assert(t, ToGo(TRUE, &b) == nil)
+	assert(t, b)
+
+	var i int16
+	assert(t, ToGo(MakeInt(-7), &i) == nil)
+	assert(t, i == -7)
+
+	var f float64
+	assert(t, ToGo(MakeInt(3), &f) == nil)
+	assert(t, f == 3.0)
+
+	var s string
+	assert(t, ToGo(MakeStr("abc"), &s) == nil)
+	assert(t, s == "abc")
+
+	var p *int
+	assert(t, ToGo(MakeInt(4), &p) == nil)
+	assert(t, *p == 4)
+	assert(t, ToGo(NULL, &p) == nil)
+	assert(t, p == nil)
+
+	var ints []int
+	assert(t, ToGo(NewList([]Value{ONE, MakeInt(2)}), &ints) == nil)
+	assert(t, reflect.DeepEqual(ints, []int{1, 2}))
+	assert(t, ToGo(NewTuple([]Value{ONE, MakeInt(2)}), &ints) == nil)
+	assert(t, reflect.DeepEqual(ints, []int{1, 2}))
+
+	var arr [2]string
+	assert(t, ToGo(NewList([]Value{MakeStr("a"), MakeStr("b")}), &arr) == nil)
+	assert(t, arr == [2]string{"a", "b"})
+
+	var m map[string]int
+	assert(t, ToGo(NewDict([]*HEntry{{MakeStr("a"), ONE}}), &m) == nil)
+	assert(t, reflect.DeepEqual(m, map[string]int{"a": 1}))
+
+	var val Value
+	assert(t, ToGo(MakeInt(9), &val) == nil)
+	assert(t, val == MakeInt(9))
+
+	var any interface{}
+	assert(t, ToGo(NewList([]Value{ONE, MakeStr("a"), NULL}), &any) == nil)
+	assert(t, reflect.DeepEqual(any, []interface{}{int64(1), "a", nil}))
+
+	stc, _ := NewStruct([]*StructEntry{
+		{"a", false, false, ONE},
+		{"b", false, false, NewDict([]*HEntry{{TRUE, MakeFloat(1.5)}})}})
+	assert(t, ToGo(stc, &any) == nil)
+	assert(t, reflect.DeepEqual(any, map[string]interface{}{
+		"a": int64(1),
+		"b": map[interface{}]interface{}{true: 1.5}}))
+
+	// structs
+	v, err := FromGo(user{
+		"Bob", 42, 1.5, []string{"x"},
+		address{"Paris", nil},
+		map[string]int{"k": 2},
+		true, "tok", "shh"})
+	assert(t, err == nil)
+
+	var u user
+	assert(t, ToGo(v, &u) == nil)
+	assert(t, reflect.DeepEqual(u, user{
+		"Bob", 42, 1.5, []string{"x"},
+		address{"Paris", nil},
+		map[string]int{"k": 2},
+		true, "", ""}))
+
+	// fields that are missing from the Golem struct are left alone
+	a := address{"Rome", nil}
+	stc, _ = NewStruct([]*StructEntry{{"zip", false, false, MakeInt(11)}})
+	assert(t, ToGo(stc, &a) == nil)
+	assert(t, a.City == "Rome" && *a.Zip == 11)
+
+	// errors
+	err = ToGo(MakeStr("a"), &i)
+	assert(t, err.Error() == "TypeMismatch: Cannot convert Str to Go type 'int16'")
+
+	err = ToGo(MakeInt(1<<20), &i)
+	assert(t, err.Error() == "InvalidArgument: Value 1048576 overflows Go type 'int16'")
+
+	var u8 uint8
+	err = ToGo(NEG_ONE, &u8)
+	assert(t, err.Error() == "InvalidArgument: Value -1 overflows Go type 'uint8'")
+
+	err = ToGo(NewList([]Value{ONE}), &arr)
+	assert(t, err.Error() ==
+		"InvalidArgument: Cannot convert List of length 1 to Go type '[2]string'")
+
+	err = ToGo(NULL, &s)
+	assert(t, err.Error() == "TypeMismatch: Cannot convert Null to Go type 'string'")
+
+	err = ToGo(NewDict([]*HEntry{{NewTuple([]Value{ONE, ONE}), ONE}}), &any)
+	assert(t, err.Error() == "TypeMismatch: Cannot use Tuple as a Go map key")
+
+	err = ToGo(ONE, i)
+	assert(t, err.Error() == "InvalidArgument: Expected a non-nil pointer")
+}
+
+type account struct {
+	Owner   string `golem:"owner"`
+	Balance int    `golem:"balance"`
+}
+
+type contextEval struct {
+	ctx context.Context
+}
+
+func (c *contextEval) Eval(fn Func, params []Value) (Value, Error) {
+	return nil, nil
+}
+
+func (c *contextEval) Context() context.Context {
+	return c.ctx
+}
+
+func TestFromGoFunc(t *testing.T) {
+
+	// simple
+	fn, err := FromGoFunc(strings.ToUpper)
+	assert(t, err == nil)
+	v, err := fn.Invoke(nil, []Value{MakeStr("abc")})
+	ok(t, v, err, MakeStr("ABC"))
+
+	v, err = fn.Invoke(nil, []Value{})
+	fail(t, v, err, "ArityMismatch: Expected 1 params, got 0")
+
+	v, err = fn.Invoke(nil, []Value{ONE})
+	fail(t, v, err, "TypeMismatch: Argument 1: Cannot convert Int to Go type 'string'")
+
+	// no results
+	called := false
+	fn, err = FromGoFunc(func() { called = true })
+	assert(t, err == nil)
+	v, err = fn.Invoke(nil, []Value{})
+	ok(t, v, err, NULL)
+	assert(t, called)
+
+	// multiple results, and errors
+	fn, err = FromGoFunc(func(a int, b int) (int, int, error) {
+		if b == 0 {
+			return 0, 0, errors.New("division by zero")
+		}
+		return a / b, a % b, nil
+	})
+	assert(t, err == nil)
+	v, err = fn.Invoke(nil, []Value{MakeInt(7), MakeInt(2)})
+	ok(t, v, err, NewTuple([]Value{MakeInt(3), ONE}))
+
+	v, err = fn.Invoke(nil, []Value{MakeInt(7), ZERO})
+	fail(t, v, err, "Generic: division by zero")
+
+	fn, err = FromGoFunc(func() error { return IndexOutOfBoundsError() })
+	assert(t, err == nil)
+	v, err = fn.Invoke(nil, []Value{})
+	fail(t, v, err, "IndexOutOfBounds")
+
+	// variadic
+	fn, err = FromGoFunc(func(sep string, a ...int) string {
+		s := []string{}
+		for _, n := range a {
+			s = append(s, strconv.Itoa(n))
+		}
+		return strings.Join(s, sep)
+	})
+	assert(t, err == nil)
+	v, err = fn.Invoke(nil, []Value{MakeStr("-"), ONE, MakeInt(2), MakeInt(3)})
+	ok(t, v, err, MakeStr("1-2-3"))
+	v, err = fn.Invoke(nil, []Value{MakeStr("-")})
+	ok(t, v, err, MakeStr(""))
+	v, err = fn.Invoke(nil, []Value{})
+	fail(t, v, err, "ArityMismatch: Expected at least 1 params, got 0")
+	v, err = fn.Invoke(nil, []Value{MakeStr("-"), ONE, MakeStr("a")})
+	fail(t, v, err, "TypeMismatch: Argument 3: Cannot convert Str to Go type 'int'")
+
+	// context and structs
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, 100)
+	fn, err = FromGoFunc(func(ctx context.Context, a account) (account, error) {
+		a.Balance += ctx.Value(key{}).(int)
+		return a, nil
+	})
+	assert(t, err == nil)
+
+	arg, err := FromGo(account{"Bob", 5})
+	assert(t, err == nil)
+	v, err = fn.Invoke(&contextEval{ctx}, []Value{arg})
+	assert(t, err == nil)
+	var a account
+	assert(t, ToGo(v, &a) == nil)
+	assert(t, a == account{"Bob", 105})
+
+	v, err = fn.Invoke(nil, []Value{ONE})
+	fail(t, v, err, "TypeMismatch: Argument 1: Cannot convert Int to Go type 'core.account'")
+
+	// funcs inside other values
+	v, err = FromGo(map[string]interface{}{"upper": strings.ToUpper})
+	assert(t, err == nil)
+	upper, err := v.(Dict).Get(MakeStr("upper"))
+	assert(t, err == nil)
+	v, err = upper.(NativeFunc).Invoke(nil, []Value{MakeStr("x")})
+	ok(t, v, err, MakeStr("X"))
+
+	// not a func
+	_, err = FromGoFunc(42)
+	assert(t, err.Error() == "InvalidArgument: Expected a Go func")
+}
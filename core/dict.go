@@ -156,11 +156,11 @@ func (d *dict) NewIterator() Iterator {
 	itr := &dictIterator{stc, d, d.hashMap.Iterator(), false}
 
 	stc.InitField(MakeStr("nextValue"), &nativeFunc{
-		func(values []Value) (Value, Error) {
+		func(ev Eval, values []Value) (Value, Error) {
 			return itr.IterNext(), nil
 		}})
 	stc.InitField(MakeStr("getValue"), &nativeFunc{
-		func(values []Value) (Value, Error) {
+		func(ev Eval, values []Value) (Value, Error) {
 			return itr.IterGet()
 		}})
 
@@ -190,7 +190,7 @@ func (d *dict) GetField(key Str) (Value, Error) {
 
 	case "addAll":
 		return &intrinsicFunc{d, "addAll", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 1 {
 					return nil, ArityMismatchError("1", len(values))
 				}
@@ -204,7 +204,7 @@ func (d *dict) GetField(key Str) (Value, Error) {
 
 	case "clear":
 		return &intrinsicFunc{d, "clear", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 0 {
 					return nil, ArityMismatchError("0", len(values))
 				}
@@ -214,7 +214,7 @@ func (d *dict) GetField(key Str) (Value, Error) {
 
 	case "isEmpty":
 		return &intrinsicFunc{d, "isEmpty", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 0 {
 					return nil, ArityMismatchError("0", len(values))
 				}
@@ -223,7 +223,7 @@ func (d *dict) GetField(key Str) (Value, Error) {
 
 	case "containsKey":
 		return &intrinsicFunc{d, "containsKey", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 1 {
 					return nil, ArityMismatchError("1", len(values))
 				}
@@ -0,0 +1,161 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//--------------------------------------------------------------
+// Diff
+
+// Diff describes how a value differs from the value that was expected,
+// with a line for each difference.  Lists, tuples, dicts and structs are
+// compared element by element, and each line begins with the path to the
+// element, e.g. "[2].name: ".  Everything else is compared with Eq.
+func Diff(actual Value, expected Value) []string {
+	lines := []string{}
+	diff("", actual, expected, &lines)
+	return lines
+}
+
+func diff(path string, actual Value, expected Value, lines *[]string) {
+
+	if actual.Eq(expected).BoolVal() {
+		return
+	}
+
+	switch a := actual.(type) {
+
+	case List:
+		if e, ok := expected.(List); ok {
+			diffValues(path, a.Values(), e.Values(), lines)
+			return
+		}
+
+	case tuple:
+		if e, ok := expected.(tuple); ok {
+			diffValues(path, a, e, lines)
+			return
+		}
+
+	case Dict:
+		if e, ok := expected.(Dict); ok {
+			diffDicts(path, a, e, lines)
+			return
+		}
+
+	case Struct:
+		if e, ok := expected.(Struct); ok {
+			diffStructs(path, a, e, lines)
+			return
+		}
+	}
+
+	addDiff(lines, path, fmt.Sprintf("%s, expected %s",
+		actual.ToStr().String(), expected.ToStr().String()))
+}
+
+func diffValues(path string, actual []Value, expected []Value, lines *[]string) {
+
+	for i := 0; i < len(actual) || i < len(expected); i++ {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i >= len(expected):
+			addDiff(lines, p, "unexpected "+actual[i].ToStr().String())
+		case i >= len(actual):
+			addDiff(lines, p, "missing, expected "+expected[i].ToStr().String())
+		default:
+			diff(p, actual[i], expected[i], lines)
+		}
+	}
+}
+
+func diffDicts(path string, actual Dict, expected Dict, lines *[]string) {
+
+	ad := actual.(*dict).hashMap
+	ed := expected.(*dict).hashMap
+
+	itr := ed.Iterator()
+	for itr.Next() {
+		entry := itr.Get()
+		p := fmt.Sprintf("%s[%s]", path, key(entry.Key))
+		// the keys of a dict are always hashable, so there are no errors
+		if has, _ := ad.ContainsKey(entry.Key); has.BoolVal() {
+			v, _ := ad.Get(entry.Key)
+			diff(p, v, entry.Value, lines)
+		} else {
+			addDiff(lines, p, "missing, expected "+entry.Value.ToStr().String())
+		}
+	}
+
+	itr = ad.Iterator()
+	for itr.Next() {
+		entry := itr.Get()
+		if has, _ := ed.ContainsKey(entry.Key); !has.BoolVal() {
+			p := fmt.Sprintf("%s[%s]", path, key(entry.Key))
+			addDiff(lines, p, "unexpected "+entry.Value.ToStr().String())
+		}
+	}
+}
+
+func diffStructs(path string, actual Struct, expected Struct, lines *[]string) {
+
+	names := map[string]bool{}
+	for _, k := range actual.Keys() {
+		names[k] = true
+	}
+	for _, k := range expected.Keys() {
+		names[k] = true
+	}
+	keys := []string{}
+	for k := range names {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := path + "." + k
+		a, aerr := actual.GetField(MakeStr(k))
+		e, eerr := expected.GetField(MakeStr(k))
+		switch {
+		case eerr != nil:
+			addDiff(lines, p, "unexpected "+a.ToStr().String())
+		case aerr != nil:
+			addDiff(lines, p, "missing, expected "+e.ToStr().String())
+		default:
+			diff(p, a, e, lines)
+		}
+	}
+}
+
+func addDiff(lines *[]string, path string, msg string) {
+	if path == "" {
+		*lines = append(*lines, msg)
+	} else {
+		*lines = append(*lines, path+": "+msg)
+	}
+}
+
+// The keys of dicts are quoted if they are strings, so that they are not
+// confused with the names of fields.
+func key(v Value) string {
+	if s, ok := v.(Str); ok {
+		return "'" + strings.Replace(s.String(), "'", "\\'", -1) + "'"
+	}
+	return v.ToStr().String()
+}
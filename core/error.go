@@ -40,6 +40,12 @@ const (
 	ASSERTION_FAILED
 	CONST_SYMBOL
 	UNDEFINIED_SYMBOL
+	CANCELLED
+	BUDGET_EXCEEDED
+	IMPORT_FAILED
+	NO_SUCH_PARAM
+	DUPLICATE_PARAM
+	INTERNAL_ERROR
 )
 
 func (t ErrorKind) String() string {
@@ -75,6 +81,18 @@ func (t ErrorKind) String() string {
 		return "ConstSymbol"
 	case UNDEFINIED_SYMBOL:
 		return "UndefinedSymbol"
+	case CANCELLED:
+		return "Cancelled"
+	case BUDGET_EXCEEDED:
+		return "BudgetExceeded"
+	case IMPORT_FAILED:
+		return "ImportFailed"
+	case NO_SUCH_PARAM:
+		return "NoSuchParam"
+	case DUPLICATE_PARAM:
+		return "DuplicateParam"
+	case INTERNAL_ERROR:
+		return "InternalError"
 
 	default:
 		panic("unreachable")
@@ -195,8 +213,8 @@ func NoSuchElementError() Error {
 	return makeError(NO_SUCH_ELEMENT, "")
 }
 
-func AssertionFailedError() Error {
-	return makeError(ASSERTION_FAILED, "")
+func AssertionFailedError(msg string) Error {
+	return makeError(ASSERTION_FAILED, msg)
 }
 
 func ConstSymbolError(name string) Error {
@@ -210,3 +228,49 @@ func UndefinedSymbolError(name string) Error {
 		UNDEFINIED_SYMBOL,
 		fmt.Sprintf("Symbol '%s' is not defined", name))
 }
+
+func CancelledError(msg string) Error {
+	return makeError(CANCELLED, msg)
+}
+
+func BudgetExceededError(max int64) Error {
+	return makeError(
+		BUDGET_EXCEEDED,
+		fmt.Sprintf("Exceeded the limit of %d opcodes", max))
+}
+
+func ImportFailedError(msg string) Error {
+	return makeError(IMPORT_FAILED, msg)
+}
+
+func NoSuchParamError(param string) Error {
+	return makeError(
+		NO_SUCH_PARAM,
+		fmt.Sprintf("Param '%s' not found", param))
+}
+
+func DuplicateParamError(param string) Error {
+	return makeError(
+		DUPLICATE_PARAM,
+		fmt.Sprintf("Param '%s' is a duplicate", param))
+}
+
+// InternalError is reported to the host program when the interpreter
+// fails unexpectedly, rather than because of an error in the Golem code.
+func InternalError(msg string) Error {
+	return makeError(INTERNAL_ERROR, msg)
+}
+
+// MissingParamError is an ArityMismatch error for a required param that
+// was not bound by an invocation that has named params.
+func MissingParamError(param string) Error {
+	return makeError(
+		ARITY_MISMATCH,
+		fmt.Sprintf("Param '%s' is missing", param))
+}
+
+// IsFatal returns whether an error aborts execution entirely.  Fatal errors
+// cannot be caught, and 'finally' clauses are not run when they are thrown.
+func IsFatal(err Error) bool {
+	return err.Kind() == CANCELLED || err.Kind() == BUDGET_EXCEEDED
+}
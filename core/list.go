@@ -207,11 +207,11 @@ func (ls *list) NewIterator() Iterator {
 	itr := &listIterator{stc, ls, -1}
 
 	stc.InitField(MakeStr("nextValue"), &nativeFunc{
-		func(values []Value) (Value, Error) {
+		func(ev Eval, values []Value) (Value, Error) {
 			return itr.IterNext(), nil
 		}})
 	stc.InitField(MakeStr("getValue"), &nativeFunc{
-		func(values []Value) (Value, Error) {
+		func(ev Eval, values []Value) (Value, Error) {
 			return itr.IterGet()
 		}})
 
@@ -239,7 +239,7 @@ func (ls *list) GetField(key Str) (Value, Error) {
 
 	case "add":
 		return &intrinsicFunc{ls, "add", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 1 {
 					return nil, ArityMismatchError("1", len(values))
 				}
@@ -253,7 +253,7 @@ func (ls *list) GetField(key Str) (Value, Error) {
 
 	case "addAll":
 		return &intrinsicFunc{ls, "addAll", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 1 {
 					return nil, ArityMismatchError("1", len(values))
 				}
@@ -267,7 +267,7 @@ func (ls *list) GetField(key Str) (Value, Error) {
 
 	case "clear":
 		return &intrinsicFunc{ls, "clear", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 0 {
 					return nil, ArityMismatchError("0", len(values))
 				}
@@ -277,7 +277,7 @@ func (ls *list) GetField(key Str) (Value, Error) {
 
 	case "isEmpty":
 		return &intrinsicFunc{ls, "isEmpty", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 0 {
 					return nil, ArityMismatchError("0", len(values))
 				}
@@ -286,7 +286,7 @@ func (ls *list) GetField(key Str) (Value, Error) {
 
 	case "contains":
 		return &intrinsicFunc{ls, "contains", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 1 {
 					return nil, ArityMismatchError("1", len(values))
 				}
@@ -295,7 +295,7 @@ func (ls *list) GetField(key Str) (Value, Error) {
 
 	case "indexOf":
 		return &intrinsicFunc{ls, "indexOf", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 1 {
 					return nil, ArityMismatchError("1", len(values))
 				}
@@ -304,7 +304,7 @@ func (ls *list) GetField(key Str) (Value, Error) {
 
 	case "join":
 		return &intrinsicFunc{ls, "join", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				var delim Str
 				switch len(values) {
 				case 0:
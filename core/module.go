@@ -22,18 +22,78 @@ import (
 //---------------------------------------------------------------
 // BytecodeModule
 
+// BytecodeModule is the result of compiling a module.  The Name identifies
+// the module's source file in stack traces.  Builtins holds the name of
+// each builtin that the module refers to, at the index that LOAD_BUILTIN
+// uses for it.
 type BytecodeModule struct {
+	Name       string
 	Pool       []Basic
 	Refs       []*Ref
 	StructDefs [][]*StructEntryDef
 	Templates  []*Template
+	Exports    []*ModuleExport
+	Builtins   []string
 	Contents   Struct
 }
 
+// ModuleExport describes a top-level 'pub' declaration.
+type ModuleExport struct {
+	Name     string
+	RefIndex int
+	IsConst  bool
+}
+
+// MakeModuleContents creates the Struct through which the exports
+// of a module are accessed.  Each export is a property that reads
+// or writes the corresponding Ref.
+func MakeModuleContents(mod *BytecodeModule) Struct {
+
+	entries := make([]*StructEntry, len(mod.Exports))
+	for i, e := range mod.Exports {
+		entries[i] = makeModuleProperty(mod, e.Name, e.RefIndex, e.IsConst)
+	}
+
+	stc, err := NewStruct(entries)
+	Assert(err == nil, "invalid module contents")
+	return stc
+}
+
+func makeModuleProperty(
+	mod *BytecodeModule,
+	key string,
+	refIndex int,
+	isConst bool) *StructEntry {
+
+	getter := NewNativeFunc(
+		func(ev Eval, values []Value) (Value, Error) {
+			if len(values) != 0 {
+				return nil, ArityMismatchError("0", len(values))
+			}
+			return mod.Refs[refIndex].Val, nil
+		})
+
+	var setter NativeFunc = nil
+	if !isConst {
+		setter = NewNativeFunc(
+			func(ev Eval, values []Value) (Value, Error) {
+				if len(values) != 1 {
+					return nil, ArityMismatchError("1", len(values))
+				}
+				mod.Refs[refIndex].Val = values[0]
+				return NULL, nil
+			})
+	}
+
+	prop := NewTuple([]Value{getter, setter})
+	return &StructEntry{key, isConst, true, prop}
+}
+
 func (m *BytecodeModule) String() string {
 	var buf bytes.Buffer
 	buf.WriteString("----------------------------\n")
 	buf.WriteString("BytecodeModule:\n")
+	buf.WriteString(fmt.Sprintf("    Name: %s\n", m.Name))
 
 	buf.WriteString("    Pool:\n")
 	for i, val := range m.Pool {
@@ -57,8 +117,8 @@ func (m *BytecodeModule) String() string {
 	for i, t := range m.Templates {
 
 		buf.WriteString(fmt.Sprintf(
-			"    Template(%d): Arity: %d, NumCaptures: %d, NumLocals: %d\n",
-			i, t.Arity, t.NumCaptures, t.NumLocals))
+			"    Template(%d): Name: %s, Arity: %d, NumCaptures: %d, NumLocals: %d\n",
+			i, t.Name, t.Arity, t.NumCaptures, t.NumLocals))
 
 		buf.WriteString("        OpCodes:\n")
 		for i := 0; i < len(t.OpCodes); {
@@ -16,21 +16,39 @@ package core
 
 import (
 	"fmt"
+	"strings"
 )
 
+//--------------------------------------------------------------
+// Eval
+
+// Eval evaluates a function.  An Eval is passed to every native
+// function when it is invoked, so that the native function can call
+// back into Golem code (e.g. to call a BytecodeFunc that it received
+// as a parameter).  Errors that are thrown by the evaluated function,
+// and not caught, are returned to the caller.
+//
+// An Eval is only valid while the Invoke that received it is running, and
+// it must not be used for more than one call at a time.  Calling it after
+// Invoke has returned, or while another call through it is still running,
+// e.g. from another goroutine, returns an InternalError.
+type Eval interface {
+	Eval(Func, []Value) (Value, Error)
+}
+
 //--------------------------------------------------------------
 // NativeFunc
 
 type NativeFunc interface {
 	Func
-	Invoke([]Value) (Value, Error)
+	Invoke(Eval, []Value) (Value, Error)
 }
 
 type nativeFunc struct {
-	invoke func([]Value) (Value, Error)
+	invoke func(Eval, []Value) (Value, Error)
 }
 
-func NewNativeFunc(f func([]Value) (Value, Error)) NativeFunc {
+func NewNativeFunc(f func(Eval, []Value) (Value, Error)) NativeFunc {
 	return &nativeFunc{f}
 }
 
@@ -75,8 +93,47 @@ func (f *nativeFunc) ToStr() Str {
 	return MakeStr(fmt.Sprintf("nativeFunc<%p>", f))
 }
 
-func (f *nativeFunc) Invoke(values []Value) (Value, Error) {
-	return f.invoke(values)
+func (f *nativeFunc) Invoke(ev Eval, values []Value) (Value, Error) {
+	return f.invoke(ev, values)
+}
+
+//--------------------------------------------------------------
+// NamedParamFunc
+
+// NamedParamFunc is a NativeFunc that can be invoked with named params,
+// e.g. 'f(x, timeout: 5)', which are passed to InvokeNamed in a Struct.
+// Other native functions cannot be invoked with named params.
+type NamedParamFunc interface {
+	NativeFunc
+	InvokeNamed(Eval, []Value, Struct) (Value, Error)
+}
+
+type namedParamFunc struct {
+	*nativeFunc
+	invokeNamed func(Eval, []Value, Struct) (Value, Error)
+}
+
+// NewNamedParamFunc creates a NamedParamFunc.  The Struct that is passed
+// to the function is nil when it is invoked without named params.
+func NewNamedParamFunc(f func(Eval, []Value, Struct) (Value, Error)) NamedParamFunc {
+	invoke := func(ev Eval, values []Value) (Value, Error) {
+		return f(ev, values, nil)
+	}
+	return &namedParamFunc{&nativeFunc{invoke}, f}
+}
+
+func (f *namedParamFunc) Eq(v Value) Bool {
+	switch t := v.(type) {
+	case NativeFunc:
+		// equality is based on identity
+		return MakeBool(f == t)
+	default:
+		return FALSE
+	}
+}
+
+func (f *namedParamFunc) InvokeNamed(ev Eval, values []Value, named Struct) (Value, Error) {
+	return f.invokeNamed(ev, values, named)
 }
 
 //---------------------------------------------------------------
@@ -101,31 +158,100 @@ func (f *intrinsicFunc) Eq(v Value) Bool {
 	}
 }
 
+// IntrinsicNames returns the names of the intrinsic functions
+// of the given Type, in the order they are documented.
+func IntrinsicNames(t Type) []string {
+	return intrinsicNames[t]
+}
+
+var intrinsicNames = map[Type][]string{
+	TLIST: {"add", "addAll", "clear", "isEmpty", "contains", "indexOf", "join"},
+	TDICT: {"addAll", "clear", "isEmpty", "containsKey"},
+	TSET:  {"add", "addAll", "clear", "isEmpty", "contains"},
+	TCHAN: {"send", "recv"},
+}
+
 //---------------------------------------------------------------
 // Builtins
 
-const (
-	PRINT = iota
-	PRINTLN
-	STR
-	LEN
-	RANGE
-	ASSERT
-	MERGE
-	CHAN
+// BuiltinEntry is a named value that is visible to every module.
+type BuiltinEntry struct {
+	Name  string
+	Value Value
+}
+
+// BuiltinManager keeps track of the builtins that are available to a module.
+// The analyzer resolves builtins by name, the compiler refers to them by
+// index, and the interpreter loads them by index.
+type BuiltinManager interface {
+	Builtins() []Value
+	Contains(name string) bool
+	IndexOf(name string) int
+}
+
+type builtinManager struct {
+	values []Value
+	lookup map[string]int
+}
+
+// NewBuiltinManager creates a BuiltinManager from a list of entries.
+func NewBuiltinManager(entries []*BuiltinEntry) BuiltinManager {
+	values := make([]Value, len(entries))
+	lookup := make(map[string]int)
+	for i, e := range entries {
+		if _, ok := lookup[e.Name]; ok {
+			panic(fmt.Sprintf("builtin '%s' is already defined", e.Name))
+		}
+		values[i] = e.Value
+		lookup[e.Name] = i
+	}
+	return &builtinManager{values, lookup}
+}
+
+func (b *builtinManager) Builtins() []Value {
+	return b.values
+}
+
+func (b *builtinManager) Contains(name string) bool {
+	_, ok := b.lookup[name]
+	return ok
+}
+
+func (b *builtinManager) IndexOf(name string) int {
+	index, ok := b.lookup[name]
+	if !ok {
+		panic(fmt.Sprintf("unknown builtin '%s'", name))
+	}
+	return index
+}
+
+var (
+	BuiltinPrint    = NewNativeFunc(builtinPrint)
+	BuiltinPrintln  = NewNativeFunc(builtinPrintln)
+	BuiltinStr      = NewNativeFunc(builtinStr)
+	BuiltinLen      = NewNativeFunc(builtinLen)
+	BuiltinRange    = NewNativeFunc(builtinRange)
+	BuiltinAssert   = NewNativeFunc(builtinAssert)
+	BuiltinMerge    = NewNativeFunc(builtinMerge)
+	BuiltinChan     = NewNativeFunc(builtinChan)
+	BuiltinAssertEq = NewNativeFunc(builtinAssertEq)
+	BuiltinAssertNe = NewNativeFunc(builtinAssertNe)
 )
 
-var Builtins = []NativeFunc{
-	&nativeFunc{builtinPrint},
-	&nativeFunc{builtinPrintln},
-	&nativeFunc{builtinStr},
-	&nativeFunc{builtinLen},
-	&nativeFunc{builtinRange},
-	&nativeFunc{builtinAssert},
-	&nativeFunc{builtinMerge},
-	&nativeFunc{builtinChan}}
-
-var builtinPrint = func(values []Value) (Value, Error) {
+// StandardBuiltins are the builtins that every module can use.
+var StandardBuiltins = []*BuiltinEntry{
+	{"print", BuiltinPrint},
+	{"println", BuiltinPrintln},
+	{"str", BuiltinStr},
+	{"len", BuiltinLen},
+	{"range", BuiltinRange},
+	{"assert", BuiltinAssert},
+	{"merge", BuiltinMerge},
+	{"chan", BuiltinChan},
+	{"assertEq", BuiltinAssertEq},
+	{"assertNe", BuiltinAssertNe}}
+
+var builtinPrint = func(ev Eval, values []Value) (Value, Error) {
 	for _, v := range values {
 		fmt.Print(v.ToStr().String())
 	}
@@ -133,7 +259,7 @@ var builtinPrint = func(values []Value) (Value, Error) {
 	return NULL, nil
 }
 
-var builtinPrintln = func(values []Value) (Value, Error) {
+var builtinPrintln = func(ev Eval, values []Value) (Value, Error) {
 	for _, v := range values {
 		fmt.Print(v.ToStr().String())
 	}
@@ -142,7 +268,7 @@ var builtinPrintln = func(values []Value) (Value, Error) {
 	return NULL, nil
 }
 
-var builtinStr = func(values []Value) (Value, Error) {
+var builtinStr = func(ev Eval, values []Value) (Value, Error) {
 	if len(values) != 1 {
 		return nil, ArityMismatchError("1", len(values))
 	}
@@ -150,7 +276,7 @@ var builtinStr = func(values []Value) (Value, Error) {
 	return values[0].ToStr(), nil
 }
 
-var builtinLen = func(values []Value) (Value, Error) {
+var builtinLen = func(ev Eval, values []Value) (Value, Error) {
 	if len(values) != 1 {
 		return nil, ArityMismatchError("1", len(values))
 	}
@@ -162,7 +288,7 @@ var builtinLen = func(values []Value) (Value, Error) {
 	}
 }
 
-var builtinRange = func(values []Value) (Value, Error) {
+var builtinRange = func(ev Eval, values []Value) (Value, Error) {
 	if len(values) < 2 || len(values) > 3 {
 		return nil, ArityMismatchError("2 or 3", len(values))
 	}
@@ -188,9 +314,9 @@ var builtinRange = func(values []Value) (Value, Error) {
 	return NewRange(from.IntVal(), to.IntVal(), step.IntVal())
 }
 
-var builtinAssert = func(values []Value) (Value, Error) {
-	if len(values) != 1 {
-		return nil, ArityMismatchError("1", len(values))
+var builtinAssert = func(ev Eval, values []Value) (Value, Error) {
+	if len(values) < 1 || len(values) > 2 {
+		return nil, ArityMismatchError("1 or 2", len(values))
 	}
 
 	b, ok := values[0].(Bool)
@@ -201,11 +327,64 @@ var builtinAssert = func(values []Value) (Value, Error) {
 	if b.BoolVal() {
 		return TRUE, nil
 	} else {
-		return nil, AssertionFailedError()
+		return nil, AssertionFailedError(assertMessage(values, 1, ""))
+	}
+}
+
+// assertEq(actual, expected [, msg]) fails if the values are not equal.
+// The message shows both values, and how they differ.
+var builtinAssertEq = func(ev Eval, values []Value) (Value, Error) {
+	if len(values) < 2 || len(values) > 3 {
+		return nil, ArityMismatchError("2 or 3", len(values))
+	}
+
+	actual, expected := values[0], values[1]
+	if actual.Eq(expected).BoolVal() {
+		return TRUE, nil
+	}
+
+	lines := []string{
+		assertMessage(values, 2, "values are not equal"),
+		"actual:   " + actual.ToStr().String(),
+		"expected: " + expected.ToStr().String()}
+
+	// only composite values of the same type have a structural diff
+	switch actual.TypeOf() {
+	case TLIST, TTUPLE, TDICT, TSTRUCT:
+		if actual.TypeOf() == expected.TypeOf() {
+			lines = append(lines, "differences:")
+			for _, d := range Diff(actual, expected) {
+				lines = append(lines, "    "+d)
+			}
+		}
+	}
+	return nil, AssertionFailedError(strings.Join(lines, "\n"))
+}
+
+// assertNe(actual, unexpected [, msg]) fails if the values are equal.
+var builtinAssertNe = func(ev Eval, values []Value) (Value, Error) {
+	if len(values) < 2 || len(values) > 3 {
+		return nil, ArityMismatchError("2 or 3", len(values))
+	}
+
+	if !values[0].Eq(values[1]).BoolVal() {
+		return TRUE, nil
+	}
+	return nil, AssertionFailedError(strings.Join([]string{
+		assertMessage(values, 2, "values are equal"),
+		"value: " + values[0].ToStr().String()}, "\n"))
+}
+
+// The message of an assertion is the optional parameter at index n,
+// or the default message if there is no such parameter.
+func assertMessage(values []Value, n int, dflt string) string {
+	if len(values) > n {
+		return values[n].ToStr().String()
 	}
+	return dflt
 }
 
-var builtinMerge = func(values []Value) (Value, Error) {
+var builtinMerge = func(ev Eval, values []Value) (Value, Error) {
 	if len(values) < 2 {
 		return nil, ArityMismatchError("at least 2", len(values))
 	}
@@ -222,7 +401,7 @@ var builtinMerge = func(values []Value) (Value, Error) {
 	return MergeStructs(structs), nil
 }
 
-var builtinChan = func(values []Value) (Value, Error) {
+var builtinChan = func(ev Eval, values []Value) (Value, Error) {
 	switch len(values) {
 	case 0:
 		return NewChan(), nil
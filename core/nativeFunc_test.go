@@ -20,8 +20,8 @@ import (
 
 func TestNative(t *testing.T) {
 
-	a := Builtins[STR]
-	b := Builtins[LEN]
+	a := BuiltinStr
+	b := BuiltinLen
 
 	okType(t, a, TFUNC)
 	okType(t, b, TFUNC)
@@ -33,10 +33,48 @@ func TestNative(t *testing.T) {
 
 	ls := NewList([]Value{ONE, ZERO})
 
-	v, err := a.Invoke([]Value{ls})
+	v, err := a.Invoke(nil, []Value{ls})
 	ok(t, v, err, MakeStr("[ 1, 0 ]"))
 
-	v, err = b.Invoke([]Value{ls})
+	v, err = b.Invoke(nil, []Value{ls})
 	ok(t, v, err, MakeInt(2))
 
 }
+
+func TestBuiltinManager(t *testing.T) {
+
+	bm := NewBuiltinManager(append(StandardBuiltins,
+		&BuiltinEntry{"foo", MakeInt(42)}))
+
+	assert(t, bm.Contains("print"))
+	assert(t, bm.Contains("foo"))
+	assert(t, !bm.Contains("bar"))
+
+	assert(t, bm.IndexOf("print") == 0)
+	assert(t, bm.IndexOf("foo") == len(StandardBuiltins))
+
+	builtins := bm.Builtins()
+	assert(t, builtins[bm.IndexOf("str")] == BuiltinStr)
+	assert(t, builtins[bm.IndexOf("foo")].Eq(MakeInt(42)).BoolVal())
+}
+
+func TestIntrinsicNames(t *testing.T) {
+
+	values := map[Type]Value{
+		TLIST: NewList([]Value{}),
+		TDICT: NewDict([]*HEntry{}),
+		TSET:  NewSet([]Value{}),
+		TCHAN: NewChan()}
+
+	for typ, v := range values {
+		names := IntrinsicNames(typ)
+		assert(t, len(names) > 0)
+		for _, n := range names {
+			f, err := v.GetField(MakeStr(n))
+			assert(t, err == nil)
+			okType(t, f, TFUNC)
+		}
+	}
+
+	assert(t, IntrinsicNames(TSTR) == nil)
+}
@@ -68,6 +68,8 @@ const (
 
 	INVOKE
 	SPAWN
+	INVOKE_NAMED
+	SPAWN_NAMED
 	RETURN
 	DONE
 	THROW
@@ -101,6 +103,8 @@ const (
 	POP
 	DUP
 
+	IMPORT
+
 	// These are temporary values created during compilation.
 	// The interpreter will panic if it encounters them.
 	BREAK    = 0xFD
@@ -114,9 +118,11 @@ func OpCodeSize(opc byte) int {
 	case LOAD_BUILTIN, LOAD_CONST,
 		LOAD_LOCAL, LOAD_CAPTURE, STORE_LOCAL, STORE_CAPTURE,
 		JUMP, JUMP_TRUE, JUMP_FALSE, BREAK, CONTINUE,
-		NEW_FUNC, FUNC_CAPTURE, FUNC_LOCAL, INVOKE, SPAWN,
+		NEW_FUNC, FUNC_CAPTURE, FUNC_LOCAL,
+		INVOKE, SPAWN, INVOKE_NAMED, SPAWN_NAMED,
 		NEW_STRUCT, GET_FIELD, INIT_FIELD, SET_FIELD, INC_FIELD,
-		NEW_DICT, NEW_LIST, NEW_SET, NEW_TUPLE, CHECK_CAST, CHECK_TUPLE:
+		NEW_DICT, NEW_LIST, NEW_SET, NEW_TUPLE, CHECK_CAST, CHECK_TUPLE,
+		IMPORT:
 
 		return 3
 
@@ -219,6 +225,10 @@ func FmtOpcode(opcodes []byte, i int) string {
 		return fmtIndex(opcodes, i, "INVOKE")
 	case SPAWN:
 		return fmtIndex(opcodes, i, "SPAWN")
+	case INVOKE_NAMED:
+		return fmtIndex(opcodes, i, "INVOKE_NAMED")
+	case SPAWN_NAMED:
+		return fmtIndex(opcodes, i, "SPAWN_NAMED")
 	case RETURN:
 		return fmt.Sprintf("%d: RETURN\n", i)
 	case DONE:
@@ -276,6 +286,9 @@ func FmtOpcode(opcodes []byte, i int) string {
 	case DUP:
 		return fmt.Sprintf("%d: DUP\n", i)
 
+	case IMPORT:
+		return fmtIndex(opcodes, i, "IMPORT")
+
 	case BREAK:
 		return fmtIndex(opcodes, i, "BREAK")
 	case CONTINUE:
@@ -154,11 +154,11 @@ func (r *rng) NewIterator() Iterator {
 	itr := &rangeIterator{stc, r, -1}
 
 	stc.InitField(MakeStr("nextValue"), &nativeFunc{
-		func(values []Value) (Value, Error) {
+		func(ev Eval, values []Value) (Value, Error) {
 			return itr.IterNext(), nil
 		}})
 	stc.InitField(MakeStr("getValue"), &nativeFunc{
-		func(values []Value) (Value, Error) {
+		func(ev Eval, values []Value) (Value, Error) {
 			return itr.IterGet()
 		}})
 
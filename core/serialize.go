@@ -0,0 +1,517 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+//---------------------------------------------------------------
+// Binary serialization of a BytecodeModule, a.k.a. the '.glmc' format.
+//
+// A file starts with the magic bytes "GLMC", followed by the format version.
+// The rest of the file is made up of the module's Name, the Pool, the
+// Builtins, the StructDefs, the Templates and the Exports, in that order.
+// Integers are encoded as varints, and every list is prefixed with its
+// length.  Refs and Contents are not written, since they are created when
+// the module is loaded and run.
+//
+// The indices of the builtins depend on the Runtime that compiled the module,
+// so only the names of the builtins that the module uses are written, and
+// the operands of LOAD_BUILTIN are rewritten to refer to that list.
+// LinkBuiltins turns them back into indices, when the module is loaded.
+
+// GlmcMagic identifies a compiled Golem module.
+const GlmcMagic = "GLMC"
+
+// GlmcVersion is incremented whenever the format changes.
+const GlmcVersion = 6
+
+// pool entry tags
+const (
+	tagNull byte = iota
+	tagTrue
+	tagFalse
+	tagInt
+	tagFloat
+	tagStr
+)
+
+// WriteModule writes a module in the '.glmc' format.
+func WriteModule(w io.Writer, mod *BytecodeModule) error {
+
+	builtins, opcodes, err := writtenBuiltins(mod)
+	if err != nil {
+		return err
+	}
+
+	mw := &moduleWriter{bufio.NewWriter(w), nil}
+	mw.bytes([]byte(GlmcMagic))
+	mw.uint(GlmcVersion)
+	mw.str(mod.Name)
+
+	// pool
+	mw.uint(len(mod.Pool))
+	for _, b := range mod.Pool {
+		mw.basic(b)
+	}
+
+	// builtins
+	mw.strs(builtins)
+
+	// struct defs
+	mw.uint(len(mod.StructDefs))
+	for _, def := range mod.StructDefs {
+		mw.uint(len(def))
+		for _, e := range def {
+			mw.str(e.Key)
+			mw.bool(e.IsConst)
+			mw.bool(e.IsProperty)
+		}
+	}
+
+	// templates
+	mw.uint(len(mod.Templates))
+	for i, t := range mod.Templates {
+		mw.str(t.Name)
+		mw.uint(t.Arity)
+		mw.uint(t.MinArity)
+		mw.int(t.MaxArity)
+		mw.uint(t.NumCaptures)
+		mw.uint(t.NumLocals)
+
+		mw.uint(len(opcodes[i]))
+		mw.bytes(opcodes[i])
+
+		mw.uint(len(t.LineNumberTable))
+		for _, ln := range t.LineNumberTable {
+			mw.uint(ln.Index)
+			mw.uint(ln.LineNum)
+			mw.uint(ln.ColNum)
+		}
+
+		mw.uint(len(t.ExceptionHandlers))
+		for _, eh := range t.ExceptionHandlers {
+			mw.int(eh.Begin)
+			mw.int(eh.End)
+			mw.int(eh.Catch)
+			mw.int(eh.Finally)
+		}
+
+		mw.uint(t.Prologue)
+
+		mw.strs(t.LocalNames)
+		mw.strs(t.CaptureNames)
+	}
+
+	// exports
+	mw.uint(len(mod.Exports))
+	for _, e := range mod.Exports {
+		mw.str(e.Name)
+		mw.uint(e.RefIndex)
+		mw.bool(e.IsConst)
+	}
+
+	if mw.err != nil {
+		return mw.err
+	}
+	return mw.w.Flush()
+}
+
+// writtenBuiltins returns the names of the builtins that a module uses,
+// in the order that they are first used, and a copy of the opcodes of
+// each template, in which LOAD_BUILTIN refers to that list of names.
+func writtenBuiltins(mod *BytecodeModule) ([]string, [][]byte, error) {
+
+	names := []string{}
+	written := make(map[int]int)
+	opcodes := make([][]byte, len(mod.Templates))
+
+	for i, t := range mod.Templates {
+		opc := append([]byte{}, t.OpCodes...)
+		for ip := 0; ip < len(opc); ip += OpCodeSize(opc[ip]) {
+			if opc[ip] != LOAD_BUILTIN {
+				continue
+			}
+			idx := index(opc, ip)
+			n, ok := written[idx]
+			if !ok {
+				if idx >= len(mod.Builtins) || mod.Builtins[idx] == "" {
+					return nil, nil, fmt.Errorf(
+						"the name of builtin %d is not known", idx)
+				}
+				n = len(names)
+				names = append(names, mod.Builtins[idx])
+				written[idx] = n
+			}
+			opc[ip+1], opc[ip+2] = byte(n>>8), byte(n)
+		}
+		opcodes[i] = opc
+	}
+	return names, opcodes, nil
+}
+
+// ReadModule reads a module that was written by WriteModule.  The module's
+// Contents are re-created, but its Refs are not, since they are created
+// when the module is initialized.  The bytecode is not checked; a module
+// that comes from an untrusted source must be checked with VerifyModule
+// before it is run.  Its builtins must be linked with LinkBuiltins after
+// that.
+func ReadModule(r io.Reader) (*BytecodeModule, error) {
+
+	mr := &moduleReader{bufio.NewReader(r), nil}
+
+	magic := mr.bytes(len(GlmcMagic))
+	if mr.err == nil && string(magic) != GlmcMagic {
+		return nil, errors.New("not a compiled golem module")
+	}
+	version := mr.uint()
+	if mr.err == nil && version != GlmcVersion {
+		return nil, fmt.Errorf(
+			"unsupported module version %d, expected %d", version, GlmcVersion)
+	}
+
+	mod := &BytecodeModule{}
+	mod.Name = mr.str()
+
+	// pool
+	n := mr.uint()
+	mod.Pool = []Basic{}
+	for i := 0; i < n && mr.err == nil; i++ {
+		mod.Pool = append(mod.Pool, mr.basic())
+	}
+
+	// builtins
+	mod.Builtins = mr.strs()
+
+	// struct defs
+	n = mr.uint()
+	mod.StructDefs = [][]*StructEntryDef{}
+	for i := 0; i < n && mr.err == nil; i++ {
+		m := mr.uint()
+		def := []*StructEntryDef{}
+		for j := 0; j < m && mr.err == nil; j++ {
+			def = append(def, &StructEntryDef{mr.str(), mr.bool(), mr.bool()})
+		}
+		mod.StructDefs = append(mod.StructDefs, def)
+	}
+
+	// templates
+	n = mr.uint()
+	mod.Templates = []*Template{}
+	for i := 0; i < n && mr.err == nil; i++ {
+		t := &Template{}
+		t.Name = mr.str()
+		t.Arity = mr.uint()
+		t.MinArity = mr.uint()
+		t.MaxArity = mr.int()
+		t.NumCaptures = mr.uint()
+		t.NumLocals = mr.uint()
+
+		t.OpCodes = mr.bytes(mr.uint())
+
+		m := mr.uint()
+		t.LineNumberTable = []LineNumberEntry{}
+		for j := 0; j < m && mr.err == nil; j++ {
+			t.LineNumberTable = append(t.LineNumberTable,
+				LineNumberEntry{mr.uint(), mr.uint(), mr.uint()})
+		}
+
+		m = mr.uint()
+		t.ExceptionHandlers = []ExceptionHandler{}
+		for j := 0; j < m && mr.err == nil; j++ {
+			t.ExceptionHandlers = append(t.ExceptionHandlers,
+				ExceptionHandler{mr.int(), mr.int(), mr.int(), mr.int()})
+		}
+
+		t.Prologue = mr.uint()
+
+		t.LocalNames = mr.strs()
+		t.CaptureNames = mr.strs()
+
+		mod.Templates = append(mod.Templates, t)
+	}
+
+	// exports
+	n = mr.uint()
+	mod.Exports = []*ModuleExport{}
+	for i := 0; i < n && mr.err == nil; i++ {
+		mod.Exports = append(mod.Exports,
+			&ModuleExport{mr.str(), mr.uint(), mr.bool()})
+	}
+
+	if mr.err != nil {
+		if mr.err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, mr.err
+	}
+
+	// there should be nothing left over
+	if _, err := mr.r.ReadByte(); err != io.EOF {
+		return nil, errors.New("unexpected data at end of module")
+	}
+
+	// the names of the exports must be unique
+	names := make(map[string]bool)
+	for _, e := range mod.Exports {
+		if names[e.Name] {
+			return nil, fmt.Errorf("duplicate export '%s'", e.Name)
+		}
+		names[e.Name] = true
+	}
+
+	mod.Contents = MakeModuleContents(mod)
+	return mod, nil
+}
+
+// LinkBuiltins changes the operands of LOAD_BUILTIN in a module that was
+// read by ReadModule, so that they are the indices of the builtins with the
+// same names in the given BuiltinManager.  The module must be verified first.
+// An error is returned if the module uses a builtin that is not available.
+func LinkBuiltins(mod *BytecodeModule, builtins BuiltinManager) error {
+
+	indices := make([]int, len(mod.Builtins))
+	linked := []string{}
+	for i, name := range mod.Builtins {
+		if !builtins.Contains(name) {
+			return fmt.Errorf("unknown builtin '%s'", name)
+		}
+		indices[i] = builtins.IndexOf(name)
+		for len(linked) <= indices[i] {
+			linked = append(linked, "")
+		}
+		linked[indices[i]] = name
+	}
+
+	for _, t := range mod.Templates {
+		opc := t.OpCodes
+		for ip := 0; ip < len(opc); ip += OpCodeSize(opc[ip]) {
+			if opc[ip] == LOAD_BUILTIN {
+				n := indices[index(opc, ip)]
+				opc[ip+1], opc[ip+2] = byte(n>>8), byte(n)
+			}
+		}
+	}
+	mod.Builtins = linked
+	return nil
+}
+
+//---------------------------------------------------------------
+// moduleWriter
+
+// moduleWriter remembers the first error that occurs, and ignores
+// everything that is written afterwards.
+type moduleWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (mw *moduleWriter) bytes(b []byte) {
+	if mw.err == nil {
+		_, mw.err = mw.w.Write(b)
+	}
+}
+
+func (mw *moduleWriter) uint(n int) {
+	if n < 0 {
+		panic("invalid unsigned value")
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	mw.bytes(buf[:binary.PutUvarint(buf, uint64(n))])
+}
+
+func (mw *moduleWriter) int(n int) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	mw.bytes(buf[:binary.PutVarint(buf, int64(n))])
+}
+
+func (mw *moduleWriter) bool(b bool) {
+	if b {
+		mw.bytes([]byte{1})
+	} else {
+		mw.bytes([]byte{0})
+	}
+}
+
+func (mw *moduleWriter) str(s string) {
+	mw.uint(len(s))
+	mw.bytes([]byte(s))
+}
+
+func (mw *moduleWriter) strs(list []string) {
+	mw.uint(len(list))
+	for _, s := range list {
+		mw.str(s)
+	}
+}
+
+func (mw *moduleWriter) basic(b Basic) {
+
+	if b == NULL {
+		mw.bytes([]byte{tagNull})
+		return
+	}
+
+	// Int must come before Float, since every Int is also a Float
+	switch t := b.(type) {
+	case Bool:
+		if t.BoolVal() {
+			mw.bytes([]byte{tagTrue})
+		} else {
+			mw.bytes([]byte{tagFalse})
+		}
+	case Int:
+		mw.bytes([]byte{tagInt})
+		buf := make([]byte, binary.MaxVarintLen64)
+		mw.bytes(buf[:binary.PutVarint(buf, t.IntVal())])
+	case Float:
+		mw.bytes([]byte{tagFloat})
+		buf := make([]byte, 8)
+		binary.BigEndian.PutUint64(buf, math.Float64bits(t.FloatVal()))
+		mw.bytes(buf)
+	case Str:
+		mw.bytes([]byte{tagStr})
+		mw.str(t.String())
+	default:
+		panic("unreachable")
+	}
+}
+
+//---------------------------------------------------------------
+// moduleReader
+
+// moduleReader remembers the first error that occurs, and returns
+// zero values for everything that is read afterwards.
+type moduleReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (mr *moduleReader) bytes(n int) []byte {
+	if mr.err != nil {
+		return nil
+	}
+
+	// copy rather than allocating all n bytes up front, so that a
+	// corrupt length cannot exhaust memory
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, mr.r, int64(n)); err != nil {
+		mr.err = err
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func (mr *moduleReader) uint() int {
+	if mr.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(mr.r)
+	if err != nil {
+		mr.err = err
+		return 0
+	}
+	if n > math.MaxInt32 {
+		mr.err = fmt.Errorf("value %d is out of range", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (mr *moduleReader) int() int {
+	if mr.err != nil {
+		return 0
+	}
+	n, err := binary.ReadVarint(mr.r)
+	if err != nil {
+		mr.err = err
+		return 0
+	}
+	if n > math.MaxInt32 || n < math.MinInt32 {
+		mr.err = fmt.Errorf("value %d is out of range", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (mr *moduleReader) bool() bool {
+	b := mr.bytes(1)
+	if mr.err != nil {
+		return false
+	}
+	switch b[0] {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		mr.err = fmt.Errorf("invalid bool %d", b[0])
+		return false
+	}
+}
+
+func (mr *moduleReader) str() string {
+	return string(mr.bytes(mr.uint()))
+}
+
+func (mr *moduleReader) strs() []string {
+	n := mr.uint()
+	list := []string{}
+	for i := 0; i < n && mr.err == nil; i++ {
+		list = append(list, mr.str())
+	}
+	return list
+}
+
+func (mr *moduleReader) basic() Basic {
+	tag := mr.bytes(1)
+	if mr.err != nil {
+		return nil
+	}
+
+	switch tag[0] {
+	case tagNull:
+		return NULL
+	case tagTrue:
+		return TRUE
+	case tagFalse:
+		return FALSE
+	case tagInt:
+		n, err := binary.ReadVarint(mr.r)
+		if err != nil {
+			mr.err = err
+			return nil
+		}
+		return MakeInt(n)
+	case tagFloat:
+		b := mr.bytes(8)
+		if mr.err != nil {
+			return nil
+		}
+		return MakeFloat(math.Float64frombits(binary.BigEndian.Uint64(b)))
+	case tagStr:
+		return MakeStr(mr.str())
+	default:
+		mr.err = fmt.Errorf("invalid pool entry tag %d", tag[0])
+		return nil
+	}
+}
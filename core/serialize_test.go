@@ -0,0 +1,128 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSerialize(t *testing.T) {
+
+	mod := &BytecodeModule{
+		"test.glm",
+		[]Basic{NULL, TRUE, FALSE, MakeInt(-12345678901), MakeFloat(1.5), MakeStr("abc")},
+		nil,
+		[][]*StructEntryDef{
+			{{"a", true, false}, {"b", false, true}},
+			{}},
+		[]*Template{
+			{"<module>", 0, 0, 0, 0, 2,
+				[]byte{LOAD_NULL, LOAD_CONST, 0, 3, STORE_LOCAL, 0, 0, RETURN},
+				[]LineNumberEntry{{0, 0, 0}, {1, 1, 9}, {7, 0, 0}},
+				[]ExceptionHandler{{1, 4, -1, 4}}, 0,
+				[]string{"x", "y"}, []string{}},
+			{"f", 3, 1, -1, 1, 4,
+				[]byte{LOAD_NULL, RETURN, JUMP_FALSE, 0, 9, LOAD_ONE, STORE_LOCAL, 0, 1,
+					LOAD_NULL, JUMP, 0, 1},
+				[]LineNumberEntry{{0, 0, 0}},
+				[]ExceptionHandler{}, 2,
+				[]string{"a", "b", "c", "#synthetic0"}, []string{"x"}}},
+		[]*ModuleExport{{"x", 0, false}, {"y", 1, true}},
+		nil, nil}
+
+	var buf bytes.Buffer
+	assert(t, WriteModule(&buf, mod) == nil)
+	assert(t, bytes.HasPrefix(buf.Bytes(), []byte("GLMC\x06")))
+	data := buf.Bytes()
+
+	result, err := ReadModule(bytes.NewReader(data))
+	assert(t, err == nil)
+	assert(t, result.Name == mod.Name)
+	assert(t, reflect.DeepEqual(result.Pool, mod.Pool))
+	assert(t, reflect.DeepEqual(result.StructDefs, mod.StructDefs))
+	assert(t, reflect.DeepEqual(result.Templates, mod.Templates))
+	assert(t, reflect.DeepEqual(result.Exports, mod.Exports))
+	assert(t, result.Refs == nil)
+
+	// the contents are re-created from the exports
+	result.Refs = []*Ref{{MakeInt(7)}, {MakeInt(8)}}
+	val, gerr := result.Contents.GetField(MakeStr("x"))
+	ok(t, val, gerr, MakeInt(7))
+	assert(t, result.Contents.SetField(MakeStr("x"), ONE) == nil)
+	ok(t, result.Refs[0].Val, nil, ONE)
+	assert(t, result.Contents.SetField(MakeStr("y"), ONE) != nil)
+
+	// errors
+	_, err = ReadModule(bytes.NewReader([]byte("GOLEM")))
+	assert(t, err.Error() == "not a compiled golem module")
+
+	_, err = ReadModule(bytes.NewReader([]byte("GLMC\x63")))
+	assert(t, err.Error() == "unsupported module version 99, expected 6")
+
+	_, err = ReadModule(bytes.NewReader(data[:len(data)-3]))
+	assert(t, err.Error() == "unexpected EOF")
+
+	_, err = ReadModule(bytes.NewReader(append(data, 0)))
+	assert(t, err.Error() == "unexpected data at end of module")
+
+	corrupt := append([]byte{}, data...)
+	corrupt[15] = 42 // the tag of the first pool entry
+	_, err = ReadModule(bytes.NewReader(corrupt))
+	assert(t, err.Error() == "invalid pool entry tag 42")
+}
+
+func TestSerializeBuiltins(t *testing.T) {
+
+	mod := &BytecodeModule{
+		"test.glm", []Basic{}, nil, [][]*StructEntryDef{},
+		[]*Template{
+			{"<module>", 0, 0, 0, 0, 0,
+				[]byte{LOAD_NULL, LOAD_BUILTIN, 0, 7, LOAD_BUILTIN, 0, 2, LOAD_BUILTIN, 0, 7, RETURN},
+				[]LineNumberEntry{{0, 0, 0}},
+				[]ExceptionHandler{}, 0, []string{}, []string{}}},
+		[]*ModuleExport{},
+		[]string{"", "", "b", "", "", "", "", "a"},
+		nil}
+
+	// only the builtins that are used are written, in the order of their first use
+	var buf bytes.Buffer
+	assert(t, WriteModule(&buf, mod) == nil)
+	result, err := ReadModule(bytes.NewReader(buf.Bytes()))
+	assert(t, err == nil)
+	assert(t, reflect.DeepEqual(result.Builtins, []string{"a", "b"}))
+	assert(t, reflect.DeepEqual(result.Templates[0].OpCodes,
+		[]byte{LOAD_NULL, LOAD_BUILTIN, 0, 0, LOAD_BUILTIN, 0, 1, LOAD_BUILTIN, 0, 0, RETURN}))
+
+	// the module itself is not changed
+	assert(t, mod.Templates[0].OpCodes[3] == 7)
+
+	// linking looks the builtins up by name
+	assert(t, LinkBuiltins(result, NewBuiltinManager([]*BuiltinEntry{
+		{"b", NULL}, {"c", NULL}, {"a", NULL}})) == nil)
+	assert(t, reflect.DeepEqual(result.Builtins, []string{"b", "", "a"}))
+	assert(t, reflect.DeepEqual(result.Templates[0].OpCodes,
+		[]byte{LOAD_NULL, LOAD_BUILTIN, 0, 2, LOAD_BUILTIN, 0, 0, LOAD_BUILTIN, 0, 2, RETURN}))
+
+	result, _ = ReadModule(bytes.NewReader(buf.Bytes()))
+	err = LinkBuiltins(result, NewBuiltinManager([]*BuiltinEntry{{"b", NULL}}))
+	assert(t, err.Error() == "unknown builtin 'a'")
+
+	// a builtin must have a name to be written
+	mod.Builtins = []string{"", "", "b"}
+	err = WriteModule(&buf, mod)
+	assert(t, err.Error() == "the name of builtin 7 is not known")
+}
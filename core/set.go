@@ -146,11 +146,11 @@ func (s *set) NewIterator() Iterator {
 	itr := &setIterator{stc, s, s.hashMap.Iterator(), false}
 
 	stc.InitField(MakeStr("nextValue"), &nativeFunc{
-		func(values []Value) (Value, Error) {
+		func(ev Eval, values []Value) (Value, Error) {
 			return itr.IterNext(), nil
 		}})
 	stc.InitField(MakeStr("getValue"), &nativeFunc{
-		func(values []Value) (Value, Error) {
+		func(ev Eval, values []Value) (Value, Error) {
 			return itr.IterGet()
 		}})
 
@@ -180,7 +180,7 @@ func (s *set) GetField(key Str) (Value, Error) {
 
 	case "add":
 		return &intrinsicFunc{s, "add", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 1 {
 					return nil, ArityMismatchError("1", len(values))
 				}
@@ -194,7 +194,7 @@ func (s *set) GetField(key Str) (Value, Error) {
 
 	case "addAll":
 		return &intrinsicFunc{s, "addAll", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 1 {
 					return nil, ArityMismatchError("1", len(values))
 				}
@@ -208,7 +208,7 @@ func (s *set) GetField(key Str) (Value, Error) {
 
 	case "clear":
 		return &intrinsicFunc{s, "clear", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 0 {
 					return nil, ArityMismatchError("0", len(values))
 				}
@@ -218,7 +218,7 @@ func (s *set) GetField(key Str) (Value, Error) {
 
 	case "isEmpty":
 		return &intrinsicFunc{s, "isEmpty", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 0 {
 					return nil, ArityMismatchError("0", len(values))
 				}
@@ -227,7 +227,7 @@ func (s *set) GetField(key Str) (Value, Error) {
 
 	case "contains":
 		return &intrinsicFunc{s, "contains", &nativeFunc{
-			func(values []Value) (Value, Error) {
+			func(ev Eval, values []Value) (Value, Error) {
 				if len(values) != 1 {
 					return nil, ArityMismatchError("1", len(values))
 				}
@@ -153,11 +153,11 @@ func (s str) NewIterator() Iterator {
 
 	// TODO make the struct immutable once we have set the functions
 	stc.InitField(MakeStr("nextValue"), &nativeFunc{
-		func(values []Value) (Value, Error) {
+		func(ev Eval, values []Value) (Value, Error) {
 			return itr.IterNext(), nil
 		}})
 	stc.InitField(MakeStr("getValue"), &nativeFunc{
-		func(values []Value) (Value, Error) {
+		func(ev Eval, values []Value) (Value, Error) {
 			return itr.IterGet()
 		}})
 
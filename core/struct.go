@@ -186,7 +186,7 @@ func (stc *_struct) GetField(key Str) (Value, Error) {
 			// containing two functions: the getter, and the setter.
 			// TODO Add support for BytecodeFunc properties.
 			fn := ((e.Value.(tuple))[0]).(NativeFunc)
-			return fn.Invoke(nil)
+			return fn.Invoke(nil, nil)
 		} else {
 			return e.Value, nil
 		}
@@ -207,7 +207,7 @@ func (stc *_struct) SetField(key Str, val Value) Error {
 				// containing two functions: the getter, and the setter.
 				// TODO Add support for BytecodeFunc properties.
 				fn := ((e.Value.(tuple))[1]).(NativeFunc)
-				_, err := fn.Invoke([]Value{val})
+				_, err := fn.Invoke(nil, []Value{val})
 				return err
 			} else {
 				e.Value = val
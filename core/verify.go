@@ -0,0 +1,644 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//---------------------------------------------------------------
+// Bytecode verification.
+//
+// The interpreter assumes that the bytecode it runs is well formed, and
+// panics if it is not.  That is fine for modules that were just produced
+// by the compiler, but a module that was read from disk must be verified
+// before it is run.
+
+// VerifyModule checks that a module is well formed.  The number of builtins
+// is the number that will be available when the module is run.
+func VerifyModule(mod *BytecodeModule, numBuiltins int) error {
+
+	if len(mod.Templates) == 0 {
+		return errors.New("invalid module: there are no templates")
+	}
+
+	// the zeroth template initializes the module
+	first := mod.Templates[0]
+	if first.Arity != 0 || first.NumCaptures != 0 {
+		return errors.New(
+			"invalid module: template 0 cannot have parameters or captures")
+	}
+	for _, e := range mod.Exports {
+		if e.RefIndex >= first.NumLocals {
+			return fmt.Errorf(
+				"invalid module: export '%s' has invalid ref index %d",
+				e.Name, e.RefIndex)
+		}
+	}
+
+	for i, tpl := range mod.Templates {
+		v := &verifier{mod, numBuiltins, i, tpl, nil}
+		if err := v.verify(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type verifier struct {
+	mod         *BytecodeModule
+	numBuiltins int
+	tplIndex    int
+	tpl         *Template
+
+	// the instruction pointers that begin an opcode
+	starts map[int]bool
+}
+
+func (v *verifier) verify() error {
+
+	tpl := v.tpl
+	opc := tpl.OpCodes
+
+	if tpl.NumLocals < tpl.Arity {
+		return v.fail("%d locals is fewer than arity %d", tpl.NumLocals, tpl.Arity)
+	}
+
+	// the required params are followed by the optional ones, which
+	// have a prologue, and then by the variadic one, if any
+	if !tpl.IsVariadic() && tpl.MaxArity != tpl.Arity {
+		return v.fail("max arity %d is not arity %d", tpl.MaxArity, tpl.Arity)
+	}
+	if tpl.MinArity < 0 || tpl.NumOptional() < 0 {
+		return v.fail("arity %d, min arity %d and max arity %d do not match",
+			tpl.Arity, tpl.MinArity, tpl.MaxArity)
+	}
+	if tpl.NumOptional() == 0 && tpl.Prologue != 0 {
+		return v.fail("prologue %d without optional params", tpl.Prologue)
+	}
+	if len(opc) == 0 {
+		return v.fail("there are no opcodes")
+	}
+	if n := len(tpl.LocalNames); n != 0 && n != tpl.NumLocals {
+		return v.fail("%d local names for %d locals", n, tpl.NumLocals)
+	}
+	if n := len(tpl.CaptureNames); n != 0 && n != tpl.NumCaptures {
+		return v.fail("%d capture names for %d captures", n, tpl.NumCaptures)
+	}
+
+	// check each opcode and its operand
+	v.starts = make(map[int]bool)
+	captures := 0
+	for ip := 0; ip < len(opc); ip += OpCodeSize(opc[ip]) {
+		v.starts[ip] = true
+
+		if err := v.checkOpcode(ip); err != nil {
+			return err
+		}
+
+		// a NEW_FUNC must be followed by one FUNC_LOCAL or FUNC_CAPTURE
+		// for each of the captures of the new function
+		isCapture := opc[ip] == FUNC_LOCAL || opc[ip] == FUNC_CAPTURE
+		switch {
+		case captures > 0 && !isCapture:
+			return v.failAt(ip, "expected %d more captures", captures)
+		case captures == 0 && isCapture:
+			return v.failAt(ip, "capture does not follow NEW_FUNC")
+		case isCapture:
+			captures--
+		}
+		if opc[ip] == NEW_FUNC {
+			captures = v.mod.Templates[index(opc, ip)].NumCaptures
+		}
+	}
+	if captures > 0 {
+		return v.fail("expected %d more captures at end of opcodes", captures)
+	}
+
+	// check the jump targets, now that we know where each opcode begins
+	for ip := 0; ip < len(opc); ip += OpCodeSize(opc[ip]) {
+		switch opc[ip] {
+		case JUMP, JUMP_TRUE, JUMP_FALSE:
+			if err := v.checkTarget(ip, index(opc, ip)); err != nil {
+				return err
+			}
+		}
+	}
+	if tpl.NumOptional() > 0 && !v.starts[tpl.Prologue] {
+		return v.fail("prologue %d is not the start of an opcode", tpl.Prologue)
+	}
+
+	if err := v.checkLineNumbers(); err != nil {
+		return err
+	}
+	if err := v.checkHandlers(); err != nil {
+		return err
+	}
+	return v.checkStack()
+}
+
+// checkOpcode checks that an opcode is valid, and that its operand is in range.
+func (v *verifier) checkOpcode(ip int) error {
+
+	opc := v.tpl.OpCodes
+	switch opc[ip] {
+	case BREAK, CONTINUE:
+		return v.failAt(ip, "unresolved %s", v.name(ip))
+	}
+	if opc[ip] > IMPORT {
+		return v.failAt(ip, "invalid opcode %d", opc[ip])
+	}
+	if ip+OpCodeSize(opc[ip]) > len(opc) {
+		return v.failAt(ip, "%s is truncated", v.name(ip))
+	}
+
+	switch opc[ip] {
+
+	case LOAD_BUILTIN:
+		return v.checkIndex(ip, v.numBuiltins, "builtins")
+
+	case LOAD_CONST:
+		return v.checkIndex(ip, len(v.mod.Pool), "pool")
+
+	case GET_FIELD, INIT_FIELD, SET_FIELD, INC_FIELD, IMPORT:
+		if err := v.checkIndex(ip, len(v.mod.Pool), "pool"); err != nil {
+			return err
+		}
+		if _, ok := v.mod.Pool[index(opc, ip)].(Str); !ok {
+			return v.failAt(ip, "%s name is not a Str", v.name(ip))
+		}
+
+	case LOAD_LOCAL, STORE_LOCAL, FUNC_LOCAL:
+		return v.checkIndex(ip, v.tpl.NumLocals, "locals")
+
+	case LOAD_CAPTURE, STORE_CAPTURE, FUNC_CAPTURE:
+		return v.checkIndex(ip, v.tpl.NumCaptures, "captures")
+
+	case NEW_FUNC:
+		return v.checkIndex(ip, len(v.mod.Templates), "templates")
+
+	case NEW_STRUCT:
+		return v.checkIndex(ip, len(v.mod.StructDefs), "struct defs")
+
+	case CHECK_CAST:
+		return v.checkIndex(ip, int(TCHAN)+1, "types")
+
+	case NEW_TUPLE:
+		if index(opc, ip) < 2 {
+			return v.failAt(ip, "NEW_TUPLE size %d is less than 2", index(opc, ip))
+		}
+	}
+
+	return nil
+}
+
+func (v *verifier) checkIndex(ip int, size int, what string) error {
+	idx := index(v.tpl.OpCodes, ip)
+	if idx >= size {
+		return v.failAt(ip, "%s index %d is out of range (%s size %d)",
+			v.name(ip), idx, what, size)
+	}
+	return nil
+}
+
+func (v *verifier) checkTarget(ip int, target int) error {
+	if !v.starts[target] {
+		return v.failAt(ip, "%s target %d is not the start of an opcode",
+			v.name(ip), target)
+	}
+	if op := v.tpl.OpCodes[target]; op == FUNC_LOCAL || op == FUNC_CAPTURE {
+		return v.failAt(ip, "%s target %d is inside a function definition",
+			v.name(ip), target)
+	}
+	return nil
+}
+
+func (v *verifier) checkLineNumbers() error {
+
+	table := v.tpl.LineNumberTable
+	if len(table) == 0 {
+		return v.fail("line number table is empty")
+	}
+	for i, ln := range table {
+		if ln.Index < 0 || ln.Index > len(v.tpl.OpCodes) ||
+			(i > 0 && ln.Index < table[i-1].Index) {
+			return v.fail("line number entry %d has invalid index %d", i, ln.Index)
+		}
+	}
+	return nil
+}
+
+func (v *verifier) checkHandlers() error {
+
+	n := len(v.tpl.OpCodes)
+	boundary := func(ip int) bool {
+		return ip == n || v.starts[ip]
+	}
+
+	for i, eh := range v.tpl.ExceptionHandlers {
+		if !boundary(eh.Begin) || !boundary(eh.End) || eh.Begin > eh.End {
+			return v.fail("exception handler %d has invalid range [%d, %d)",
+				i, eh.Begin, eh.End)
+		}
+		if eh.Catch == -1 && eh.Finally == -1 {
+			return v.fail("exception handler %d has neither catch nor finally", i)
+		}
+		if eh.Catch != -1 && !v.starts[eh.Catch] {
+			return v.fail("exception handler %d has invalid catch %d", i, eh.Catch)
+		}
+		if eh.Finally != -1 && !v.starts[eh.Finally] {
+			return v.fail("exception handler %d has invalid finally %d", i, eh.Finally)
+		}
+	}
+	return nil
+}
+
+// checkStack follows every path through the opcodes, to make sure that the
+// stack never underflows, that execution never runs off the end, that the
+// iterator opcodes are only used on iterators, and that DONE is only
+// reached at the end of a catch or finally clause.  Expression statements
+// leave their value on the stack, so the depth can differ between the paths
+// that reach an opcode.  We keep track of the smallest depth, since that is
+// the one that could underflow.
+func (v *verifier) checkStack() error {
+
+	opc := v.tpl.OpCodes
+	states := make(map[int]*stackState)
+	work := []int{}
+
+	enter := func(ip int, st *stackState) {
+		if old, ok := states[ip]; ok {
+			var changed bool
+			if st, changed = old.merge(st); !changed {
+				return
+			}
+		}
+		states[ip] = st
+		work = append(work, ip)
+	}
+
+	ends := v.clauseEnds()
+	captured := v.capturedLocals()
+
+	enter(0, newStackState(0, v.tpl.NumLocals))
+	if n := v.tpl.NumOptional(); n > 0 {
+		enter(v.tpl.Prologue, newStackState(n, v.tpl.NumLocals))
+	}
+
+	for {
+		for len(work) > 0 {
+			ip := work[len(work)-1]
+			work = work[:len(work)-1]
+			st := states[ip]
+			depth := len(st.iters)
+
+			need, effect := stackEffect(opc, ip)
+			if depth < need {
+				return v.failAt(ip, "%s needs %d values, but the stack has %d",
+					v.name(ip), need, depth)
+			}
+
+			switch opc[ip] {
+			case ITER_NEXT, ITER_GET:
+				if !st.iters[depth-1] {
+					return v.failAt(ip, "%s operand was not created by ITER", v.name(ip))
+				}
+			case DONE:
+				if !ends[ip] {
+					return v.failAt(ip, "DONE does not end a catch or finally clause")
+				}
+			}
+
+			// DONE marks the end of a catch or finally clause.  When
+			// a finally clause is reached by normal execution, then
+			// execution simply carries on past it.
+			after := st.advance(opc, ip, need, effect, captured)
+			switch opc[ip] {
+			case RETURN, THROW:
+				continue
+			case JUMP:
+				enter(index(opc, ip), after)
+				continue
+			case JUMP_TRUE, JUMP_FALSE:
+				enter(index(opc, ip), after)
+			}
+
+			next := ip + OpCodeSize(opc[ip])
+			if next == len(opc) {
+				return v.failAt(ip, "execution runs past the end of the opcodes")
+			}
+			enter(next, after)
+		}
+
+		// A catch clause starts with the error pushed onto the stack, and a
+		// finally clause starts with the stack, as they were when the error
+		// was raised.  That can be anywhere in the protected range, even
+		// before the first opcode has done anything, and we cannot count on
+		// an opcode to leave its operands behind.  Entering a clause can
+		// lower the depths in another range, so we go around again until
+		// nothing changes.
+		for _, eh := range v.tpl.ExceptionHandlers {
+			if st, ok := v.handlerState(states, eh); ok {
+				if eh.Catch != -1 {
+					enter(eh.Catch, st.push(false))
+				}
+				if eh.Finally != -1 {
+					enter(eh.Finally, st)
+				}
+			}
+		}
+		if len(work) == 0 {
+			return nil
+		}
+	}
+}
+
+// handlerState returns the state that a clause of an exception handler
+// starts with: the smallest depth that the stack can have when an error
+// is raised in the handler's range, and the locals as they could be
+// anywhere in the range.  If none of the range is reachable yet, then
+// ok is false.
+func (v *verifier) handlerState(states map[int]*stackState, eh ExceptionHandler) (*stackState, bool) {
+
+	opc := v.tpl.OpCodes
+	var result *stackState
+	for ip := eh.Begin; ip < eh.End; ip += OpCodeSize(opc[ip]) {
+		if st, found := states[ip]; found {
+			need, _ := stackEffect(opc, ip)
+			st = &stackState{make([]bool, len(st.iters)-need), st.locals}
+			if result == nil {
+				result = st
+			} else {
+				result, _ = result.merge(st)
+			}
+		}
+	}
+	return result, result != nil
+}
+
+// clauseEnds returns the DONE opcodes at which a catch or finally clause
+// can end.  A clause runs until the first DONE that it reaches, except
+// that a nested finally clause that it falls into ends at its own DONE,
+// after which the enclosing clause carries on.
+func (v *verifier) clauseEnds() map[int]bool {
+
+	opc := v.tpl.OpCodes
+	clauses := make(map[int]bool)
+	for _, eh := range v.tpl.ExceptionHandlers {
+		if eh.Catch != -1 {
+			clauses[eh.Catch] = true
+		}
+		if eh.Finally != -1 {
+			clauses[eh.Finally] = true
+		}
+	}
+
+	found := make(map[int][]int)
+	var endsOf func(begin int) []int
+	endsOf = func(begin int) []int {
+
+		if ends, ok := found[begin]; ok {
+			return ends
+		}
+		found[begin] = nil
+
+		ends := []int{}
+		seen := make(map[int]bool)
+		work := []int{begin}
+		for len(work) > 0 {
+			ip := work[len(work)-1]
+			work = work[:len(work)-1]
+			if ip >= len(opc) || seen[ip] {
+				continue
+			}
+			seen[ip] = true
+
+			if ip != begin && clauses[ip] {
+				for _, end := range endsOf(ip) {
+					work = append(work, end+OpCodeSize(DONE))
+				}
+				continue
+			}
+
+			switch opc[ip] {
+			case DONE:
+				ends = append(ends, ip)
+				continue
+			case RETURN, THROW:
+				continue
+			case JUMP:
+				work = append(work, index(opc, ip))
+				continue
+			case JUMP_TRUE, JUMP_FALSE:
+				work = append(work, index(opc, ip))
+			}
+			work = append(work, ip+OpCodeSize(opc[ip]))
+		}
+
+		found[begin] = ends
+		return ends
+	}
+
+	result := make(map[int]bool)
+	for begin := range clauses {
+		for _, end := range endsOf(begin) {
+			result[end] = true
+		}
+	}
+	return result
+}
+
+// capturedLocals returns the locals that are captured by a function.
+// A captured local can be changed whenever a function is invoked,
+// so we cannot tell what it holds.
+func (v *verifier) capturedLocals() map[int]bool {
+
+	opc := v.tpl.OpCodes
+	captured := make(map[int]bool)
+	for ip := 0; ip < len(opc); ip += OpCodeSize(opc[ip]) {
+		if opc[ip] == FUNC_LOCAL {
+			captured[index(opc, ip)] = true
+		}
+	}
+	return captured
+}
+
+// stackEffect returns the number of values that an opcode needs on the
+// stack, and how much it changes the depth of the stack.
+func stackEffect(opc []byte, ip int) (need int, effect int) {
+
+	switch opc[ip] {
+
+	case LOAD_NULL, LOAD_TRUE, LOAD_FALSE, LOAD_ZERO, LOAD_ONE, LOAD_NEG_ONE,
+		LOAD_BUILTIN, LOAD_CONST, LOAD_LOCAL, LOAD_CAPTURE,
+		NEW_FUNC, NEW_STRUCT, IMPORT:
+		return 0, 1
+
+	case DUP:
+		return 1, 1
+
+	case STORE_LOCAL, STORE_CAPTURE, JUMP_TRUE, JUMP_FALSE, POP:
+		return 1, -1
+
+	case EQ, NE, GT, GTE, LT, LTE, CMP, HAS,
+		PLUS, SUB, MUL, DIV,
+		REM, BIT_AND, BIT_OR, BIT_XOR, LEFT_SHIFT, RIGHT_SHIFT,
+		INIT_FIELD, SET_FIELD, INC_FIELD,
+		GET_INDEX, SLICE_FROM, SLICE_TO:
+		return 2, -1
+
+	case SET_INDEX, INC_INDEX, SLICE:
+		return 3, -2
+
+	case NEGATE, NOT, COMPLEMENT, GET_FIELD,
+		ITER, ITER_NEXT, ITER_GET,
+		FUNC_LOCAL, FUNC_CAPTURE, CHECK_CAST, CHECK_TUPLE,
+		RETURN, THROW:
+		return 1, 0
+
+	case INVOKE:
+		n := index(opc, ip)
+		return n + 1, -n
+
+	case SPAWN:
+		n := index(opc, ip)
+		return n + 1, -(n + 1)
+
+	case INVOKE_NAMED:
+		n := index(opc, ip)
+		return n + 2, -(n + 1)
+
+	case SPAWN_NAMED:
+		n := index(opc, ip)
+		return n + 2, -(n + 2)
+
+	case NEW_LIST, NEW_SET, NEW_TUPLE:
+		n := index(opc, ip)
+		return n, 1 - n
+
+	case NEW_DICT:
+		n := index(opc, ip)
+		return 2 * n, 1 - 2*n
+
+	case JUMP, DONE:
+		return 0, 0
+
+	default:
+		panic("unreachable")
+	}
+}
+
+// name returns the name of the opcode at the given instruction pointer.
+func (v *verifier) name(ip int) string {
+	opc := v.tpl.OpCodes
+	if ip+OpCodeSize(opc[ip]) > len(opc) {
+		// FmtOpcode needs the operand as well
+		opc = append(append([]byte{}, opc[ip]), 0, 0)
+		ip = 0
+	}
+	return strings.Fields(FmtOpcode(opc, ip))[1]
+}
+
+func (v *verifier) fail(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid bytecode in template %d: %s",
+		v.tplIndex, fmt.Sprintf(format, args...))
+}
+
+func (v *verifier) failAt(ip int, format string, args ...interface{}) error {
+	return fmt.Errorf("invalid bytecode in template %d at %d: %s",
+		v.tplIndex, ip, fmt.Sprintf(format, args...))
+}
+
+// index decodes the operand of the opcode at the given instruction pointer.
+func index(opcodes []byte, ip int) int {
+	return int(opcodes[ip+1])<<8 + int(opcodes[ip+2])
+}
+
+//---------------------------------------------------------------
+// stackState
+
+// stackState is what the verifier knows about the values on the stack, and
+// in the locals, at a given opcode.  The only thing that we keep track of is
+// which of the values are the iterators that ITER creates, since ITER_NEXT
+// and ITER_GET trust that their operand is one.
+type stackState struct {
+	iters  []bool
+	locals []bool
+}
+
+func newStackState(depth int, numLocals int) *stackState {
+	return &stackState{make([]bool, depth), make([]bool, numLocals)}
+}
+
+func (st *stackState) push(iter bool) *stackState {
+	iters := append(append([]bool{}, st.iters...), iter)
+	return &stackState{iters, st.locals}
+}
+
+// advance returns the state after an opcode has been executed.
+func (st *stackState) advance(
+	opc []byte, ip int, need int, effect int, captured map[int]bool) *stackState {
+
+	depth := len(st.iters)
+	iters := append([]bool{}, st.iters[:depth-need]...)
+	locals := st.locals
+	pushed := make([]bool, need+effect)
+
+	switch opc[ip] {
+	case ITER:
+		pushed[0] = true
+	case DUP:
+		pushed[0], pushed[1] = st.iters[depth-1], st.iters[depth-1]
+	case LOAD_LOCAL:
+		pushed[0] = st.locals[index(opc, ip)]
+	case STORE_LOCAL:
+		idx := index(opc, ip)
+		locals = append([]bool{}, st.locals...)
+		locals[idx] = st.iters[depth-1] && !captured[idx]
+	}
+
+	return &stackState{append(iters, pushed...), locals}
+}
+
+// merge returns the state of an opcode that can be reached with either
+// state, and whether it differs from this one.  Any values that are
+// deeper in the stack than the smallest depth cannot be used, and a value
+// is only an iterator if it is one in both states.
+func (st *stackState) merge(other *stackState) (*stackState, bool) {
+
+	n := len(st.iters)
+	if len(other.iters) < n {
+		n = len(other.iters)
+	}
+	changed := n < len(st.iters)
+
+	iters := make([]bool, n)
+	for j := range iters {
+		a := st.iters[len(st.iters)-n+j]
+		b := other.iters[len(other.iters)-n+j]
+		iters[j] = a && b
+		changed = changed || a != iters[j]
+	}
+
+	locals := make([]bool, len(st.locals))
+	for j := range locals {
+		locals[j] = st.locals[j] && other.locals[j]
+		changed = changed || st.locals[j] != locals[j]
+	}
+
+	return &stackState{iters, locals}, changed
+}
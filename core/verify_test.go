@@ -0,0 +1,333 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"testing"
+)
+
+func verifyModule(opcodes []byte, handlers []ExceptionHandler) *BytecodeModule {
+	return &BytecodeModule{
+		"test.glm",
+		[]Basic{MakeStr("a"), ONE},
+		nil,
+		[][]*StructEntryDef{{{"a", false, false}}},
+		[]*Template{
+			{"<module>", 0, 0, 0, 0, 2, opcodes, []LineNumberEntry{{0, 1, 1}}, handlers, 0,
+				[]string{"f", "x"}, nil},
+			{"f", 1, 1, 1, 1, 1,
+				[]byte{LOAD_CAPTURE, 0, 0, RETURN},
+				[]LineNumberEntry{{0, 2, 1}},
+				[]ExceptionHandler{}, 0,
+				[]string{"a"}, []string{"f"}}},
+		[]*ModuleExport{{"x", 1, false}},
+		nil, nil}
+}
+
+func verifyFail(t *testing.T, mod *BytecodeModule, expect string) {
+	err := VerifyModule(mod, 3)
+	if err == nil {
+		t.Error("expected error: ", expect)
+	} else if err.Error() != expect {
+		t.Error(err.Error(), " != ", expect)
+	}
+}
+
+func TestVerify(t *testing.T) {
+
+	// a valid module
+	mod := verifyModule([]byte{
+		NEW_FUNC, 0, 1, // 0
+		FUNC_LOCAL, 0, 0, // 3
+		STORE_LOCAL, 0, 1, // 6
+		LOAD_BUILTIN, 0, 2, // 9
+		LOAD_CONST, 0, 1, // 12
+		INVOKE, 0, 1, // 15
+		JUMP_FALSE, 0, 27, // 18
+		LOAD_TRUE,        // 21
+		THROW,            // 22
+		NEW_STRUCT, 0, 0, // 23
+		RETURN,            // 26
+		LOAD_NULL,         // 27
+		RETURN,            // 28
+		STORE_LOCAL, 0, 0, // 29: catch
+		LOAD_NULL,         // 32
+		DONE,              // 33
+		LOAD_NULL,         // 34: finally
+		STORE_LOCAL, 0, 0, // 35
+		DONE,      // 38
+		LOAD_NULL, // 39
+		RETURN},   // 40
+		[]ExceptionHandler{{9, 23, 29, 34}})
+	assert(t, VerifyModule(mod, 3) == nil)
+
+	// a for loop
+	mod = verifyModule([]byte{
+		NEW_LIST, 0, 0, // 0
+		ITER,              // 3
+		STORE_LOCAL, 0, 0, // 4
+		LOAD_LOCAL, 0, 0, // 7
+		ITER_NEXT,         // 10
+		JUMP_FALSE, 0, 24, // 11
+		LOAD_LOCAL, 0, 0, // 14
+		ITER_GET,          // 17
+		STORE_LOCAL, 0, 1, // 18
+		JUMP, 0, 7, // 21
+		LOAD_NULL, // 24
+		RETURN},   // 25
+		nil)
+	assert(t, VerifyModule(mod, 3) == nil)
+
+	// module
+	verifyFail(t, &BytecodeModule{}, "invalid module: there are no templates")
+
+	mod = verifyModule([]byte{LOAD_NULL, RETURN}, nil)
+	mod.Templates[0].Arity = 1
+	verifyFail(t, mod, "invalid module: template 0 cannot have parameters or captures")
+
+	mod = verifyModule([]byte{LOAD_NULL, RETURN}, nil)
+	mod.Exports[0].RefIndex = 2
+	verifyFail(t, mod, "invalid module: export 'x' has invalid ref index 2")
+
+	mod = verifyModule([]byte{LOAD_NULL, RETURN}, nil)
+	mod.Templates[1].NumLocals = 0
+	verifyFail(t, mod, "invalid bytecode in template 1: 0 locals is fewer than arity 1")
+
+	mod = verifyModule([]byte{LOAD_NULL, RETURN}, nil)
+	mod.Templates[1].MaxArity = 2
+	verifyFail(t, mod, "invalid bytecode in template 1: max arity 2 is not arity 1")
+
+	mod = verifyModule([]byte{LOAD_NULL, RETURN}, nil)
+	mod.Templates[1].MinArity = 2
+	verifyFail(t, mod, "invalid bytecode in template 1: arity 1, min arity 2 and max arity 1 do not match")
+
+	mod = verifyModule([]byte{LOAD_NULL, RETURN}, nil)
+	mod.Templates[1].Prologue = 1
+	verifyFail(t, mod, "invalid bytecode in template 1: prologue 1 without optional params")
+
+	mod = verifyModule([]byte{LOAD_NULL, RETURN}, nil)
+	mod.Templates[1].MinArity = 0
+	mod.Templates[1].Prologue = 1
+	verifyFail(t, mod, "invalid bytecode in template 1: prologue 1 is not the start of an opcode")
+
+	// the prologue begins with a flag on the stack for each optional param
+	mod = verifyModule([]byte{LOAD_NULL, RETURN}, nil)
+	mod.Templates[1].OpCodes = []byte{LOAD_NULL, RETURN, POP, POP, RETURN}
+	mod.Templates[1].MinArity = 0
+	mod.Templates[1].Prologue = 2
+	verifyFail(t, mod, "invalid bytecode in template 1 at 3: POP needs 1 values, but the stack has 0")
+
+	mod = verifyModule([]byte{LOAD_NULL, RETURN}, nil)
+	mod.Templates[1].LineNumberTable = nil
+	verifyFail(t, mod, "invalid bytecode in template 1: line number table is empty")
+
+	mod = verifyModule([]byte{LOAD_NULL, RETURN}, nil)
+	mod.Templates[1].LocalNames = []string{"a", "b"}
+	verifyFail(t, mod, "invalid bytecode in template 1: 2 local names for 1 locals")
+
+	mod = verifyModule([]byte{LOAD_NULL, RETURN}, nil)
+	mod.Templates[1].CaptureNames = []string{"a", "b"}
+	verifyFail(t, mod, "invalid bytecode in template 1: 2 capture names for 1 captures")
+
+	// opcodes and operands
+	verifyFail(t, verifyModule([]byte{}, nil),
+		"invalid bytecode in template 0: there are no opcodes")
+
+	verifyFail(t, verifyModule([]byte{LOAD_NULL, 200}, nil),
+		"invalid bytecode in template 0 at 1: invalid opcode 200")
+
+	verifyFail(t, verifyModule([]byte{LOAD_NULL, BREAK, 0, 0}, nil),
+		"invalid bytecode in template 0 at 1: unresolved BREAK")
+
+	verifyFail(t, verifyModule([]byte{LOAD_NULL, CONTINUE, 0, 0}, nil),
+		"invalid bytecode in template 0 at 1: unresolved CONTINUE")
+
+	verifyFail(t, verifyModule([]byte{LOAD_NULL, LOAD_CONST, 0}, nil),
+		"invalid bytecode in template 0 at 1: LOAD_CONST is truncated")
+
+	verifyFail(t, verifyModule([]byte{LOAD_CONST, 0, 2, RETURN}, nil),
+		"invalid bytecode in template 0 at 0: LOAD_CONST index 2 is out of range (pool size 2)")
+
+	verifyFail(t, verifyModule([]byte{LOAD_NULL, GET_FIELD, 0, 1, RETURN}, nil),
+		"invalid bytecode in template 0 at 1: GET_FIELD name is not a Str")
+
+	verifyFail(t, verifyModule([]byte{IMPORT, 0, 1, RETURN}, nil),
+		"invalid bytecode in template 0 at 0: IMPORT name is not a Str")
+
+	verifyFail(t, verifyModule([]byte{LOAD_BUILTIN, 0, 3, RETURN}, nil),
+		"invalid bytecode in template 0 at 0: LOAD_BUILTIN index 3 is out of range (builtins size 3)")
+
+	verifyFail(t, verifyModule([]byte{LOAD_LOCAL, 1, 0, RETURN}, nil),
+		"invalid bytecode in template 0 at 0: LOAD_LOCAL index 256 is out of range (locals size 2)")
+
+	verifyFail(t, verifyModule([]byte{LOAD_CAPTURE, 0, 0, RETURN}, nil),
+		"invalid bytecode in template 0 at 0: LOAD_CAPTURE index 0 is out of range (captures size 0)")
+
+	verifyFail(t, verifyModule([]byte{NEW_FUNC, 0, 2, RETURN}, nil),
+		"invalid bytecode in template 0 at 0: NEW_FUNC index 2 is out of range (templates size 2)")
+
+	verifyFail(t, verifyModule([]byte{NEW_STRUCT, 0, 1, RETURN}, nil),
+		"invalid bytecode in template 0 at 0: NEW_STRUCT index 1 is out of range (struct defs size 1)")
+
+	verifyFail(t, verifyModule([]byte{LOAD_NULL, CHECK_CAST, 0, 99, RETURN}, nil),
+		"invalid bytecode in template 0 at 1: CHECK_CAST index 99 is out of range (types size 13)")
+
+	verifyFail(t, verifyModule([]byte{LOAD_NULL, NEW_TUPLE, 0, 1, RETURN}, nil),
+		"invalid bytecode in template 0 at 1: NEW_TUPLE size 1 is less than 2")
+
+	// captures
+	verifyFail(t, verifyModule([]byte{NEW_FUNC, 0, 1, RETURN}, nil),
+		"invalid bytecode in template 0 at 3: expected 1 more captures")
+
+	verifyFail(t, verifyModule([]byte{NEW_FUNC, 0, 1}, nil),
+		"invalid bytecode in template 0: expected 1 more captures at end of opcodes")
+
+	verifyFail(t, verifyModule([]byte{LOAD_NULL, FUNC_LOCAL, 0, 0, RETURN}, nil),
+		"invalid bytecode in template 0 at 1: capture does not follow NEW_FUNC")
+
+	// jumps
+	verifyFail(t, verifyModule([]byte{JUMP, 0, 2, LOAD_NULL, RETURN}, nil),
+		"invalid bytecode in template 0 at 0: JUMP target 2 is not the start of an opcode")
+
+	verifyFail(t, verifyModule([]byte{JUMP, 0, 9, LOAD_NULL, RETURN}, nil),
+		"invalid bytecode in template 0 at 0: JUMP target 9 is not the start of an opcode")
+
+	verifyFail(t, verifyModule([]byte{
+		JUMP, 0, 6, NEW_FUNC, 0, 1, FUNC_LOCAL, 0, 0, RETURN}, nil),
+		"invalid bytecode in template 0 at 0: JUMP target 6 is inside a function definition")
+
+	// exception handlers
+	verifyFail(t, verifyModule([]byte{LOAD_NULL, RETURN}, []ExceptionHandler{{0, 3, -1, 1}}),
+		"invalid bytecode in template 0: exception handler 0 has invalid range [0, 3)")
+
+	verifyFail(t, verifyModule([]byte{LOAD_NULL, RETURN}, []ExceptionHandler{{0, 1, -1, -1}}),
+		"invalid bytecode in template 0: exception handler 0 has neither catch nor finally")
+
+	verifyFail(t, verifyModule([]byte{LOAD_NULL, RETURN}, []ExceptionHandler{{0, 1, 5, -1}}),
+		"invalid bytecode in template 0: exception handler 0 has invalid catch 5")
+
+	// stack
+	verifyFail(t, verifyModule([]byte{LOAD_NULL, PLUS, RETURN}, nil),
+		"invalid bytecode in template 0 at 1: PLUS needs 2 values, but the stack has 1")
+
+	verifyFail(t, verifyModule([]byte{LOAD_NULL, LOAD_NULL, INVOKE, 0, 2, RETURN}, nil),
+		"invalid bytecode in template 0 at 2: INVOKE needs 3 values, but the stack has 2")
+
+	// the smallest depth is used when paths merge
+	verifyFail(t, verifyModule([]byte{
+		LOAD_TRUE,       // 0
+		JUMP_TRUE, 0, 7, // 1
+		LOAD_NULL, LOAD_NULL, // 4
+		POP, // 6
+		POP, // 7
+		LOAD_NULL, RETURN}, nil),
+		"invalid bytecode in template 0 at 7: POP needs 1 values, but the stack has 0")
+
+	// an error can be raised before anything has been pushed in a protected range
+	verifyFail(t, verifyModule([]byte{
+		IMPORT, 0, 0, // 0
+		RETURN,            // 3
+		STORE_LOCAL, 0, 0, // 4: catch
+		POP,  // 7
+		DONE, // 8
+	}, []ExceptionHandler{{0, 3, 4, -1}}),
+		"invalid bytecode in template 0 at 7: POP needs 1 values, but the stack has 0")
+
+	// or after the depth has dropped below where the range began
+	verifyFail(t, verifyModule([]byte{
+		LOAD_NULL,    // 0
+		POP,          // 1
+		IMPORT, 0, 0, // 2
+		RETURN,            // 5
+		STORE_LOCAL, 0, 0, // 6: catch
+		POP,  // 9
+		DONE, // 10
+	}, []ExceptionHandler{{1, 5, 6, -1}}),
+		"invalid bytecode in template 0 at 9: POP needs 1 values, but the stack has 0")
+
+	verifyFail(t, verifyModule([]byte{
+		LOAD_NULL,    // 0
+		IMPORT, 0, 0, // 1
+		RETURN, // 4
+		POP,    // 5: finally
+		POP,    // 6
+		DONE,   // 7
+	}, []ExceptionHandler{{1, 4, -1, 5}}),
+		"invalid bytecode in template 0 at 6: POP needs 1 values, but the stack has 0")
+
+	verifyFail(t, verifyModule([]byte{LOAD_NULL, RETURN, POP}, []ExceptionHandler{{0, 1, 2, -1}}),
+		"invalid bytecode in template 0 at 2: execution runs past the end of the opcodes")
+
+	verifyFail(t, verifyModule([]byte{LOAD_NULL, STORE_LOCAL, 0, 0}, nil),
+		"invalid bytecode in template 0 at 1: execution runs past the end of the opcodes")
+
+	// a finally clause that is reached by normal execution carries on past its DONE
+	verifyFail(t, verifyModule([]byte{
+		LOAD_NULL, // 0
+		POP,       // 1
+		DONE,      // 2: finally
+	}, []ExceptionHandler{{0, 1, -1, 2}}),
+		"invalid bytecode in template 0 at 2: execution runs past the end of the opcodes")
+
+	// DONE
+	verifyFail(t, verifyModule([]byte{DONE}, nil),
+		"invalid bytecode in template 0 at 0: DONE does not end a catch or finally clause")
+
+	verifyFail(t, verifyModule([]byte{
+		LOAD_NULL, // 0
+		POP,       // 1
+		DONE,      // 2
+		LOAD_NULL, // 3: finally
+		POP,       // 4
+		DONE,      // 5
+		LOAD_NULL, // 6
+		RETURN,    // 7
+	}, []ExceptionHandler{{0, 1, -1, 3}}),
+		"invalid bytecode in template 0 at 2: DONE does not end a catch or finally clause")
+
+	// iterators
+	verifyFail(t, verifyModule([]byte{LOAD_ONE, ITER_NEXT, RETURN}, nil),
+		"invalid bytecode in template 0 at 1: ITER_NEXT operand was not created by ITER")
+
+	verifyFail(t, verifyModule([]byte{LOAD_LOCAL, 0, 0, ITER_GET, RETURN}, nil),
+		"invalid bytecode in template 0 at 3: ITER_GET operand was not created by ITER")
+
+	// the iterator must be there on every path
+	verifyFail(t, verifyModule([]byte{
+		LOAD_TRUE,        // 0
+		JUMP_TRUE, 0, 11, // 1
+		NEW_LIST, 0, 0, // 4
+		ITER,        // 7
+		JUMP, 0, 12, // 8
+		LOAD_NULL, // 11
+		ITER_NEXT, // 12
+		RETURN},   // 13
+		nil),
+		"invalid bytecode in template 0 at 12: ITER_NEXT operand was not created by ITER")
+
+	// a captured local can be changed by any function
+	verifyFail(t, verifyModule([]byte{
+		NEW_LIST, 0, 0, // 0
+		ITER,              // 3
+		STORE_LOCAL, 0, 1, // 4
+		NEW_FUNC, 0, 1, // 7
+		FUNC_LOCAL, 0, 1, // 10
+		POP,              // 13
+		LOAD_LOCAL, 0, 1, // 14
+		ITER_NEXT, // 17
+		RETURN},   // 18
+		nil),
+		"invalid bytecode in template 0 at 17: ITER_NEXT operand was not created by ITER")
+}
@@ -0,0 +1,307 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package coverage records which lines of Golem programs are executed.
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	g "golem/core"
+	"golem/interpreter"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//--------------------------------------------------------------
+// Coverage
+
+// Coverage implements interpreter.Debugger, and counts the number of times
+// that each line of the modules that run is executed.  The lines of a module
+// are the ones in the line number tables of its templates.  A Coverage can
+// be used for any number of runs, and by the goroutines that they spawn,
+// and the counts are merged.
+type Coverage struct {
+	mu      sync.Mutex
+	main    *tracker
+	files   map[string]*File
+	modules map[*g.BytecodeModule]bool
+	tables  map[*g.Template][]int
+}
+
+// File is the coverage of a source file.  Lines maps each line that
+// can be executed to the number of times that it was.
+type File struct {
+	Name  string
+	Lines map[int]int64
+}
+
+// New creates a Coverage.
+func New() *Coverage {
+	c := &Coverage{
+		sync.Mutex{},
+		nil,
+		make(map[string]*File),
+		make(map[*g.BytecodeModule]bool),
+		make(map[*g.Template][]int)}
+	c.main = c.newTracker()
+	return c
+}
+
+// Before implements interpreter.Debugger.
+func (c *Coverage) Before(intp *interpreter.Interpreter) g.Error {
+	return c.main.Before(intp)
+}
+
+// Spawn implements interpreter.Spawner.
+func (c *Coverage) Spawn() interpreter.Debugger {
+	return c.newTracker()
+}
+
+// The lines of a module are added the first time that it runs, so that the
+// lines that are never executed are known.  The lock must be held.
+func (c *Coverage) addModule(mod *g.BytecodeModule) {
+	c.modules[mod] = true
+	f := c.file(mod.Name)
+	for _, tpl := range mod.Templates {
+		for _, e := range tpl.LineNumberTable {
+			if _, ok := f.Lines[e.LineNum]; !ok && e.LineNum != 0 {
+				f.Lines[e.LineNum] = 0
+			}
+		}
+	}
+}
+
+// The lock must be held.
+func (c *Coverage) file(name string) *File {
+	f, ok := c.files[name]
+	if !ok {
+		f = &File{name, make(map[int]int64)}
+		c.files[name] = f
+	}
+	return f
+}
+
+// The line of each opcode of a template.  The lock must be held.
+func (c *Coverage) lineTable(tpl *g.Template) []int {
+
+	if table, ok := c.tables[tpl]; ok {
+		return table
+	}
+
+	table := make([]int, len(tpl.OpCodes))
+	for ip := range table {
+		table[ip] = tpl.LineNumber(ip)
+	}
+	c.tables[tpl] = table
+	return table
+}
+
+//--------------------------------------------------------------
+// tracker
+
+// A tracker follows the frames of one goroutine, so that a line is counted
+// each time that a frame moves on to it, rather than once for each opcode.
+type tracker struct {
+	c *Coverage
+
+	// the nested interpreters that are running, outermost first, and the
+	// line that each of their frames is at
+	intps []*interpreter.Interpreter
+	lines [][]int
+}
+
+func (c *Coverage) newTracker() *tracker {
+	return &tracker{c, []*interpreter.Interpreter{}, [][]int{}}
+}
+
+// Before implements interpreter.Debugger.
+func (t *tracker) Before(intp *interpreter.Interpreter) g.Error {
+
+	// Importing a module, or calling back into Golem code via Runtime.Call,
+	// runs another interpreter on top of the ones that are already running.
+	n := len(t.intps) - 1
+	for n >= 0 && t.intps[n] != intp {
+		n--
+	}
+	if n >= 0 {
+		t.intps = t.intps[:n+1]
+		t.lines = t.lines[:n+1]
+	} else {
+		n = len(t.intps)
+		t.intps = append(t.intps, intp)
+		t.lines = append(t.lines, []int{})
+	}
+
+	// the frames that have returned are dropped, and a frame that has
+	// just been called has not reached a line yet
+	frame := intp.DebugFrame(0)
+	depth := intp.NumFrames() - 1
+	lines := t.lines[n]
+	if len(lines) > depth+1 {
+		lines = lines[:depth+1]
+	}
+	for len(lines) <= depth {
+		lines = append(lines, 0)
+	}
+	if frame.Func.Template().IsEntry(frame.IP) {
+		lines[depth] = 0
+	}
+	t.lines[n] = lines
+
+	c := t.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	mod := frame.Func.Module()
+	if !c.modules[mod] {
+		c.addModule(mod)
+	}
+
+	line := c.lineTable(frame.Func.Template())[frame.IP]
+	if line != 0 && line != lines[depth] {
+		c.files[mod.Name].Lines[line]++
+		lines[depth] = line
+	}
+	return nil
+}
+
+//--------------------------------------------------------------
+// results
+
+// Files returns the coverage of each file, sorted by name.
+func (c *Coverage) Files() []*File {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	files := []*File{}
+	for _, f := range c.files {
+		lines := make(map[int]int64)
+		for n, count := range f.Lines {
+			lines[n] = count
+		}
+		files = append(files, &File{f.Name, lines})
+	}
+	sort.Slice(files, func(a, b int) bool {
+		return files[a].Name < files[b].Name
+	})
+	return files
+}
+
+// Covered returns the number of lines that were executed.
+func (f *File) Covered() int {
+	n := 0
+	for _, count := range f.Lines {
+		if count > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Percent returns the percentage of the lines that were executed.
+func (f *File) Percent() float64 {
+	if len(f.Lines) == 0 {
+		return 100
+	}
+	return 100 * float64(f.Covered()) / float64(len(f.Lines))
+}
+
+// The lines of a file, in order.
+func (f *File) sortedLines() []int {
+	lines := []int{}
+	for n := range f.Lines {
+		lines = append(lines, n)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+//--------------------------------------------------------------
+// reports
+
+// WriteSummary writes the percentage of the lines of each file that
+// were executed, and of all of them.
+func (c *Coverage) WriteSummary(w io.Writer) error {
+
+	files := c.Files()
+	covered, total := 0, 0
+	for _, f := range files {
+		fmt.Fprintf(w, "%6.1f%%  %5d/%-5d  %s\n", f.Percent(), f.Covered(), len(f.Lines), f.Name)
+		covered += f.Covered()
+		total += len(f.Lines)
+	}
+
+	percent := 100.0
+	if total > 0 {
+		percent = 100 * float64(covered) / float64(total)
+	}
+	_, err := fmt.Fprintf(w, "%6.1f%%  %5d/%-5d  total\n", percent, covered, total)
+	return err
+}
+
+// WriteLCOV writes the coverage in the LCOV tracefile format that
+// genhtml and most CI services read.
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+
+	bw := bufio.NewWriter(w)
+	for _, f := range c.Files() {
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", f.Name)
+		for _, n := range f.sortedLines() {
+			fmt.Fprintf(bw, "DA:%d,%d\n", n, f.Lines[n])
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", len(f.Lines), f.Covered())
+	}
+	return bw.Flush()
+}
+
+// ReadLCOV merges the coverage in an LCOV tracefile, such as one that was
+// written by WriteLCOV during an earlier run, into this Coverage.
+func (c *Coverage) ReadLCOV(r io.Reader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var f *File
+	sc := bufio.NewScanner(r)
+	for num := 1; sc.Scan(); num++ {
+		line := strings.TrimSpace(sc.Text())
+
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			f = c.file(line[3:])
+
+		case strings.HasPrefix(line, "DA:"):
+			fields := strings.Split(line[3:], ",")
+			if f == nil || len(fields) < 2 {
+				return fmt.Errorf("invalid LCOV record on line %d", num)
+			}
+			n, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return fmt.Errorf("invalid LCOV record on line %d", num)
+			}
+			count, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid LCOV record on line %d", num)
+			}
+			f.Lines[n] += count
+
+		case line == "end_of_record":
+			f = nil
+		}
+	}
+	return sc.Err()
+}
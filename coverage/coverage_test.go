@@ -0,0 +1,143 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coverage
+
+import (
+	"bytes"
+	"golem"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func assert(t *testing.T, flag bool) {
+	if !flag {
+		t.Error("assertion failure")
+	}
+}
+
+func ok(t *testing.T, result interface{}, expect interface{}) {
+	if !reflect.DeepEqual(result, expect) {
+		t.Error(result, " != ", expect)
+	}
+}
+
+const program = `fn sign(n) {
+    if n < 0 {
+        return -1;
+    }
+    return 1;
+}
+fn send(c, n) {
+    c.send(sign(n));
+}
+pub fn main(args) {
+    let c = chan();
+    spawn send(c, len(args));
+    let total = c.recv();
+    for i in [1, 2, 3] {
+        total += sign(i);
+    }
+    return total;
+}
+`
+
+func coverRun(t *testing.T, c *Coverage, src string) {
+	rt := golem.NewRuntime()
+	rt.Debugger = c
+
+	mod, err := rt.Compile("test.glm", src)
+	assert(t, err == nil)
+	assert(t, rt.RunMain(mod, nil) == nil)
+}
+
+func TestLines(t *testing.T) {
+
+	c := New()
+	coverRun(t, c, program)
+
+	files := c.Files()
+	ok(t, len(files), 1)
+	f := files[0]
+	ok(t, f.Name, "test.glm")
+
+	// line 3 is never executed, and the spawned goroutine covers line 8,
+	// and the closing brace of the loop jumps back to its start
+	ok(t, f.Lines, map[int]int64{
+		1:  1,
+		2:  4,
+		3:  0,
+		5:  4,
+		7:  1,
+		8:  1,
+		10: 1,
+		11: 1,
+		12: 1,
+		13: 1,
+		14: 4,
+		15: 3,
+		16: 3,
+		17: 1,
+	})
+	ok(t, f.Covered(), 13)
+	ok(t, len(f.Lines), 14)
+
+	// a second run is merged with the first
+	coverRun(t, c, program)
+	f = c.Files()[0]
+	ok(t, f.Lines[2], int64(8))
+	ok(t, f.Lines[3], int64(0))
+}
+
+func TestReports(t *testing.T) {
+
+	c := New()
+	coverRun(t, c, program)
+
+	var buf bytes.Buffer
+	assert(t, c.WriteSummary(&buf) == nil)
+	ok(t, buf.String(), ""+
+		"  92.9%     13/14     test.glm\n"+
+		"  92.9%     13/14     total\n")
+
+	// LCOV
+	buf.Reset()
+	assert(t, c.WriteLCOV(&buf) == nil)
+	lcov := buf.String()
+	assert(t, strings.HasPrefix(lcov, "TN:\nSF:test.glm\nDA:1,1\nDA:2,4\nDA:3,0\n"))
+	assert(t, strings.HasSuffix(lcov, "DA:17,1\nLF:14\nLH:13\nend_of_record\n"))
+
+	// reading the LCOV of a run merges it
+	other := New()
+	assert(t, other.ReadLCOV(strings.NewReader(lcov)) == nil)
+	ok(t, other.Files(), c.Files())
+	assert(t, other.ReadLCOV(strings.NewReader(lcov)) == nil)
+	ok(t, other.Files()[0].Lines[2], int64(8))
+
+	err := other.ReadLCOV(strings.NewReader("SF:a.glm\nDA:x,1\n"))
+	ok(t, err.Error(), "invalid LCOV record on line 2")
+
+	// HTML
+	buf.Reset()
+	source := func(file string) (string, bool) {
+		return program, file == "test.glm"
+	}
+	assert(t, c.WriteHTML(&buf, source) == nil)
+	html := buf.String()
+	assert(t, strings.Contains(html, `<a href="#file0">test.glm</a>`))
+	assert(t, strings.Contains(html, `<span class="uncovered"><span class="num">3</span> <span class="count">0</span>          return -1;</span>`))
+	assert(t, strings.Contains(html, `<span class="covered"><span class="num">2</span> <span class="count">4</span>      if n &lt; 0 {</span>`))
+	assert(t, strings.Contains(html, `<span class=""><span class="num">4</span> <span class="count"></span>      }</span>`))
+}
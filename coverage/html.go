@@ -0,0 +1,97 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coverage
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+var htmlReport = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage</title>
+<style>
+body { font-family: sans-serif; }
+table.summary td { padding: 0 1em 0 0; }
+pre { font-family: monospace; line-height: 1.3; }
+.covered { background: #dfd; }
+.uncovered { background: #fdd; }
+.num, .count { color: #888; display: inline-block; text-align: right; width: 4em; }
+</style>
+</head>
+<body>
+<h1>Coverage</h1>
+<table class="summary">
+{{range .}}<tr><td><a href="#{{.ID}}">{{.Name}}</a></td><td>{{.Percent}}</td><td>{{.Covered}}/{{.Total}} lines</td></tr>
+{{end}}</table>
+{{range .}}
+<h2 id="{{.ID}}">{{.Name}}</h2>
+{{if .Lines}}<pre>
+{{range .Lines}}<span class="{{.Class}}"><span class="num">{{.Num}}</span> <span class="count">{{.Count}}</span>  {{.Text}}</span>
+{{end}}</pre>{{else}}<p>The source code is not available.</p>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+type htmlFile struct {
+	ID      string
+	Name    string
+	Percent string
+	Covered int
+	Total   int
+	Lines   []htmlLine
+}
+
+type htmlLine struct {
+	Num   int
+	Count string
+	Class string
+	Text  string
+}
+
+// WriteHTML writes a page that shows the source code of each file,
+// with the lines that were executed and the lines that were not
+// highlighted.  The source code is found with the source function.
+func (c *Coverage) WriteHTML(w io.Writer, source func(file string) (string, bool)) error {
+
+	files := []*htmlFile{}
+	for n, f := range c.Files() {
+		hf := &htmlFile{fmt.Sprintf("file%d", n), f.Name,
+			fmt.Sprintf("%.1f%%", f.Percent()), f.Covered(), len(f.Lines), nil}
+
+		if src, ok := source(f.Name); ok {
+			text := strings.Split(strings.TrimSuffix(src, "\n"), "\n")
+			for j, t := range text {
+				hl := htmlLine{j + 1, "", "", strings.TrimRight(t, "\r")}
+				if count, ok := f.Lines[j+1]; ok {
+					hl.Count = fmt.Sprintf("%d", count)
+					hl.Class = "uncovered"
+					if count > 0 {
+						hl.Class = "covered"
+					}
+				}
+				hf.Lines = append(hf.Lines, hl)
+			}
+		}
+		files = append(files, hf)
+	}
+
+	return htmlReport.Execute(w, files)
+}
@@ -0,0 +1,256 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debug
+
+import (
+	"bufio"
+	"fmt"
+	g "golem/core"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const consoleHelp = `Commands:
+    break [file:]line [if expr]   set a breakpoint, or list them if there are no arguments
+    delete [id]                   delete a breakpoint, or all of them
+    continue                      run until a breakpoint is reached
+    step                          step to the next line, into any function that is called
+    next                          step to the next line, over any function that is called
+    out                           step out of the current function
+    vars                          show the variables of the current frame
+    ops                           show the operand stack of the current frame
+    stack                         show the frames on the stack
+    frame <n>                     make frame n the current frame
+    print <expr>                  evaluate an expression in the current frame
+    list                          show the source code around the current line
+    quit                          stop the program
+    help                          show this message
+
+Commands can be abbreviated to their first letter, and 'bt' is the same as 'stack'.
+An empty line repeats the last step, next or out.
+`
+
+const consolePrompt = "(debug) "
+
+// Console is a Frontend that reads commands, like those of gdb, from
+// a terminal.
+type Console struct {
+	in   *bufio.Reader
+	out  io.Writer
+	last string
+
+	// Source returns the source code of a file.
+	Source func(file string) (string, bool)
+}
+
+// NewConsole creates a Console that reads commands from in, and writes
+// to out.
+func NewConsole(in io.Reader, out io.Writer, source func(string) (string, bool)) *Console {
+	return &Console{bufio.NewReader(in), out, "", source}
+}
+
+// Stopped implements Frontend.
+func (c *Console) Stopped(s *Stop) Action {
+
+	frame := 0
+	if s.Err != nil {
+		fmt.Fprintf(c.out, "error in breakpoint condition: %s\n", s.Err.Error())
+	}
+	c.where(s.Frames[frame])
+
+	for {
+		fmt.Fprint(c.out, consolePrompt)
+		line, err := c.in.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(c.out)
+			return Quit
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = c.last
+		}
+
+		cmd, arg := line, ""
+		if n := strings.IndexAny(line, " \t"); n != -1 {
+			cmd, arg = line[:n], strings.TrimSpace(line[n:])
+		}
+
+		switch cmd {
+
+		case "":
+
+		case "b", "break":
+			c.setBreakpoint(s, s.Frames[frame], arg)
+
+		case "d", "delete":
+			if arg == "" {
+				s.Debugger.ClearBreakpoints("")
+			} else if id, err := strconv.Atoi(arg); err != nil || !s.Debugger.ClearBreakpoint(id) {
+				fmt.Fprintf(c.out, "there is no breakpoint '%s'\n", arg)
+			}
+
+		case "c", "continue":
+			c.last = ""
+			return Continue
+
+		case "s", "step":
+			c.last = line
+			return StepInto
+
+		case "n", "next":
+			c.last = line
+			return StepOver
+
+		case "o", "out":
+			c.last = line
+			return StepOut
+
+		case "v", "vars":
+			f := s.Frames[frame]
+			c.vars("locals", f.Locals())
+			c.vars("captures", f.Captures())
+
+		case "ops":
+			for j, v := range s.Frames[frame].Stack {
+				fmt.Fprintf(c.out, "%4d  %s\n", j, show(v))
+			}
+
+		case "bt", "stack":
+			for j, f := range s.Frames {
+				mark := " "
+				if j == frame {
+					mark = "*"
+				}
+				fmt.Fprintf(c.out, "%s#%d  %s at %s:%d\n", mark, j, f.Name(), f.File(), f.Line())
+			}
+
+		case "f", "frame":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 || n >= len(s.Frames) {
+				fmt.Fprintf(c.out, "there is no frame '%s'\n", arg)
+				break
+			}
+			frame = n
+			c.where(s.Frames[frame])
+
+		case "p", "print":
+			val, err := s.Eval(frame, arg)
+			if err != nil {
+				fmt.Fprintln(c.out, err.Error())
+			} else {
+				fmt.Fprintln(c.out, show(val))
+			}
+
+		case "l", "list":
+			f := s.Frames[frame]
+			for n := f.Line() - 5; n <= f.Line()+5; n++ {
+				if text, ok := c.sourceLine(f.File(), n); ok {
+					mark := " "
+					if n == f.Line() {
+						mark = ">"
+					}
+					fmt.Fprintf(c.out, "%s%4d  %s\n", mark, n, text)
+				}
+			}
+
+		case "q", "quit":
+			return Quit
+
+		case "h", "help":
+			fmt.Fprint(c.out, consoleHelp)
+
+		default:
+			fmt.Fprintf(c.out, "unknown command '%s', enter help for help\n", cmd)
+		}
+	}
+}
+
+// show where a frame is, and the line of source code it is at
+func (c *Console) where(f *Frame) {
+	fmt.Fprintf(c.out, "%s at %s:%d\n", f.Name(), f.File(), f.Line())
+	if text, ok := c.sourceLine(f.File(), f.Line()); ok {
+		fmt.Fprintf(c.out, "%4d  %s\n", f.Line(), text)
+	}
+}
+
+func (c *Console) setBreakpoint(s *Stop, f *Frame, arg string) {
+
+	if arg == "" {
+		for _, bp := range s.Debugger.Breakpoints() {
+			fmt.Fprintf(c.out, "%d: %s:%d", bp.ID, bp.File, bp.Line)
+			if bp.Condition != "" {
+				fmt.Fprintf(c.out, " if %s", bp.Condition)
+			}
+			fmt.Fprintln(c.out)
+		}
+		return
+	}
+
+	loc, cond := arg, ""
+	if n := strings.Index(arg, " if "); n != -1 {
+		loc, cond = strings.TrimSpace(arg[:n]), strings.TrimSpace(arg[n+4:])
+	}
+
+	file := f.File()
+	if n := strings.LastIndex(loc, ":"); n != -1 {
+		file, loc = loc[:n], loc[n+1:]
+	}
+	line, err := strconv.Atoi(loc)
+	if err != nil || line < 1 {
+		fmt.Fprintf(c.out, "invalid line '%s'\n", loc)
+		return
+	}
+
+	bp := s.Debugger.SetBreakpoint(file, line, cond)
+	fmt.Fprintf(c.out, "breakpoint %d at %s:%d\n", bp.ID, bp.File, bp.Line)
+}
+
+func (c *Console) vars(kind string, vars []*Variable) {
+	if len(vars) == 0 {
+		return
+	}
+	fmt.Fprintf(c.out, "%s:\n", kind)
+	for _, v := range vars {
+		fmt.Fprintf(c.out, "    %s = %s\n", v.Name, show(v.Value))
+	}
+}
+
+func (c *Console) sourceLine(file string, n int) (string, bool) {
+	if c.Source == nil || n < 1 {
+		return "", false
+	}
+	src, ok := c.Source(file)
+	if !ok {
+		return "", false
+	}
+	lines := strings.Split(strings.TrimSuffix(src, "\n"), "\n")
+	if n > len(lines) {
+		return "", false
+	}
+	return strings.TrimRight(lines[n-1], "\r"), true
+}
+
+// Show a value the way that it would be written in source code, so that
+// strings can be told apart from other values.
+func show(val g.Value) string {
+	if val == nil {
+		return "null"
+	}
+	if s, ok := val.(g.Str); ok {
+		return strconv.Quote(s.String())
+	}
+	return val.ToStr().String()
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debug
+
+import (
+	"bytes"
+	"golem"
+	"strings"
+	"testing"
+)
+
+func console(t *testing.T, commands string) string {
+
+	var out bytes.Buffer
+	rt := golem.NewRuntime()
+	d := New(rt, NewConsole(strings.NewReader(commands), &out, rt.Source))
+	d.StopOnEntry = true
+
+	mod, err := rt.Compile("test.glm", program)
+	assert(t, err == nil)
+	rt.RunMain(mod, nil)
+	return out.String()
+}
+
+func TestConsole(t *testing.T) {
+
+	result := console(t, `b 4 if n == 2
+b test.glm:9
+b
+c
+d 2
+c
+vars
+print sq * 10
+print nope
+ops
+bt
+frame 1
+list
+frame 5
+n
+
+s
+delete 9
+foo
+out
+q
+`)
+
+	if result != consoleOutput {
+		t.Error("\n"+result, " != \n", consoleOutput)
+	}
+}
+
+const consoleOutput = `<module> at test.glm:1
+   1  let total = 0;
+(debug) breakpoint 1 at test.glm:4
+(debug) breakpoint 2 at test.glm:9
+(debug) 1: test.glm:4 if n == 2
+2: test.glm:9
+(debug) main at test.glm:9
+   9          add(i);
+(debug) (debug) add at test.glm:4
+   4      total += sq;
+(debug) locals:
+    n = 2
+    sq = 4
+captures:
+    total = 1
+(debug) 40
+(debug) Symbol 'nope' is not defined
+(debug)    0  null
+(debug) *#0  add at test.glm:4
+ #1  main at test.glm:9
+(debug) main at test.glm:9
+   9          add(i);
+(debug)     4      total += sq;
+    5      return sq;
+    6  }
+    7  pub fn main(args) {
+    8      for i in [1, 2, 3] {
+>   9          add(i);
+   10      }
+   11      return total;
+   12  }
+(debug) there is no frame '5'
+(debug) add at test.glm:5
+   5      return sq;
+(debug) main at test.glm:10
+  10      }
+(debug) main at test.glm:8
+   8      for i in [1, 2, 3] {
+(debug) there is no breakpoint '9'
+(debug) unknown command 'foo', enter help for help
+(debug) `
@@ -0,0 +1,679 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debug
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"golem"
+	g "golem/core"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//--------------------------------------------------------------
+// Debug Adapter Protocol
+//
+// Every message is a JSON object, preceded by a header that gives
+// the length of the object in bytes:
+//
+//	Content-Length: 59\r\n
+//	\r\n
+//	{"seq":1,"type":"request","command":"threads","arguments":{}}
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type capabilities struct {
+	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+	SupportsConditionalBreakpoints   bool `json:"supportsConditionalBreakpoints"`
+	SupportsEvaluateForHovers        bool `json:"supportsEvaluateForHovers"`
+	SupportsTerminateRequest         bool `json:"supportsTerminateRequest"`
+}
+
+type launchArguments struct {
+	Program     string   `json:"program"`
+	Args        []string `json:"args"`
+	StopOnEntry bool     `json:"stopOnEntry"`
+}
+
+type source struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type sourceBreakpoint struct {
+	Line      int    `json:"line"`
+	Condition string `json:"condition"`
+}
+
+type setBreakpointsArguments struct {
+	Source      source             `json:"source"`
+	Breakpoints []sourceBreakpoint `json:"breakpoints"`
+}
+
+type breakpoint struct {
+	ID       int  `json:"id"`
+	Verified bool `json:"verified"`
+	Line     int  `json:"line"`
+}
+
+type thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type stackFrame struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Source source `json:"source"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+type scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type frameArguments struct {
+	FrameID int `json:"frameId"`
+}
+
+type variablesArguments struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+type evaluateArguments struct {
+	Expression string `json:"expression"`
+	FrameID    int    `json:"frameId"`
+}
+
+type stoppedEvent struct {
+	Reason            string `json:"reason"`
+	ThreadID          int    `json:"threadId"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+	Text              string `json:"text,omitempty"`
+}
+
+type outputEvent struct {
+	Category string `json:"category"`
+	Output   string `json:"output"`
+}
+
+type exitedEvent struct {
+	ExitCode int `json:"exitCode"`
+}
+
+// the scopes of each frame, whose variables references are
+// frame*numScopes + scope + 1
+var scopeNames = []string{"Locals", "Captures", "Globals", "Operand Stack"}
+
+// the only thread
+const threadID = 1
+
+//--------------------------------------------------------------
+// DAPServer
+
+// DAPServer is a debug adapter, which lets editors debug Golem programs
+// via the Debug Adapter Protocol.  A program is launched once the client
+// has sent both a 'launch' and a 'configurationDone' request.
+type DAPServer struct {
+	newRuntime func(program string) *golem.Runtime
+
+	wmu sync.Mutex
+	out io.Writer
+	seq int
+	err error
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	launch      *launchArguments
+	configured  bool
+	breakpoints map[string][]sourceBreakpoint
+	dbg         *Debugger
+	running     bool
+	quit        bool
+	cancel      context.CancelFunc
+	done        chan struct{}
+
+	// the requests that are waiting for the program to stop
+	queue []*dapRequest
+
+	// Output, if it is not nil, is read while the server runs, and what is
+	// read is sent to the client in 'output' events.  It is typically a
+	// pipe that the standard output of the program is redirected to.
+	Output io.Reader
+}
+
+// NewDAPServer creates a DAPServer.  The Runtime that each program is
+// run with is created by calling newRuntime with the program's path.
+func NewDAPServer(newRuntime func(program string) *golem.Runtime) *DAPServer {
+	s := &DAPServer{
+		newRuntime,
+		sync.Mutex{}, nil, 0, nil,
+		sync.Mutex{}, nil, nil, false,
+		make(map[string][]sourceBreakpoint),
+		nil, false, false, nil, nil,
+		[]*dapRequest{},
+		nil}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Serve reads requests from r, and writes responses and events to w, until
+// the client sends a 'disconnect' request, or r is closed.  If a program is
+// running, then it is stopped.
+func (s *DAPServer) Serve(r io.Reader, w io.Writer) error {
+
+	s.out = w
+	if s.Output != nil {
+		go s.forward(s.Output)
+	}
+
+	in := bufio.NewReader(r)
+	for {
+		content, err := readMessage(in)
+		if err != nil {
+			s.stop()
+			if err == io.EOF {
+				err = nil
+			}
+			return err
+		}
+
+		req := &dapRequest{}
+		if err := json.Unmarshal(content, req); err != nil {
+			s.stop()
+			return err
+		}
+		if req.Command == "disconnect" {
+			s.stop()
+			s.reply(req, nil, nil)
+			return s.writeErr()
+		}
+		s.handle(req)
+
+		if err := s.writeErr(); err != nil {
+			s.stop()
+			return err
+		}
+	}
+}
+
+// handle a request while the program is running, or before it starts
+func (s *DAPServer) handle(req *dapRequest) {
+
+	switch req.Command {
+
+	case "initialize":
+		s.reply(req, &capabilities{true, true, true, true}, nil)
+		s.event("initialized", nil)
+
+	case "launch":
+		args := &launchArguments{}
+		if err := json.Unmarshal(req.Arguments, args); err != nil {
+			s.reply(req, nil, err)
+			return
+		}
+		if args.Program == "" {
+			s.reply(req, nil, fmt.Errorf("no program was given"))
+			return
+		}
+		s.mu.Lock()
+		s.launch = args
+		s.mu.Unlock()
+		s.reply(req, nil, nil)
+		s.start()
+
+	case "configurationDone":
+		s.mu.Lock()
+		s.configured = true
+		s.mu.Unlock()
+		s.reply(req, nil, nil)
+		s.start()
+
+	case "setBreakpoints":
+		args := &setBreakpointsArguments{}
+		if err := json.Unmarshal(req.Arguments, args); err != nil {
+			s.reply(req, nil, err)
+			return
+		}
+		s.mu.Lock()
+		s.breakpoints[args.Source.Path] = args.Breakpoints
+		result := []*breakpoint{}
+		if s.dbg != nil {
+			result = s.setBreakpoints(args.Source.Path, args.Breakpoints)
+		} else {
+			for _, sb := range args.Breakpoints {
+				result = append(result, &breakpoint{0, true, sb.Line})
+			}
+		}
+		s.mu.Unlock()
+		s.reply(req, map[string]interface{}{"breakpoints": result}, nil)
+
+	case "threads":
+		s.reply(req, map[string]interface{}{
+			"threads": []*thread{{threadID, "main"}}}, nil)
+
+	case "pause":
+		s.mu.Lock()
+		if s.dbg != nil {
+			s.dbg.Pause()
+		}
+		s.mu.Unlock()
+		s.reply(req, nil, nil)
+
+	case "terminate":
+		s.mu.Lock()
+		if s.running {
+			s.quit = true
+			s.cancel()
+			s.queue = append(s.queue, req)
+			s.cond.Signal()
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+		s.reply(req, nil, nil)
+
+	case "stackTrace", "scopes", "variables", "evaluate",
+		"continue", "next", "stepIn", "stepOut":
+
+		// these are answered by the program, once it has stopped
+		s.mu.Lock()
+		if s.running {
+			s.queue = append(s.queue, req)
+			s.cond.Signal()
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+		s.reply(req, nil, fmt.Errorf("the program is not running"))
+
+	default:
+		s.reply(req, nil, fmt.Errorf("unsupported request '%s'", req.Command))
+	}
+}
+
+// Set the breakpoints of a file in the debugger.  The server must be locked.
+func (s *DAPServer) setBreakpoints(path string, sbs []sourceBreakpoint) []*breakpoint {
+	s.dbg.ClearBreakpoints(path)
+	result := []*breakpoint{}
+	for _, sb := range sbs {
+		bp := s.dbg.SetBreakpoint(path, sb.Line, sb.Condition)
+		result = append(result, &breakpoint{bp.ID, true, bp.Line})
+	}
+	return result
+}
+
+// Start the program, if it has been launched and configured.
+func (s *DAPServer) start() {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.launch == nil || !s.configured || s.done != nil {
+		return
+	}
+
+	args := s.launch
+	rt := s.newRuntime(args.Program)
+	s.dbg = New(rt, s)
+	s.dbg.StopOnEntry = args.StopOnEntry
+	for path, sbs := range s.breakpoints {
+		s.setBreakpoints(path, sbs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.running = true
+	s.done = make(chan struct{})
+
+	go s.run(ctx, rt, args)
+}
+
+func (s *DAPServer) run(ctx context.Context, rt *golem.Runtime, args *launchArguments) {
+
+	err := runProgram(ctx, rt, args.Program, args.Args)
+
+	s.mu.Lock()
+	quit := s.quit
+	s.running = false
+	pending := s.queue
+	s.queue = []*dapRequest{}
+	s.mu.Unlock()
+
+	for _, req := range pending {
+		if req.Command == "terminate" {
+			s.reply(req, nil, nil)
+		} else {
+			s.reply(req, nil, fmt.Errorf("the program is not running"))
+		}
+	}
+
+	// the error from stopping the program is not reported
+	exitCode := 0
+	if err != nil && !quit {
+		exitCode = 1
+		var buf bytes.Buffer
+		rnd := &golem.Renderer{false, rt.Source}
+		for _, d := range golem.Diagnostics(err) {
+			rnd.Render(&buf, d)
+		}
+		s.event("output", &outputEvent{"stderr", buf.String()})
+	}
+	s.event("exited", &exitedEvent{exitCode})
+	s.event("terminated", nil)
+	close(s.done)
+}
+
+// Compile or load a program, and run it.
+func runProgram(ctx context.Context, rt *golem.Runtime, program string, args []string) error {
+
+	buf, err := ioutil.ReadFile(program)
+	if err != nil {
+		return err
+	}
+
+	var mod *g.BytecodeModule
+	if bytes.HasPrefix(buf, []byte(g.GlmcMagic)) {
+		mod, err = rt.Load(bytes.NewReader(buf))
+	} else {
+		mod, err = rt.Compile(program, string(buf))
+	}
+	if err != nil {
+		return err
+	}
+	return rt.RunMainContext(ctx, mod, args)
+}
+
+// Stop the program, if it is running, and wait for it to finish.
+func (s *DAPServer) stop() {
+
+	s.mu.Lock()
+	if s.running {
+		s.quit = true
+		s.cancel()
+		s.queue = append(s.queue, &dapRequest{0, "disconnect", nil})
+		s.cond.Signal()
+	}
+	done := s.done
+	s.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+// Stopped implements Frontend.  The requests that need a stopped program
+// are answered here, until the client asks for the program to resume.
+func (s *DAPServer) Stopped(stop *Stop) Action {
+
+	text := ""
+	if stop.Err != nil {
+		text = stop.Err.Error()
+	}
+	s.event("stopped", &stoppedEvent{stop.Reason, threadID, true, text})
+
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 {
+			s.cond.Wait()
+		}
+		req := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		switch req.Command {
+		case "continue":
+			s.reply(req, map[string]interface{}{"allThreadsContinued": true}, nil)
+			return Continue
+		case "next":
+			s.reply(req, nil, nil)
+			return StepOver
+		case "stepIn":
+			s.reply(req, nil, nil)
+			return StepInto
+		case "stepOut":
+			s.reply(req, nil, nil)
+			return StepOut
+		case "terminate":
+			s.reply(req, nil, nil)
+			return Quit
+		case "disconnect":
+			return Quit
+		default:
+			body, err := s.inspect(stop, req)
+			s.reply(req, body, err)
+		}
+	}
+}
+
+// answer a request about a program that has stopped
+func (s *DAPServer) inspect(stop *Stop, req *dapRequest) (interface{}, error) {
+
+	switch req.Command {
+
+	case "stackTrace":
+		frames := []*stackFrame{}
+		for j, f := range stop.Frames {
+			path := canonical(f.File())
+			frames = append(frames, &stackFrame{
+				j, f.Name(), source{filepath.Base(path), path}, f.Line(), f.Col()})
+		}
+		return map[string]interface{}{
+			"stackFrames": frames, "totalFrames": len(frames)}, nil
+
+	case "scopes":
+		args := &frameArguments{}
+		if err := json.Unmarshal(req.Arguments, args); err != nil {
+			return nil, err
+		}
+		if args.FrameID < 0 || args.FrameID >= len(stop.Frames) {
+			return nil, fmt.Errorf("there is no frame %d", args.FrameID)
+		}
+		scopes := []*scope{}
+		for j, name := range scopeNames {
+			scopes = append(scopes, &scope{name, args.FrameID*len(scopeNames) + j + 1, false})
+		}
+		return map[string]interface{}{"scopes": scopes}, nil
+
+	case "variables":
+		args := &variablesArguments{}
+		if err := json.Unmarshal(req.Arguments, args); err != nil {
+			return nil, err
+		}
+		n := args.VariablesReference - 1
+		frame, kind := n/len(scopeNames), n%len(scopeNames)
+		if n < 0 || frame >= len(stop.Frames) {
+			return nil, fmt.Errorf("invalid variables reference %d", args.VariablesReference)
+		}
+
+		f := stop.Frames[frame]
+		var vars []*Variable
+		switch kind {
+		case 0:
+			vars = f.Locals()
+		case 1:
+			vars = f.Captures()
+		case 2:
+			vars = f.Globals()
+		default:
+			vars = []*Variable{}
+			for j, v := range f.Stack {
+				vars = append(vars, &Variable{strconv.Itoa(j), v})
+			}
+		}
+
+		result := []*variable{}
+		for _, v := range vars {
+			result = append(result, &variable{v.Name, show(v.Value), 0})
+		}
+		return map[string]interface{}{"variables": result}, nil
+
+	case "evaluate":
+		args := &evaluateArguments{}
+		if err := json.Unmarshal(req.Arguments, args); err != nil {
+			return nil, err
+		}
+		val, err := stop.Eval(args.FrameID, args.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"result": show(val), "variablesReference": 0}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported request '%s'", req.Command)
+	}
+}
+
+// send what the program writes to the client
+func (s *DAPServer) forward(r io.Reader) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			s.event("output", &outputEvent{"stdout", string(buf[:n])})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+//--------------------------------------------------------------
+// messages
+
+// If err is not nil, then the request failed.
+func (s *DAPServer) reply(req *dapRequest, body interface{}, err error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	s.seq++
+	resp := &dapResponse{s.seq, "response", req.Seq, err == nil, req.Command, "", body}
+	if err != nil {
+		resp.Message = strings.TrimSpace(err.Error())
+	}
+	s.send(resp)
+}
+
+func (s *DAPServer) event(name string, body interface{}) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	s.seq++
+	s.send(&dapEvent{s.seq, "event", name, body})
+}
+
+// The first error that occurs while writing is remembered, and stops
+// the server.  The writer must be locked.
+func (s *DAPServer) send(msg interface{}) {
+	if s.err == nil {
+		s.err = writeMessage(s.out, msg)
+	}
+}
+
+func (s *DAPServer) writeErr() error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return s.err
+}
+
+// read the content of the next message
+func readMessage(r *bufio.Reader) ([]byte, error) {
+
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line != "" {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+
+		colon := strings.Index(line, ":")
+		if colon == -1 {
+			return nil, fmt.Errorf("invalid header '%s'", line)
+		}
+		name := strings.TrimSpace(line[:colon])
+		if strings.EqualFold(name, "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[colon+1:]))
+			if err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid header '%s'", line)
+			}
+		}
+	}
+	if length == -1 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func writeMessage(w io.Writer, msg interface{}) error {
+
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(content)); err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
@@ -0,0 +1,204 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debug
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"golem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// a client that talks to a DAPServer over pipes
+type client struct {
+	t    *testing.T
+	w    *os.File
+	r    *bufio.Reader
+	seq  int
+	done chan error
+}
+
+func newClient(t *testing.T) *client {
+
+	// the pipes are buffered, so that requests can be sent without
+	// reading the messages that the server has written
+	inR, inW, err := os.Pipe()
+	assert(t, err == nil)
+	outR, outW, err := os.Pipe()
+	assert(t, err == nil)
+	c := &client{t, inW, bufio.NewReader(outR), 0, make(chan error, 1)}
+
+	srv := NewDAPServer(func(program string) *golem.Runtime {
+		return golem.NewRuntime()
+	})
+	go func() {
+		c.done <- srv.Serve(inR, outW)
+		outW.Close()
+	}()
+	return c
+}
+
+func (c *client) send(command string, args string) {
+	c.seq++
+	content := fmt.Sprintf(
+		`{"seq":%d,"type":"request","command":"%s","arguments":%s}`, c.seq, command, args)
+	_, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(content), content)
+	assert(c.t, err == nil)
+}
+
+// Read messages until the response to a command, or an event, with the
+// given name arrives, and return its body, encoded as JSON.  If it is
+// a response that failed, then its message is returned instead.
+func (c *client) expect(name string) string {
+
+	for {
+		content, err := readMessage(c.r)
+		if err != nil {
+			c.t.Fatal("expected ", name, ": ", err)
+		}
+
+		var msg struct {
+			Type    string          `json:"type"`
+			Command string          `json:"command"`
+			Event   string          `json:"event"`
+			Success bool            `json:"success"`
+			Message string          `json:"message"`
+			Body    json.RawMessage `json:"body"`
+		}
+		assert(c.t, json.Unmarshal(content, &msg) == nil)
+
+		if (msg.Type == "response" && msg.Command == name) ||
+			(msg.Type == "event" && msg.Event == name) {
+			if msg.Type == "response" && !msg.Success {
+				return "error: " + msg.Message
+			}
+			return string(msg.Body)
+		}
+	}
+}
+
+func writeProgram(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "golem")
+	assert(t, err == nil)
+	file := filepath.Join(dir, "test.glm")
+	assert(t, ioutil.WriteFile(file, []byte(program), 0644) == nil)
+	return file, func() { os.RemoveAll(dir) }
+}
+
+func TestDAP(t *testing.T) {
+
+	file, cleanup := writeProgram(t)
+	defer cleanup()
+
+	c := newClient(t)
+	c.send("initialize", `{"adapterID":"golem"}`)
+	ok(t, c.expect("initialize"), `{"supportsConfigurationDoneRequest":true,`+
+		`"supportsConditionalBreakpoints":true,"supportsEvaluateForHovers":true,`+
+		`"supportsTerminateRequest":true}`)
+	ok(t, c.expect("initialized"), "")
+
+	c.send("setBreakpoints", fmt.Sprintf(
+		`{"source":{"path":"%s"},"breakpoints":[{"line":4,"condition":"n == 2"}]}`, file))
+	ok(t, c.expect("setBreakpoints"), `{"breakpoints":[{"id":0,"verified":true,"line":4}]}`)
+
+	c.send("stackTrace", `{"threadId":1}`)
+	ok(t, c.expect("stackTrace"), "error: the program is not running")
+
+	c.send("launch", fmt.Sprintf(`{"program":"%s"}`, file))
+	ok(t, c.expect("launch"), "")
+	c.send("configurationDone", `{}`)
+	ok(t, c.expect("configurationDone"), "")
+	ok(t, c.expect("stopped"), `{"reason":"breakpoint","threadId":1,"allThreadsStopped":true}`)
+
+	c.send("threads", `{}`)
+	ok(t, c.expect("threads"), `{"threads":[{"id":1,"name":"main"}]}`)
+
+	c.send("stackTrace", `{"threadId":1}`)
+	ok(t, c.expect("stackTrace"), fmt.Sprintf(`{"stackFrames":[`+
+		`{"id":0,"name":"add","source":{"name":"test.glm","path":"%s"},"line":4,"column":5},`+
+		`{"id":1,"name":"main","source":{"name":"test.glm","path":"%s"},"line":9,"column":9}],`+
+		`"totalFrames":2}`, file, file))
+
+	c.send("scopes", `{"frameId":1}`)
+	ok(t, c.expect("scopes"), `{"scopes":[`+
+		`{"name":"Locals","variablesReference":5,"expensive":false},`+
+		`{"name":"Captures","variablesReference":6,"expensive":false},`+
+		`{"name":"Globals","variablesReference":7,"expensive":false},`+
+		`{"name":"Operand Stack","variablesReference":8,"expensive":false}]}`)
+
+	c.send("variables", `{"variablesReference":1}`)
+	ok(t, c.expect("variables"), `{"variables":[`+
+		`{"name":"n","value":"2","variablesReference":0},`+
+		`{"name":"sq","value":"4","variablesReference":0}]}`)
+
+	c.send("variables", `{"variablesReference":5}`)
+	ok(t, c.expect("variables"), `{"variables":[`+
+		`{"name":"args","value":"[ ]","variablesReference":0},`+
+		`{"name":"i","value":"2","variablesReference":0}]}`)
+
+	c.send("variables", `{"variablesReference":9}`)
+	ok(t, c.expect("variables"), "error: invalid variables reference 9")
+
+	c.send("evaluate", `{"expression":"sq * 10","frameId":0}`)
+	ok(t, c.expect("evaluate"), `{"result":"40","variablesReference":0}`)
+	c.send("evaluate", `{"expression":"nope","frameId":0}`)
+	ok(t, c.expect("evaluate"), "error: Symbol 'nope' is not defined")
+
+	c.send("next", `{"threadId":1}`)
+	ok(t, c.expect("next"), "")
+	ok(t, c.expect("stopped"), `{"reason":"step","threadId":1,"allThreadsStopped":true}`)
+
+	c.send("continue", `{"threadId":1}`)
+	ok(t, c.expect("continue"), `{"allThreadsContinued":true}`)
+	ok(t, c.expect("exited"), `{"exitCode":0}`)
+	ok(t, c.expect("terminated"), "")
+
+	c.send("disconnect", `{}`)
+	ok(t, c.expect("disconnect"), "")
+	assert(t, <-c.done == nil)
+}
+
+func TestDAPDisconnect(t *testing.T) {
+
+	file, cleanup := writeProgram(t)
+	defer cleanup()
+
+	// disconnecting stops the program
+	c := newClient(t)
+	c.send("initialize", `{}`)
+	c.send("launch", fmt.Sprintf(`{"program":"%s","stopOnEntry":true}`, file))
+	c.send("configurationDone", `{}`)
+	ok(t, c.expect("stopped"), `{"reason":"entry","threadId":1,"allThreadsStopped":true}`)
+
+	c.send("disconnect", `{}`)
+	ok(t, c.expect("exited"), `{"exitCode":0}`)
+	ok(t, c.expect("terminated"), "")
+	ok(t, c.expect("disconnect"), "")
+	assert(t, <-c.done == nil)
+
+	// errors are reported as output
+	c = newClient(t)
+	c.send("initialize", `{}`)
+	c.send("configurationDone", `{}`)
+	c.send("launch", `{"program":"nope.glm"}`)
+	ok(t, c.expect("output"), `{"category":"stderr","output":"open nope.glm: no such file or directory\n"}`)
+	ok(t, c.expect("exited"), `{"exitCode":1}`)
+	c.w.Close()
+	assert(t, <-c.done == nil)
+}
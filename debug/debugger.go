@@ -0,0 +1,536 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package debug implements a source-level debugger for Golem programs,
+// with a command-line front end and a Debug Adapter Protocol server.
+package debug
+
+import (
+	"errors"
+	"fmt"
+	"golem"
+	g "golem/core"
+	"golem/interpreter"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//--------------------------------------------------------------
+// Debugger
+
+// Action tells the debugger what to do after the program has stopped.
+type Action int
+
+// The Actions
+const (
+	// run until a breakpoint is reached
+	Continue Action = iota
+	// stop at the next line, even if it is in a function that is called
+	StepInto
+	// stop at the next line of the current function, or of its caller
+	StepOver
+	// stop when the current function returns to its caller
+	StepOut
+	// abort the program
+	Quit
+)
+
+// Frontend is the user interface of a debugger.
+type Frontend interface {
+
+	// Stopped is called when the program has stopped.  The program stays
+	// stopped until Stopped returns the Action that it should take next.
+	Stopped(s *Stop) Action
+}
+
+// Breakpoint stops the program when it reaches a line of a file.  If there
+// is a condition, then the program stops only if the condition, which is
+// a Golem expression, evaluates to true.
+type Breakpoint struct {
+	ID        int
+	File      string
+	Line      int
+	Condition string
+
+	// the compiled conditions, by the template that they are evaluated in
+	conds map[*g.Template]g.Value
+}
+
+// Debugger implements interpreter.Debugger.  The program stops whenever
+// it reaches a new line where it ought to stop, either because there is
+// a breakpoint on the line or because it is being stepped through.  Code
+// that runs in a spawned goroutine is not debugged.
+type Debugger struct {
+	rt *golem.Runtime
+	fe Frontend
+
+	// StopOnEntry stops the program at the first line that it executes.
+	StopOnEntry bool
+
+	mu          sync.Mutex
+	breakpoints []*Breakpoint
+	nextID      int
+	pause       bool
+
+	// the nested interpreters that are running, outermost first
+	intps []*interpreter.Interpreter
+
+	// the line that each frame on the stack was last seen at, outermost first
+	lines []location
+
+	action     Action
+	stepDepth  int
+	started    bool
+	evaluating bool
+
+	// the canonical paths of the modules' files, by module name
+	paths map[string]string
+}
+
+type location struct {
+	tpl  *g.Template
+	line int
+}
+
+// New creates a Debugger, and attaches it to the given Runtime.  Every
+// program that the Runtime runs afterwards is debugged.
+func New(rt *golem.Runtime, fe Frontend) *Debugger {
+	d := &Debugger{rt, fe, false,
+		sync.Mutex{}, []*Breakpoint{}, 0, false,
+		[]*interpreter.Interpreter{}, []location{},
+		Continue, 0, false, false,
+		make(map[string]string)}
+	rt.Debugger = d
+	return d
+}
+
+// SetBreakpoint adds a breakpoint, with an optional condition.
+func (d *Debugger) SetBreakpoint(file string, line int, cond string) *Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.nextID++
+	bp := &Breakpoint{d.nextID, file, line, cond, make(map[*g.Template]g.Value)}
+	d.breakpoints = append(d.breakpoints, bp)
+	return bp
+}
+
+// ClearBreakpoint removes the breakpoint with the given ID, and returns
+// whether there was one.
+func (d *Debugger) ClearBreakpoint(id int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for j, bp := range d.breakpoints {
+		if bp.ID == id {
+			d.breakpoints = append(d.breakpoints[:j], d.breakpoints[j+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ClearBreakpoints removes all of the breakpoints in a file, or all
+// of the breakpoints if the file is empty.
+func (d *Debugger) ClearBreakpoints(file string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	bps := []*Breakpoint{}
+	for _, bp := range d.breakpoints {
+		if file != "" && canonical(bp.File) != canonical(file) {
+			bps = append(bps, bp)
+		}
+	}
+	d.breakpoints = bps
+}
+
+// Breakpoints returns the breakpoints, in the order they were set.
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]*Breakpoint{}, d.breakpoints...)
+}
+
+// Pause stops the program at the next line that it reaches.  It may be
+// called while the program is running, from any goroutine.
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pause = true
+}
+
+// Before implements interpreter.Debugger.
+func (d *Debugger) Before(intp *interpreter.Interpreter) g.Error {
+
+	// the expressions that a Stop evaluates are not debugged
+	if d.evaluating {
+		return nil
+	}
+
+	d.track(intp)
+	depth := len(d.lines)
+	top := intp.DebugFrame(0)
+	tpl := top.Func.Template()
+	line := tpl.LineNumber(top.IP)
+
+	// stop only when a new line is reached, which happens when the line of
+	// the frame changes, or when a function starts.  The opcodes that the
+	// compiler adds to the start and end of a function do not have a line.
+	if tpl.IsEntry(top.IP) {
+		d.lines[depth-1] = location{}
+	}
+	if line == 0 || d.lines[depth-1] == (location{tpl, line}) {
+		return nil
+	}
+	d.lines[depth-1] = location{tpl, line}
+
+	reason := ""
+	var condErr error
+	if !d.started {
+		d.started = true
+		if d.StopOnEntry {
+			reason = "entry"
+		}
+	}
+
+	d.mu.Lock()
+	pause := d.pause
+	d.pause = false
+	bps := []*Breakpoint{}
+	for _, bp := range d.breakpoints {
+		if bp.Line == line && d.canonicalModule(top.Func.Module()) == canonical(bp.File) {
+			bps = append(bps, bp)
+		}
+	}
+	d.mu.Unlock()
+
+	stop := d.newStop()
+	if reason == "" && pause {
+		reason = "pause"
+	}
+	if reason == "" {
+		for _, bp := range bps {
+			hit, err := d.evalCondition(stop, bp)
+			if hit {
+				reason = "breakpoint"
+				condErr = err
+				break
+			}
+		}
+	}
+	if reason == "" {
+		switch d.action {
+		case StepInto:
+			reason = "step"
+		case StepOver:
+			if depth <= d.stepDepth {
+				reason = "step"
+			}
+		case StepOut:
+			if depth < d.stepDepth {
+				reason = "step"
+			}
+		}
+	}
+	if reason == "" {
+		return nil
+	}
+
+	stop.Reason = reason
+	stop.Err = condErr
+	d.action = d.fe.Stopped(stop)
+	d.stepDepth = depth
+	if d.action == Quit {
+		return g.CancelledError("The program was stopped by the debugger")
+	}
+	return nil
+}
+
+// Keep track of the interpreters that are running.  Importing a module,
+// or calling back into Golem code via Runtime.Call, runs another
+// interpreter on top of the ones that are already running.
+func (d *Debugger) track(intp *interpreter.Interpreter) {
+
+	n := len(d.intps) - 1
+	for n >= 0 && d.intps[n] != intp {
+		n--
+	}
+	if n >= 0 {
+		d.intps = d.intps[:n+1]
+	} else {
+		d.intps = append(d.intps, intp)
+	}
+
+	// the frames that have returned are forgotten
+	depth := 0
+	for _, it := range d.intps {
+		depth += it.NumFrames()
+	}
+	if len(d.lines) > depth {
+		d.lines = d.lines[:depth]
+	}
+	for len(d.lines) < depth {
+		d.lines = append(d.lines, location{})
+	}
+}
+
+// whether a breakpoint is hit.  If its condition cannot be evaluated,
+// then it is hit, and the error is returned.
+func (d *Debugger) evalCondition(s *Stop, bp *Breakpoint) (bool, error) {
+
+	if bp.Condition == "" {
+		return true, nil
+	}
+
+	f := s.Frames[0]
+	fn, ok := bp.conds[f.Func.Template()]
+	if !ok {
+		var err error
+		fn, err = s.compile(f, bp.Condition)
+		if err != nil {
+			return true, err
+		}
+		bp.conds[f.Func.Template()] = fn
+	}
+
+	val, err := s.call(f, fn)
+	if err != nil {
+		return true, err
+	}
+	return val == g.TRUE, nil
+}
+
+func (d *Debugger) canonicalModule(mod *g.BytecodeModule) string {
+	if p, ok := d.paths[mod.Name]; ok {
+		return p
+	}
+	p := canonical(mod.Name)
+	d.paths[mod.Name] = p
+	return p
+}
+
+// the absolute path of a file, so that the same file is always
+// named the same way
+func canonical(file string) string {
+	if p, err := filepath.Abs(file); err == nil {
+		return p
+	}
+	return filepath.Clean(file)
+}
+
+//--------------------------------------------------------------
+// Stop
+
+// Stop describes a program that has stopped.  It is valid only until
+// the Frontend's Stopped method returns.
+type Stop struct {
+
+	// Reason is why the program stopped: "entry", "breakpoint",
+	// "step" or "pause".
+	Reason string
+
+	// Err is the error, if any, that the condition of
+	// a breakpoint caused.
+	Err error
+
+	// Frames are the frames on the stack, innermost first.
+	Frames []*Frame
+
+	Debugger *Debugger
+}
+
+// Frame is a frame on the stack of a program that has stopped.
+type Frame struct {
+	Func g.BytecodeFunc
+	IP   int
+
+	// the operand stack, from the bottom up
+	Stack []g.Value
+
+	locals []*g.Ref
+}
+
+// Variable is a named value.
+type Variable struct {
+	Name  string
+	Value g.Value
+}
+
+func (d *Debugger) newStop() *Stop {
+
+	frames := []*Frame{}
+	for j := len(d.intps) - 1; j >= 0; j-- {
+		intp := d.intps[j]
+		for k := 0; k < intp.NumFrames(); k++ {
+			f := intp.DebugFrame(k)
+			frames = append(frames, &Frame{f.Func, f.IP, f.Stack, f.Locals})
+		}
+	}
+	return &Stop{"", nil, frames, d}
+}
+
+// File returns the name of the module that the frame's function is in.
+func (f *Frame) File() string {
+	return f.Func.Module().Name
+}
+
+// Name returns the name of the frame's function.
+func (f *Frame) Name() string {
+	return f.Func.Template().Name
+}
+
+// Line returns the line that the frame is at.
+func (f *Frame) Line() int {
+	return f.Func.Template().LineNumber(f.IP)
+}
+
+// Col returns the column that the frame is at.
+func (f *Frame) Col() int {
+	return f.Func.Template().ColNumber(f.IP)
+}
+
+// Locals returns the frame's local variables.  The variables that the
+// compiler creates are left out.
+func (f *Frame) Locals() []*Variable {
+	return variables(f.Func.Template().LocalNames, len(f.locals),
+		func(j int) g.Value { return f.locals[j].Val })
+}
+
+// Captures returns the variables that the frame's function has captured.
+func (f *Frame) Captures() []*Variable {
+	return variables(f.Func.Template().CaptureNames, f.Func.Template().NumCaptures,
+		func(j int) g.Value { return f.Func.GetCapture(j).Val })
+}
+
+// Globals returns the top-level variables of the frame's module.  The
+// frame of the module itself has none, since they are its locals.
+func (f *Frame) Globals() []*Variable {
+	mod := f.Func.Module()
+	if f.Func.Template() == mod.Templates[0] {
+		return []*Variable{}
+	}
+	return variables(mod.Templates[0].LocalNames, len(mod.Refs),
+		func(j int) g.Value { return mod.Refs[j].Val })
+}
+
+func variables(names []string, n int, value func(int) g.Value) []*Variable {
+	vars := []*Variable{}
+	for j := 0; j < n && j < len(names); j++ {
+		if !strings.HasPrefix(names[j], "#") {
+			vars = append(vars, &Variable{names[j], value(j)})
+		}
+	}
+	return vars
+}
+
+// The variables that an expression can refer to, sorted by name.  Locals
+// take precedence over captures, which take precedence over globals.  When
+// a function has several locals with the same name in different blocks,
+// the one that was defined last is used.
+func (f *Frame) visible() []*Variable {
+
+	byName := make(map[string]*Variable)
+	for _, vars := range [][]*Variable{f.Globals(), f.Captures(), f.Locals()} {
+		for _, v := range vars {
+			if v.Name != "this" {
+				byName[v.Name] = v
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	vars := make([]*Variable, len(names))
+	for j, name := range names {
+		vars[j] = byName[name]
+	}
+	return vars
+}
+
+// Eval evaluates a Golem expression in one of the frames.
+func (s *Stop) Eval(frame int, expr string) (g.Value, error) {
+
+	if frame < 0 || frame >= len(s.Frames) {
+		return nil, fmt.Errorf("there is no frame %d", frame)
+	}
+	f := s.Frames[frame]
+	fn, err := s.compile(f, expr)
+	if err != nil {
+		return nil, err
+	}
+	return s.call(f, fn)
+}
+
+// Compile an expression into a function whose parameters are the
+// variables that are visible in a frame.
+func (s *Stop) compile(f *Frame, expr string) (g.Value, error) {
+
+	names := []string{}
+	for _, v := range f.visible() {
+		names = append(names, v.Name)
+	}
+	src := fmt.Sprintf("fn(%s) {\nreturn (\n%s\n);\n};",
+		strings.Join(names, ", "), expr)
+
+	d := s.Debugger
+	d.evaluating = true
+	defer func() { d.evaluating = false }()
+
+	mod, err := d.rt.Compile("<expr>", src)
+	if err != nil {
+		return nil, exprError(err)
+	}
+	val, err := d.rt.Run(mod)
+	if err != nil {
+		return nil, exprError(err)
+	}
+	return val, nil
+}
+
+func (s *Stop) call(f *Frame, fn g.Value) (g.Value, error) {
+
+	args := []g.Value{}
+	for _, v := range f.visible() {
+		args = append(args, v.Value)
+	}
+
+	d := s.Debugger
+	d.evaluating = true
+	defer func() { d.evaluating = false }()
+
+	val, err := d.rt.Call(fn, args...)
+	if err != nil {
+		return nil, exprError(err)
+	}
+	return val, nil
+}
+
+// The positions of the errors in an expression are left out, since they
+// refer to the function that the expression was compiled into.
+func exprError(err error) error {
+	msgs := []string{}
+	for _, d := range golem.Diagnostics(err) {
+		msgs = append(msgs, d.Message)
+	}
+	return errors.New(strings.Join(msgs, "\n"))
+}
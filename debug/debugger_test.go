@@ -0,0 +1,292 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debug
+
+import (
+	"fmt"
+	"golem"
+	g "golem/core"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func assert(t *testing.T, flag bool) {
+	if !flag {
+		t.Error("assertion failure")
+	}
+}
+
+func ok(t *testing.T, result interface{}, expect interface{}) {
+	if !reflect.DeepEqual(result, expect) {
+		t.Error(result, " != ", expect)
+	}
+}
+
+// A Frontend that takes the given actions, in order, and records
+// where the program stopped.  Once it runs out of actions, it continues.
+type script struct {
+	actions []Action
+	stops   []string
+	inspect func(s *Stop)
+}
+
+func (sc *script) Stopped(s *Stop) Action {
+	f := s.Frames[0]
+	sc.stops = append(sc.stops, fmt.Sprintf("%s %s:%d", s.Reason, f.Name(), f.Line()))
+	if sc.inspect != nil {
+		sc.inspect(s)
+	}
+	if len(sc.actions) == 0 {
+		return Continue
+	}
+	a := sc.actions[0]
+	sc.actions = sc.actions[1:]
+	return a
+}
+
+const program = `let total = 0;
+fn add(n) {
+    let sq = n * n;
+    total += sq;
+    return sq;
+}
+pub fn main(args) {
+    for i in [1, 2, 3] {
+        add(i);
+    }
+    return total;
+}
+`
+
+func debugRun(t *testing.T, src string, sc *script, setup func(d *Debugger)) error {
+
+	rt := golem.NewRuntime()
+	d := New(rt, sc)
+	if setup != nil {
+		setup(d)
+	}
+
+	mod, err := rt.Compile("test.glm", src)
+	assert(t, err == nil)
+	return rt.RunMain(mod, []string{})
+}
+
+func TestStepping(t *testing.T) {
+
+	sc := &script{[]Action{StepInto, StepInto, StepInto, StepInto, StepInto,
+		StepOver, StepOver, StepOver, StepOver, StepOver, StepInto, StepOut}, nil, nil}
+	err := debugRun(t, program, sc, func(d *Debugger) { d.StopOnEntry = true })
+	assert(t, err == nil)
+
+	ok(t, sc.stops, []string{
+		"entry <module>:1",
+		"step <module>:2",
+		"step <module>:7",
+		"step main:8",
+		"step main:9",
+		"step add:3",
+		"step add:4",
+		"step add:5",
+		"step main:10",
+		"step main:8",
+		"step main:9",
+		"step add:3",
+		"step main:10",
+	})
+}
+
+func TestBreakpoints(t *testing.T) {
+
+	// unconditional
+	sc := &script{nil, nil, nil}
+	err := debugRun(t, program, sc, func(d *Debugger) {
+		d.SetBreakpoint("test.glm", 4, "")
+	})
+	assert(t, err == nil)
+	ok(t, sc.stops, []string{
+		"breakpoint add:4", "breakpoint add:4", "breakpoint add:4"})
+
+	// conditional
+	sc = &script{nil, nil, func(s *Stop) {
+		assert(t, s.Err == nil)
+		val, err := s.Eval(0, "[n, sq, total]")
+		assert(t, err == nil)
+		ok(t, val.ToStr().String(), "[ 2, 4, 1 ]")
+	}}
+	err = debugRun(t, program, sc, func(d *Debugger) {
+		d.SetBreakpoint(filepath.Join(".", "test.glm"), 4, "n == 2")
+	})
+	assert(t, err == nil)
+	ok(t, sc.stops, []string{"breakpoint add:4"})
+
+	// a condition that cannot be evaluated stops the program
+	sc = &script{nil, nil, func(s *Stop) {
+		ok(t, s.Err.Error(), "Symbol 'x' is not defined")
+	}}
+	err = debugRun(t, program, sc, func(d *Debugger) {
+		d.SetBreakpoint("test.glm", 3, "x == 2")
+	})
+	assert(t, err == nil)
+	ok(t, len(sc.stops), 3)
+
+	// clearing
+	sc = &script{nil, nil, nil}
+	err = debugRun(t, program, sc, func(d *Debugger) {
+		bp := d.SetBreakpoint("test.glm", 3, "")
+		d.SetBreakpoint("test.glm", 4, "")
+		d.SetBreakpoint("other.glm", 4, "")
+		assert(t, d.ClearBreakpoint(bp.ID))
+		assert(t, !d.ClearBreakpoint(bp.ID))
+		d.ClearBreakpoints("test.glm")
+		ok(t, len(d.Breakpoints()), 1)
+	})
+	assert(t, err == nil)
+	ok(t, len(sc.stops), 0)
+}
+
+func TestVariables(t *testing.T) {
+
+	src := `let a = 1;
+let b = 'x';
+fn outer(p) {
+    let c = [p];
+    return fn(q) {
+        let d = q + a;
+        return c;
+    };
+}
+let f = outer(2);
+f(3);
+`
+	sc := &script{nil, nil, func(s *Stop) {
+
+		names := func(vars []*Variable) string {
+			strs := []string{}
+			for _, v := range vars {
+				if _, ok := v.Value.(g.Func); ok {
+					strs = append(strs, v.Name)
+				} else {
+					strs = append(strs, v.Name+"="+show(v.Value))
+				}
+			}
+			return strings.Join(strs, " ")
+		}
+
+		f := s.Frames[0]
+		ok(t, f.Name(), "<lambda>")
+		ok(t, names(f.Locals()), "q=3 d=4")
+		ok(t, names(f.Captures()), "a=1 c=[ 2 ]")
+		ok(t, names(f.Globals()), "outer a=1 b=\"x\" f")
+		ok(t, len(s.Frames), 2)
+		ok(t, names(s.Frames[1].Locals()), "outer a=1 b=\"x\" f")
+		ok(t, len(s.Frames[1].Globals()), 0)
+
+		val, err := s.Eval(0, "c[0] + d")
+		assert(t, err == nil)
+		ok(t, val, g.MakeInt(6))
+
+		val, err = s.Eval(1, "b + a")
+		assert(t, err == nil)
+		ok(t, val, g.MakeStr("x1"))
+
+		_, err = s.Eval(1, "d")
+		ok(t, err.Error(), "Symbol 'd' is not defined")
+
+		_, err = s.Eval(0, "a / 0")
+		ok(t, err.Error(), "DivideByZero")
+
+		_, err = s.Eval(2, "a")
+		ok(t, err.Error(), "there is no frame 2")
+	}}
+	err := debugRun(t, src, sc, func(d *Debugger) {
+		d.SetBreakpoint("test.glm", 7, "")
+	})
+	assert(t, err == nil)
+	ok(t, sc.stops, []string{"breakpoint <lambda>:7"})
+}
+
+func TestCallbacks(t *testing.T) {
+
+	// a native function that calls a function twice
+	twice := g.NewNativeFunc(func(ev g.Eval, values []g.Value) (g.Value, g.Error) {
+		fn := values[0].(g.Func)
+		if _, err := ev.Eval(fn, []g.Value{g.ONE}); err != nil {
+			return nil, err
+		}
+		return ev.Eval(fn, []g.Value{g.MakeInt(2)})
+	})
+
+	// every call is stepped into, even though the function is all on one line
+	sc := &script{[]Action{StepInto, StepInto, StepInto, StepInto}, nil, func(s *Stop) {
+		if s.Frames[0].Name() == "<lambda>" {
+			ok(t, len(s.Frames), 2)
+		}
+	}}
+	rt := golem.NewRuntime()
+	assert(t, rt.RegisterBuiltin("twice", twice) == nil)
+	d := New(rt, sc)
+	d.StopOnEntry = true
+
+	mod, err := rt.Compile("test.glm", "let a = twice(fn(x) { return x * 2; });\nlet b = a;\n")
+	assert(t, err == nil)
+	assert(t, rt.RunMain(mod, nil) == nil)
+	ok(t, sc.stops, []string{
+		"entry <module>:1",
+		"step <lambda>:1",
+		"step <lambda>:1",
+		"step <module>:2",
+	})
+}
+
+func TestImports(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "golem")
+	assert(t, err == nil)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "util.glm")
+	err = ioutil.WriteFile(file, []byte("pub fn double(x) {\n    return x * 2;\n}\n"), 0644)
+	assert(t, err == nil)
+
+	sc := &script{[]Action{StepOut}, nil, func(s *Stop) {
+		if s.Frames[0].Name() == "double" {
+			ok(t, len(s.Frames), 2)
+			ok(t, s.Frames[1].Name(), "<module>")
+		}
+	}}
+	rt := golem.NewRuntime()
+	rt.Path = []string{dir}
+	d := New(rt, sc)
+	d.SetBreakpoint(file, 2, "")
+
+	mod, err := rt.Compile("test.glm", "import util;\nlet a = util.double(3);\nlet b = a;\n")
+	assert(t, err == nil)
+	assert(t, rt.RunMain(mod, nil) == nil)
+	ok(t, sc.stops, []string{"breakpoint double:2", "step <module>:3"})
+}
+
+func TestQuit(t *testing.T) {
+
+	sc := &script{[]Action{Quit}, nil, nil}
+	err := debugRun(t, program, sc, func(d *Debugger) { d.StopOnEntry = true })
+	rte, isRte := err.(*golem.RuntimeError)
+	assert(t, isRte)
+	ok(t, rte.Err.Kind(), g.CANCELLED)
+	ok(t, len(sc.stops), 1)
+}
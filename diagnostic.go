@@ -0,0 +1,303 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package golem
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golem/analyzer"
+	"golem/ast"
+	g "golem/core"
+	"golem/interpreter"
+	"golem/parser"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+//--------------------------------------------------------------
+// Diagnostic
+
+// Diagnostic describes a single problem with a module, in a form that
+// can be rendered for people to read, or consumed by tools.  Begin and
+// End are the range of the offending source code, inclusive.  They are
+// zero if the position is not known.  Runtime errors have a single
+// position, and the stack frames that were executing when the error was thrown.
+type Diagnostic struct {
+	Kind    string
+	Message string
+	File    string
+	Begin   ast.Pos
+	End     ast.Pos
+	Frames  []*interpreter.StackFrame
+}
+
+// Diagnostics converts an error that was returned by Compile, Run or
+// Call into a list of diagnostics.  Any other kind of error is
+// converted into a single Diagnostic that does not have a position.
+func Diagnostics(err error) []*Diagnostic {
+
+	diags := []*Diagnostic{}
+
+	switch t := err.(type) {
+
+	case *SyntaxError:
+		for _, e := range t.Errors {
+			if pe, ok := e.(*parser.Error); ok {
+				diags = append(diags, &Diagnostic{
+					pe.Kind.String(), pe.Message(), t.Module, pe.Begin, pe.End, nil})
+			} else {
+				diags = append(diags, &Diagnostic{
+					"SyntaxError", e.Error(), t.Module, ast.Pos{}, ast.Pos{}, nil})
+			}
+		}
+
+	case *AnalysisError:
+		for _, e := range t.Errors {
+			if ae, ok := e.(*analyzer.Error); ok {
+				diags = append(diags, &Diagnostic{
+					ae.Kind.String(), ae.Error(), t.Module, ae.Begin, ae.End, nil})
+			} else {
+				diags = append(diags, &Diagnostic{
+					"AnalysisError", e.Error(), t.Module, ast.Pos{}, ast.Pos{}, nil})
+			}
+		}
+
+	case *RuntimeError:
+		d := &Diagnostic{
+			t.Err.Kind().String(), errorMessage(t.Err), "", ast.Pos{}, ast.Pos{}, t.Frames}
+
+		// the error occurred at the top of the stack
+		if len(t.Frames) > 0 {
+			f := t.Frames[0]
+			d.File = f.File
+			d.Begin = ast.Pos{f.Line, f.Col}
+			d.End = d.Begin
+		}
+		diags = append(diags, d)
+
+	default:
+		diags = append(diags, &Diagnostic{
+			"Error", err.Error(), "", ast.Pos{}, ast.Pos{}, nil})
+	}
+
+	return diags
+}
+
+// The message of a runtime error, without the kind that Error() starts
+// with.  An error without a message is described by its kind.  A Generic
+// error is a struct that was thrown by a script, so it is shown in full.
+func errorMessage(err g.Error) string {
+	if err.Kind() == g.GENERIC {
+		return err.Error()
+	}
+	if msg, e := err.Struct().GetField(g.MakeStr("msg")); e == nil {
+		return msg.ToStr().String()
+	}
+	return err.Kind().String()
+}
+
+// hasPos returns whether the position of the diagnostic is known
+func (d *Diagnostic) hasPos() bool {
+	return d.Begin.Line > 0
+}
+
+// MarshalJSON encodes a diagnostic for tools to consume, like so:
+//
+//	{
+//	  "kind": "DivideByZero",
+//	  "message": "DivideByZero",
+//	  "file": "foo.glm",
+//	  "range": {
+//	    "begin": {"line": 3, "col": 14},
+//	    "end": {"line": 3, "col": 14}
+//	  },
+//	  "stack": [
+//	    {"func": "divide", "file": "foo.glm", "line": 3, "col": 14},
+//	    {"func": "<module>", "file": "foo.glm", "line": 5, "col": 1}
+//	  ]
+//	}
+//
+// Every field is always present.  The range is null if the position is
+// not known, and the stack is empty unless the diagnostic is for a
+// runtime error.  Lines and columns start at 1.
+func (d *Diagnostic) MarshalJSON() ([]byte, error) {
+
+	type jsonPos struct {
+		Line int `json:"line"`
+		Col  int `json:"col"`
+	}
+	type jsonRange struct {
+		Begin jsonPos `json:"begin"`
+		End   jsonPos `json:"end"`
+	}
+	type jsonFrame struct {
+		Func string `json:"func"`
+		File string `json:"file"`
+		Line int    `json:"line"`
+		Col  int    `json:"col"`
+	}
+	type jsonDiagnostic struct {
+		Kind    string      `json:"kind"`
+		Message string      `json:"message"`
+		File    string      `json:"file"`
+		Range   *jsonRange  `json:"range"`
+		Stack   []jsonFrame `json:"stack"`
+	}
+
+	jd := &jsonDiagnostic{d.Kind, d.Message, d.File, nil, []jsonFrame{}}
+	if d.hasPos() {
+		jd.Range = &jsonRange{
+			jsonPos{d.Begin.Line, d.Begin.Col},
+			jsonPos{d.End.Line, d.End.Col}}
+	}
+	for _, f := range d.Frames {
+		jd.Stack = append(jd.Stack, jsonFrame{f.Func, f.File, f.Line, f.Col})
+	}
+	return json.Marshal(jd)
+}
+
+//--------------------------------------------------------------
+// Renderer
+
+// ANSI escape codes
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[1;31m"
+	ansiGreen = "\x1b[1;32m"
+)
+
+// Renderer formats diagnostics for people to read.  The line of source code
+// that a diagnostic refers to is shown, with the offending span underlined
+// by a '^~~~' marker, followed by the stack trace of a runtime error.
+type Renderer struct {
+
+	// Color enables ANSI colour escape codes.  It should be false when
+	// the output is going to a file or a log.
+	Color bool
+
+	// Source returns the source code of the given file, if it is available.
+	// If Source is nil, or the source code is not available, then
+	// diagnostics are rendered without any source code.
+	Source func(file string) (string, bool)
+}
+
+// Render writes a diagnostic.
+func (r *Renderer) Render(w io.Writer, d *Diagnostic) error {
+
+	var buf bytes.Buffer
+
+	// header
+	loc := d.File
+	if d.hasPos() {
+		if loc != "" {
+			loc += ":"
+		}
+		loc += fmt.Sprintf("%d:%d", d.Begin.Line, d.Begin.Col)
+	}
+	if loc != "" {
+		buf.WriteString(r.paint(ansiBold, loc+":"))
+		buf.WriteString(" ")
+	}
+	buf.WriteString(r.paint(ansiRed, d.Message))
+	buf.WriteString("\n")
+
+	// snippet
+	if line, ok := r.sourceLine(d); ok {
+		buf.WriteString(line)
+		buf.WriteString("\n")
+		buf.WriteString(r.marker(d, line))
+		buf.WriteString("\n")
+	}
+
+	// stack trace
+	for _, f := range d.Frames {
+		buf.WriteString(f.String())
+		buf.WriteString("\n")
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// find the line of source code that a diagnostic refers to
+func (r *Renderer) sourceLine(d *Diagnostic) (string, bool) {
+
+	if r.Source == nil || !d.hasPos() {
+		return "", false
+	}
+	src, ok := r.Source(d.File)
+	if !ok {
+		return "", false
+	}
+
+	lines := strings.Split(src, "\n")
+	if d.Begin.Line > len(lines) {
+		return "", false
+	}
+	return strings.TrimRight(lines[d.Begin.Line-1], "\r"), true
+}
+
+// Make the marker that goes underneath the source line.  Tabs in the
+// source line are copied, so that the marker lines up with the span.
+// A span that continues onto later lines is marked up to the end of
+// the first line.
+func (r *Renderer) marker(d *Diagnostic, line string) string {
+
+	runes := []rune(line)
+
+	begin := runeIndex(line, d.Begin.Col)
+	end := runeIndex(line, d.End.Col)
+	if d.End.Line > d.Begin.Line {
+		end = len(runes) - 1
+	}
+	if end < begin {
+		end = begin
+	}
+
+	var indent bytes.Buffer
+	for i := 0; i < begin; i++ {
+		if i < len(runes) && runes[i] == '\t' {
+			indent.WriteRune('\t')
+		} else {
+			indent.WriteRune(' ')
+		}
+	}
+
+	mark := "^" + strings.Repeat("~", end-begin)
+	return indent.String() + r.paint(ansiGreen, mark)
+}
+
+// Columns count bytes, so a column has to be converted to the index of
+// the rune that it refers to.
+func runeIndex(line string, col int) int {
+	n := col - 1
+	if n < 0 {
+		return 0
+	}
+	if n > len(line) {
+		return utf8.RuneCountInString(line) + n - len(line)
+	}
+	return utf8.RuneCountInString(line[:n])
+}
+
+func (r *Renderer) paint(code string, s string) string {
+	if r.Color {
+		return code + s + ansiReset
+	}
+	return s
+}
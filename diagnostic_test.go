@@ -0,0 +1,151 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package golem
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"golem/ast"
+	"reflect"
+	"testing"
+)
+
+func render(r *Renderer, err error) string {
+	var buf bytes.Buffer
+	for _, d := range Diagnostics(err) {
+		r.Render(&buf, d)
+	}
+	return buf.String()
+}
+
+func TestDiagnostics(t *testing.T) {
+
+	// syntax errors
+	rt := NewRuntime()
+	_, err := rt.Compile("foo.glm", "let a = 1 +;\nlet b = (2;")
+	diags := Diagnostics(err)
+	assert(t, len(diags) == 2)
+	assert(t, reflect.DeepEqual(diags[0], &Diagnostic{
+		"UnexpectedToken", "Unexpected Token ';'", "foo.glm",
+		ast.Pos{1, 12}, ast.Pos{1, 12}, nil}))
+
+	r := &Renderer{false, rt.Source}
+	assert(t, render(r, err) == `foo.glm:1:12: Unexpected Token ';'
+let a = 1 +;
+           ^
+foo.glm:2:11: Unexpected Token ';'
+let b = (2;
+          ^
+`)
+
+	// analysis errors, with tabs and colour
+	_, err = rt.Compile("bar.glm", "let abc = 1;\n\tconst abc = 2;")
+	diags = Diagnostics(err)
+	assert(t, len(diags) == 1)
+	assert(t, diags[0].Kind == "AlreadyDefined")
+
+	assert(t, render(r, err) == "bar.glm:2:8: Symbol 'abc' is already defined\n"+
+		"\tconst abc = 2;\n"+
+		"\t      ^~~\n")
+
+	r.Color = true
+	assert(t, render(r, err) ==
+		"\x1b[1mbar.glm:2:8:\x1b[0m \x1b[1;31mSymbol 'abc' is already defined\x1b[0m\n"+
+			"\tconst abc = 2;\n"+
+			"\t      \x1b[1;32m^~~\x1b[0m\n")
+
+	// runtime errors
+	r.Color = false
+	mod, err := rt.Compile("baz.glm", `
+fn divide(a, b) {
+    return a / b;
+}
+divide(1, 0);
+`)
+	assert(t, err == nil)
+	_, err = rt.Run(mod)
+	diags = Diagnostics(err)
+	assert(t, len(diags) == 1)
+	assert(t, diags[0].Kind == "DivideByZero")
+	assert(t, len(diags[0].Frames) == 2)
+
+	assert(t, render(r, err) == `baz.glm:3:14: DivideByZero
+    return a / b;
+             ^
+    at divide (baz.glm:3:14)
+    at <module> (baz.glm:5:1)
+`)
+
+	// the source code is not available
+	r.Source = nil
+	assert(t, render(r, err) == `baz.glm:3:14: DivideByZero
+    at divide (baz.glm:3:14)
+    at <module> (baz.glm:5:1)
+`)
+
+	// spans that cover more than one line
+	r.Source = func(string) (string, bool) { return "abc\ndef", true }
+	var buf bytes.Buffer
+	r.Render(&buf, &Diagnostic{"", "oops", "", ast.Pos{1, 2}, ast.Pos{2, 2}, nil})
+	assert(t, buf.String() == "1:2: oops\nabc\n ^~\n")
+
+	// columns count bytes, but the marker counts characters
+	r.Source = rt.Source
+	_, err = rt.Compile("utf.glm", "let s = 'ééééé'; let t = zz;")
+	assert(t, render(r, err) == `utf.glm:1:31: Symbol 'zz' is not defined
+let s = 'ééééé'; let t = zz;
+                         ^~
+`)
+
+	// other errors
+	assert(t, render(r, errors.New("oops")) == "oops\n")
+}
+
+func TestDiagnosticJSON(t *testing.T) {
+
+	rt := NewRuntime()
+	mod, err := rt.Compile("foo.glm", "let a = 1;\na / 0;")
+	assert(t, err == nil)
+	_, err = rt.Run(mod)
+
+	b, jerr := json.Marshal(Diagnostics(err)[0])
+	assert(t, jerr == nil)
+	assert(t, string(b) == `{"kind":"DivideByZero","message":"DivideByZero",`+
+		`"file":"foo.glm","range":{"begin":{"line":2,"col":3},"end":{"line":2,"col":3}},`+
+		`"stack":[{"func":"\u003cmodule\u003e","file":"foo.glm","line":2,"col":3}]}`)
+
+	// the message does not repeat the kind
+	mod, err = rt.Compile("baz.glm", "let s = struct {};\ns.nope;")
+	assert(t, err == nil)
+	_, err = rt.Run(mod)
+	b, jerr = json.Marshal(Diagnostics(err)[0])
+	assert(t, jerr == nil)
+	assert(t, string(b) == `{"kind":"NoSuchField","message":"Field 'nope' not found",`+
+		`"file":"baz.glm","range":{"begin":{"line":2,"col":3},"end":{"line":2,"col":3}},`+
+		`"stack":[{"func":"\u003cmodule\u003e","file":"baz.glm","line":2,"col":3}]}`)
+
+	_, err = rt.Compile("bar.glm", "a;")
+	b, jerr = json.Marshal(Diagnostics(err)[0])
+	assert(t, jerr == nil)
+	assert(t, string(b) == `{"kind":"NotDefined","message":"Symbol 'a' is not defined",`+
+		`"file":"bar.glm","range":{"begin":{"line":1,"col":1},"end":{"line":1,"col":1}},`+
+		`"stack":[]}`)
+
+	b, jerr = json.Marshal(Diagnostics(errors.New("oops"))[0])
+	assert(t, jerr == nil)
+	assert(t, string(b) ==
+		`{"kind":"Error","message":"oops","file":"","range":null,"stack":[]}`)
+}
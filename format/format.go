@@ -0,0 +1,897 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package format prints Golem source code in its canonical form.
+package format
+
+import (
+	"bytes"
+	"golem/ast"
+	"golem/parser"
+	"golem/scanner"
+	"math"
+	"strings"
+)
+
+// Source formats the source code of a module.  Blocks are indented with
+// four spaces, and every statement is put on a line of its own.  Comments
+// are kept, as are blank lines, except at the start and end of a block,
+// and a run of blank lines is printed as a single one.  A comment inside
+// an expression stays next to the token that it follows.
+// A list, set, dict or struct is printed with one entry per line if there
+// is a line break after its opening bracket, and on a single line otherwise.
+// Parentheses are only printed where they are needed.
+func Source(src string) (string, error) {
+
+	scn := scanner.NewScanner(src)
+	mod, err := parser.NewParser(scn).ParseModule()
+	if err != nil {
+		return "", err
+	}
+
+	p := &printer{
+		src:      src,
+		lines:    lineOffsets(src),
+		comments: scn.Comments()}
+	// the blank lines at the start and end of the module are kept too
+	first := math.MaxInt32
+	if len(mod.Body.Nodes) > 0 {
+		first = mod.Body.Nodes[0].Begin().Line
+	}
+	if len(p.comments) > 0 && p.comments[0].Position.Line < first {
+		first = p.comments[0].Position.Line
+	}
+	if first != math.MaxInt32 {
+		p.lastLine = first - 1
+		p.blankLines(first - 1)
+	}
+
+	p.nodes(mod.Body.Nodes, ast.Pos{math.MaxInt32, math.MaxInt32})
+	p.flush(ast.Pos{math.MaxInt32, math.MaxInt32})
+
+	if p.lastLine > 0 && p.lastLine < len(p.lines) {
+		rest := src[p.lines[p.lastLine]:]
+		if strings.TrimSpace(rest) == "" {
+			p.blankLines(strings.Count(rest, "\n"))
+		}
+	}
+
+	return p.buf.String(), nil
+}
+
+//--------------------------------------------------------------
+// printer
+
+type printer struct {
+	src      string
+	lines    []int
+	comments []*ast.Token
+	buf      bytes.Buffer
+	indent   int
+
+	// whether nothing has been written on the current line yet
+	lineStart bool
+
+	// The source line of the last statement, entry or comment that was
+	// printed, or 0 at the start of a block, where blank lines are dropped.
+	lastLine int
+
+	// whether the current line continues an expression that was broken
+	// by a line comment
+	continued bool
+}
+
+func (p *printer) write(s string) {
+	if p.lineStart && p.buf.Len() > 0 {
+		p.buf.WriteString(strings.Repeat("    ", p.indent))
+		if p.continued {
+			p.buf.WriteString("    ")
+		}
+	}
+	p.buf.WriteString(s)
+	p.lineStart = false
+}
+
+func (p *printer) newline() {
+	p.buf.WriteString("\n")
+	p.lineStart = true
+	p.continued = false
+}
+
+// Print the blank lines that the source has before the given line.
+func (p *printer) blankLine(line int) {
+	if p.lastLine > 0 && line > p.lastLine+1 {
+		p.blankLines(line - p.lastLine - 1)
+	}
+}
+
+// A run of blank lines is printed as a single one.
+func (p *printer) blankLines(n int) {
+	if n > 0 {
+		p.newline()
+	}
+}
+
+// Print the comments that come before a position, each on a line of its own.
+func (p *printer) flush(pos ast.Pos) {
+	for len(p.comments) > 0 && before(p.comments[0].Position, pos) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+
+		p.blankLine(c.Position.Line)
+		p.write(commentText(c))
+		p.newline()
+		p.lastLine = endLine(c)
+	}
+}
+
+// Print the comments that are on the given line, and that come before
+// the limit, at the end of the current line.
+func (p *printer) trailing(line int, limit ast.Pos) {
+	for len(p.comments) > 0 &&
+		p.comments[0].Position.Line == line &&
+		before(p.comments[0].Position, limit) {
+
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+
+		p.write(" ")
+		p.write(commentText(c))
+		p.lastLine = endLine(c)
+	}
+}
+
+// Print the comments that come before a position in the middle of an
+// expression.  A line comment breaks the line, and the rest of the
+// expression is continued on the next one.
+func (p *printer) inline(pos ast.Pos) {
+	for len(p.comments) > 0 && before(p.comments[0].Position, pos) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+
+		if !p.lineStart && !p.spaced() {
+			p.write(" ")
+		}
+		p.write(commentText(c))
+		if strings.HasPrefix(c.Text, "//") {
+			p.buf.WriteString("\n")
+			p.lineStart = true
+			p.continued = true
+		} else {
+			p.write(" ")
+		}
+	}
+}
+
+// Print a block comment that directly follows the end of an expression.
+func (p *printer) following(end ast.Pos) {
+	for len(p.comments) > 0 && !strings.HasPrefix(p.comments[0].Text, "//") {
+		c := p.comments[0]
+		if c.Position.Line != end.Line || before(c.Position, end) ||
+			strings.TrimSpace(p.src[p.offset(end)+1:p.offset(c.Position)]) != "" {
+			return
+		}
+		p.comments = p.comments[1:]
+
+		p.write(" ")
+		p.write(commentText(c))
+	}
+}
+
+// whether the last thing that was written ends with a space or an
+// opening bracket
+func (p *printer) spaced() bool {
+	b := p.buf.Bytes()
+	if len(b) == 0 {
+		return true
+	}
+	return strings.IndexByte(" ([", b[len(b)-1]) != -1
+}
+
+// whether there are any comments before a position
+func (p *printer) hasComments(pos ast.Pos) bool {
+	return len(p.comments) > 0 && before(p.comments[0].Position, pos)
+}
+
+// Trailing whitespace is removed from line comments.  Block comments are
+// printed exactly as they are.
+func commentText(c *ast.Token) string {
+	if strings.HasPrefix(c.Text, "//") {
+		return strings.TrimRight(c.Text, " \t\r")
+	}
+	return c.Text
+}
+
+func endLine(c *ast.Token) int {
+	return c.Position.Line + strings.Count(c.Text, "\n")
+}
+
+func before(a ast.Pos, b ast.Pos) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Col < b.Col)
+}
+
+//--------------------------------------------------------------
+// statements
+
+// Print a sequence of statements, each on a line of its own.  The
+// sequence ends at the given position.
+func (p *printer) nodes(nodes []ast.Node, end ast.Pos) {
+
+	for i, n := range nodes {
+		limit := end
+		if i < len(nodes)-1 {
+			limit = nodes[i+1].Begin()
+		}
+
+		p.flush(n.Begin())
+		p.blankLine(n.Begin().Line)
+		p.node(n)
+		p.trailing(n.End().Line, limit)
+		p.newline()
+		if n.End().Line > p.lastLine {
+			p.lastLine = n.End().Line
+		}
+	}
+}
+
+func (p *printer) node(node ast.Node) {
+
+	switch t := node.(type) {
+
+	case *ast.Block:
+		p.block(t)
+
+	case *ast.Const:
+		p.decls("const", t.Decls, t.IsPub)
+
+	case *ast.Let:
+		p.decls("let", t.Decls, t.IsPub)
+
+	case *ast.NamedFn:
+		if t.IsPub {
+			p.write("pub ")
+		}
+		p.write("fn ")
+		p.write(t.Ident.Symbol.Text)
+		p.formalParams(t.Func)
+		p.write(" ")
+		p.block(t.Func.Body)
+
+	case *ast.If:
+		p.ifStmt(t)
+
+	case *ast.While:
+		p.write("while ")
+		p.expr(t.Cond, precLowest)
+		p.write(" ")
+		p.block(t.Body)
+
+	case *ast.For:
+		p.write("for ")
+		if len(t.Idents) == 1 {
+			p.write(t.Idents[0].Symbol.Text)
+		} else {
+			p.params(t.Idents)
+		}
+		p.write(" in ")
+		p.expr(t.Iterable, precLowest)
+		p.write(" ")
+		p.block(t.Body)
+
+	case *ast.Switch:
+		p.switchStmt(t)
+
+	case *ast.Break:
+		p.write("break;")
+
+	case *ast.Continue:
+		p.write("continue;")
+
+	case *ast.Return:
+		p.write("return")
+		if t.Val != nil {
+			p.write(" ")
+			p.expr(t.Val, precLowest)
+		}
+		p.write(";")
+
+	case *ast.Throw:
+		p.write("throw ")
+		p.expr(t.Val, precLowest)
+		p.write(";")
+
+	case *ast.Try:
+		p.write("try ")
+		p.block(t.TryBlock)
+		if t.CatchToken != nil {
+			p.write(" catch ")
+			p.write(t.CatchIdent.Symbol.Text)
+			p.write(" ")
+			p.block(t.CatchBlock)
+		}
+		if t.FinallyToken != nil {
+			p.write(" finally ")
+			p.block(t.FinallyBlock)
+		}
+
+	case *ast.Spawn:
+		p.write("spawn ")
+		p.expr(t.Invocation, precLowest)
+		p.write(";")
+
+	case *ast.Import:
+		p.write("import ")
+		p.write(t.Name.Text)
+		if t.Ident.Symbol != t.Name {
+			p.write(" as ")
+			p.write(t.Ident.Symbol.Text)
+		}
+		p.write(";")
+
+	case ast.Expr:
+		p.expr(t, precLowest)
+		p.write(";")
+
+	default:
+		panic("unreachable")
+	}
+}
+
+// An empty block is printed as '{}', unless it contains comments.
+func (p *printer) block(blk *ast.Block) {
+
+	p.write("{")
+	if len(blk.Nodes) == 0 && !p.hasComments(blk.RBrace.Position) {
+		p.write("}")
+		return
+	}
+
+	end := blk.RBrace.Position
+	limit := end
+	if len(blk.Nodes) > 0 {
+		limit = blk.Nodes[0].Begin()
+	}
+	p.trailing(blk.LBrace.Position.Line, limit)
+	p.newline()
+
+	p.indent++
+	p.lastLine = 0
+	p.nodes(blk.Nodes, end)
+	p.flush(end)
+	p.indent--
+
+	p.write("}")
+	p.lastLine = end.Line
+}
+
+func (p *printer) decls(keyword string, decls []*ast.Decl, isPub bool) {
+
+	if isPub {
+		p.write("pub ")
+	}
+	p.write(keyword)
+	p.write(" ")
+	for i, d := range decls {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.write(d.Ident.Symbol.Text)
+		if d.Val != nil {
+			p.write(" = ")
+			p.expr(d.Val, precLowest)
+		}
+	}
+	p.write(";")
+}
+
+func (p *printer) ifStmt(ifn *ast.If) {
+
+	p.write("if ")
+	p.expr(ifn.Cond, precLowest)
+	p.write(" ")
+	p.block(ifn.Then)
+
+	if ifn.Else != nil {
+		p.write(" else ")
+		p.node(ifn.Else)
+	}
+}
+
+// The cases are lined up with the 'switch', and their bodies are indented.
+func (p *printer) switchStmt(sw *ast.Switch) {
+
+	p.write("switch ")
+	if sw.Item != nil {
+		p.expr(sw.Item, precLowest)
+		p.write(" ")
+	}
+	p.write("{")
+	p.trailing(sw.LBrace.Position.Line, sw.Cases[0].Begin())
+	p.newline()
+	p.lastLine = 0
+
+	end := sw.RBrace.Position
+	for i, c := range sw.Cases {
+		limit := end
+		if i < len(sw.Cases)-1 {
+			limit = sw.Cases[i+1].Begin()
+		} else if sw.Default != nil {
+			limit = sw.Default.Begin()
+		}
+
+		p.flush(c.Token.Position)
+		p.blankLine(c.Token.Position.Line)
+		p.write("case ")
+		p.exprs(c.Matches)
+		p.write(":")
+		p.caseBody(c.Matches[len(c.Matches)-1].End().Line, c.Body, limit)
+	}
+
+	if sw.Default != nil {
+		p.flush(sw.Default.Token.Position)
+		p.blankLine(sw.Default.Token.Position.Line)
+		p.write("default:")
+		p.caseBody(sw.Default.Token.Position.Line, sw.Default.Body, end)
+	}
+
+	p.indent++
+	p.flush(end)
+	p.indent--
+	p.write("}")
+	p.lastLine = end.Line
+}
+
+func (p *printer) caseBody(line int, body []ast.Node, end ast.Pos) {
+
+	p.trailing(line, body[0].Begin())
+	p.newline()
+
+	p.indent++
+	p.lastLine = 0
+	p.nodes(body, end)
+	p.indent--
+}
+
+//--------------------------------------------------------------
+// expressions
+
+// The precedence of each kind of expression, from lowest to highest.
+// Lambdas have the lowest precedence, since their bodies extend as
+// far to the right as possible.
+const (
+	precLowest = iota
+	precTernary
+	precOr
+	precAnd
+	precComparative
+	precAdditive
+	precMultiplicative
+	precUnary
+	precPostfix
+	precPrimary
+)
+
+func precedence(expr ast.Expr) int {
+
+	switch t := expr.(type) {
+
+	case *ast.Assignment:
+		return precLowest
+
+	case *ast.FnExpr:
+		if isLambda(t) {
+			return precLowest
+		}
+		return precPrimary
+
+	case *ast.TernaryExpr:
+		return precTernary
+
+	case *ast.BinaryExpr:
+		return binaryPrecedence(t.Op)
+
+	case *ast.UnaryExpr:
+		return precUnary
+
+	case *ast.PostfixExpr:
+		return precPostfix
+
+	default:
+		return precPrimary
+	}
+}
+
+func binaryPrecedence(op *ast.Token) int {
+
+	switch op.Kind {
+
+	case ast.DBL_PIPE:
+		return precOr
+
+	case ast.DBL_AMP:
+		return precAnd
+
+	case ast.DBL_EQ, ast.NOT_EQ, ast.GT, ast.GT_EQ, ast.LT, ast.LT_EQ, ast.CMP, ast.HAS:
+		return precComparative
+
+	case ast.PLUS, ast.MINUS, ast.PIPE, ast.CARET:
+		return precAdditive
+
+	case ast.STAR, ast.SLASH, ast.PERCENT, ast.AMP, ast.DBL_LT, ast.DBL_GT:
+		return precMultiplicative
+
+	default:
+		panic("unreachable")
+	}
+}
+
+// Print an expression, in parentheses if its precedence
+// is lower than the given precedence.
+func (p *printer) expr(expr ast.Expr, prec int) {
+	p.inline(expr.Begin())
+	if precedence(expr) < prec {
+		p.write("(")
+		p.doExpr(expr)
+		p.write(")")
+	} else {
+		p.doExpr(expr)
+	}
+	p.following(expr.End())
+}
+
+func (p *printer) doExpr(expr ast.Expr) {
+
+	switch t := expr.(type) {
+
+	case *ast.Assignment:
+		p.expr(t.Assignee, precPrimary)
+		if t.Eq.Kind == ast.EQ {
+			p.write(" = ")
+			p.expr(t.Val, precLowest)
+		} else {
+			// an assignment operation, like 'a += b', is parsed as 'a = a + b'
+			p.write(" " + t.Eq.Text + " ")
+			p.expr(t.Val.(*ast.BinaryExpr).Rhs, precLowest)
+		}
+
+	case *ast.TernaryExpr:
+		p.expr(t.Cond, precOr)
+		p.write(" ? ")
+		p.expr(t.Then, precLowest)
+		p.write(" : ")
+		p.expr(t.Else, precTernary)
+
+	case *ast.BinaryExpr:
+		// binary operators are left-associative
+		prec := binaryPrecedence(t.Op)
+		p.expr(t.Lhs, prec)
+		p.write(" " + t.Op.Text + " ")
+		p.expr(t.Rhs, prec+1)
+
+	case *ast.UnaryExpr:
+		p.write(t.Op.Text)
+		// '- -a' must not be printed as '--a'
+		if u, ok := t.Operand.(*ast.UnaryExpr); ok && u.Op.Kind == ast.MINUS && t.Op.Kind == ast.MINUS {
+			p.write("(")
+			p.doExpr(u)
+			p.write(")")
+		} else {
+			p.expr(t.Operand, precUnary)
+		}
+
+	case *ast.PostfixExpr:
+		p.expr(t.Assignee, precPrimary)
+		p.write(t.Op.Text)
+
+	case *ast.BasicExpr:
+		p.basic(t.Token)
+
+	case *ast.IdentExpr:
+		p.write(t.Symbol.Text)
+
+	case *ast.FnExpr:
+		p.fn(t)
+
+	case *ast.InvokeExpr:
+		p.operand(t.Operand)
+		p.write("(")
+		p.exprs(t.Params)
+		for i, n := range t.Names {
+			if i > 0 || len(t.Params) > 0 {
+				p.write(", ")
+			}
+			p.write(n.Text)
+			p.write(": ")
+			p.expr(t.NamedParams[i], precLowest)
+		}
+		p.write(")")
+
+	case *ast.ListExpr:
+		p.entries("[", "]", t.LBracket, t.RBracket, len(t.Elems),
+			func(i int) (ast.Pos, ast.Pos) { return t.Elems[i].Begin(), t.Elems[i].End() },
+			func(i int) { p.expr(t.Elems[i], precLowest) })
+
+	case *ast.SetExpr:
+		p.write("set ")
+		p.entries("{ ", " }", t.LBrace, t.RBrace, len(t.Elems),
+			func(i int) (ast.Pos, ast.Pos) { return t.Elems[i].Begin(), t.Elems[i].End() },
+			func(i int) { p.expr(t.Elems[i], precLowest) })
+
+	case *ast.TupleExpr:
+		p.write("(")
+		p.exprs(t.Elems)
+		p.write(")")
+
+	case *ast.StructExpr:
+		p.write("struct ")
+		p.entries("{ ", " }", t.LBrace, t.RBrace, len(t.Keys),
+			func(i int) (ast.Pos, ast.Pos) { return t.Keys[i].Position, t.Values[i].End() },
+			func(i int) {
+				p.write(t.Keys[i].Text)
+				p.write(": ")
+				p.expr(t.Values[i], precLowest)
+			})
+
+	case *ast.ThisExpr:
+		p.write("this")
+
+	case *ast.FieldExpr:
+		p.operand(t.Operand)
+		p.write(".")
+		p.write(t.Key.Text)
+
+	case *ast.DictExpr:
+		p.write("dict ")
+		p.entries("{ ", " }", t.LBrace, t.RBrace, len(t.Entries),
+			func(i int) (ast.Pos, ast.Pos) { return t.Entries[i].Begin(), t.Entries[i].End() },
+			func(i int) {
+				p.expr(t.Entries[i].Key, precLowest)
+				p.write(": ")
+				p.expr(t.Entries[i].Value, precLowest)
+			})
+
+	case *ast.IndexExpr:
+		p.operand(t.Operand)
+		p.write("[")
+		p.expr(t.Index, precLowest)
+		p.write("]")
+
+	case *ast.SliceExpr:
+		p.operand(t.Operand)
+		p.write("[")
+		p.expr(t.From, precLowest)
+		p.write(":")
+		p.expr(t.To, precLowest)
+		p.write("]")
+
+	case *ast.SliceFromExpr:
+		p.operand(t.Operand)
+		p.write("[")
+		p.expr(t.From, precLowest)
+		p.write(":]")
+
+	case *ast.SliceToExpr:
+		p.operand(t.Operand)
+		p.write("[:")
+		p.expr(t.To, precLowest)
+		p.write("]")
+
+	default:
+		panic("unreachable")
+	}
+}
+
+// Print the operand of an invocation, field, index or slice.  An integer
+// needs parentheses, or else the '.' of a field would make it a float.
+func (p *printer) operand(expr ast.Expr) {
+	if b, ok := expr.(*ast.BasicExpr); ok && b.Token.Kind == ast.INT {
+		p.write("(")
+		p.basic(b.Token)
+		p.write(")")
+	} else {
+		p.expr(expr, precPrimary)
+	}
+}
+
+func (p *printer) exprs(exprs []ast.Expr) {
+	for i, e := range exprs {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expr(e, precLowest)
+	}
+}
+
+func (p *printer) params(params []*ast.IdentExpr) {
+	p.write("(")
+	for i, ident := range params {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.write(ident.Symbol.Text)
+	}
+	p.write(")")
+}
+
+// The params of a function, along with their default values.
+func (p *printer) formalParams(fn *ast.FnExpr) {
+	p.write("(")
+	for i, ident := range fn.FormalParams {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.write(ident.Symbol.Text)
+		if d := fn.Default(i); d != nil {
+			p.write(" = ")
+			p.expr(d, precLowest)
+		}
+	}
+	if fn.Variadic {
+		p.write("...")
+	}
+	p.write(")")
+}
+
+// A lambda has a body that is a single expression, without braces.
+func isLambda(fn *ast.FnExpr) bool {
+	return fn.Body.LBrace == nil
+}
+
+// Lambdas are printed in the form that they were written in.
+func (p *printer) fn(fn *ast.FnExpr) {
+
+	if !isLambda(fn) {
+		p.write("fn")
+		p.formalParams(fn)
+		p.write(" ")
+		p.block(fn.Body)
+		return
+	}
+
+	switch fn.Token.Kind {
+	case ast.IDENT:
+		p.write(fn.FormalParams[0].Symbol.Text)
+	case ast.DBL_PIPE:
+		p.write("||")
+	default:
+		p.write("|")
+		for i, ident := range fn.FormalParams {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.write(ident.Symbol.Text)
+		}
+		p.write("|")
+	}
+	p.write(" => ")
+	p.expr(fn.Body.Nodes[0].(ast.Expr), precLowest)
+}
+
+// Print the entries of a list, set, struct or dict.  If there is a line
+// break after the opening bracket, then each entry is printed on a line
+// of its own, along with any comments.  The brackets of an empty
+// collection are printed without anything between them.
+func (p *printer) entries(
+	open string, close string,
+	lbracket *ast.Token, rbracket *ast.Token, n int,
+	span func(int) (ast.Pos, ast.Pos), print func(int)) {
+
+	if n == 0 && !p.hasComments(rbracket.Position) {
+		p.write(strings.TrimSpace(open))
+		p.write(strings.TrimSpace(close))
+		return
+	}
+
+	if n > 0 && p.sameLine(lbracket, span) {
+		p.write(open)
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				p.write(", ")
+			}
+			print(i)
+		}
+		p.write(close)
+		return
+	}
+
+	end := rbracket.Position
+	limit := end
+	if n > 0 {
+		limit, _ = span(0)
+	}
+	p.write(strings.TrimSpace(open))
+	p.trailing(lbracket.Position.Line, limit)
+	p.newline()
+	p.indent++
+	p.lastLine = 0
+
+	for i := 0; i < n; i++ {
+		limit := end
+		if i < n-1 {
+			limit, _ = span(i + 1)
+		}
+
+		begin, last := span(i)
+		p.flush(begin)
+		p.blankLine(begin.Line)
+		print(i)
+		if i < n-1 {
+			p.write(",")
+		}
+		p.trailing(last.Line, limit)
+		p.newline()
+		p.lastLine = last.Line
+	}
+
+	p.flush(end)
+	p.indent--
+	p.write(strings.TrimSpace(close))
+	p.lastLine = end.Line
+}
+
+// whether the first entry begins on the same line as the opening bracket
+func (p *printer) sameLine(lbracket *ast.Token, span func(int) (ast.Pos, ast.Pos)) bool {
+	first, _ := span(0)
+	return first.Line == lbracket.Position.Line
+}
+
+// Strings are printed with the delimiter they were written with.
+func (p *printer) basic(tok *ast.Token) {
+
+	if tok.Kind != ast.STR {
+		p.write(tok.Text)
+		return
+	}
+
+	delim := p.src[p.offset(tok.Position)]
+	var buf bytes.Buffer
+	buf.WriteByte(delim)
+	for _, r := range tok.Text {
+		switch r {
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case rune(delim):
+			buf.WriteByte('\\')
+			buf.WriteByte(delim)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte(delim)
+	p.write(buf.String())
+}
+
+//--------------------------------------------------------------
+// positions
+
+// the offset of the beginning of each line
+func lineOffsets(src string) []int {
+	offsets := []int{0}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
+
+func (p *printer) offset(pos ast.Pos) int {
+	return p.lines[pos.Line-1] + pos.Col - 1
+}
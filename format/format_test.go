@@ -0,0 +1,378 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"golem/parser"
+	"golem/scanner"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func ok(t *testing.T, src string, expect string) {
+	result, err := Source(src)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if result != expect {
+		t.Error("\n" + result + " != \n" + expect)
+	}
+}
+
+func parse(src string) (string, error) {
+	mod, err := parser.NewParser(scanner.NewScanner(src)).ParseModule()
+	if err != nil {
+		return "", err
+	}
+	return mod.String(), nil
+}
+
+// Formatting must not change the meaning of a module, and formatting
+// a module that has already been formatted must not change it.
+func roundTrip(t *testing.T, name string, src string) {
+
+	before, err := parse(src)
+	if err != nil {
+		t.Fatal(name, err)
+	}
+
+	result, err := Source(src)
+	if err != nil {
+		t.Error(name, err)
+		return
+	}
+	after, err := parse(result)
+	if err != nil {
+		t.Error(name, err, "\n"+result)
+		return
+	}
+	if before != after {
+		t.Error(name, "\n"+before+" != \n"+after)
+	}
+
+	again, _ := Source(result)
+	if again != result {
+		t.Error(name, "\n"+again+" != \n"+result)
+	}
+}
+
+func TestStatements(t *testing.T) {
+
+	ok(t, "let a=1,b;const c=2\n;pub let d = 3; pub const e = 4;",
+		"let a = 1, b;\nconst c = 2;\npub let d = 3;\npub const e = 4;\n")
+
+	ok(t, "pub fn a(x,y){return x+y;} fn b(){} fn c() { return; }",
+		`pub fn a(x, y) {
+    return x + y;
+}
+fn b() {}
+fn c() {
+    return;
+}
+`)
+
+	ok(t, "if a {b;} else if c {d;} else {e;}",
+		`if a {
+    b;
+} else if c {
+    d;
+} else {
+    e;
+}
+`)
+
+	ok(t, "while true { break; continue; } for x in a {} for (k, v) in b {}",
+		`while true {
+    break;
+    continue;
+}
+for x in a {}
+for (k, v) in b {}
+`)
+
+	ok(t, "switch a { case 1, 2: b; c; case 3: d; default: e; } switch { case x: y; }",
+		`switch a {
+case 1, 2:
+    b;
+    c;
+case 3:
+    d;
+default:
+    e;
+}
+switch {
+case x:
+    y;
+}
+`)
+
+	ok(t, "try { throw 'a'; } catch e { b; } finally { c; } try {} finally {}",
+		`try {
+    throw 'a';
+} catch e {
+    b;
+} finally {
+    c;
+}
+try {} finally {}
+`)
+
+	ok(t, "import sys;import foo as bar; spawn f(1, 2);",
+		"import sys;\nimport foo as bar;\nspawn f(1, 2);\n")
+
+	// blank lines are kept, except at the start and end of a block,
+	// and a run of them is collapsed into one
+	ok(t, "\nlet a = 1;\n\n\nlet b = 2;  \n  \n\n",
+		"\nlet a = 1;\n\nlet b = 2;\n\n")
+	ok(t, "let a = [\n    1,\n\n\n\n    2\n];\n",
+		"let a = [\n    1,\n\n    2\n];\n")
+
+	ok(t, "fn f() {\n\n    a;\n\n    b;\n\n}",
+		"fn f() {\n    a;\n\n    b;\n}\n")
+}
+
+func TestExpressions(t *testing.T) {
+
+	ok(t, "a = b = 1 + 2 * 3; a += 1; a <<= 2; a++; b--;",
+		"a = b = 1 + 2 * 3;\na += 1;\na <<= 2;\na++;\nb--;\n")
+
+	ok(t, "(1 + 2) * 3; 1 - (2 - 3); (1 - 2) - 3; -(-a); - (!b); ~(a|b);",
+		"(1 + 2) * 3;\n1 - (2 - 3);\n1 - 2 - 3;\n-(-a);\n-!b;\n~(a | b);\n")
+
+	ok(t, "a || b && c; (a || b) && c; a == (b < c); a has b;",
+		"a || b && c;\n(a || b) && c;\na == (b < c);\na has b;\n")
+
+	ok(t, "a ? b : c ? d : e; (a ? b : c) ? d : e; a ? b = 1 : c;",
+		"a ? b : c ? d : e;\n(a ? b : c) ? d : e;\na ? b = 1 : c;\n")
+
+	ok(t, "x=>x+1; |a,b| => a; || => 3; f(x => x); (x => x)(1); fn(a){}(2);",
+		"x => x + 1;\n|a, b| => a;\n|| => 3;\nf(x => x);\n(x => x)(1);\nfn(a) {}(2);\n")
+
+	ok(t, "fn f(a,b=a*2,rest...) {} let g = fn(a=[1,2]) {};",
+		"fn f(a, b = a * 2, rest...) {}\nlet g = fn(a = [1, 2]) {};\n")
+
+	ok(t, "f(1,b:2,c:x?y:z); g(a:1); spawn h(x, y: 2);",
+		"f(1, b: 2, c: x ? y : z);\ng(a: 1);\nspawn h(x, y: 2);\n")
+
+	ok(t, "[]; [1,2]; set{}; set {1}; dict{}; dict{'a':1,b:2}; struct{}; struct{a:1,b:this}; (1,2);",
+		"[];\n[1, 2];\nset {};\nset { 1 };\ndict {};\ndict { 'a': 1, b: 2 };\nstruct {};\nstruct { a: 1, b: this };\n(1, 2);\n")
+
+	ok(t, "a.b.c(); a[1]; a[1:2]; a[1:]; a[:2]; (1).b; (a + b).c; -a[0]; 1.5.a;",
+		"a.b.c();\na[1];\na[1:2];\na[1:];\na[:2];\n(1).b;\n(a + b).c;\n-a[0];\n1.5.a;\n")
+
+	ok(t, `'a\'b"'; "a'b\"\n\t\\"; 0x1F; 1e3;`,
+		`'a\'b"';`+"\n"+`"a'b\"\n\t\\";`+"\n0x1F;\n1e3;\n")
+}
+
+func TestMultiline(t *testing.T) {
+
+	ok(t, `let a = [
+1, 2,
+    3];
+let b = struct {
+  x: 1, // one
+
+  y: fn() {
+return 2; }
+};
+let c = dict { 1: 2,
+   3: 4 };
+`,
+		`let a = [
+    1,
+    2,
+    3
+];
+let b = struct {
+    x: 1, // one
+
+    y: fn() {
+        return 2;
+    }
+};
+let c = dict { 1: 2, 3: 4 };
+`)
+}
+
+func TestComments(t *testing.T) {
+
+	ok(t, `
+
+// leading
+
+
+/* block
+   comment */
+let a = 1;  // trailing
+fn f() { // open
+    // inside
+
+
+    a; /* after */ b;
+
+    // end
+}
+let b = [ // open
+    1, // one
+    // two
+    2
+    // end
+];
+let c = set {
+    // empty
+};
+if a {
+    // empty
+} else {}
+switch a {
+// first
+case 1:
+    // body
+    b;
+
+// second
+case 2: c; // c
+    // end
+}
+// last
+`,
+		`
+// leading
+
+/* block
+   comment */
+let a = 1; // trailing
+fn f() { // open
+    // inside
+
+    a; /* after */
+    b;
+
+    // end
+}
+let b = [ // open
+    1, // one
+    // two
+    2
+    // end
+];
+let c = set {
+    // empty
+};
+if a {
+    // empty
+} else {}
+switch a {
+// first
+case 1:
+    // body
+    b;
+
+// second
+case 2:
+    c; // c
+    // end
+}
+// last
+`)
+}
+
+func TestInlineComments(t *testing.T) {
+
+	// a comment inside an expression stays where it was
+	ok(t, "f(a, /* b */ b, c /* c */);\nlet x = 1 + /* one */ 2;\n",
+		"f(a, /* b */ b, c /* c */);\nlet x = 1 + /* one */ 2;\n")
+	ok(t, "f(/* a */a,b);", "f(/* a */ a, b);\n")
+
+	// a line comment breaks the expression onto the next line
+	ok(t, "f(a, // about b\n  b);\ng();",
+		"f(a, // about b\n    b);\ng();\n")
+	ok(t, "fn f() {\n    return x && // y\n    y;\n}",
+		"fn f() {\n    return x && // y\n        y;\n}\n")
+
+	roundTrip(t, "inline",
+		"f(a, /* b */ b, // c\n c);\nlet d = dict { 1: /* one */ 2 };\n")
+}
+
+func TestErrors(t *testing.T) {
+
+	_, err := Source("let a = ;")
+	if err == nil || err.Error() != "Unexpected Token ';' at (1, 9)" {
+		t.Error(err)
+	}
+}
+
+func TestExamples(t *testing.T) {
+
+	files, err := filepath.Glob("../examples/*.glm")
+	if err != nil || len(files) == 0 {
+		t.Fatal(files, err)
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roundTrip(t, f, string(b))
+	}
+}
+
+// Every string literal in the test suites that is a
+// valid module is formatted.
+func TestSuites(t *testing.T) {
+
+	files, err := filepath.Glob("../*/*_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	more, err := filepath.Glob("../*_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, more...)
+
+	fset := gotoken.NewFileSet()
+	count := 0
+	for _, f := range files {
+		file, err := goparser.ParseFile(fset, f, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		goast.Inspect(file, func(n goast.Node) bool {
+			lit, ok := n.(*goast.BasicLit)
+			if !ok || lit.Kind != gotoken.STRING {
+				return true
+			}
+			src, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := parse(src); err == nil {
+				roundTrip(t, fset.Position(lit.Pos()).String(), src)
+				count++
+			}
+			return true
+		})
+	}
+	if count < 100 {
+		t.Error("only", count, "modules were found")
+	}
+}
@@ -12,9 +12,15 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package main
+// Package golem is the API for embedding the Golem language in a
+// Go program.  A Runtime chains together the scanner, parser, analyzer,
+// compiler and interpreter, and reports every failure as an error
+// rather than panicking.
+package golem
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"golem/analyzer"
 	"golem/compiler"
@@ -22,78 +28,613 @@ import (
 	"golem/interpreter"
 	"golem/parser"
 	"golem/scanner"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 )
 
-func main() {
+//--------------------------------------------------------------
+// Runtime
 
-	if len(os.Args) < 2 {
-		panic("No source file was specified")
+// Runtime compiles and runs Golem modules.  Modules can be compiled, run
+// and imported from several goroutines at once, but builtins should be
+// registered, and the exported fields set, before the Runtime is shared.
+type Runtime struct {
+	entries  []*g.BuiltinEntry
+	builtins g.BuiltinManager
+
+	// mu guards the modules, sources, imports and importing.
+	mu      sync.Mutex
+	modules map[*g.BytecodeModule]bool
+
+	// the source code of the modules that have been compiled, by name
+	sources map[string]string
+
+	// The modules that have been imported, and, for each interpreter that
+	// is initializing an imported module, the names of the modules that
+	// are being imported to get there.  Modules are imported one at a time,
+	// while holding importMu.
+	imports   map[string]*g.BytecodeModule
+	importing map[*interpreter.Interpreter][]string
+	importMu  sync.Mutex
+
+	// MaxOpcodes limits the number of opcodes that each call to Run or Call
+	// may execute, including the opcodes executed by any goroutines that
+	// are spawned.  Zero means that there is no limit.
+	MaxOpcodes int64
+
+	// Path is the list of directories that are searched, in order, for
+	// the modules named by 'import' statements.  If it is empty, then
+	// nothing can be imported.
+	Path []string
+
+	// Debugger, if it is not nil, is attached to the interpreter of each
+	// call to Run or Call, and of each module that is imported.
+	Debugger interpreter.Debugger
+
+	// Tracer, if it is not nil, is attached to the same interpreters
+	// as the Debugger.
+	Tracer interpreter.Tracer
+}
+
+// NewRuntime creates a new Runtime.
+func NewRuntime() *Runtime {
+	entries := append([]*g.BuiltinEntry{}, g.StandardBuiltins...)
+	return &Runtime{
+		entries,
+		g.NewBuiltinManager(entries),
+		sync.Mutex{},
+		make(map[*g.BytecodeModule]bool),
+		make(map[string]string),
+		make(map[string]*g.BytecodeModule),
+		make(map[*interpreter.Interpreter][]string),
+		sync.Mutex{},
+		0,
+		nil,
+		nil,
+		nil}
+}
+
+// RegisterBuiltin makes a value visible, under the given name, to every
+// module that is compiled afterwards.  Local variables with the same name
+// shadow the builtin.
+func (r *Runtime) RegisterBuiltin(name string, value g.Value) error {
+
+	if r.builtins.Contains(name) {
+		return fmt.Errorf("builtin '%s' is already defined", name)
+	}
+
+	r.entries = append(r.entries, &g.BuiltinEntry{name, value})
+	r.builtins = g.NewBuiltinManager(r.entries)
+	return nil
+}
+
+// RegisterModule registers a builtin Struct whose readonly fields are
+// the given entries, so that scripts can refer to them as 'name.field'.
+func (r *Runtime) RegisterModule(name string, entries []*g.BuiltinEntry) error {
+
+	fields := make([]*g.StructEntry, len(entries))
+	for i, e := range entries {
+		fields[i] = &g.StructEntry{e.Name, true, false, e.Value}
 	}
 
-	// read source
-	filename := os.Args[1]
-	buf, err := ioutil.ReadFile(filename)
+	stc, err := g.NewStruct(fields)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	source := string(buf)
+	return r.RegisterBuiltin(name, stc)
+}
+
+// Compile parses, analyzes and compiles the given source code.
+// The name is used to identify the module in any errors that are reported.
+func (r *Runtime) Compile(name string, source string) (*g.BytecodeModule, error) {
+	mod, _, err := r.compile(name, source, nil)
+	return mod, err
+}
+
+// Compile a module.  If prev is not nil, then the module continues on
+// from the module that prev analyzed.
+func (r *Runtime) compile(
+	name string, source string,
+	prev analyzer.Analyzer) (mod *g.BytecodeModule, anl analyzer.Analyzer, err error) {
+
+	// An internal error in the analyzer or compiler should not
+	// bring down the host program.
+	defer func() {
+		if p := recover(); p != nil {
+			mod, anl, err = nil, nil, fmt.Errorf("%s: internal error: %v", name, p)
+		}
+	}()
+
+	r.mu.Lock()
+	r.sources[name] = source
+	r.mu.Unlock()
 
 	// parse
-	scanner := scanner.NewScanner(source)
-	parser := parser.NewParser(scanner)
-	exprMod, err := parser.ParseModule()
-	if err != nil {
-		panic(err.Error())
+	scn := scanner.NewScanner(source)
+	prs := parser.NewParser(scn)
+	exprMod, perr := prs.ParseModule()
+	if perr != nil {
+		errors := []error{}
+		for _, e := range perr.(parser.ErrorList) {
+			errors = append(errors, e)
+		}
+		return nil, nil, &SyntaxError{name, errors}
 	}
 
 	// analyze
-	anl := analyzer.NewAnalyzer(exprMod)
+	if prev == nil {
+		anl = analyzer.NewAnalyzer(exprMod, r.builtins)
+	} else {
+		anl = analyzer.NewSessionAnalyzer(exprMod, r.builtins, prev)
+	}
 	errors := anl.Analyze()
 	if len(errors) > 0 {
-		panic(fmt.Sprintf("%v", errors))
+		return nil, nil, &AnalysisError{name, errors}
 	}
 
 	// compile
-	cmp := compiler.NewCompiler(anl)
-	mod := cmp.Compile()
+	mod = compiler.NewCompiler(anl).Compile()
+	mod.Name = name
+	r.addModule(mod)
+	return mod, anl, nil
+}
+
+// Source returns the source code of a module that was compiled
+// with the given name, so that diagnostics can be rendered with it.
+func (r *Runtime) Source(name string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	src, ok := r.sources[name]
+	return src, ok
+}
+
+// Forget releases the modules that were compiled or loaded with the given
+// name, and their source code, so that a program that keeps compiling new
+// modules does not hold on to all of them.  The functions that those
+// modules define can no longer be passed to Call.  A module that was
+// imported stays imported.
+func (r *Runtime) Forget(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sources, name)
+	for mod := range r.modules {
+		if mod.Name == name {
+			delete(r.modules, mod)
+		}
+	}
+}
+
+// Load reads a module that was compiled ahead of time, and written
+// with core.WriteModule.  The builtins that the module uses are looked up
+// by name, so they must be registered with this Runtime, although not
+// necessarily in the same order as when the module was compiled.
+// The module's bytecode is verified before it is returned.
+func (r *Runtime) Load(rd io.Reader) (mod *g.BytecodeModule, err error) {
+
+	// a module that is malformed in a way that we did not foresee
+	// should not bring down the host program
+	defer func() {
+		if p := recover(); p != nil {
+			mod, err = nil, fmt.Errorf("invalid module: internal error: %v", p)
+		}
+	}()
+
+	mod, err = g.ReadModule(rd)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.VerifyModule(mod, len(mod.Builtins)); err != nil {
+		return nil, err
+	}
+	if err := g.LinkBuiltins(mod, r.builtins); err != nil {
+		return nil, err
+	}
+	r.addModule(mod)
+	return mod, nil
+}
+
+// Import implements interpreter.Importer.  The first time that a module
+// is imported, it is found on the Path, compiled (or loaded, if it is a
+// '.glmc' file) and initialized.  After that, the same contents are returned.
+// A module is initialized under the limits of the interpreter that imports
+// it, so its opcodes count against the same budget.
+func (r *Runtime) Import(from *interpreter.Interpreter, name string) (g.Struct, g.Error) {
+
+	// An import made while initializing an imported module is part of
+	// that import, which already holds importMu.
+	r.mu.Lock()
+	chain, nested := r.importing[from]
+	r.mu.Unlock()
+	if !nested {
+		r.importMu.Lock()
+		defer r.importMu.Unlock()
+	}
+
+	r.mu.Lock()
+	mod, ok := r.imports[name]
+	r.mu.Unlock()
+	if ok {
+		return mod.Contents, nil
+	}
+
+	// a module cannot import itself, directly or indirectly
+	for j, n := range chain {
+		if n == name {
+			cycle := append(append([]string{}, chain[j:]...), name)
+			return nil, g.ImportFailedError(
+				fmt.Sprintf("Import cycle: %s", strings.Join(cycle, " -> ")))
+		}
+	}
+
+	mod, err := r.findModule(name)
+	if err != nil {
+		return nil, g.ImportFailedError(err.Error())
+	}
+
+	intp := r.newInterpreter(mod)
+	r.mu.Lock()
+	r.importing[intp] = append(append([]string{}, chain...), name)
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.importing, intp)
+		r.mu.Unlock()
+	}()
+
+	if _, errTrace := intp.InitShared(from); errTrace != nil {
+		return nil, errTrace.Error
+	}
+
+	r.mu.Lock()
+	r.imports[name] = mod
+	r.mu.Unlock()
+	return mod.Contents, nil
+}
 
-	// interpret
-	intp := interpreter.NewInterpreter(mod)
-	_, errTrace := intp.Init()
+// find an imported module on the path, and compile or load it
+func (r *Runtime) findModule(name string) (*g.BytecodeModule, error) {
+
+	for _, dir := range r.Path {
+		filename := filepath.Join(dir, name+".glm")
+		buf, err := ioutil.ReadFile(filename)
+		if err == nil {
+			return r.Compile(filename, string(buf))
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		f, err := os.Open(filename + "c")
+		if err == nil {
+			defer f.Close()
+			return r.Load(f)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("Module '%s' not found", name)
+}
+
+// Run initializes a module by executing its top-level statements.
+// The result is the value of the last expression that was evaluated.
+func (r *Runtime) Run(mod *g.BytecodeModule) (g.Value, error) {
+	return r.RunContext(context.Background(), mod)
+}
+
+// RunContext is like Run, except that execution is aborted with a
+// Cancelled error when the context is done.
+func (r *Runtime) RunContext(
+	ctx context.Context, mod *g.BytecodeModule) (result g.Value, err error) {
+
+	defer recoverInternal(&result, &err)
+
+	intp := r.newInterpreter(mod)
+	result, errTrace := intp.InitContext(ctx, r.MaxOpcodes)
 	if errTrace != nil {
-		fmt.Printf("%v\n", errTrace.Error)
-		fmt.Printf("%v\n", errTrace.StackTrace)
-	}
-
-	// run main
-	mainVal, mainErr := mod.Contents.GetField(g.MakeStr("main"))
-	fmt.Printf("%v, %v\n", mainVal, mainErr)
-	if mainErr == nil {
-		mainFn, ok := mainVal.(g.BytecodeFunc)
-		if !ok {
-			panic("'main' is not a function")
-		}
-
-		params := []g.Value{}
-		arity := mainFn.Template().Arity
-		if arity == 1 {
-			osArgs := os.Args[2:]
-			args := make([]g.Value, len(osArgs), len(osArgs))
-			for i, a := range osArgs {
-				args[i] = g.MakeStr(a)
-			}
-			params = append(params, g.NewList(args))
-		} else if arity > 1 {
-			panic("'main' has too many arguments")
+		return nil, newRuntimeError(errTrace)
+	}
+	return result, nil
+}
+
+// Call invokes a function with the given arguments.  If the function
+// was defined in Golem source code, then the module that defines it must
+// have been compiled by this Runtime.
+func (r *Runtime) Call(fn g.Value, args ...g.Value) (g.Value, error) {
+	return r.CallContext(context.Background(), fn, args...)
+}
+
+// CallContext is like Call, except that execution is aborted with a
+// Cancelled error when the context is done.
+func (r *Runtime) CallContext(
+	ctx context.Context, fn g.Value, args ...g.Value) (result g.Value, err error) {
+
+	defer recoverInternal(&result, &err)
+
+	switch t := fn.(type) {
+
+	case g.BytecodeFunc:
+		mod := t.Module()
+		if !r.ownsModule(mod) {
+			return nil, fmt.Errorf("function was not compiled by this runtime")
 		}
 
-		intp = interpreter.NewInterpreter(mod)
-		_, errTrace := intp.RunBytecode(mainFn, params)
+		if err := t.Template().CheckArity(len(args)); err != nil {
+			return nil, &RuntimeError{err, nil, nil}
+		}
+
+		intp := r.newInterpreter(mod)
+		result, errTrace := intp.RunBytecodeContext(ctx, r.MaxOpcodes, t, args)
 		if errTrace != nil {
-			fmt.Printf("%v\n", errTrace.Error)
-			fmt.Printf("%v\n", errTrace.StackTrace)
+			return nil, newRuntimeError(errTrace)
 		}
+		return result, nil
+
+	case g.NativeFunc:
+		result, err := t.Invoke(&contextEval{r, ctx}, args)
+		if err != nil {
+			return nil, &RuntimeError{err, nil, nil}
+		}
+		return result, nil
+
+	default:
+		return nil, &RuntimeError{g.TypeMismatchError("Expected 'Func'"), nil, nil}
 	}
 }
+
+// RunMain runs a program: it initializes a module, and then calls the
+// module's 'main' function, if there is one.  If main has a parameter,
+// then it is passed the given arguments as a List of Strs.  The main
+// function must be public, just like anything else that a module exposes
+// to the outside.
+func (r *Runtime) RunMain(mod *g.BytecodeModule, args []string) error {
+	return r.RunMainContext(context.Background(), mod, args)
+}
+
+// RunMainContext is like RunMain, except that execution is aborted with
+// a Cancelled error when the context is done.
+func (r *Runtime) RunMainContext(
+	ctx context.Context, mod *g.BytecodeModule, args []string) (err error) {
+
+	defer recoverInternal(nil, &err)
+
+	if _, err := r.RunContext(ctx, mod); err != nil {
+		return err
+	}
+
+	val, err := mod.Contents.GetField(g.MakeStr("main"))
+	if err != nil {
+		if len(mod.Templates) > 0 {
+			for _, name := range mod.Templates[0].LocalNames {
+				if name == "main" {
+					return fmt.Errorf("'main' must be public")
+				}
+			}
+		}
+		return nil
+	}
+	fn, ok := val.(g.BytecodeFunc)
+	if !ok {
+		return fmt.Errorf("'main' is not a function")
+	}
+
+	params := []g.Value{}
+	switch tpl := fn.Template(); {
+	case tpl.MaxArity == 0:
+	case tpl.MinArity <= 1:
+		strs := make([]g.Value, len(args))
+		for i, a := range args {
+			strs[i] = g.MakeStr(a)
+		}
+		params = append(params, g.NewList(strs))
+	default:
+		return fmt.Errorf("'main' has too many arguments")
+	}
+
+	_, e := r.CallContext(ctx, fn, params...)
+	return e
+}
+
+// Eval implements core.Eval, so that native functions that are invoked
+// via Call can call back into Golem code.
+func (r *Runtime) Eval(fn g.Func, params []g.Value) (g.Value, g.Error) {
+	return (&contextEval{r, context.Background()}).Eval(fn, params)
+}
+
+// contextEval is the core.Eval that is passed to native functions
+// invoked via CallContext.
+type contextEval struct {
+	r   *Runtime
+	ctx context.Context
+}
+
+func (ce *contextEval) Eval(fn g.Func, params []g.Value) (g.Value, g.Error) {
+
+	result, err := ce.r.CallContext(ce.ctx, fn, params...)
+	if err != nil {
+		if rte, ok := err.(*RuntimeError); ok {
+			return nil, rte.Err
+		}
+		return nil, g.InvalidArgumentError(err.Error())
+	}
+	return result, nil
+}
+
+func (ce *contextEval) Context() context.Context {
+	return ce.ctx
+}
+
+func (r *Runtime) newInterpreter(mod *g.BytecodeModule) *interpreter.Interpreter {
+	intp := interpreter.NewInterpreter(mod, r.builtins, r)
+	if r.Debugger != nil {
+		intp.SetDebugger(r.Debugger)
+	}
+	if r.Tracer != nil {
+		intp.SetTracer(r.Tracer)
+	}
+	return intp
+}
+
+// An internal error in the interpreter, such as a failed assertion about
+// the values on the stack, should not bring down the host program either.
+// It is reported as a RuntimeError without a stack trace.
+func recoverInternal(result *g.Value, err *error) {
+	if p := recover(); p != nil {
+		if result != nil {
+			*result = nil
+		}
+		*err = &RuntimeError{g.InternalError(fmt.Sprint(p)), nil, nil}
+	}
+}
+
+func (r *Runtime) addModule(mod *g.BytecodeModule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.modules[mod] = true
+}
+
+// whether a module was compiled or loaded by this runtime
+func (r *Runtime) ownsModule(mod *g.BytecodeModule) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.modules[mod]
+}
+
+//--------------------------------------------------------------
+// Session
+
+// Session compiles and runs a succession of inputs, such as the lines
+// entered at a REPL, as though each input had been appended to the end
+// of one module.  The top-level variables that an input defines are
+// visible to the inputs that come after it.
+type Session struct {
+	r *Runtime
+
+	// the analyzer and the module of the last input that was run
+	anl analyzer.Analyzer
+	mod *g.BytecodeModule
+}
+
+// NewSession creates a Session that compiles and runs its inputs
+// with this Runtime.
+func (r *Runtime) NewSession() *Session {
+	return &Session{r, nil, nil}
+}
+
+// Compile compiles an input without running it.  The variables that the
+// input defines are not visible to later inputs.
+func (s *Session) Compile(name string, source string) (*g.BytecodeModule, error) {
+	mod, _, err := s.r.compile(name, source, s.anl)
+	return mod, err
+}
+
+// Eval compiles and runs an input.  The name is used to identify the input
+// in any errors that are reported.  The result is the value of the last
+// expression that was evaluated.  Once an input has compiled, the variables
+// it defines are visible to later inputs, even if running it fails.
+func (s *Session) Eval(name string, source string) (g.Value, error) {
+	return s.EvalContext(context.Background(), name, source)
+}
+
+// EvalContext is like Eval, except that execution is aborted with a
+// Cancelled error when the context is done.
+func (s *Session) EvalContext(
+	ctx context.Context, name string, source string) (result g.Value, err error) {
+
+	defer recoverInternal(&result, &err)
+
+	mod, anl, err := s.r.compile(name, source, s.anl)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []*g.Ref
+	if s.mod != nil {
+		refs = s.mod.Refs
+	}
+	s.anl = anl
+	s.mod = mod
+
+	intp := s.r.newInterpreter(mod)
+	result, errTrace := intp.ContinueContext(ctx, s.r.MaxOpcodes, refs)
+	if errTrace != nil {
+		return nil, newRuntimeError(errTrace)
+	}
+	return result, nil
+}
+
+// Module returns the module that was compiled for the last input that
+// was passed to Eval, or nil if there has not been one.
+func (s *Session) Module() *g.BytecodeModule {
+	return s.mod
+}
+
+//--------------------------------------------------------------
+// errors
+
+// SyntaxError is returned by Compile when the source code cannot be parsed.
+// It contains every syntax error in the module.
+type SyntaxError struct {
+	Module string
+	Errors []error
+}
+
+func (e *SyntaxError) Error() string {
+	var buf bytes.Buffer
+	for i, err := range e.Errors {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		if pe, ok := err.(*parser.Error); ok {
+			buf.WriteString(fmt.Sprintf("%s:%d:%d: %s",
+				e.Module, pe.Begin.Line, pe.Begin.Col, pe.Message()))
+		} else {
+			buf.WriteString(fmt.Sprintf("%s: %s", e.Module, err.Error()))
+		}
+	}
+	return buf.String()
+}
+
+// AnalysisError is returned by Compile when the source code parses
+// successfully, but is not semantically valid.
+type AnalysisError struct {
+	Module string
+	Errors []error
+}
+
+func (e *AnalysisError) Error() string {
+	var buf bytes.Buffer
+	for i, err := range e.Errors {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		if ae, ok := err.(*analyzer.Error); ok {
+			buf.WriteString(fmt.Sprintf("%s:%d:%d: %s",
+				e.Module, ae.Begin.Line, ae.Begin.Col, ae.Error()))
+		} else {
+			buf.WriteString(fmt.Sprintf("%s: %s", e.Module, err.Error()))
+		}
+	}
+	return buf.String()
+}
+
+// RuntimeError is returned by Run and Call when a Golem error
+// is thrown and not caught, or when the interpreter fails unexpectedly,
+// in which case the error is an InternalError.
+type RuntimeError struct {
+	Err        g.Error
+	StackTrace []string
+	Frames     []*interpreter.StackFrame
+}
+
+func newRuntimeError(errTrace *interpreter.ErrorTrace) *RuntimeError {
+	return &RuntimeError{errTrace.Error, errTrace.StackTrace, errTrace.Frames}
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Err.Error()
+}
@@ -0,0 +1,637 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package golem
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	g "golem/core"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func assert(t *testing.T, flag bool) {
+	if !flag {
+		t.Error("assertion failure")
+	}
+}
+
+func TestRun(t *testing.T) {
+
+	rt := NewRuntime()
+	mod, err := rt.Compile("test", "let a = 1; a + 2;")
+	assert(t, err == nil)
+
+	val, err := rt.Run(mod)
+	assert(t, err == nil)
+	assert(t, val.Eq(g.MakeInt(3)).BoolVal())
+}
+
+func TestCall(t *testing.T) {
+
+	rt := NewRuntime()
+	mod, err := rt.Compile("test", `
+let n = 10;
+pub fn add(a, b = n) { return a + b; }
+`)
+	assert(t, err == nil)
+
+	_, err = rt.Run(mod)
+	assert(t, err == nil)
+
+	fn, gerr := mod.Contents.GetField(g.MakeStr("add"))
+	assert(t, gerr == nil)
+
+	val, err := rt.Call(fn, g.MakeInt(5))
+	assert(t, err == nil)
+	assert(t, val.Eq(g.MakeInt(15)).BoolVal())
+
+	val, err = rt.Call(fn, g.MakeInt(5), g.ONE)
+	assert(t, err == nil)
+	assert(t, val.Eq(g.MakeInt(6)).BoolVal())
+
+	_, err = rt.Call(fn)
+	rte, ok := err.(*RuntimeError)
+	assert(t, ok)
+	assert(t, rte.Err.Kind() == g.ARITY_MISMATCH)
+
+	_, err = rt.Call(fn, g.ONE, g.ONE, g.ONE)
+	rte, ok = err.(*RuntimeError)
+	assert(t, ok)
+	assert(t, rte.Err.Error() == "ArityMismatch: Expected 1 or 2 params, got 3")
+
+	_, err = rt.Call(g.ONE)
+	rte, ok = err.(*RuntimeError)
+	assert(t, ok)
+	assert(t, rte.Err.Kind() == g.TYPE_MISMATCH)
+
+	val, err = rt.Call(g.BuiltinStr, g.ONE)
+	assert(t, err == nil)
+	assert(t, val.Eq(g.MakeStr("1")).BoolVal())
+
+	// a native function that calls back into golem
+	apply := g.NewNativeFunc(
+		func(ev g.Eval, values []g.Value) (g.Value, g.Error) {
+			return ev.Eval(values[0].(g.Func), values[1:])
+		})
+	val, err = rt.Call(apply, fn, g.MakeInt(7))
+	assert(t, err == nil)
+	assert(t, val.Eq(g.MakeInt(17)).BoolVal())
+
+	_, err = rt.Call(apply, fn)
+	rte, ok = err.(*RuntimeError)
+	assert(t, ok)
+	assert(t, rte.Err.Kind() == g.ARITY_MISMATCH)
+
+	// a function from a module compiled by a different runtime
+	_, err = NewRuntime().Call(fn, g.ONE)
+	assert(t, err != nil)
+	_, ok = err.(*RuntimeError)
+	assert(t, !ok)
+}
+
+func TestForget(t *testing.T) {
+
+	rt := NewRuntime()
+	mod, err := rt.Compile("test", "pub fn f() { return 1; }")
+	assert(t, err == nil)
+	_, err = rt.Run(mod)
+	assert(t, err == nil)
+	fn, gerr := mod.Contents.GetField(g.MakeStr("f"))
+	assert(t, gerr == nil)
+
+	_, ok := rt.Source("test")
+	assert(t, ok)
+
+	rt.Forget("test")
+	_, ok = rt.Source("test")
+	assert(t, !ok)
+	_, err = rt.Call(fn)
+	assert(t, err != nil)
+	assert(t, err.Error() == "function was not compiled by this runtime")
+
+	// the source of a module that did not compile is released too
+	_, err = rt.Compile("bad", "let a =")
+	assert(t, err != nil)
+	rt.Forget("bad")
+	_, ok = rt.Source("bad")
+	assert(t, !ok)
+}
+
+func TestRunMain(t *testing.T) {
+
+	var got g.Value
+	rt := NewRuntime()
+	rt.RegisterBuiltin("record", g.NewNativeFunc(
+		func(ev g.Eval, values []g.Value) (g.Value, g.Error) {
+			got = values[0]
+			return g.NULL, nil
+		}))
+
+	mod, err := rt.Compile("test", "pub fn main(args) { record(args); }")
+	assert(t, err == nil)
+	assert(t, rt.RunMain(mod, []string{"a", "b"}) == nil)
+	assert(t, got.ToStr().String() == "[ a, b ]")
+
+	// main is optional
+	mod, err = rt.Compile("test", "record(1);")
+	assert(t, err == nil)
+	assert(t, rt.RunMain(mod, []string{"a"}) == nil)
+	assert(t, got.Eq(g.ONE).BoolVal())
+
+	mod, err = rt.Compile("test", "pub fn main() { record(2); }")
+	assert(t, err == nil)
+	assert(t, rt.RunMain(mod, []string{"a"}) == nil)
+	assert(t, got.Eq(g.MakeInt(2)).BoolVal())
+
+	mod, err = rt.Compile("test", "pub fn main(a, b) {}")
+	assert(t, err == nil)
+	err = rt.RunMain(mod, nil)
+	assert(t, err != nil && err.Error() == "'main' has too many arguments")
+
+	mod, err = rt.Compile("test", "pub let main = 1;")
+	assert(t, err == nil)
+	err = rt.RunMain(mod, nil)
+	assert(t, err != nil && err.Error() == "'main' is not a function")
+
+	mod, err = rt.Compile("test", "fn main() { record(3); }")
+	assert(t, err == nil)
+	err = rt.RunMain(mod, nil)
+	assert(t, err != nil && err.Error() == "'main' must be public")
+	assert(t, got.Eq(g.MakeInt(2)).BoolVal())
+}
+
+func TestSyntaxError(t *testing.T) {
+
+	_, err := NewRuntime().Compile("foo.glm", "let a = ;")
+	se, ok := err.(*SyntaxError)
+	assert(t, ok)
+	assert(t, se.Module == "foo.glm")
+	assert(t, err.Error() == "foo.glm:1:9: Unexpected Token ';'")
+
+	// every syntax error is reported
+	_, err = NewRuntime().Compile("foo.glm", "let a = ;\nlet b = 1 +;\nlet c = 2;")
+	se, ok = err.(*SyntaxError)
+	assert(t, ok)
+	assert(t, len(se.Errors) == 2)
+	assert(t, err.Error() ==
+		"foo.glm:1:9: Unexpected Token ';'\n"+
+			"foo.glm:2:12: Unexpected Token ';'")
+}
+
+func TestAnalysisError(t *testing.T) {
+
+	_, err := NewRuntime().Compile("foo.glm", "a = 1; const b = 2; b = 3;")
+	ae, ok := err.(*AnalysisError)
+	assert(t, ok)
+	assert(t, len(ae.Errors) == 2)
+	assert(t, err.Error() ==
+		"foo.glm:1:1: Symbol 'a' is not defined\nfoo.glm:1:21: Symbol 'b' is constant")
+
+	_, err = NewRuntime().Compile("foo.glm", "let f = fn(a, a) {};")
+	ae, ok = err.(*AnalysisError)
+	assert(t, ok)
+	assert(t, err.Error() == "foo.glm:1:15: Symbol 'a' is already defined")
+}
+
+func TestRuntimeError(t *testing.T) {
+
+	rt := NewRuntime()
+	mod, err := rt.Compile("test.glm", `
+fn divide(a, b) {
+    return a / b;
+}
+let c = 1;
+c = divide(c, 0);
+`)
+	assert(t, err == nil)
+
+	_, err = rt.Run(mod)
+	rte, ok := err.(*RuntimeError)
+	assert(t, ok)
+	assert(t, rte.Err.Kind() == g.DIVIDE_BY_ZERO)
+	assert(t, reflect.DeepEqual(rte.StackTrace, []string{
+		"    at divide (test.glm:3:14)",
+		"    at <module> (test.glm:6:5)"}))
+	assert(t, err.Error() == "DivideByZero")
+
+	mod, err = rt.Compile("test.glm", "for v in 5 {}")
+	assert(t, err == nil)
+	_, err = rt.Run(mod)
+	assert(t, err != nil && err.Error() == "TypeMismatch: Expected Iterable Type")
+
+	// internal errors are reported, rather than bringing down the host
+	oops := g.NewNativeFunc(
+		func(ev g.Eval, values []g.Value) (g.Value, g.Error) {
+			panic("oops")
+		})
+	assert(t, rt.RegisterBuiltin("oops", oops) == nil)
+
+	mod, err = rt.Compile("test.glm", "pub fn f() { oops(); }\nf();")
+	assert(t, err == nil)
+	_, err = rt.Run(mod)
+	rte, ok = err.(*RuntimeError)
+	assert(t, ok)
+	assert(t, rte.Err.Kind() == g.INTERNAL_ERROR)
+	assert(t, err.Error() == "InternalError: oops")
+
+	fn, gerr := mod.Contents.GetField(g.MakeStr("f"))
+	assert(t, gerr == nil)
+	_, err = rt.Call(fn)
+	assert(t, err != nil && err.Error() == "InternalError: oops")
+
+	_, err = rt.Call(oops)
+	assert(t, err != nil && err.Error() == "InternalError: oops")
+
+	mod, err = rt.Compile("test.glm", "pub fn main() { oops(); }")
+	assert(t, err == nil)
+	err = rt.RunMain(mod, nil)
+	assert(t, err != nil && err.Error() == "InternalError: oops")
+}
+
+func TestLimits(t *testing.T) {
+
+	rt := NewRuntime()
+	mod, err := rt.Compile("test", `
+pub fn loop() { while true {} }
+`)
+	assert(t, err == nil)
+
+	_, err = rt.Run(mod)
+	assert(t, err == nil)
+
+	fn, gerr := mod.Contents.GetField(g.MakeStr("loop"))
+	assert(t, gerr == nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = rt.CallContext(ctx, fn)
+	rte, ok := err.(*RuntimeError)
+	assert(t, ok)
+	assert(t, rte.Err.Kind() == g.CANCELLED)
+
+	rt.MaxOpcodes = 500
+	_, err = rt.Call(fn)
+	rte, ok = err.(*RuntimeError)
+	assert(t, ok)
+	assert(t, rte.Err.Kind() == g.BUDGET_EXCEEDED)
+}
+
+func TestBuiltins(t *testing.T) {
+
+	rt := NewRuntime()
+	double := g.NewNativeFunc(
+		func(ev g.Eval, values []g.Value) (g.Value, g.Error) {
+			return values[0].Plus(values[0])
+		})
+	assert(t, rt.RegisterBuiltin("double", double) == nil)
+	assert(t, rt.RegisterBuiltin("double", double) != nil)
+	assert(t, rt.RegisterBuiltin("print", double) != nil)
+
+	assert(t, rt.RegisterModule("math", []*g.BuiltinEntry{
+		{"pi", g.MakeFloat(3.14)},
+		{"double", double}}) == nil)
+
+	mod, err := rt.Compile("test", `
+let a = double(21);
+let b = math.double(math.pi);
+a + b;
+`)
+	assert(t, err == nil)
+	val, err := rt.Run(mod)
+	assert(t, err == nil)
+	assert(t, val.Eq(g.MakeFloat(48.28)).BoolVal())
+
+	_, err = rt.Compile("test", "math = 1;")
+	assert(t, err != nil)
+	assert(t, err.Error() == "test:1:1: Symbol 'math' is constant")
+
+	_, err = NewRuntime().Compile("test", "double(1);")
+	assert(t, err != nil)
+	assert(t, err.Error() == "test:1:1: Symbol 'double' is not defined")
+}
+
+func TestGoFunc(t *testing.T) {
+
+	type key struct{}
+	lookup, gerr := g.FromGoFunc(func(ctx context.Context, name string) (string, error) {
+		user, ok := ctx.Value(key{}).(string)
+		if !ok {
+			return "", fmt.Errorf("no user for '%s'", name)
+		}
+		return name + ":" + user, nil
+	})
+	assert(t, gerr == nil)
+
+	rt := NewRuntime()
+	assert(t, rt.RegisterBuiltin("lookup", lookup) == nil)
+	mod, err := rt.Compile("test", `
+pub fn run() { return lookup("a"); }
+`)
+	assert(t, err == nil)
+	_, err = rt.Run(mod)
+	assert(t, err == nil)
+
+	run, gerr := mod.Contents.GetField(g.MakeStr("run"))
+	assert(t, gerr == nil)
+
+	ctx := context.WithValue(context.Background(), key{}, "bob")
+	val, err := rt.CallContext(ctx, run)
+	assert(t, err == nil)
+	assert(t, val.Eq(g.MakeStr("a:bob")).BoolVal())
+
+	_, err = rt.Call(run)
+	assert(t, err != nil)
+	assert(t, err.Error() == "Generic: no user for 'a'")
+}
+
+func TestLoad(t *testing.T) {
+
+	rt := NewRuntime()
+	mod, err := rt.Compile("test", `
+let a = [1, 2];
+pub let b = struct { x: 3 };
+pub fn sum() {
+    try {
+        return a[0] + a[1] + b.x;
+    } finally {
+        a = null;
+    }
+}
+`)
+	assert(t, err == nil)
+
+	var buf bytes.Buffer
+	assert(t, g.WriteModule(&buf, mod) == nil)
+
+	rt = NewRuntime()
+	mod, err = rt.Load(&buf)
+	assert(t, err == nil)
+	_, err = rt.Run(mod)
+	assert(t, err == nil)
+
+	sum, gerr := mod.Contents.GetField(g.MakeStr("sum"))
+	assert(t, gerr == nil)
+	val, err := rt.Call(sum)
+	assert(t, err == nil)
+	assert(t, val.Eq(g.MakeInt(6)).BoolVal())
+
+	_, err = rt.Load(bytes.NewReader([]byte("let a = 1;")))
+	assert(t, err != nil)
+
+	// builtins are linked by name
+	rt = NewRuntime()
+	assert(t, rt.RegisterBuiltin("answer", g.MakeInt(42)) == nil)
+	mod, err = rt.Compile("test", "pub fn f() { return answer + len([1]); }")
+	assert(t, err == nil)
+	buf.Reset()
+	assert(t, g.WriteModule(&buf, mod) == nil)
+	data := buf.Bytes()
+
+	_, err = NewRuntime().Load(bytes.NewReader(data))
+	assert(t, err.Error() == "unknown builtin 'answer'")
+
+	rt = NewRuntime()
+	assert(t, rt.RegisterBuiltin("other", g.NULL) == nil)
+	assert(t, rt.RegisterBuiltin("answer", g.MakeInt(42)) == nil)
+	mod, err = rt.Load(bytes.NewReader(data))
+	assert(t, err == nil)
+	_, err = rt.Run(mod)
+	assert(t, err == nil)
+	f, gerr := mod.Contents.GetField(g.MakeStr("f"))
+	assert(t, gerr == nil)
+	val, err = rt.Call(f)
+	assert(t, err == nil)
+	assert(t, val.Eq(g.MakeInt(43)).BoolVal())
+
+	// the bytecode is verified
+	mod.Templates[0].OpCodes[0] = g.BREAK
+	buf.Reset()
+	assert(t, g.WriteModule(&buf, mod) == nil)
+	_, err = rt.Load(&buf)
+	assert(t, err.Error() == "invalid bytecode in template 0 at 0: unresolved BREAK")
+
+	// a finally clause that is reached by normal execution
+	mod, err = rt.Compile("test", "let a = 0; try { a = 1; } finally { a = 2; } a;")
+	assert(t, err == nil)
+	buf.Reset()
+	assert(t, g.WriteModule(&buf, mod) == nil)
+	mod, err = rt.Load(&buf)
+	assert(t, err == nil)
+	val, err = rt.Run(mod)
+	assert(t, err == nil)
+	assert(t, val.Eq(g.MakeInt(2)).BoolVal())
+
+	// modules that would fail when they are run are rejected
+	load := func(opcodes ...byte) (*g.BytecodeModule, error) {
+		mod, err := rt.Compile("test", "1;")
+		assert(t, err == nil)
+		tpl := mod.Templates[0]
+		tpl.OpCodes = opcodes
+		tpl.LineNumberTable = []g.LineNumberEntry{{0, 1, 1}}
+		buf.Reset()
+		assert(t, g.WriteModule(&buf, mod) == nil)
+		return rt.Load(&buf)
+	}
+
+	_, err = load(g.DONE)
+	assert(t, err.Error() ==
+		"invalid bytecode in template 0 at 0: DONE does not end a catch or finally clause")
+
+	_, err = load(g.LOAD_ONE, g.ITER_NEXT, g.RETURN)
+	assert(t, err.Error() ==
+		"invalid bytecode in template 0 at 1: ITER_NEXT operand was not created by ITER")
+
+	mod, err = load(g.LOAD_ONE, g.ITER, g.RETURN)
+	assert(t, err == nil)
+	_, err = rt.Run(mod)
+	assert(t, err.Error() == "TypeMismatch: Expected Iterable Type")
+}
+
+func TestImport(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "golem")
+	assert(t, err == nil)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"util.glm": `
+record('util');
+pub fn double(x) { return x * 2; }
+`,
+		"other.glm": `
+import util;
+pub const four = util.double(2);
+`,
+		"a.glm":    "import b;",
+		"b.glm":    "import a;",
+		"bad.glm":  "let;",
+		"spin.glm": "let i = 0; while i < 100 { i++; }"}
+	for name, source := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(source), 0644)
+		assert(t, err == nil)
+	}
+
+	rt := NewRuntime()
+	rt.Path = []string{filepath.Join(dir, "missing"), dir}
+	inits := []string{}
+	rt.RegisterBuiltin("record", g.NewNativeFunc(
+		func(ev g.Eval, values []g.Value) (g.Value, g.Error) {
+			inits = append(inits, values[0].ToStr().String())
+			return g.NULL, nil
+		}))
+
+	// modules are compiled and initialized only once
+	mod, err := rt.Compile("test", `
+import util;
+import other as o;
+util.double(o.four);
+`)
+	assert(t, err == nil)
+	val, err := rt.Run(mod)
+	assert(t, err == nil)
+	assert(t, val.Eq(g.MakeInt(8)).BoolVal())
+	assert(t, reflect.DeepEqual(inits, []string{"util"}))
+
+	// errors
+	fail := func(source string, expect string) {
+		mod, err := rt.Compile("test", source)
+		assert(t, err == nil)
+		_, err = rt.Run(mod)
+		if err == nil || err.Error() != expect {
+			t.Error(err, " != ", expect)
+		}
+	}
+	fail("import a;", "ImportFailed: Import cycle: a -> b -> a")
+	fail("import nope;", "ImportFailed: Module 'nope' not found")
+	fail("import bad;", "ImportFailed: "+filepath.Join(dir, "bad.glm")+
+		":1:4: Unexpected Token ';'")
+
+	_, err = NewRuntime().Run(mod)
+	assert(t, err.Error() == "ImportFailed: Module 'util' not found")
+
+	// an imported module counts against the budget of the module
+	// that imports it, although each would fit within it on its own
+	rt = NewRuntime()
+	rt.Path = []string{dir}
+	rt.MaxOpcodes = 1500
+	mod, err = rt.Compile("test", "let j = 0; while j < 100 { j++; }")
+	assert(t, err == nil)
+	_, err = rt.Run(mod)
+	assert(t, err == nil)
+	mod, err = rt.Compile("test", "import spin; let j = 0; while j < 100 { j++; }")
+	assert(t, err == nil)
+	_, err = rt.Run(mod)
+	rte, ok := err.(*RuntimeError)
+	assert(t, ok && rte.Err.Kind() == g.BUDGET_EXCEEDED)
+}
+
+func TestConcurrency(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "golem")
+	assert(t, err == nil)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"util.glm":  "record('util'); pub const one = 1;",
+		"other.glm": "import util; record('other'); pub const two = util.one + 1;"}
+	for name, source := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(source), 0644)
+		assert(t, err == nil)
+	}
+
+	rt := NewRuntime()
+	rt.Path = []string{dir}
+	inits := make(chan string, 10)
+	rt.RegisterBuiltin("record", g.NewNativeFunc(
+		func(ev g.Eval, values []g.Value) (g.Value, g.Error) {
+			inits <- values[0].ToStr().String()
+			return g.NULL, nil
+		}))
+
+	// every module is compiled and initialized only once,
+	// no matter how many goroutines import it
+	done := make(chan g.Value)
+	for j := 0; j < 8; j++ {
+		go func(j int) {
+			name := fmt.Sprintf("test%d", j)
+			mod, err := rt.Compile(name, "import other; import util; other.two + util.one;")
+			assert(t, err == nil)
+			val, err := rt.Run(mod)
+			assert(t, err == nil)
+			_, ok := rt.Source(name)
+			assert(t, ok)
+			done <- val
+		}(j)
+	}
+	for j := 0; j < 8; j++ {
+		val := <-done
+		assert(t, val.Eq(g.MakeInt(3)).BoolVal())
+	}
+	close(inits)
+	names := []string{}
+	for n := range inits {
+		names = append(names, n)
+	}
+	assert(t, reflect.DeepEqual(names, []string{"util", "other"}))
+}
+
+func TestSession(t *testing.T) {
+
+	rt := NewRuntime()
+	s := rt.NewSession()
+	assert(t, s.Module() == nil)
+
+	eval := func(source string, expect string) {
+		val, err := s.Eval("input", source)
+		if err != nil {
+			if err.Error() != expect {
+				t.Error(err, " != ", expect)
+			}
+		} else if val.ToStr().String() != expect {
+			t.Error(val, " != ", expect)
+		}
+	}
+
+	// variables, functions and captures are kept between inputs
+	eval("let a = 1; const b = 2;", "null")
+	eval("fn f() { return a + b; }", "null")
+	eval("a = 10; f();", "12")
+	eval("let c = struct { x: f() };", "null")
+	eval("c.x + a;", "22")
+
+	// inputs that fail are not kept, unless they compile
+	eval("let d = ;", "input:1:9: Unexpected Token ';'")
+	eval("let a = 3;", "input:1:5: Symbol 'a' is already defined")
+	eval("let e = 5; e / 0;", "DivideByZero")
+	eval("let g = e + a;", "null")
+	eval("g;", "15")
+
+	// compiling an input does not define its variables
+	mod, err := s.Compile("input", "let h = 1;")
+	assert(t, err == nil && mod != nil)
+	eval("h;", "input:1:1: Symbol 'h' is not defined")
+	assert(t, s.Module().Refs[0].Val.Eq(g.MakeInt(10)).BoolVal())
+
+	// the functions that were defined can be called
+	f, ok := s.Module().Refs[2].Val.(g.BytecodeFunc)
+	assert(t, ok)
+	val, err := rt.Call(f)
+	assert(t, err == nil && val.Eq(g.MakeInt(12)).BoolVal())
+}
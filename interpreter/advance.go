@@ -22,45 +22,62 @@ import (
 // Advance the interpreter forwards by one opcode.
 func (i *Interpreter) advance(lastFrame int) (g.Value, g.Error) {
 
-	pool := i.mod.Pool
+	if i.limits != nil {
+		if err := i.tick(); err != nil {
+			return nil, err
+		}
+	}
+	if i.debugger != nil {
+		if err := i.debugger.Before(i); err != nil {
+			return nil, err
+		}
+	}
+	if i.tracer != nil {
+		i.traceOpcode()
+	}
+
 	frameIndex := len(i.frames) - 1
 	f := i.frames[frameIndex]
+	mod := f.fn.Module()
+	pool := mod.Pool
 	n := len(f.stack) - 1
 	opc := f.fn.Template().OpCodes
 
 	switch opc[f.ip] {
 
-	case g.INVOKE:
+	case g.INVOKE, g.INVOKE_NAMED:
 
-		idx := index(opc, f.ip)
-		params := f.stack[n-idx+1:]
+		base, params, named, err := invocation(f, opc)
+		if err != nil {
+			return nil, err
+		}
 
-		switch fn := f.stack[n-idx].(type) {
+		switch fn := f.stack[base].(type) {
 		case g.BytecodeFunc:
 
-			// check arity
-			arity := len(params)
-			if arity != fn.Template().Arity {
-				err := g.ArityMismatchError(
-					fmt.Sprintf("%d", fn.Template().Arity), arity)
+			// check arity, and bind the params
+			nf, err := newFrame(fn, params, named)
+			if err != nil {
 				return nil, err
 			}
 
 			// pop from stack
-			f.stack = f.stack[:n-idx]
+			f.stack = f.stack[:base]
 
 			// push a new frame
-			locals := newLocals(fn.Template().NumLocals, params)
-			i.frames = append(i.frames, &frame{fn, locals, []g.Value{}, 0})
+			i.frames = append(i.frames, nf)
+			if i.tracer != nil {
+				i.tracer.Call(i, fn, tracedParams(params, named))
+			}
 
 		case g.NativeFunc:
 
-			val, err := fn.Invoke(params)
+			val, err := i.invokeNative(fn, params, named)
 			if err != nil {
 				return nil, err
 			}
 
-			f.stack = f.stack[:n-idx]
+			f.stack = f.stack[:base]
 			f.stack = append(f.stack, val)
 			f.ip += 3
 
@@ -83,6 +100,9 @@ func (i *Interpreter) advance(lastFrame int) (g.Value, g.Error) {
 
 		// get result from top of stack
 		result := f.stack[n]
+		if i.tracer != nil {
+			i.tracer.Return(i, f.fn, result)
+		}
 
 		if frameIndex == lastFrame {
 			// If we are on the last frame, then we are done. Note that lastFrame
@@ -101,22 +121,35 @@ func (i *Interpreter) advance(lastFrame int) (g.Value, g.Error) {
 		}
 
 	case g.DONE:
-		panic("DONE cannot be executed directly")
+		// a finally clause that is reached by normal execution
+		// carries on past the DONE that marks its end
+		f.ip++
 
-	case g.SPAWN:
+	case g.SPAWN, g.SPAWN_NAMED:
 
-		idx := index(opc, f.ip)
-		params := f.stack[n-idx+1:]
+		base, params, named, err := invocation(f, opc)
+		if err != nil {
+			return nil, err
+		}
 
-		switch fn := f.stack[n-idx].(type) {
+		switch fn := f.stack[base].(type) {
 		case g.BytecodeFunc:
-			f.stack = f.stack[:n-idx]
+			nf, err := newFrame(fn, params, named)
+			if err != nil {
+				return nil, err
+			}
+			f.stack = f.stack[:base]
 			f.ip += 3
 
-			intp := NewInterpreter(i.mod)
-			locals := newLocals(fn.Template().NumLocals, params)
+			intp := i.spawn()
+			// copy the params, since they are on this goroutine's stack
+			args := tracedParams(append([]g.Value{}, params...), named)
+			if i.tracer != nil {
+				i.tracer.Spawn(i, fn, args, intp)
+			}
 			go (func() {
-				_, errTrace := intp.run(fn, locals)
+				defer printPanic()
+				_, errTrace := intp.run(nf, args)
 				if errTrace != nil {
 					fmt.Printf("%v\n", errTrace.Error)
 					fmt.Printf("%v\n", errTrace.StackTrace)
@@ -124,11 +157,16 @@ func (i *Interpreter) advance(lastFrame int) (g.Value, g.Error) {
 			})()
 
 		case g.NativeFunc:
-			f.stack = f.stack[:n-idx]
+			f.stack = f.stack[:base]
 			f.ip += 3
 
+			intp := i.spawn()
+			if i.tracer != nil {
+				i.tracer.Spawn(i, fn, tracedParams(params, named), intp)
+			}
 			go (func() {
-				_, err := fn.Invoke(params)
+				defer printPanic()
+				_, err := intp.invokeNative(fn, params, named)
 				if err != nil {
 					fmt.Printf("%v\n", err)
 				}
@@ -146,15 +184,16 @@ func (i *Interpreter) advance(lastFrame int) (g.Value, g.Error) {
 			return nil, g.TypeMismatchError("Expected 'Struct'")
 		}
 
-		// throw a generic error
+		// throw a generic error, which is all that the catch clause sees
+		f.stack = f.stack[:n]
 		return nil, g.GenericError(stc)
 
 	case g.NEW_FUNC:
 
 		// push a function
 		idx := index(opc, f.ip)
-		tpl := i.mod.Templates[idx]
-		nf := g.NewBytecodeFunc(tpl)
+		tpl := mod.Templates[idx]
+		nf := g.NewBytecodeFunc(mod, tpl)
 		f.stack = append(f.stack, nf)
 		f.ip += 3
 
@@ -186,7 +225,7 @@ func (i *Interpreter) advance(lastFrame int) (g.Value, g.Error) {
 
 	case g.NEW_STRUCT:
 
-		def := i.mod.StructDefs[index(opc, f.ip)]
+		def := mod.StructDefs[index(opc, f.ip)]
 		stc, err := g.BlankStruct(def)
 		if err != nil {
 			return nil, err
@@ -508,7 +547,7 @@ func (i *Interpreter) advance(lastFrame int) (g.Value, g.Error) {
 
 	case g.LOAD_BUILTIN:
 		idx := index(opc, f.ip)
-		f.stack = append(f.stack, g.Builtins[idx])
+		f.stack = append(f.stack, i.builtins[idx])
 		f.ip += 3
 
 	case g.LOAD_CONST:
@@ -807,7 +846,9 @@ func (i *Interpreter) advance(lastFrame int) (g.Value, g.Error) {
 	case g.ITER:
 
 		ibl, ok := f.stack[n].(g.Iterable)
-		g.Assert(ok, "Expected Iterable")
+		if !ok {
+			return nil, g.TypeMismatchError("Expected Iterable Type")
+		}
 
 		f.stack[n] = ibl.NewIterator()
 		f.ip++
@@ -841,6 +882,20 @@ func (i *Interpreter) advance(lastFrame int) (g.Value, g.Error) {
 		f.stack = f.stack[:n]
 		f.ip++
 
+	case g.IMPORT:
+		name := pool[index(opc, f.ip)].(g.Str).String()
+		if i.importer == nil {
+			return nil, g.ImportFailedError(
+				fmt.Sprintf("Module '%s' not found", name))
+		}
+
+		contents, err := i.importer.Import(i, name)
+		if err != nil {
+			return nil, err
+		}
+		f.stack = append(f.stack, contents)
+		f.ip += 3
+
 	default:
 		panic("Invalid opcode")
 	}
@@ -853,3 +908,31 @@ func index(opcodes []byte, ip int) int {
 	low := opcodes[ip+2]
 	return int(high)<<8 + int(low)
 }
+
+// Find the params of an invocation, and the index of the function that is
+// being invoked, which precedes them on the stack.  The named params, if
+// any, are in a struct that follows the positional params.
+func invocation(f *frame, opc []byte) (int, []g.Value, g.Struct, g.Error) {
+
+	end := len(f.stack)
+	var named g.Struct
+	if opc[f.ip] == g.INVOKE_NAMED || opc[f.ip] == g.SPAWN_NAMED {
+		stc, ok := f.stack[end-1].(g.Struct)
+		if !ok {
+			return 0, nil, nil, g.TypeMismatchError("Expected 'Struct'")
+		}
+		named = stc
+		end--
+	}
+
+	base := end - index(opc, f.ip) - 1
+	return base, f.stack[base+1 : end], named, nil
+}
+
+// A spawned goroutine has nowhere to return an internal error to, so it is
+// printed like any other error, rather than bringing down the host program.
+func printPanic() {
+	if p := recover(); p != nil {
+		fmt.Printf("%v\n", g.InternalError(fmt.Sprint(p)))
+	}
+}
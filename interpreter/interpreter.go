@@ -15,23 +15,81 @@
 package interpreter
 
 import (
+	"context"
 	"fmt"
 	g "golem/core"
+	"sort"
+	"sync"
+	"sync/atomic"
 )
 
 //---------------------------------------------------------------
 // The Golem Interpreter
 
 type Interpreter struct {
-	mod    *g.BytecodeModule
-	frames []*frame
+	mod       *g.BytecodeModule
+	builtins  []g.Value
+	importer  Importer
+	frames    []*frame
+	evalTrace *ErrorTrace
+	limits    *limits
+	ticks     int
+	debugger  Debugger
+	tracer    Tracer
 }
 
-func NewInterpreter(mod *g.BytecodeModule) *Interpreter {
-	return &Interpreter{mod, []*frame{}}
+// Importer provides the modules that are imported by the code being
+// interpreted.  Import returns the contents of the module with the given
+// name, initializing the module first if it has not been imported before.
+// The interpreter is the one that is executing the import; a module that
+// is initialized on its behalf should be run with InitShared.
+type Importer interface {
+	Import(from *Interpreter, name string) (g.Struct, g.Error)
+}
+
+// NewInterpreter creates an interpreter for a module.  If the importer
+// is nil, then the module cannot import other modules.
+func NewInterpreter(
+	mod *g.BytecodeModule, builtins g.BuiltinManager, importer Importer) *Interpreter {
+
+	return &Interpreter{mod, builtins.Builtins(), importer, []*frame{}, nil, nil, 0, nil, nil}
+}
+
+// InitContext is like Init, except that execution is aborted with a
+// CANCELLED error when the context is done, or with a BUDGET_EXCEEDED error
+// once more than maxOpcodes opcodes have been executed.  A maxOpcodes
+// of zero means there is no limit.
+func (i *Interpreter) InitContext(
+	ctx context.Context, maxOpcodes int64) (g.Value, *ErrorTrace) {
+
+	i.limits = newLimits(ctx, maxOpcodes)
+	return i.Init()
 }
 
 func (i *Interpreter) Init() (g.Value, *ErrorTrace) {
+	return i.initRefs(nil)
+}
+
+// InitShared is like Init, except that execution counts against the limits
+// of another interpreter, such as the one that is importing the module.
+func (i *Interpreter) InitShared(other *Interpreter) (g.Value, *ErrorTrace) {
+	i.limits = other.limits
+	return i.initRefs(nil)
+}
+
+// ContinueContext is like InitContext, except that the module's local
+// variables start out as the given Refs, rather than being empty.  This lets
+// a module share the top-level variables of a module that ran before it,
+// the way that the inputs to a REPL do.  Any locals beyond the end of
+// refs are created empty.
+func (i *Interpreter) ContinueContext(
+	ctx context.Context, maxOpcodes int64, refs []*g.Ref) (g.Value, *ErrorTrace) {
+
+	i.limits = newLimits(ctx, maxOpcodes)
+	return i.initRefs(refs)
+}
+
+func (i *Interpreter) initRefs(refs []*g.Ref) (g.Value, *ErrorTrace) {
 
 	// use the zeroth template
 	tpl := i.mod.Templates[0]
@@ -39,33 +97,190 @@ func (i *Interpreter) Init() (g.Value, *ErrorTrace) {
 	if tpl.Arity != 0 || tpl.NumCaptures != 0 {
 		panic("TODO")
 	}
+	if len(refs) > tpl.NumLocals {
+		panic("invalid refs")
+	}
 
-	// create empty locals
-	i.mod.Refs = newLocals(tpl.NumLocals, nil)
+	// create the locals that are not in refs
+	i.mod.Refs = append(
+		append([]*g.Ref{}, refs...),
+		newLocals(tpl.NumLocals-len(refs), nil)...)
 
 	// make func
-	fn := g.NewBytecodeFunc(tpl)
+	fn := g.NewBytecodeFunc(i.mod, tpl)
 
 	// go
-	return i.run(fn, i.mod.Refs)
+	return i.run(&frame{fn, i.mod.Refs, []g.Value{}, 0}, nil)
 }
 
 func (i *Interpreter) RunBytecode(
 	fn g.BytecodeFunc, params []g.Value) (result g.Value, errTrace *ErrorTrace) {
 
-	return i.run(fn, newLocals(fn.Template().NumLocals, params))
+	f, err := newFrame(fn, params, nil)
+	if err != nil {
+		return nil, &ErrorTrace{err, []string{}, []*StackFrame{}, nil}
+	}
+	return i.run(f, params)
+}
+
+// RunBytecodeContext is like RunBytecode, with the same limits as InitContext.
+func (i *Interpreter) RunBytecodeContext(
+	ctx context.Context, maxOpcodes int64,
+	fn g.BytecodeFunc, params []g.Value) (result g.Value, errTrace *ErrorTrace) {
+
+	i.limits = newLimits(ctx, maxOpcodes)
+	return i.RunBytecode(fn, params)
+}
+
+// Context returns the context that the interpreter is running under.
+func (i *Interpreter) Context() context.Context {
+	if i.limits == nil {
+		return context.Background()
+	}
+	return i.limits.ctx
+}
+
+// Create an interpreter for a spawned goroutine.  The new interpreter
+// shares the limits and the tracer of this one.
+func (i *Interpreter) spawn() *Interpreter {
+	var d Debugger
+	if s, ok := i.debugger.(Spawner); ok {
+		d = s.Spawn()
+	}
+	return &Interpreter{i.mod, i.builtins, i.importer, []*frame{}, nil, i.limits, 0, d, i.tracer}
 }
 
 func (i *Interpreter) run(
-	fn g.BytecodeFunc, locals []*g.Ref) (result g.Value, errTrace *ErrorTrace) {
+	f *frame, params []g.Value) (result g.Value, errTrace *ErrorTrace) {
+
+	base := len(i.frames)
+	i.frames = append(i.frames, f)
+	if i.tracer != nil {
+		i.tracer.Call(i, f.fn, params)
+	}
+	result, errTrace = i.loop(base)
+
+	// pop the frame
+	i.frames = i.frames[:base]
+	return result, errTrace
+}
+
+// Eval invokes a function on behalf of a native function, so that the
+// native function can call back into Golem code.  Frames are pushed on top
+// of the frame that invoked the native function.  If the evaluated function
+// throws an error that is not caught, then the error is returned, and the
+// stack trace is preserved so that it can be reported in full once the
+// native function passes the error back to the interpreter.
+func (i *Interpreter) Eval(fn g.Func, params []g.Value) (g.Value, g.Error) {
 
-	i.frames = append(i.frames, &frame{fn, locals, []g.Value{}, 0})
+	// discard the trace of any earlier error that was not passed back
+	i.evalTrace = nil
+
+	switch t := fn.(type) {
+	case g.BytecodeFunc:
+
+		// push a new frame, and run it until it returns
+		f, err := newFrame(t, params, nil)
+		if err != nil {
+			return nil, err
+		}
+		base := len(i.frames)
+		i.frames = append(i.frames, f)
+		if i.tracer != nil {
+			i.tracer.Call(i, t, params)
+		}
+
+		result, errTrace := i.loop(base)
+		if errTrace != nil {
+			i.evalTrace = errTrace
+			return nil, errTrace.Error
+		}
+
+		// pop the frame
+		i.frames = i.frames[:base]
+		return result, nil
+
+	case g.NativeFunc:
+		return i.invokeNative(t, params, nil)
+
+	default:
+		return nil, g.TypeMismatchError("Expected 'Func'")
+	}
+}
+
+// Invoke a native function, and trace the call.  Only a NamedParamFunc
+// can be invoked with named params.  If the native function succeeds, then
+// it has swallowed any error returned by Eval, so the saved trace is stale.
+func (i *Interpreter) invokeNative(
+	fn g.NativeFunc, params []g.Value, named g.Struct) (g.Value, g.Error) {
+
+	invoke := fn.Invoke
+	if named != nil {
+		nf, ok := fn.(g.NamedParamFunc)
+		if !ok {
+			return nil, g.NoSuchParamError(sortedKeys(named)[0])
+		}
+		invoke = func(ev g.Eval, values []g.Value) (g.Value, g.Error) {
+			return nf.InvokeNamed(ev, values, named)
+		}
+	}
+	if i.tracer != nil {
+		i.tracer.Call(i, fn, tracedParams(params, named))
+	}
+	ev := &nativeEval{i, sync.Mutex{}, false}
+	val, err := invoke(ev, params)
+	ev.close()
+	if err != nil {
+		return val, err
+	}
+
+	i.evalTrace = nil
+	if i.tracer != nil {
+		i.tracer.Return(i, fn, val)
+	}
+	return val, nil
+}
+
+// nativeEval is the Eval that is passed to a native function.  Frames are
+// pushed onto the interpreter's stack by each call, so it refuses to be
+// used once the native function has returned, or by two calls at once.
+type nativeEval struct {
+	i    *Interpreter
+	mu   sync.Mutex
+	done bool
+}
+
+func (ev *nativeEval) Eval(fn g.Func, params []g.Value) (g.Value, g.Error) {
+	if !ev.mu.TryLock() {
+		return nil, g.InternalError("Eval is already in use")
+	}
+	defer ev.mu.Unlock()
+
+	if ev.done {
+		return nil, g.InternalError("Eval was called after the native function returned")
+	}
+	return ev.i.Eval(fn, params)
+}
+
+func (ev *nativeEval) Context() context.Context {
+	return ev.i.Context()
+}
+
+// Wait for any call that is still running, and then invalidate the Eval.
+func (ev *nativeEval) close() {
+	ev.mu.Lock()
+	ev.done = true
+	ev.mu.Unlock()
+}
+
+// Advance the interpreter until the frame at the given index returns.
+func (i *Interpreter) loop(base int) (result g.Value, errTrace *ErrorTrace) {
 
 	var err g.Error
 	for result == nil {
-		result, err = i.advance(0)
+		result, err = i.advance(base)
 		if err != nil {
-			result, errTrace = i.walkStack(makeErrorTrace(err, i.stackTrace()))
+			result, errTrace = i.walkStack(i.makeErrorTrace(err), base)
 			if errTrace != nil {
 				return nil, errTrace
 			}
@@ -75,10 +290,18 @@ func (i *Interpreter) run(
 	return result, nil
 }
 
-func (i *Interpreter) walkStack(errTrace *ErrorTrace) (g.Value, *ErrorTrace) {
+// Unwind the frames, down to and including the frame at the given index,
+// until we find an exception handler that can deal with the error.
+func (i *Interpreter) walkStack(errTrace *ErrorTrace, base int) (g.Value, *ErrorTrace) {
+
+	// fatal errors skip the exception handlers
+	if g.IsFatal(errTrace.Error) {
+		i.frames = i.frames[:base]
+		return nil, errTrace
+	}
 
 	// unwind the frames
-	for len(i.frames) > 0 {
+	for len(i.frames) > base {
 		frameIndex := len(i.frames) - 1
 		f := i.frames[frameIndex]
 		instPtr := f.ip
@@ -96,7 +319,7 @@ func (i *Interpreter) walkStack(errTrace *ErrorTrace) (g.Value, *ErrorTrace) {
 					cres, cerr := i.runTryClause(f, frameIndex)
 					if cerr != nil {
 						// save the error
-						errTrace = makeErrorTrace(cerr, i.stackTrace())
+						errTrace = i.makeErrorTrace(cerr)
 
 						// run finally clause
 						if eh.Finally != -1 {
@@ -104,10 +327,10 @@ func (i *Interpreter) walkStack(errTrace *ErrorTrace) (g.Value, *ErrorTrace) {
 							fres, ferr := i.runTryClause(f, frameIndex)
 							if ferr != nil {
 								// save the error
-								errTrace = makeErrorTrace(ferr, i.stackTrace())
+								errTrace = i.makeErrorTrace(ferr)
 							} else if fres != nil {
 								// stop unwinding the stack
-								return fres, nil
+								return i.returnFrom(frameIndex, base, fres), nil
 							}
 						}
 
@@ -119,15 +342,18 @@ func (i *Interpreter) walkStack(errTrace *ErrorTrace) (g.Value, *ErrorTrace) {
 							fres, ferr := i.runTryClause(f, frameIndex)
 							if ferr != nil {
 								// save the error
-								errTrace = makeErrorTrace(ferr, i.stackTrace())
+								errTrace = i.makeErrorTrace(ferr)
 							} else if fres != nil {
 								// stop unwinding the stack
-								return fres, nil
+								return i.returnFrom(frameIndex, base, fres), nil
 							}
 						}
 
 						// done!
-						return cres, nil
+						if cres != nil {
+							return i.returnFrom(frameIndex, base, cres), nil
+						}
+						return nil, nil
 					}
 				} else {
 					g.Assert(eh.Finally != -1, "invalid try")
@@ -135,10 +361,10 @@ func (i *Interpreter) walkStack(errTrace *ErrorTrace) (g.Value, *ErrorTrace) {
 					fres, ferr := i.runTryClause(f, frameIndex)
 					if ferr != nil {
 						// save the error
-						errTrace = makeErrorTrace(ferr, i.stackTrace())
+						errTrace = i.makeErrorTrace(ferr)
 					} else if fres != nil {
 						// stop unwinding the stack
-						return fres, nil
+						return i.returnFrom(frameIndex, base, fres), nil
 					}
 				}
 			}
@@ -151,6 +377,23 @@ func (i *Interpreter) walkStack(errTrace *ErrorTrace) (g.Value, *ErrorTrace) {
 	return nil, errTrace
 }
 
+// A 'catch' or 'finally' clause executed a 'return'.  If the clause
+// belongs to the base frame, then the result is final.  Otherwise, pop
+// the clause's frame and hand the result to the frame that invoked it.
+func (i *Interpreter) returnFrom(frameIndex int, base int, result g.Value) g.Value {
+
+	if frameIndex == base {
+		return result
+	}
+
+	i.frames = i.frames[:frameIndex]
+	f := i.frames[frameIndex-1]
+	f.stack = append(f.stack, result)
+	f.ip += 3
+
+	return nil
+}
+
 func (i *Interpreter) runTryClause(f *frame, frameIndex int) (g.Value, g.Error) {
 
 	opc := f.fn.Template().OpCodes
@@ -166,20 +409,139 @@ func (i *Interpreter) runTryClause(f *frame, frameIndex int) (g.Value, g.Error)
 	return nil, nil
 }
 
-func (i *Interpreter) stackTrace() []string {
+// Create an ErrorTrace for an error that has just occurred.  If the error
+// escaped from a function that was evaluated on behalf of a native function,
+// then we reuse the stack trace that was created when the error was thrown.
+func (i *Interpreter) makeErrorTrace(err g.Error) *ErrorTrace {
+
+	if i.evalTrace != nil {
+		errTrace := i.evalTrace
+		i.evalTrace = nil
+		if errTrace.Error == err {
+			return errTrace
+		}
+	}
+
+	errTrace := makeErrorTrace(err, i.stackFrames())
+	if i.tracer != nil {
+		i.tracer.Throw(i, errTrace)
+	}
+	return errTrace
+}
+
+func (i *Interpreter) stackFrames() []*StackFrame {
 
 	n := len(i.frames)
-	stack := []string{}
+	stack := []*StackFrame{}
 
 	for j := n - 1; j >= 0; j-- {
-		tpl := i.frames[j].fn.Template()
-		lineNum := tpl.LineNumber(i.frames[j].ip)
-		stack = append(stack, fmt.Sprintf("    at line %d", lineNum))
+		f := i.frames[j]
+		tpl := f.fn.Template()
+		stack = append(stack, &StackFrame{
+			tpl.Name,
+			f.fn.Module().Name,
+			tpl.LineNumber(f.ip),
+			tpl.ColNumber(f.ip)})
 	}
 
 	return stack
 }
 
+// Create a frame for an invocation of a function, after binding the params.
+// The positional params are bound in order, and the named params, if any,
+// are bound to the params that have the same names.  The surplus positional
+// params of a variadic function are collected into a List.  If any optional
+// params were omitted, the frame begins at the prologue, which evaluates
+// their default values.
+func newFrame(fn g.BytecodeFunc, params []g.Value, named g.Struct) (*frame, g.Error) {
+
+	tpl := fn.Template()
+	var names []string
+	if named != nil {
+		names = sortedKeys(named)
+	}
+	// too few positional params are not an error if there are named
+	// params, which are checked once they have been bound
+	if err := tpl.CheckArity(len(params)); err != nil &&
+		(len(names) == 0 || len(params) > tpl.MinArity) {
+		return nil, err
+	}
+
+	n := tpl.Arity
+	var rest g.List
+	if tpl.IsVariadic() {
+		n--
+		values := []g.Value{}
+		if len(params) > n {
+			values = append(values, params[n:]...)
+			params = params[:n]
+		}
+		rest = g.NewList(values)
+	}
+
+	locals := newLocals(tpl.NumLocals, params)
+	if rest != nil {
+		locals[n].Val = rest
+	}
+
+	// keep track of which params were bound, if they are not simply
+	// the ones that precede the first omitted param
+	var bound []bool
+	if len(names) > 0 {
+		bound = make([]bool, n)
+		for j := range params {
+			bound[j] = true
+		}
+		for _, name := range names {
+			j := tpl.ParamIndex(name)
+			if j == -1 {
+				return nil, g.NoSuchParamError(name)
+			}
+			if bound[j] {
+				return nil, g.DuplicateParamError(name)
+			}
+			val, err := named.GetField(g.MakeStr(name))
+			if err != nil {
+				return nil, err
+			}
+			locals[j].Val = val
+			bound[j] = true
+		}
+		for j := 0; j < tpl.MinArity; j++ {
+			if !bound[j] {
+				return nil, g.MissingParamError(tpl.LocalNames[j])
+			}
+		}
+	}
+	isBound := func(j int) bool {
+		if bound == nil {
+			return j < len(params)
+		}
+		return bound[j]
+	}
+
+	// if any optional params were omitted, then push a flag for each
+	// of them, the first one on top, and begin at the prologue
+	f := &frame{fn, locals, []g.Value{}, 0}
+	for j := tpl.MinArity; j < n; j++ {
+		if !isBound(j) {
+			for k := n - 1; k >= tpl.MinArity; k-- {
+				f.stack = append(f.stack, g.MakeBool(!isBound(k)))
+			}
+			f.ip = tpl.Prologue
+			break
+		}
+	}
+	return f, nil
+}
+
+// The keys of a struct of named params, in a predictable order.
+func sortedKeys(named g.Struct) []string {
+	keys := named.Keys()
+	sort.Strings(keys)
+	return keys
+}
+
 func newLocals(numLocals int, params []g.Value) []*g.Ref {
 	p := len(params)
 	locals := make([]*g.Ref, numLocals, numLocals)
@@ -193,18 +555,124 @@ func newLocals(numLocals int, params []g.Value) []*g.Ref {
 	return locals
 }
 
-func (i *Interpreter) dump() {
+//---------------------------------------------------------------
+// Limits on execution.  The limits are shared with every goroutine
+// that is spawned, so the opcode budget is decremented atomically.
 
-	println("-----------------------------------------")
+// how often the context is polled
+const pollInterval = 1024
 
-	f := i.frames[len(i.frames)-1]
-	opc := f.fn.Template().OpCodes
-	print(g.FmtOpcode(opc, f.ip))
+type limits struct {
+	ctx        context.Context
+	maxOpcodes int64
+	remaining  int64
+}
+
+func newLimits(ctx context.Context, maxOpcodes int64) *limits {
+	return &limits{ctx, maxOpcodes, maxOpcodes}
+}
+
+// Account for the execution of one opcode.
+func (i *Interpreter) tick() g.Error {
+
+	lim := i.limits
+	if lim.maxOpcodes > 0 {
+		if atomic.AddInt64(&lim.remaining, -1) < 0 {
+			return g.BudgetExceededError(lim.maxOpcodes)
+		}
+	}
+
+	if i.ticks%pollInterval == 0 {
+		if err := lim.ctx.Err(); err != nil {
+			return g.CancelledError(err.Error())
+		}
+	}
+	i.ticks++
+
+	return nil
+}
+
+//---------------------------------------------------------------
+// Debugging
+
+// Debugger is notified before the interpreter executes each opcode.  The
+// interpreter waits for Before to return, so a debugger can pause the
+// program by not returning until it is told to continue.  If Before
+// returns an error, then the error is thrown by the opcode, which is
+// not executed.
+type Debugger interface {
+	Before(i *Interpreter) g.Error
+}
+
+// Spawner is implemented by a Debugger that wants to see the goroutines
+// that are spawned.  Spawn is called when a goroutine is spawned, and
+// returns the Debugger for the goroutine's interpreter, or nil.
+type Spawner interface {
+	Spawn() Debugger
+}
+
+// SetDebugger attaches a debugger to the interpreter.  The interpreters
+// of the goroutines that are spawned do not have a debugger, unless the
+// debugger is a Spawner.
+func (i *Interpreter) SetDebugger(d Debugger) {
+	i.debugger = d
+}
+
+// Debuggers combines several debuggers into one, so that more than one of
+// them can be attached to an interpreter.  Before calls each debugger in
+// turn, and stops at the first one that returns an error.  The goroutines
+// that are spawned see the debuggers that are Spawners.
+func Debuggers(ds ...Debugger) Debugger {
+	return debuggers(ds)
+}
+
+type debuggers []Debugger
+
+func (ds debuggers) Before(i *Interpreter) g.Error {
+	for _, d := range ds {
+		if err := d.Before(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	for j, f := range i.frames {
-		fmt.Printf("frame %d\n", j)
-		f.dump()
+func (ds debuggers) Spawn() Debugger {
+	spawned := debuggers{}
+	for _, d := range ds {
+		if s, ok := d.(Spawner); ok {
+			if sd := s.Spawn(); sd != nil {
+				spawned = append(spawned, sd)
+			}
+		}
 	}
+	if len(spawned) == 0 {
+		return nil
+	}
+	return spawned
+}
+
+// DebugFrame is a view of a frame on the stack, for debuggers.  IP
+// is the instruction pointer of the opcode that is about to be executed,
+// or of the INVOKE that is waiting for a function to return.  The
+// values must not be modified.
+type DebugFrame struct {
+	Func   g.BytecodeFunc
+	IP     int
+	Locals []*g.Ref
+	Stack  []g.Value
+}
+
+// NumFrames returns the number of frames on the stack.
+func (i *Interpreter) NumFrames() int {
+	return len(i.frames)
+}
+
+// DebugFrame returns the frame at the given depth, where 0 is the
+// innermost frame.
+func (i *Interpreter) DebugFrame(depth int) DebugFrame {
+	f := i.frames[len(i.frames)-1-depth]
+	return DebugFrame{f.fn, f.ip, f.locals, f.stack}
 }
 
 //---------------------------------------------------------------
@@ -217,27 +685,17 @@ type frame struct {
 	ip     int
 }
 
-func (f *frame) dump() {
-	fmt.Printf("    locals:\n")
-	for j, r := range f.locals {
-		fmt.Printf("        %d: %s\n", j, r.Val.ToStr())
-	}
-	fmt.Printf("    stack:\n")
-	for j, v := range f.stack {
-		fmt.Printf("        %d: %s\n", j, v.ToStr())
-	}
-	fmt.Printf("    ip: %d\n", f.ip)
-}
-
 //---------------------------------------------------------------
 // A combination of an error, and a stack trace
 
-func makeErrorTrace(err g.Error, stackTrace []string) *ErrorTrace {
+func makeErrorTrace(err g.Error, frames []*StackFrame) *ErrorTrace {
 
 	// make list-of-str
-	vals := make([]g.Value, len(stackTrace), len(stackTrace))
-	for i, s := range stackTrace {
-		vals[i] = g.MakeStr(s)
+	stackTrace := make([]string, len(frames), len(frames))
+	vals := make([]g.Value, len(frames), len(frames))
+	for i, f := range frames {
+		stackTrace[i] = f.String()
+		vals[i] = g.MakeStr(stackTrace[i])
 	}
 	// TODO make the list immutable
 	list := g.NewList(vals)
@@ -246,11 +704,33 @@ func makeErrorTrace(err g.Error, stackTrace []string) *ErrorTrace {
 	g.Assert(e == nil, "invalid struct")
 
 	merge := g.MergeStructs([]g.Struct{err.Struct(), stc})
-	return &ErrorTrace{err, stackTrace, merge}
+	return &ErrorTrace{err, stackTrace, frames, merge}
 }
 
+// ErrorTrace is an error that was thrown and not caught.  The
+// StackTrace is the formatted version of the Frames.
 type ErrorTrace struct {
 	Error      g.Error
 	StackTrace []string
+	Frames     []*StackFrame
 	Struct     g.Struct
 }
+
+// StackFrame is a function that was executing when an error was thrown,
+// and the position in the source code that it had reached.
+type StackFrame struct {
+	Func string
+	File string
+	Line int
+	Col  int
+}
+
+// String formats the frame like 'at foo (path/to/file.glm:12:7)'.
+// The file is left out if it is not known.
+func (f *StackFrame) String() string {
+	pos := fmt.Sprintf("%d:%d", f.Line, f.Col)
+	if f.File != "" {
+		pos = f.File + ":" + pos
+	}
+	return fmt.Sprintf("    at %s (%s)", f.Func, pos)
+}
@@ -15,6 +15,8 @@
 package interpreter
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"golem/analyzer"
 	"golem/compiler"
@@ -22,7 +24,10 @@ import (
 	"golem/parser"
 	"golem/scanner"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func assert(t *testing.T, flag bool) {
@@ -33,7 +38,7 @@ func assert(t *testing.T, flag bool) {
 
 func ok_expr(t *testing.T, source string, expect g.Value) {
 	mod := newCompiler(source).Compile()
-	intp := NewInterpreter(mod)
+	intp := NewInterpreter(mod, builtins, nil)
 
 	result, errTrace := intp.Init()
 	if errTrace != nil {
@@ -47,6 +52,12 @@ func ok_expr(t *testing.T, source string, expect g.Value) {
 	}
 }
 
+func ok_strs(t *testing.T, result []string, expect []string) {
+	if !reflect.DeepEqual(result, expect) {
+		t.Error(result, " != ", expect)
+	}
+}
+
 func ok_ref(t *testing.T, ref *g.Ref, expect g.Value) {
 	b := ref.Val.Eq(expect)
 	if !b.BoolVal() {
@@ -56,7 +67,7 @@ func ok_ref(t *testing.T, ref *g.Ref, expect g.Value) {
 
 func ok_mod(t *testing.T, source string, expectResult g.Value, expectRefs []*g.Ref) {
 	mod := newCompiler(source).Compile()
-	intp := NewInterpreter(mod)
+	intp := NewInterpreter(mod, builtins, nil)
 
 	result, errTrace := intp.Init()
 	if errTrace != nil {
@@ -76,7 +87,7 @@ func ok_mod(t *testing.T, source string, expectResult g.Value, expectRefs []*g.R
 func fail_expr(t *testing.T, source string, expect string) {
 
 	mod := newCompiler(source).Compile()
-	intp := NewInterpreter(mod)
+	intp := NewInterpreter(mod, builtins, nil)
 
 	result, errTrace := intp.Init()
 	if result != nil {
@@ -91,7 +102,7 @@ func fail_expr(t *testing.T, source string, expect string) {
 func fail(t *testing.T, source string, expectErr g.Error, expectErrTrace []string) *g.BytecodeModule {
 
 	mod := newCompiler(source).Compile()
-	intp := NewInterpreter(mod)
+	intp := NewInterpreter(mod, builtins, nil)
 
 	result, errTrace := intp.Init()
 	if result != nil {
@@ -112,7 +123,7 @@ func fail(t *testing.T, source string, expectErr g.Error, expectErrTrace []strin
 func failErr(t *testing.T, source string, expect g.Error) {
 
 	mod := newCompiler(source).Compile()
-	intp := NewInterpreter(mod)
+	intp := NewInterpreter(mod, builtins, nil)
 
 	result, errTrace := intp.Init()
 	if result != nil {
@@ -140,17 +151,33 @@ func newCompiler(source string) compiler.Compiler {
 	if err != nil {
 		panic(err.Error())
 	}
-	anl := analyzer.NewAnalyzer(mod)
+	anl := analyzer.NewAnalyzer(mod, builtins)
 	errors := anl.Analyze()
 	if len(errors) > 0 {
 		panic(fmt.Sprintf("%v", errors))
 	}
 
-	return compiler.NewCompiler(anl)
+	return &verifyingCompiler{compiler.NewCompiler(anl)}
 }
 
+// verifyingCompiler makes sure that every module compiled by these
+// tests passes the bytecode verifier.
+type verifyingCompiler struct {
+	compiler.Compiler
+}
+
+func (c *verifyingCompiler) Compile() *g.BytecodeModule {
+	mod := c.Compiler.Compile()
+	if err := g.VerifyModule(mod, len(builtins.Builtins())); err != nil {
+		panic(err.Error())
+	}
+	return mod
+}
+
+var builtins = g.NewBuiltinManager(g.StandardBuiltins)
+
 func interpret(mod *g.BytecodeModule) {
-	intp := NewInterpreter(mod)
+	intp := NewInterpreter(mod, builtins, nil)
 	_, errTrace := intp.Init()
 	if errTrace != nil {
 		fmt.Printf("%v\n", errTrace.Error)
@@ -496,6 +523,16 @@ let y = a(1);
 	//fmt.Println(source)
 	//fmt.Println(mod)
 
+	// captures that are not in alphabetical order
+	source = `
+let b = 'b';
+let a = 'a';
+let f = fn() { return b + a; };
+let c = f();
+`
+	mod = newCompiler(source).Compile()
+	interpret(mod)
+	ok_ref(t, mod.Refs[3], g.MakeStr("ba"))
 }
 
 func TestStruct(t *testing.T) {
@@ -637,8 +674,8 @@ let a = divide(3, 0);
 	fail(t, source,
 		g.DivideByZeroError(),
 		[]string{
-			"    at line 3",
-			"    at line 5"})
+			"    at divide (3:14)",
+			"    at <module> (5:9)"})
 
 	source = `
 let foo = fn(n) { n + n; };
@@ -647,7 +684,7 @@ let a = foo(5, 6);
 	fail(t, source,
 		g.ArityMismatchError("1", 2),
 		[]string{
-			"    at line 3"})
+			"    at <module> (3:9)"})
 }
 
 func TestPostfix(t *testing.T) {
@@ -988,20 +1025,57 @@ let a = assert(true);
 	interpret(mod)
 	ok_ref(t, mod.Refs[0], g.TRUE)
 
-	fail(t, "assert(1, 2);",
-		g.ArityMismatchError("1", 2),
+	fail(t, "assert(1, 2, 3);",
+		g.ArityMismatchError("1 or 2", 3),
 		[]string{
-			"    at line 1"})
+			"    at <module> (1:1)"})
 
 	fail(t, "assert(1);",
 		g.TypeMismatchError("Expected 'Bool'"),
 		[]string{
-			"    at line 1"})
+			"    at <module> (1:1)"})
 
 	fail(t, "assert(1 == 2);",
-		g.AssertionFailedError(),
+		g.AssertionFailedError(""),
+		[]string{
+			"    at <module> (1:1)"})
+
+	fail(t, "assert(1 == 2, 'one is not ' + 2);",
+		g.AssertionFailedError("one is not 2"),
+		[]string{
+			"    at <module> (1:1)"})
+
+	source = `
+assert(assertEq([1, 2.0], [1, 2]));
+assert(assertNe(1, '1', 'a message'));
+`
+	mod = newCompiler(source).Compile()
+	interpret(mod)
+
+	fail(t, "assertEq(1, 2);",
+		g.AssertionFailedError("values are not equal\nactual:   1\nexpected: 2"),
+		[]string{
+			"    at <module> (1:1)"})
+
+	fail(t, "assertEq([1, struct { a: 2 }], [1, struct { a: 3 }, 4], 'lists');",
+		g.AssertionFailedError("lists\n"+
+			"actual:   [ 1, struct { a: 2 } ]\n"+
+			"expected: [ 1, struct { a: 3 }, 4 ]\n"+
+			"differences:\n"+
+			"    [1].a: 2, expected 3\n"+
+			"    [2]: missing, expected 4"),
+		[]string{
+			"    at <module> (1:1)"})
+
+	fail(t, "assertEq(1);",
+		g.ArityMismatchError("2 or 3", 1),
 		[]string{
-			"    at line 1"})
+			"    at <module> (1:1)"})
+
+	fail(t, "assertNe('a', 'a');",
+		g.AssertionFailedError("values are equal\nvalue: a"),
+		[]string{
+			"    at <module> (1:1)"})
 }
 
 func TestTuple(t *testing.T) {
@@ -1093,12 +1167,12 @@ assert(values == 6);
 	source = "for (k, v)  in [1, 2, 3] {}"
 	fail(t, source,
 		g.TypeMismatchError("Expected 'Tuple'"),
-		[]string{"    at line 1"})
+		[]string{"    at <module> (1:16)"})
 
 	source = "for (a, b, c)  in [('a', 1), ('b', 2), ('c', 3)] {}"
 	fail(t, source,
 		g.InvalidArgumentError("Expected Tuple of length 3"),
-		[]string{"    at line 1"})
+		[]string{"    at <module> (1:19)"})
 }
 
 func TestSwitch(t *testing.T) {
@@ -1160,7 +1234,7 @@ func TestGetField(t *testing.T) {
 	source := "null.bogus;"
 	fail(t, source,
 		g.NullValueError(),
-		[]string{"    at line 1"})
+		[]string{"    at <module> (1:6)"})
 
 	err := g.NoSuchFieldError("bogus")
 
@@ -1196,7 +1270,7 @@ try {
 	mod := fail(t, source,
 		g.DivideByZeroError(),
 		[]string{
-			"    at line 4"})
+			"    at <module> (4:7)"})
 	ok_ref(t, mod.Refs[0], g.MakeInt(2))
 
 	source = `
@@ -1214,7 +1288,7 @@ try {
 	mod = fail(t, source,
 		g.DivideByZeroError(),
 		[]string{
-			"    at line 5"})
+			"    at <module> (5:11)"})
 	ok_ref(t, mod.Refs[0], g.MakeInt(3))
 
 	source = `
@@ -1234,7 +1308,7 @@ try {
 	mod = fail(t, source,
 		g.DivideByZeroError(),
 		[]string{
-			"    at line 6"})
+			"    at <module> (6:11)"})
 	ok_ref(t, mod.Refs[0], g.MakeInt(4))
 
 	source = `
@@ -1264,8 +1338,8 @@ try {
 	mod = fail(t, source,
 		g.DivideByZeroError(),
 		[]string{
-			"    at line 6",
-			"    at line 15"})
+			"    at b (6:15)",
+			"    at <module> (15:5)"})
 	ok_ref(t, mod.Refs[0], g.MakeInt(4))
 
 	source = `
@@ -1307,9 +1381,9 @@ try {
 }
 `
 	mod = fail(t, source,
-		g.ArityMismatchError("1", 3),
+		g.ArityMismatchError("1 or 2", 3),
 		[]string{
-			"    at line 3"})
+			"    at <module> (3:5)"})
 
 	source = `
 try {
@@ -1321,7 +1395,7 @@ try {
 	mod = fail(t, source,
 		g.DivideByZeroError(),
 		[]string{
-			"    at line 5"})
+			"    at <module> (5:6)"})
 }
 
 func TestCatch(t *testing.T) {
@@ -1332,7 +1406,7 @@ try {
 } catch e {
     assert(e.kind == "DivideByZero");
     assert(!(e has "msg"));
-    assert(e.stackTrace == ['    at line 3']);
+    assert(e.stackTrace == ['    at <module> (3:7)']);
 }
 `
 	mod := newCompiler(source).Compile()
@@ -1347,8 +1421,8 @@ try {
     }
 } catch e {
     assert(e.kind == "ArityMismatch");
-    assert(e.msg == "Expected 1 params, got 0");
-    assert(e.stackTrace == ['    at line 6']);
+    assert(e.msg == "Expected 1 or 2 params, got 0");
+    assert(e.stackTrace == ['    at <module> (6:9)']);
 }
 `
 	mod = newCompiler(source).Compile()
@@ -1369,6 +1443,20 @@ try {
 }
 assert(a == 1);
 assert(b == 2);
+`
+	mod = newCompiler(source).Compile()
+	interpret(mod)
+
+	source = `
+fn f() {
+    try {
+        3 / 0;
+    } catch e {
+        return 1;
+    }
+}
+let a = f();
+assert(a == 1);
 `
 	mod = newCompiler(source).Compile()
 	interpret(mod)
@@ -1436,7 +1524,7 @@ try {
     throw struct { foo: 'zork' };
 } catch e {
     assert(e.foo == 'zork');
-    assert(e.stackTrace == ['    at line 3']);
+    assert(e.stackTrace == ['    at <module> (3:5)']);
 }
 `
 	mod := newCompiler(source).Compile()
@@ -1473,6 +1561,138 @@ assert(c(1, 2) == 15);
 	interpret(mod)
 }
 
+func TestOptionalParams(t *testing.T) {
+
+	source := `
+let n = 0;
+fn next() {
+    n += 1;
+    return n;
+}
+fn a(x, y = x * 2, z = next()) {
+    return [x, y, z];
+}
+assert(a(1) == [1, 2, 1]);
+assert(a(1) == [1, 2, 2]);
+assert(a(1, 5) == [1, 5, 3]);
+assert(a(1, 5, 6) == [1, 5, 6]);
+assert(a(1, null) == [1, null, 4]);
+
+let b = fn(f = || => 3) { return f(); };
+assert(b() == 3);
+assert(b(|| => 4) == 4);
+
+let c = fn(list = []) {
+    list.add(1);
+    return list;
+};
+assert(c() == [1]);
+assert(c() == [1]);
+`
+	mod := newCompiler(source).Compile()
+	interpret(mod)
+
+	failErr(t, "fn(x, y = 1) {}();", g.ArityMismatchError("1 or 2", 0))
+	failErr(t, "fn(x, y = 1) {}(1, 2, 3);", g.ArityMismatchError("1 or 2", 3))
+	failErr(t, "fn(x = 1, y = 2, z = 3) {}(1, 2, 3, 4);", g.ArityMismatchError("0 to 3", 4))
+}
+
+func TestVariadic(t *testing.T) {
+
+	source := `
+fn a(x, rest...) {
+    return [x, rest];
+}
+assert(a(1) == [1, []]);
+assert(a(1, 2) == [1, [2]]);
+assert(a(1, 2, 3) == [1, [2, 3]]);
+
+let b = fn(x, y = 2, rest...) { return [x, y, rest]; };
+assert(b(1) == [1, 2, []]);
+assert(b(1, 3) == [1, 3, []]);
+assert(b(1, 3, 4, 5) == [1, 3, [4, 5]]);
+
+let c = chan();
+spawn fn(rest...) { c.send(rest); }(1, 2);
+assert(c.recv() == [1, 2]);
+`
+	mod := newCompiler(source).Compile()
+	interpret(mod)
+
+	failErr(t, "fn(x, rest...) {}();", g.ArityMismatchError("at least 1", 0))
+	failErr(t, "spawn fn(x, y) {}(1);", g.ArityMismatchError("2", 1))
+
+	// natives that call back into golem are checked too
+	mod = newCompiler("fn(x, y = x + 1, rest...) { return [x, y, rest]; };").Compile()
+	intp := NewInterpreter(mod, builtins, nil)
+	fn, errTrace := intp.Init()
+	assert(t, errTrace == nil)
+
+	result, err := intp.Eval(fn.(g.Func), []g.Value{g.ONE})
+	assert(t, err == nil)
+	ok_expr(t, "[1, 2, []];", result)
+
+	result, err = intp.Eval(fn.(g.Func), []g.Value{g.ONE, g.ONE, g.ONE})
+	assert(t, err == nil)
+	ok_expr(t, "[1, 1, [1]];", result)
+
+	_, err = intp.Eval(fn.(g.Func), []g.Value{})
+	assert(t, reflect.DeepEqual(err, g.ArityMismatchError("at least 1", 0)))
+}
+
+func TestNamedParams(t *testing.T) {
+
+	source := `
+fn a(x, y = 2, z = x + y) {
+    return [x, y, z];
+}
+assert(a(1, z: 5) == [1, 2, 5]);
+assert(a(1, y: 3) == [1, 3, 4]);
+assert(a(z: 0, x: 1) == [1, 2, 0]);
+assert(a(x: 1, y: 1, z: 1) == [1, 1, 1]);
+
+let b = fn(x, rest...) { return [x, rest]; };
+assert(b(x: 1) == [1, []]);
+
+let c = chan();
+spawn fn(x, y = 2) { c.send([x, y]); }(y: 3, x: 1);
+assert(c.recv() == [1, 3]);
+`
+	mod := newCompiler(source).Compile()
+	interpret(mod)
+
+	failErr(t, "fn(x) {}(y: 1);", g.NoSuchParamError("y"))
+	failErr(t, "fn(x) {}(1, x: 2);", g.DuplicateParamError("x"))
+	failErr(t, "fn(x, y = 1) {}(y: 2);", g.MissingParamError("x"))
+	failErr(t, "fn(x) {}(1, 2, y: 3);", g.ArityMismatchError("1", 2))
+	failErr(t, "fn(x, rest...) {}(1, rest: 2);", g.NoSuchParamError("rest"))
+	failErr(t, "spawn fn(x) {}(y: 1);", g.NoSuchParamError("y"))
+	failErr(t, "str(x: 1);", g.NoSuchParamError("x"))
+
+	// natives can opt into receiving the named params
+	options := g.NewNamedParamFunc(
+		func(ev g.Eval, values []g.Value, named g.Struct) (g.Value, g.Error) {
+			values = append([]g.Value{}, values...)
+			if named != nil {
+				values = append(values, named)
+			}
+			return g.NewList(values), nil
+		})
+
+	mod = newCompiler(`
+fn(options) {
+    assert(options(1) == [1]);
+    assert(options(1, timeout: 5, retries: 3) == [1, struct { timeout: 5, retries: 3 }]);
+};
+`).Compile()
+	intp := NewInterpreter(mod, builtins, nil)
+	fn, errTrace := intp.Init()
+	assert(t, errTrace == nil)
+
+	_, err := intp.Eval(fn.(g.Func), []g.Value{options})
+	assert(t, err == nil)
+}
+
 func TestSpawn(t *testing.T) {
 
 	source := `
@@ -1577,3 +1797,463 @@ pub fn main(args) {}
 	err = mod.Contents.SetField(g.MakeStr("main"), g.NEG_ONE)
 	failVal(t, nil, err, "ReadonlyField: Field 'main' is readonly")
 }
+
+type mapImporter map[string]g.Struct
+
+func (m mapImporter) Import(from *Interpreter, name string) (g.Struct, g.Error) {
+	if stc, ok := m[name]; ok {
+		return stc, nil
+	}
+	return nil, g.ImportFailedError(fmt.Sprintf("Module '%s' not found", name))
+}
+
+func TestImport(t *testing.T) {
+
+	foo := newStruct([]*g.StructEntry{{"a", true, false, g.ONE}})
+	importer := mapImporter{"foo": foo}
+
+	mod := newCompiler(`
+import foo;
+import foo as bar;
+let b = foo.a + bar.a;
+`).Compile()
+	intp := NewInterpreter(mod, builtins, importer)
+	_, errTrace := intp.Init()
+	assert(t, errTrace == nil)
+	assert(t, mod.Refs[0].Val == foo)
+	assert(t, mod.Refs[1].Val == foo)
+	ok_ref(t, mod.Refs[2], g.MakeInt(2))
+
+	mod = newCompiler("import foo; import baz;").Compile()
+	intp = NewInterpreter(mod, builtins, importer)
+	_, errTrace = intp.Init()
+	assert(t, errTrace.Error.Error() == "ImportFailed: Module 'baz' not found")
+
+	// without an importer, nothing can be imported
+	fail_expr(t, "import foo;", "ImportFailed: Module 'foo' not found")
+}
+
+func TestEval(t *testing.T) {
+
+	// apply(f, x) calls back into golem
+	apply := g.NewNativeFunc(
+		func(ev g.Eval, values []g.Value) (g.Value, g.Error) {
+			fn, ok := values[0].(g.Func)
+			if !ok {
+				return nil, g.TypeMismatchError("Expected 'Func'")
+			}
+			return ev.Eval(fn, values[1:])
+		})
+
+	source := `
+fn(apply) {
+    assert(apply(|x| => x * 2, 5) == 10);
+    assert(apply(apply, |x| => x + 1, 5) == 6);
+
+    let a = 0;
+    try {
+        apply(|x| => x / 0, 5);
+    } catch e {
+        assert(e.kind == "DivideByZero");
+        assert(e.stackTrace == ['    at <lambda> (8:24)', '    at <lambda> (8:9)']);
+        a = 1;
+    }
+    assert(a == 1);
+
+    let b = apply(fn(x) {
+        try {
+            return x / 0;
+        } catch e {
+            return -1;
+        }
+    }, 5);
+    assert(b == -1);
+
+    return apply(fn(x) {
+        let y = x + 1;
+        return y / 0;
+    }, 5);
+};
+`
+	mod := newCompiler(source).Compile()
+	intp := NewInterpreter(mod, builtins, nil)
+	fn, errTrace := intp.Init()
+	assert(t, errTrace == nil)
+
+	intp = NewInterpreter(mod, builtins, nil)
+	result, errTrace := intp.RunBytecode(fn.(g.BytecodeFunc), []g.Value{apply})
+	assert(t, result == nil)
+	assert(t, reflect.DeepEqual(errTrace.Error, g.DivideByZeroError()))
+	assert(t, reflect.DeepEqual(errTrace.StackTrace, []string{
+		"    at <lambda> (27:18)",
+		"    at <lambda> (25:12)"}))
+
+	result, err := intp.Eval(apply, []g.Value{fn, apply})
+	assert(t, result == nil)
+	assert(t, reflect.DeepEqual(err, g.DivideByZeroError()))
+
+	result, err = intp.Eval(fn.(g.Func), []g.Value{})
+	assert(t, result == nil)
+	assert(t, reflect.DeepEqual(err, g.ArityMismatchError("1", 0)))
+
+	// an error that a native function swallows does not leave its trace behind
+	var kept g.Error
+	keep := g.NewNativeFunc(
+		func(ev g.Eval, values []g.Value) (g.Value, g.Error) {
+			_, kept = ev.Eval(values[0].(g.Func), nil)
+			return g.NULL, nil
+		})
+	give := g.NewNativeFunc(
+		func(ev g.Eval, values []g.Value) (g.Value, g.Error) {
+			return nil, kept
+		})
+	source = `
+fn(keep, give) {
+    keep(|| => 1 / 0);
+    give();
+};
+`
+	mod = newCompiler(source).Compile()
+	fn, errTrace = NewInterpreter(mod, builtins, nil).Init()
+	assert(t, errTrace == nil)
+
+	intp = NewInterpreter(mod, builtins, nil)
+	result, errTrace = intp.RunBytecode(fn.(g.BytecodeFunc), []g.Value{keep, give})
+	assert(t, result == nil)
+	assert(t, errTrace.Error == kept)
+	assert(t, reflect.DeepEqual(errTrace.StackTrace, []string{
+		"    at <lambda> (4:5)"}))
+
+	// an Eval cannot be used twice at once, or after its native function returns
+	var outer g.Eval
+	twice := g.NewNativeFunc(
+		func(ev g.Eval, values []g.Value) (g.Value, g.Error) {
+			outer = ev
+			return ev.Eval(values[0].(g.Func), nil)
+		})
+	again := g.NewNativeFunc(
+		func(ev g.Eval, values []g.Value) (g.Value, g.Error) {
+			return outer.Eval(values[0].(g.Func), nil)
+		})
+	source = `
+fn(twice, again) {
+    try {
+        twice(|| => again(|| => 1));
+    } catch e {
+        assert(e.msg == "Eval is already in use");
+    }
+    again(|| => 1);
+};
+`
+	mod = newCompiler(source).Compile()
+	fn, errTrace = NewInterpreter(mod, builtins, nil).Init()
+	assert(t, errTrace == nil)
+
+	intp = NewInterpreter(mod, builtins, nil)
+	result, errTrace = intp.RunBytecode(fn.(g.BytecodeFunc), []g.Value{twice, again})
+	assert(t, result == nil)
+	assert(t, errTrace.Error.Error() ==
+		"InternalError: Eval was called after the native function returned")
+}
+
+func TestLimits(t *testing.T) {
+
+	source := `
+let a = 0;
+try {
+    while true { a++; }
+} catch e {
+    a = -1;
+} finally {
+    a = -2;
+}
+`
+	// budget
+	mod := newCompiler(source).Compile()
+	intp := NewInterpreter(mod, builtins, nil)
+	result, errTrace := intp.InitContext(context.Background(), 1000)
+	assert(t, result == nil)
+	assert(t, errTrace.Error.Kind() == g.BUDGET_EXCEEDED)
+	assert(t, errTrace.Error.Error() ==
+		"BudgetExceeded: Exceeded the limit of 1000 opcodes")
+	assert(t, reflect.DeepEqual(errTrace.StackTrace, []string{"    at <module> (4:19)"}))
+	assert(t, mod.Refs[0].Val.(g.Int).IntVal() > 0)
+
+	// timeout
+	mod = newCompiler(source).Compile()
+	intp = NewInterpreter(mod, builtins, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	result, errTrace = intp.InitContext(ctx, 0)
+	assert(t, result == nil)
+	assert(t, errTrace.Error.Kind() == g.CANCELLED)
+	assert(t, errTrace.Error.Error() == "Cancelled: context deadline exceeded")
+	assert(t, mod.Refs[0].Val.(g.Int).IntVal() > 0)
+
+	// the budget is enforced inside functions called back from native code
+	apply := g.NewNativeFunc(
+		func(ev g.Eval, values []g.Value) (g.Value, g.Error) {
+			return ev.Eval(values[0].(g.Func), values[1:])
+		})
+	source = `
+fn(apply) {
+    try {
+        apply(fn() { while true {} });
+    } catch e {
+        return 1;
+    }
+    return 2;
+};
+`
+	mod = newCompiler(source).Compile()
+	fn, errTrace := NewInterpreter(mod, builtins, nil).Init()
+	assert(t, errTrace == nil)
+
+	intp = NewInterpreter(mod, builtins, nil)
+	result, errTrace = intp.RunBytecodeContext(
+		context.Background(), 100, fn.(g.BytecodeFunc), []g.Value{apply})
+	assert(t, result == nil)
+	assert(t, errTrace.Error.Kind() == g.BUDGET_EXCEEDED)
+
+	// spawned goroutines run under the same context
+	type contextual interface {
+		Context() context.Context
+	}
+	spawned := make(chan context.Context)
+	record := g.NewNativeFunc(
+		func(ev g.Eval, values []g.Value) (g.Value, g.Error) {
+			spawned <- ev.(contextual).Context()
+			return g.NULL, nil
+		})
+	source = `
+fn(record) {
+    spawn record();
+};
+`
+	mod = newCompiler(source).Compile()
+	fn, errTrace = NewInterpreter(mod, builtins, nil).Init()
+	assert(t, errTrace == nil)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	intp = NewInterpreter(mod, builtins, nil)
+	_, errTrace = intp.RunBytecodeContext(
+		ctx, 0, fn.(g.BytecodeFunc), []g.Value{record})
+	assert(t, errTrace == nil)
+	assert(t, <-spawned == ctx)
+}
+
+// records the frames that it sees, and throws an error at a given line
+type testDebugger struct {
+	seen    []string
+	stopAt  int
+	opcodes int
+}
+
+func (d *testDebugger) Before(intp *Interpreter) g.Error {
+	d.opcodes++
+	f := intp.DebugFrame(0)
+	line := f.Func.Template().LineNumber(f.IP)
+	if line == d.stopAt {
+		names := []string{}
+		for j := 0; j < intp.NumFrames(); j++ {
+			df := intp.DebugFrame(j)
+			names = append(names, fmt.Sprintf("%s:%d:%d",
+				df.Func.Template().Name, len(df.Locals), len(df.Stack)))
+		}
+		d.seen = names
+		return g.CancelledError("stopped")
+	}
+	return nil
+}
+
+func TestDebugger(t *testing.T) {
+
+	source := `
+fn f(x) {
+    let y = x * 2;
+    return y;
+}
+let a = f(1);
+`
+	mod := newCompiler(source).Compile()
+	intp := NewInterpreter(mod, builtins, nil)
+	d := &testDebugger{nil, 4, 0}
+	intp.SetDebugger(d)
+
+	result, errTrace := intp.Init()
+	assert(t, result == nil)
+	assert(t, errTrace.Error.Kind() == g.CANCELLED)
+	assert(t, reflect.DeepEqual(errTrace.StackTrace,
+		[]string{"    at f (4:12)", "    at <module> (6:9)"}))
+	assert(t, reflect.DeepEqual(d.seen, []string{"f:2:1", "<module>:2:1"}))
+	assert(t, intp.NumFrames() == 0)
+
+	// the debugger sees every opcode
+	mod = newCompiler(source).Compile()
+	intp = NewInterpreter(mod, builtins, nil)
+	d = &testDebugger{nil, -1, 0}
+	intp.SetDebugger(d)
+	_, errTrace = intp.Init()
+	assert(t, errTrace == nil)
+	assert(t, d.opcodes == 15)
+
+	// several debuggers can be attached at once
+	mod = newCompiler(source).Compile()
+	intp = NewInterpreter(mod, builtins, nil)
+	d = &testDebugger{nil, -1, 0}
+	d2 := &testDebugger{nil, 4, 0}
+	intp.SetDebugger(Debuggers(d, d2))
+	_, errTrace = intp.Init()
+	assert(t, errTrace.Error.Kind() == g.CANCELLED)
+	assert(t, reflect.DeepEqual(d2.seen, []string{"f:2:1", "<module>:2:1"}))
+	assert(t, d.opcodes == d2.opcodes)
+}
+
+// A Tracer that records the events of each interpreter, and counts the opcodes.
+type testTracer struct {
+	mu      sync.Mutex
+	events  map[*Interpreter][]string
+	opcodes int
+}
+
+func (tt *testTracer) record(i *Interpreter, event string) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.events[i] = append(tt.events[i], event)
+}
+
+func (tt *testTracer) Opcode(i *Interpreter, frame DebugFrame, text string) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.opcodes++
+	if strings.HasSuffix(text, ": THROW") {
+		tt.events[i] = append(tt.events[i], fmt.Sprintf("THROW %v", traceValues(frame.Stack[1:])))
+	}
+}
+
+func (tt *testTracer) Call(i *Interpreter, fn g.Func, args []g.Value) {
+	tt.record(i, fmt.Sprintf("call %s %v", traceValue(fn), traceValues(args)))
+}
+
+func (tt *testTracer) Return(i *Interpreter, fn g.Func, result g.Value) {
+	tt.record(i, fmt.Sprintf("return %s %v", traceValue(fn), traceValues([]g.Value{result})))
+}
+
+func (tt *testTracer) Throw(i *Interpreter, errTrace *ErrorTrace) {
+	tt.record(i, fmt.Sprintf("throw %s %v", errTrace.Error.Error(), errTrace.StackTrace))
+}
+
+func (tt *testTracer) Spawn(i *Interpreter, fn g.Func, args []g.Value, child *Interpreter) {
+	tt.record(i, fmt.Sprintf("spawn %s %v", traceValue(fn), traceValues(args)))
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.events[child] = []string{}
+}
+
+func TestTracer(t *testing.T) {
+
+	source := `
+fn f(x) {
+    return x * 2;
+}
+fn g(c) {
+    c.send(f(3));
+}
+let c = chan();
+spawn g(c);
+let a = f(c.recv());
+try {
+    throw struct { msg: 'x' };
+} catch e {
+}
+`
+	mod := newCompiler(source).Compile()
+	intp := NewInterpreter(mod, builtins, nil)
+	tt := &testTracer{sync.Mutex{}, make(map[*Interpreter][]string), 0}
+	intp.SetTracer(tt)
+
+	_, errTrace := intp.Init()
+	assert(t, errTrace == nil)
+
+	ok_strs(t, tt.events[intp], []string{
+		"call fn <module> []",
+		"call fn <native> []",
+		"return fn <native> [chan]",
+		"spawn fn g [chan]",
+		"call fn <native> []",
+		"return fn <native> [6]",
+		"call fn f [6]",
+		"return fn f [12]",
+		"THROW [struct { msg: \"x\" }]",
+		"throw struct { msg: x } [    at <module> (12:5)]",
+		"return fn <module> [null]",
+	})
+
+	assert(t, len(tt.events) == 2)
+	for i, events := range tt.events {
+		if i != intp {
+			ok_strs(t, events, []string{
+				"call fn g [chan]",
+				"call fn f [3]",
+				"return fn f [6]",
+				"call fn <native> [6]",
+				"return fn <native> [null]",
+				"return fn g [null]",
+			})
+		}
+	}
+	assert(t, tt.opcodes > 0)
+}
+
+func TestJSONTracer(t *testing.T) {
+
+	source := `fn f(x) {
+    return x + 'b';
+}
+let a = f('a');`
+
+	mod := newCompiler(source).Compile()
+	mod.Name = "test.glm"
+	intp := NewInterpreter(mod, builtins, nil)
+	var buf bytes.Buffer
+	tracer := NewJSONTracer(&buf)
+	intp.SetTracer(tracer)
+
+	_, errTrace := intp.Init()
+	assert(t, errTrace == nil)
+	assert(t, tracer.Err() == nil)
+
+	ok_strs(t, strings.Split(buf.String(), "\n")[:10], []string{
+		`{"intp":1,"event":"call","depth":1,"func":"<module>","file":"test.glm"}`,
+		`{"intp":1,"event":"opcode","depth":1,"func":"<module>","file":"test.glm","ip":0,"op":"LOAD_NULL"}`,
+		`{"intp":1,"event":"opcode","depth":1,"func":"<module>","file":"test.glm","line":1,"ip":1,"op":"NEW_FUNC 0 1 (1)","stack":["null"]}`,
+		`{"intp":1,"event":"opcode","depth":1,"func":"<module>","file":"test.glm","line":1,"ip":4,"op":"STORE_LOCAL 0 0 (0)","stack":["null","fn f"]}`,
+		`{"intp":1,"event":"opcode","depth":1,"func":"<module>","file":"test.glm","line":4,"ip":7,"op":"LOAD_LOCAL 0 0 (0)","stack":["null"]}`,
+		`{"intp":1,"event":"opcode","depth":1,"func":"<module>","file":"test.glm","line":4,"ip":10,"op":"LOAD_CONST 0 0 (0)","stack":["null","fn f"]}`,
+		`{"intp":1,"event":"opcode","depth":1,"func":"<module>","file":"test.glm","line":4,"ip":13,"op":"INVOKE 0 1 (1)","stack":["null","fn f","\"a\""]}`,
+		`{"intp":1,"event":"call","depth":2,"func":"f","file":"test.glm","args":["\"a\""]}`,
+		`{"intp":1,"event":"opcode","depth":2,"func":"f","file":"test.glm","ip":0,"op":"LOAD_NULL"}`,
+		`{"intp":1,"event":"opcode","depth":2,"func":"f","file":"test.glm","line":2,"ip":1,"op":"LOAD_LOCAL 0 0 (0)","stack":["null"]}`,
+	})
+	assert(t, strings.HasSuffix(buf.String(),
+		`{"intp":1,"event":"return","depth":2,"func":"f","file":"test.glm","result":"\"ab\""}`+"\n"+
+			`{"intp":1,"event":"opcode","depth":1,"func":"<module>","file":"test.glm","line":4,"ip":16,"op":"STORE_LOCAL 0 1 (1)","stack":["null","\"ab\""]}`+"\n"+
+			`{"intp":1,"event":"opcode","depth":1,"func":"<module>","file":"test.glm","ip":19,"op":"RETURN","stack":["null"]}`+"\n"+
+			`{"intp":1,"event":"return","depth":1,"func":"<module>","file":"test.glm","result":"null"}`+"\n"))
+}
+
+func TestTraceValue(t *testing.T) {
+
+	source := `
+fn m() {}
+let s = struct { m: m, a: 'a' };
+let d = dict { 'k': [m, (1, 'b')] };
+[s, d, set { 'x' }, chan(), range(0, 2)];
+`
+	mod := newCompiler(source).Compile()
+	result, errTrace := NewInterpreter(mod, builtins, nil).Init()
+	assert(t, errTrace == nil)
+	ok_strs(t, []string{traceValue(result)}, []string{
+		`[ struct { m: fn m, a: "a" }, dict { "k": [ fn m, (1, "b") ] }, set { "x" }, chan, range<0, 2, 1> ]`})
+}
@@ -0,0 +1,293 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"bytes"
+	"encoding/json"
+	g "golem/core"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//---------------------------------------------------------------
+// Tracing
+
+// Tracer is notified of everything that an interpreter does, so that
+// executions can be logged, and compared with each other.  The
+// interpreters of spawned goroutines share the Tracer of the interpreter
+// that spawned them, so a Tracer must be safe for concurrent use.  The
+// slices that are passed to a Tracer must not be modified or kept.
+type Tracer interface {
+
+	// Opcode is called before each opcode is executed.  Text is the
+	// opcode, as formatted by core.FmtOpcode, and the frame's Stack is
+	// a snapshot of the operand stack.
+	Opcode(i *Interpreter, frame DebugFrame, text string)
+
+	// Call is called when a function is called, before it runs.  The
+	// named params, if any, follow the positional ones in a Struct.
+	Call(i *Interpreter, fn g.Func, args []g.Value)
+
+	// Return is called when a function returns.  A function that throws
+	// an error does not return.
+	Return(i *Interpreter, fn g.Func, result g.Value)
+
+	// Throw is called when an error is thrown, before it is caught.
+	Throw(i *Interpreter, errTrace *ErrorTrace)
+
+	// Spawn is called when a goroutine is spawned, with the interpreter
+	// that will run it.
+	Spawn(i *Interpreter, fn g.Func, args []g.Value, child *Interpreter)
+}
+
+// SetTracer attaches a tracer to the interpreter.
+func (i *Interpreter) SetTracer(t Tracer) {
+	i.tracer = t
+}
+
+func (i *Interpreter) traceOpcode() {
+	f := i.frames[len(i.frames)-1]
+	text := strings.TrimSpace(g.FmtOpcode(f.fn.Template().OpCodes, f.ip))
+	stack := append([]g.Value{}, f.stack...)
+	i.tracer.Opcode(i, DebugFrame{f.fn, f.ip, f.locals, stack}, text)
+}
+
+// The params of an invocation are traced with the struct
+// of named params, if there is one, at the end.
+func tracedParams(params []g.Value, named g.Struct) []g.Value {
+	if named == nil {
+		return params
+	}
+	return append(append([]g.Value{}, params...), named)
+}
+
+//---------------------------------------------------------------
+// JSONTracer
+
+// JSONTracer is a Tracer that writes each event as a JSON object, on a
+// line of its own.  Values are written as strings, the way that they
+// would appear in source code, except that functions are written as
+// 'fn <name>', and channels as 'chan', even inside lists, structs and
+// so on, so that traces of different runs can be compared.
+// Interpreters are numbered in the order that they are first seen.
+type JSONTracer struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	intps map[*Interpreter]int
+	err   error
+}
+
+type traceEvent struct {
+	Intp   int      `json:"intp"`
+	Event  string   `json:"event"`
+	Depth  int      `json:"depth"`
+	Func   string   `json:"func,omitempty"`
+	File   string   `json:"file,omitempty"`
+	Line   int      `json:"line,omitempty"`
+	IP     *int     `json:"ip,omitempty"`
+	Op     string   `json:"op,omitempty"`
+	Stack  []string `json:"stack,omitempty"`
+	Args   []string `json:"args,omitempty"`
+	Result *string  `json:"result,omitempty"`
+	Error  string   `json:"error,omitempty"`
+	Trace  []string `json:"trace,omitempty"`
+	Child  int      `json:"child,omitempty"`
+}
+
+// NewJSONTracer creates a JSONTracer that writes to w.
+func NewJSONTracer(w io.Writer) *JSONTracer {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &JSONTracer{sync.Mutex{}, enc, make(map[*Interpreter]int), nil}
+}
+
+// Err returns the first error that occurred while writing the trace.
+func (t *JSONTracer) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Opcode implements Tracer.
+func (t *JSONTracer) Opcode(i *Interpreter, frame DebugFrame, text string) {
+	ev := t.event(i, "opcode", frame.Func)
+	ip := frame.IP
+	ev.Line = frame.Func.Template().LineNumber(ip)
+	ev.IP = &ip
+	if n := strings.Index(text, ": "); n != -1 {
+		text = text[n+2:]
+	}
+	ev.Op = text
+	ev.Stack = traceValues(frame.Stack)
+	t.write(ev)
+}
+
+// Call implements Tracer.
+func (t *JSONTracer) Call(i *Interpreter, fn g.Func, args []g.Value) {
+	ev := t.event(i, "call", fn)
+	ev.Args = traceValues(args)
+	t.write(ev)
+}
+
+// Return implements Tracer.
+func (t *JSONTracer) Return(i *Interpreter, fn g.Func, result g.Value) {
+	ev := t.event(i, "return", fn)
+	s := traceValue(result)
+	ev.Result = &s
+	t.write(ev)
+}
+
+// Throw implements Tracer.
+func (t *JSONTracer) Throw(i *Interpreter, errTrace *ErrorTrace) {
+	ev := t.event(i, "throw", nil)
+	ev.Error = errTrace.Error.Error()
+	ev.Trace = errTrace.StackTrace
+	t.write(ev)
+}
+
+// Spawn implements Tracer.
+func (t *JSONTracer) Spawn(i *Interpreter, fn g.Func, args []g.Value, child *Interpreter) {
+	ev := t.event(i, "spawn", fn)
+	ev.Args = traceValues(args)
+	t.mu.Lock()
+	ev.Child = t.intp(child)
+	t.mu.Unlock()
+	t.write(ev)
+}
+
+func (t *JSONTracer) event(i *Interpreter, kind string, fn g.Value) *traceEvent {
+	t.mu.Lock()
+	ev := &traceEvent{Intp: t.intp(i), Event: kind, Depth: i.NumFrames()}
+	t.mu.Unlock()
+
+	switch f := fn.(type) {
+	case g.BytecodeFunc:
+		ev.Func = f.Template().Name
+		ev.File = f.Module().Name
+	case g.NativeFunc:
+		ev.Func = "<native>"
+	}
+	return ev
+}
+
+// The number of an interpreter.  The lock must be held.
+func (t *JSONTracer) intp(i *Interpreter) int {
+	n, ok := t.intps[i]
+	if !ok {
+		n = len(t.intps) + 1
+		t.intps[i] = n
+	}
+	return n
+}
+
+func (t *JSONTracer) write(ev *traceEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = t.enc.Encode(ev)
+	}
+}
+
+func traceValues(values []g.Value) []string {
+	strs := make([]string, len(values))
+	for j, v := range values {
+		strs[j] = traceValue(v)
+	}
+	return strs
+}
+
+// Values are traced the way that ToStr shows them, except that strings are
+// quoted, and that functions and channels, at any depth, are shown without
+// their address.
+func traceValue(v g.Value) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case g.Str:
+		return strconv.Quote(t.String())
+	case g.BytecodeFunc:
+		return "fn " + t.Template().Name
+	case g.NativeFunc:
+		return "fn <native>"
+	case g.Chan:
+		return "chan"
+
+	case g.List:
+		return "[" + traceEntries(nil, t.Values(), ",", " ") + " ]"
+
+	case g.Dict:
+		keys, values := []string{}, []g.Value{}
+		itr := t.NewIterator()
+		for itr.IterNext().BoolVal() {
+			entry, _ := itr.IterGet()
+			k, _ := entry.(g.Tuple).Get(g.ZERO)
+			v, _ := entry.(g.Tuple).Get(g.ONE)
+			keys, values = append(keys, traceValue(k)), append(values, v)
+		}
+		return "dict {" + traceEntries(keys, values, ",", " ") + " }"
+
+	case g.Set:
+		values := []g.Value{}
+		itr := t.NewIterator()
+		for itr.IterNext().BoolVal() {
+			v, _ := itr.IterGet()
+			values = append(values, v)
+		}
+		return "set {" + traceEntries(nil, values, ",", " ") + " }"
+
+	case g.Struct:
+		keys := t.Keys()
+		values := make([]g.Value, len(keys))
+		for j, k := range keys {
+			values[j], _ = t.GetField(g.MakeStr(k))
+		}
+		return "struct {" + traceEntries(keys, values, ",", " ") + " }"
+
+	// a Range is Getable and Lenable too, so it must come before Tuple
+	case g.Range:
+		return t.ToStr().String()
+
+	case g.Tuple:
+		values := make([]g.Value, t.Len().IntVal())
+		for j := range values {
+			values[j], _ = t.Get(g.MakeInt(int64(j)))
+		}
+		return "(" + traceEntries(nil, values, ", ", "") + ")"
+
+	default:
+		return v.ToStr().String()
+	}
+}
+
+// The entries of a composite value, each one preceded by a prefix, and
+// by its key if there are keys, and separated from each other.
+func traceEntries(keys []string, values []g.Value, sep string, prefix string) string {
+	var buf bytes.Buffer
+	for j, v := range values {
+		if j > 0 {
+			buf.WriteString(sep)
+		}
+		buf.WriteString(prefix)
+		if keys != nil {
+			buf.WriteString(keys[j])
+			buf.WriteString(": ")
+		}
+		buf.WriteString(traceValue(v))
+	}
+	return buf.String()
+}
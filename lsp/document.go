@@ -0,0 +1,491 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"fmt"
+	"golem"
+	"golem/analyzer"
+	"golem/ast"
+	g "golem/core"
+	"golem/parser"
+	"golem/scanner"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+//--------------------------------------------------------------
+// document
+
+// A document is an open source file that has been parsed and analyzed.
+// If there are syntax errors, then the statements that could not be
+// parsed are missing, but everything else can still be navigated.
+type document struct {
+	uri   string
+	text  string
+	lines []string
+	mod   *ast.FnExpr
+	diags []*golem.Diagnostic
+
+	// the identifiers that define variables, and every identifier
+	// that refers to a variable, in the order they were visited
+	defs   []*definition
+	idents []*ast.IdentExpr
+}
+
+// A definition is an identifier that defines a variable, and the
+// range of source code in which the variable is visible.
+type definition struct {
+	ident    *ast.IdentExpr
+	val      ast.Expr
+	isImport bool
+	begin    ast.Pos
+	end      ast.Pos
+}
+
+func newDocument(uri string, text string, builtins g.BuiltinManager) *document {
+
+	d := &document{uri, text, strings.Split(text, "\n"), nil, nil, nil, nil}
+
+	// Parse.  The partial module is analyzed even if there are syntax
+	// errors, but only the syntax errors are reported, since the
+	// missing statements would cause spurious analysis errors.
+	mod, err := parser.NewParser(scanner.NewScanner(text)).ParseModule()
+	if err != nil {
+		errors := []error{}
+		for _, e := range err.(parser.ErrorList) {
+			errors = append(errors, e)
+		}
+		d.diags = golem.Diagnostics(&golem.SyntaxError{uri, errors})
+	}
+	d.mod = mod
+
+	// An internal error while analyzing or indexing is reported as a
+	// diagnostic, and leaves the document with nothing to navigate.
+	defer func() {
+		if p := recover(); p != nil {
+			d.defs, d.idents = nil, nil
+			d.diags = []*golem.Diagnostic{{
+				"InternalError", fmt.Sprintf("internal error: %v", p),
+				uri, ast.Pos{1, 1}, ast.Pos{1, 1}, nil}}
+		}
+	}()
+
+	// analyze
+	errors := analyzer.NewAnalyzer(mod, builtins).Analyze()
+	if err == nil && len(errors) > 0 {
+		d.diags = golem.Diagnostics(&golem.AnalysisError{uri, errors})
+	}
+
+	// index
+	ix := &indexer{d, nil}
+	ix.visitBlock(mod.Body.Nodes,
+		ast.Pos{1, 1}, ast.Pos{math.MaxInt32, math.MaxInt32})
+
+	return d
+}
+
+// find the identifier at a position, if there is one
+func (d *document) identAt(p ast.Pos) *ast.IdentExpr {
+	for _, ident := range d.idents {
+		b, e := ident.Begin(), ident.End()
+		if p.Line == b.Line && p.Col >= b.Col && p.Col <= e.Col+1 {
+			return ident
+		}
+	}
+	return nil
+}
+
+// find where a variable is defined
+func (d *document) definitionOf(v *ast.Variable) *definition {
+	v = root(v)
+	for _, def := range d.defs {
+		if def.ident.Variable == v {
+			return def
+		}
+	}
+	return nil
+}
+
+// find every identifier that refers to the same variable,
+// including the identifier that defines it
+func (d *document) references(v *ast.Variable) []*ast.IdentExpr {
+	v = root(v)
+	refs := []*ast.IdentExpr{}
+	for _, ident := range d.idents {
+		if sameVariable(root(ident.Variable), v) {
+			refs = append(refs, ident)
+		}
+	}
+	return refs
+}
+
+// Follow a capture back to the variable that was captured.
+func root(v *ast.Variable) *ast.Variable {
+	for v.Captured != nil {
+		v = v.Captured
+	}
+	return v
+}
+
+// A new Variable is created for each use of a builtin,
+// so builtins are compared by index.
+func sameVariable(a *ast.Variable, b *ast.Variable) bool {
+	if a.IsBuiltin || b.IsBuiltin {
+		return a.IsBuiltin && b.IsBuiltin && a.Index == b.Index
+	}
+	return a == b
+}
+
+// Describe the variable that an identifier refers to, like so:
+//
+//	let a
+//	captured 1, from local 0
+func (d *document) describe(ident *ast.IdentExpr) string {
+
+	v := ident.Variable
+	decl := "let"
+	if v.IsConst {
+		decl = "const"
+	}
+
+	var where string
+	switch {
+	case v.IsBuiltin:
+		where = fmt.Sprintf("builtin %d", v.Index)
+	case v.IsCapture:
+		where = fmt.Sprintf("captured %d, from local %d", v.Index, root(v).Index)
+	default:
+		where = fmt.Sprintf("local %d", v.Index)
+	}
+
+	return fmt.Sprintf("%s %s\n%s", decl, ident.Symbol.Text, where)
+}
+
+// Complete an identifier at a position.  The variables that are visible
+// at the position come first, followed by the builtins that they do not hide.
+func (d *document) completeIdent(p ast.Pos, prefix string, builtins []*g.BuiltinEntry) []completionItem {
+
+	// the innermost definition of each name wins
+	visible := make(map[string]*definition)
+	for _, def := range d.defs {
+		if !before(p, def.begin) && !before(def.end, p) {
+			name := def.ident.Symbol.Text
+			if prev, ok := visible[name]; !ok || before(prev.begin, def.begin) {
+				visible[name] = def
+			}
+		}
+	}
+
+	items := []completionItem{}
+	for name, def := range visible {
+		if strings.HasPrefix(name, prefix) {
+			items = append(items, completionItem{name, def.kind(), d.describe(def.ident)})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+
+	for i, b := range builtins {
+		if _, ok := visible[b.Name]; !ok && strings.HasPrefix(b.Name, prefix) {
+			items = append(items, completionItem{
+				b.Name, kindFunction, fmt.Sprintf("const %s\nbuiltin %d", b.Name, i)})
+		}
+	}
+	return items
+}
+
+// Complete a field of the expression that ends at a position.
+func (d *document) completeField(p ast.Pos, prefix string) []completionItem {
+
+	// find the innermost expression that ends at the position
+	var operand ast.Expr
+	d.mod.Body.Traverse(walk(func(n ast.Node) {
+		if e, ok := n.(ast.Expr); ok && e.End() == p {
+			if operand == nil || !before(e.Begin(), operand.Begin()) {
+				operand = e
+			}
+		}
+	}))
+	if operand == nil {
+		return []completionItem{}
+	}
+
+	items := []completionItem{}
+	for _, item := range d.fields(operand, 0) {
+		if strings.HasPrefix(item.Label, prefix) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// The fields of an expression, if its type is known.  The types of
+// literals are known, and so are the types of the variables that are
+// initialized with them.
+func (d *document) fields(expr ast.Expr, depth int) []completionItem {
+
+	intrinsics := func(t g.Type) []completionItem {
+		items := []completionItem{}
+		for _, name := range g.IntrinsicNames(t) {
+			items = append(items, completionItem{name, kindMethod, t.String()})
+		}
+		return items
+	}
+
+	switch t := expr.(type) {
+
+	case *ast.ListExpr:
+		return intrinsics(g.TLIST)
+
+	case *ast.DictExpr:
+		return intrinsics(g.TDICT)
+
+	case *ast.SetExpr:
+		return intrinsics(g.TSET)
+
+	case *ast.InvokeExpr:
+		if ident, ok := t.Operand.(*ast.IdentExpr); ok &&
+			ident.Symbol.Text == "chan" && ident.Variable != nil && ident.Variable.IsBuiltin {
+			return intrinsics(g.TCHAN)
+		}
+
+	case *ast.StructExpr:
+		items := []completionItem{}
+		for _, k := range t.Keys {
+			items = append(items, completionItem{k.Text, kindField, "Struct"})
+		}
+		return items
+
+	case *ast.IdentExpr:
+		// guard against variables that are initialized with each other
+		if t.Variable == nil || depth > len(d.defs) {
+			return nil
+		}
+		if def := d.definitionOf(t.Variable); def != nil && def.val != nil {
+			return d.fields(def.val, depth+1)
+		}
+	}
+
+	return nil
+}
+
+func (def *definition) kind() int {
+	if def.isImport {
+		return kindModule
+	}
+	if _, ok := def.val.(*ast.FnExpr); ok {
+		return kindFunction
+	}
+	if def.ident.Variable.IsConst {
+		return kindConstant
+	}
+	return kindVariable
+}
+
+//--------------------------------------------------------------
+// positions
+
+// Convert an LSP position to a golem position.  Golem lines and
+// columns start at 1, and columns are counted in bytes.
+func (d *document) toPos(p position) ast.Pos {
+	line := d.line(p.Line)
+	n, units := 0, 0
+	for n < len(line) && units < p.Character {
+		r, size := utf8.DecodeRuneInString(line[n:])
+		n += size
+		units += utf16Len(r)
+	}
+	return ast.Pos{p.Line + 1, n + 1}
+}
+
+// Convert a golem position to an LSP position.
+func (d *document) fromPos(p ast.Pos) position {
+	line := d.line(p.Line - 1)
+	n, units := 0, 0
+	for n < len(line) && n < p.Col-1 {
+		r, size := utf8.DecodeRuneInString(line[n:])
+		n += size
+		units += utf16Len(r)
+	}
+	return position{p.Line - 1, units}
+}
+
+// The LSP position just after the character at a golem position.
+func (d *document) after(p ast.Pos) position {
+	line := d.line(p.Line - 1)
+	size := 1
+	if p.Col-1 < len(line) {
+		_, size = utf8.DecodeRuneInString(line[p.Col-1:])
+	}
+	return d.fromPos(ast.Pos{p.Line, p.Col + size})
+}
+
+// The range of a node, or of a diagnostic.  Golem ranges are inclusive.
+func (d *document) toRange(begin ast.Pos, end ast.Pos) rng {
+	return rng{d.fromPos(begin), d.after(end)}
+}
+
+// The offset of a golem position in the text.
+func (d *document) offset(p ast.Pos) int {
+	n := 0
+	for i := 0; i < p.Line-1 && i < len(d.lines); i++ {
+		n += len(d.lines[i]) + 1
+	}
+	return n + p.Col - 1
+}
+
+func (d *document) line(n int) string {
+	if n < 0 || n >= len(d.lines) {
+		return ""
+	}
+	return d.lines[n]
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+func before(a ast.Pos, b ast.Pos) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Col < b.Col)
+}
+
+//--------------------------------------------------------------
+// indexer
+
+// The indexer records the definitions in a module, and the
+// identifiers that refer to them.  It keeps track of where each
+// enclosing scope ends, so that it knows where the definitions are visible.
+type indexer struct {
+	doc  *document
+	ends []ast.Pos
+}
+
+func (ix *indexer) Visit(node ast.Node) {
+	switch t := node.(type) {
+
+	case *ast.Block:
+		ix.visitBlock(t.Nodes, t.Begin(), t.End())
+
+	case *ast.FnExpr:
+		ix.push(t.End())
+		for j, p := range t.FormalParams {
+			if d := t.Default(j); d != nil {
+				ix.Visit(d)
+			}
+			ix.define(p, nil, false, t.Begin())
+		}
+		ix.Visit(t.Body)
+		ix.pop()
+
+	case *ast.Const:
+		ix.visitDecls(t.Decls, t.End())
+
+	case *ast.Let:
+		ix.visitDecls(t.Decls, t.End())
+
+	case *ast.NamedFn:
+		// the identifier was defined by the enclosing block
+		ix.Visit(t.Func)
+
+	case *ast.For:
+		ix.push(t.End())
+		for _, ident := range t.Idents {
+			ix.define(ident, nil, false, t.Begin())
+		}
+		ix.Visit(t.Iterable)
+		ix.Visit(t.Body)
+		ix.pop()
+
+	case *ast.Try:
+		ix.Visit(t.TryBlock)
+		if t.CatchToken != nil {
+			ix.push(t.CatchBlock.End())
+			ix.define(t.CatchIdent, nil, false, t.CatchBlock.Begin())
+			ix.Visit(t.CatchBlock)
+			ix.pop()
+		}
+		if t.FinallyToken != nil {
+			ix.Visit(t.FinallyBlock)
+		}
+
+	case *ast.Import:
+		ix.define(t.Ident, nil, true, t.End())
+
+	case *ast.IdentExpr:
+		if t.Variable != nil {
+			ix.doc.idents = append(ix.doc.idents, t)
+		}
+
+	default:
+		t.Traverse(ix)
+	}
+}
+
+// Named functions are visible throughout the block that defines them.
+func (ix *indexer) visitBlock(nodes []ast.Node, begin ast.Pos, end ast.Pos) {
+
+	ix.push(end)
+	for _, n := range nodes {
+		if nf, ok := n.(*ast.NamedFn); ok {
+			ix.define(nf.Ident, nf.Func, false, begin)
+		}
+	}
+	for _, n := range nodes {
+		ix.Visit(n)
+	}
+	ix.pop()
+}
+
+// Declared variables are visible after the end of their declaration.
+func (ix *indexer) visitDecls(decls []*ast.Decl, end ast.Pos) {
+	for _, d := range decls {
+		if d.Val != nil {
+			ix.Visit(d.Val)
+		}
+		ix.define(d.Ident, d.Val, false, end)
+	}
+}
+
+// Identifiers that could not be defined, and synthetic
+// identifiers, are skipped.
+func (ix *indexer) define(ident *ast.IdentExpr, val ast.Expr, isImport bool, begin ast.Pos) {
+	if ident.Variable == nil || strings.HasPrefix(ident.Symbol.Text, "#") {
+		return
+	}
+	ix.doc.defs = append(ix.doc.defs,
+		&definition{ident, val, isImport, begin, ix.ends[len(ix.ends)-1]})
+	ix.doc.idents = append(ix.doc.idents, ident)
+}
+
+func (ix *indexer) push(end ast.Pos) {
+	ix.ends = append(ix.ends, end)
+}
+
+func (ix *indexer) pop() {
+	ix.ends = ix.ends[:len(ix.ends)-1]
+}
+
+// walk calls a function for every node in a tree, parents first.
+type walk func(ast.Node)
+
+func (w walk) Visit(node ast.Node) {
+	w(node)
+	node.Traverse(w)
+}
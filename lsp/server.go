@@ -0,0 +1,340 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lsp implements a Language Server Protocol server for Golem.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golem/ast"
+	g "golem/core"
+	"io"
+	"log"
+)
+
+//--------------------------------------------------------------
+// Server
+
+// Server provides diagnostics, go-to-definition, find-references, hover
+// and completion for the Golem source files that an editor has open.
+// Requests are handled one at a time, in the order they are received.
+type Server struct {
+	builtins []*g.BuiltinEntry
+	manager  g.BuiltinManager
+	docs     map[string]*document
+	out      io.Writer
+	err      error
+	shutdown bool
+}
+
+// NewServer creates a Server for modules that can use the given builtins.
+func NewServer(builtins []*g.BuiltinEntry) *Server {
+	return &Server{
+		builtins,
+		g.NewBuiltinManager(builtins),
+		make(map[string]*document),
+		nil,
+		nil,
+		false}
+}
+
+// Serve reads messages from r, and writes messages to w, until the client
+// sends an 'exit' notification, or r is closed.  An error is returned if the
+// client did not send a 'shutdown' request first.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+
+	s.out = w
+	in := bufio.NewReader(r)
+
+	for {
+		content, err := readMessage(in)
+		if err != nil {
+			if err == io.EOF && s.shutdown {
+				return nil
+			}
+			return err
+		}
+
+		req := &request{}
+		if err := json.Unmarshal(content, req); err != nil {
+			s.reply(nil, nil, &responseError{parseError, err.Error()})
+		} else if req.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit before shutdown")
+			}
+			return nil
+		} else {
+			result, rerr := s.handle(req)
+			if req.ID != nil {
+				s.reply(req.ID, result, rerr)
+			}
+		}
+
+		if s.err != nil {
+			return s.err
+		}
+	}
+}
+
+func (s *Server) handle(req *request) (result interface{}, rerr *responseError) {
+
+	// An internal error fails the request, rather than the server.
+	defer func() {
+		if p := recover(); p != nil {
+			result, rerr = nil, &responseError{
+				internalError, fmt.Sprintf("internal error: %v", p)}
+		}
+	}()
+
+	if s.shutdown {
+		return nil, &responseError{invalidRequest, "the server has been shut down"}
+	}
+
+	switch req.Method {
+
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1, // full
+				"definitionProvider": true,
+				"referencesProvider": true,
+				"hoverProvider":      true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{"."},
+				},
+			},
+			"serverInfo": map[string]interface{}{
+				"name": "golem",
+			},
+		}, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		params := &didOpenParams{}
+		if err := decode(req, params); err != nil {
+			return nil, err
+		}
+		s.update(params.TextDocument.URI, params.TextDocument.Text)
+		return nil, nil
+
+	case "textDocument/didChange":
+		params := &didChangeParams{}
+		if err := decode(req, params); err != nil {
+			return nil, err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.update(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+
+	case "textDocument/didClose":
+		params := &didCloseParams{}
+		if err := decode(req, params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics",
+			&publishDiagnosticsParams{params.TextDocument.URI, []diagnostic{}})
+		return nil, nil
+
+	case "textDocument/definition":
+		params := &textDocumentPositionParams{}
+		if err := decode(req, params); err != nil {
+			return nil, err
+		}
+		return s.definition(params), nil
+
+	case "textDocument/references":
+		params := &referenceParams{}
+		if err := decode(req, params); err != nil {
+			return nil, err
+		}
+		return s.references(params), nil
+
+	case "textDocument/hover":
+		params := &textDocumentPositionParams{}
+		if err := decode(req, params); err != nil {
+			return nil, err
+		}
+		return s.hover(params), nil
+
+	case "textDocument/completion":
+		params := &textDocumentPositionParams{}
+		if err := decode(req, params); err != nil {
+			return nil, err
+		}
+		return s.completion(params), nil
+
+	default:
+		// notifications that are not supported are ignored
+		if req.ID == nil {
+			return nil, nil
+		}
+		return nil, &responseError{
+			methodNotFound, fmt.Sprintf("method '%s' is not supported", req.Method)}
+	}
+}
+
+// Re-analyze a document, and publish its diagnostics.
+func (s *Server) update(uri string, text string) {
+
+	doc := newDocument(uri, text, s.manager)
+	s.docs[uri] = doc
+
+	diags := []diagnostic{}
+	for _, d := range doc.diags {
+		diags = append(diags, diagnostic{
+			doc.toRange(d.Begin, d.End), severityError, d.Kind, "golem", d.Message})
+	}
+	s.notify("textDocument/publishDiagnostics", &publishDiagnosticsParams{uri, diags})
+}
+
+// find the identifier at a position in an open document
+func (s *Server) identAt(params *textDocumentPositionParams) (*document, *ast.IdentExpr) {
+	doc, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil, nil
+	}
+	return doc, doc.identAt(doc.toPos(params.Position))
+}
+
+func (s *Server) definition(params *textDocumentPositionParams) interface{} {
+
+	doc, ident := s.identAt(params)
+	if ident == nil {
+		return nil
+	}
+	def := doc.definitionOf(ident.Variable)
+	if def == nil {
+		return nil
+	}
+	return doc.location(def.ident)
+}
+
+func (s *Server) references(params *referenceParams) interface{} {
+
+	doc, ident := s.identAt(&params.textDocumentPositionParams)
+	if ident == nil {
+		return nil
+	}
+	def := doc.definitionOf(ident.Variable)
+
+	locs := []location{}
+	for _, ref := range doc.references(ident.Variable) {
+		if def != nil && ref == def.ident && !params.Context.IncludeDeclaration {
+			continue
+		}
+		locs = append(locs, doc.location(ref))
+	}
+	return locs
+}
+
+func (s *Server) hover(params *textDocumentPositionParams) interface{} {
+
+	doc, ident := s.identAt(params)
+	if ident == nil {
+		return nil
+	}
+	return &hover{
+		markupContent{"plaintext", doc.describe(ident)},
+		doc.toRange(ident.Begin(), ident.End())}
+}
+
+// Complete the identifier that ends at the cursor.  If the identifier
+// follows a '.', then the fields of the expression before the '.' are
+// completed instead.
+func (s *Server) completion(params *textDocumentPositionParams) interface{} {
+
+	doc, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	pos := doc.toPos(params.Position)
+	end := doc.offset(pos)
+	if end > len(doc.text) {
+		return []completionItem{}
+	}
+
+	// find the part of the identifier that has been typed so far
+	begin := end
+	for begin > 0 && isIdentByte(doc.text[begin-1]) {
+		begin--
+	}
+	prefix := doc.text[begin:end]
+	col := pos.Col - len(prefix)
+
+	if begin == 0 || doc.text[begin-1] != '.' {
+		return doc.completeIdent(pos, prefix, s.builtins)
+	}
+
+	// The statement that contains the '.' cannot be parsed yet, so
+	// it is terminated just before the '.', and the document is
+	// analyzed again.
+	text := doc.text[:begin-1] + ";" + doc.text[end:]
+	patched := newDocument(doc.uri, text, s.manager)
+	return patched.completeField(ast.Pos{pos.Line, col - 2}, prefix)
+}
+
+func (d *document) location(ident *ast.IdentExpr) location {
+	return location{d.uri, d.toRange(ident.Begin(), ident.End())}
+}
+
+func isIdentByte(b byte) bool {
+	return b == '_' ||
+		(b >= 'a' && b <= 'z') ||
+		(b >= 'A' && b <= 'Z') ||
+		(b >= '0' && b <= '9')
+}
+
+//--------------------------------------------------------------
+// messages
+
+func decode(req *request, params interface{}) *responseError {
+	if err := json.Unmarshal(req.Params, params); err != nil {
+		return &responseError{invalidParams, err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, rerr *responseError) {
+
+	resp := &response{"2.0", id, nil, rerr}
+	if rerr == nil {
+		b, err := json.Marshal(result)
+		if err != nil {
+			log.Printf("lsp: cannot encode result: %v", err)
+			resp.Error = &responseError{internalError, err.Error()}
+		} else {
+			resp.Result = b
+		}
+	}
+	s.send(resp)
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	s.send(&notification{"2.0", method, params})
+}
+
+// The first error that occurs while writing is remembered, and stops the server.
+func (s *Server) send(msg interface{}) {
+	if s.err == nil {
+		s.err = writeMessage(s.out, msg)
+	}
+}
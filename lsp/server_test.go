@@ -0,0 +1,265 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	g "golem/core"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func assert(t *testing.T, flag bool) {
+	if !flag {
+		t.Error("assertion failure")
+	}
+}
+
+func frame(content string) string {
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(content), content)
+}
+
+func req(id int, method string, params string) string {
+	return frame(fmt.Sprintf(
+		`{"jsonrpc":"2.0","id":%d,"method":"%s","params":%s}`, id, method, params))
+}
+
+func notif(method string, params string) string {
+	return frame(fmt.Sprintf(
+		`{"jsonrpc":"2.0","method":"%s","params":%s}`, method, params))
+}
+
+func open(uri string, text string) string {
+	b, _ := json.Marshal(text)
+	return notif("textDocument/didOpen",
+		fmt.Sprintf(`{"textDocument":{"uri":"%s","languageId":"golem","version":1,"text":%s}}`, uri, b))
+}
+
+func at(id int, method string, line int, char int) string {
+	return req(id, method, fmt.Sprintf(
+		`{"textDocument":{"uri":"file:///a.glm"},"position":{"line":%d,"character":%d},`+
+			`"context":{"includeDeclaration":true}}`, line, char))
+}
+
+// Run the server over a session, which is shut down cleanly, and
+// return the messages it wrote, re-encoded compactly.
+func serve(t *testing.T, session ...string) []string {
+
+	session = append(session, req(999, "shutdown", "null"), notif("exit", "null"))
+	var out bytes.Buffer
+	err := NewServer(g.StandardBuiltins).Serve(
+		strings.NewReader(strings.Join(session, "")), &out)
+	assert(t, err == nil)
+
+	msgs := []string{}
+	r := bufio.NewReader(&out)
+	for {
+		content, err := readMessage(r)
+		if err == io.EOF {
+			break
+		}
+		assert(t, err == nil)
+		var buf bytes.Buffer
+		assert(t, json.Compact(&buf, content) == nil)
+		msgs = append(msgs, buf.String())
+	}
+
+	// the last message is the reply to 'shutdown'
+	assert(t, msgs[len(msgs)-1] == `{"jsonrpc":"2.0","id":999,"result":null}`)
+	return msgs[:len(msgs)-1]
+}
+
+func okMessage(t *testing.T, msg string, expect string) {
+	if msg != expect {
+		t.Error(msg, " != ", expect)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+
+	msgs := serve(t,
+		req(1, "initialize", `{"capabilities":{}}`),
+		notif("initialized", "{}"),
+		req(2, "workspace/symbol", `{"query":""}`),
+		frame("{oops"))
+	assert(t, len(msgs) == 3)
+	assert(t, strings.HasPrefix(msgs[0],
+		`{"jsonrpc":"2.0","id":1,"result":{"capabilities":{"completionProvider":{"triggerCharacters":["."]},`))
+	okMessage(t, msgs[1], `{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"method 'workspace/symbol' is not supported"}}`)
+	assert(t, strings.HasPrefix(msgs[2], `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,`))
+
+	// exit without shutdown
+	var out bytes.Buffer
+	err := NewServer(g.StandardBuiltins).Serve(strings.NewReader(notif("exit", "null")), &out)
+	assert(t, err.Error() == "exit before shutdown")
+
+	// missing header
+	err = NewServer(g.StandardBuiltins).Serve(strings.NewReader("\r\n{}"), &out)
+	assert(t, err.Error() == "missing Content-Length header")
+}
+
+func TestDiagnostics(t *testing.T) {
+
+	msgs := serve(t,
+		open("file:///a.glm", "let a = 1 +;\nlet b = 2;"),
+		open("file:///b.glm", "let a = 1;\nconst a = 2;\nc;"),
+		notif("textDocument/didClose", `{"textDocument":{"uri":"file:///b.glm"}}`))
+	assert(t, len(msgs) == 3)
+
+	okMessage(t, msgs[0], `{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///a.glm","diagnostics":[`+
+		`{"range":{"start":{"line":0,"character":11},"end":{"line":0,"character":12}},"severity":1,"code":"UnexpectedToken","source":"golem","message":"Unexpected Token ';'"}]}}`)
+
+	okMessage(t, msgs[1], `{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///b.glm","diagnostics":[`+
+		`{"range":{"start":{"line":1,"character":6},"end":{"line":1,"character":7}},"severity":1,"code":"AlreadyDefined","source":"golem","message":"Symbol 'a' is already defined"},`+
+		`{"range":{"start":{"line":2,"character":0},"end":{"line":2,"character":1}},"severity":1,"code":"NotDefined","source":"golem","message":"Symbol 'c' is not defined"}]}}`)
+
+	okMessage(t, msgs[2], `{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///b.glm","diagnostics":[]}}`)
+}
+
+const source = `let a = 1;
+const b = [1, 2];
+fn f(x) {
+    return fn() { return a + x; };
+}
+println(a, b);
+let é = 3; é;
+`
+
+func TestNavigation(t *testing.T) {
+
+	msgs := serve(t,
+		open("file:///a.glm", source),
+		at(1, "textDocument/definition", 3, 25),
+		at(2, "textDocument/references", 3, 26),
+		at(3, "textDocument/hover", 3, 25),
+		at(4, "textDocument/hover", 3, 29),
+		at(5, "textDocument/hover", 5, 11),
+		at(6, "textDocument/hover", 5, 2),
+		at(7, "textDocument/definition", 5, 2),
+		at(8, "textDocument/definition", 2, 1),
+		at(9, "textDocument/definition", 6, 12))
+	assert(t, len(msgs) == 10)
+
+	okMessage(t, msgs[1], `{"jsonrpc":"2.0","id":1,"result":`+
+		`{"uri":"file:///a.glm","range":{"start":{"line":0,"character":4},"end":{"line":0,"character":5}}}}`)
+
+	okMessage(t, msgs[2], `{"jsonrpc":"2.0","id":2,"result":[`+
+		`{"uri":"file:///a.glm","range":{"start":{"line":0,"character":4},"end":{"line":0,"character":5}}},`+
+		`{"uri":"file:///a.glm","range":{"start":{"line":3,"character":25},"end":{"line":3,"character":26}}},`+
+		`{"uri":"file:///a.glm","range":{"start":{"line":5,"character":8},"end":{"line":5,"character":9}}}]}`)
+
+	okMessage(t, msgs[3], `{"jsonrpc":"2.0","id":3,"result":{"contents":{"kind":"plaintext","value":"let a\ncaptured 0, from local 1"},`+
+		`"range":{"start":{"line":3,"character":25},"end":{"line":3,"character":26}}}}`)
+
+	okMessage(t, msgs[4], `{"jsonrpc":"2.0","id":4,"result":{"contents":{"kind":"plaintext","value":"let x\ncaptured 1, from local 0"},`+
+		`"range":{"start":{"line":3,"character":29},"end":{"line":3,"character":30}}}}`)
+
+	okMessage(t, msgs[5], `{"jsonrpc":"2.0","id":5,"result":{"contents":{"kind":"plaintext","value":"const b\nlocal 2"},`+
+		`"range":{"start":{"line":5,"character":11},"end":{"line":5,"character":12}}}}`)
+
+	okMessage(t, msgs[6], `{"jsonrpc":"2.0","id":6,"result":{"contents":{"kind":"plaintext","value":"const println\nbuiltin 1"},`+
+		`"range":{"start":{"line":5,"character":0},"end":{"line":5,"character":7}}}}`)
+
+	// builtins, and positions that are not on an identifier
+	okMessage(t, msgs[7], `{"jsonrpc":"2.0","id":7,"result":null}`)
+	okMessage(t, msgs[8], `{"jsonrpc":"2.0","id":8,"result":null}`)
+
+	// characters are counted in UTF-16 code units
+	okMessage(t, msgs[9], `{"jsonrpc":"2.0","id":9,"result":`+
+		`{"uri":"file:///a.glm","range":{"start":{"line":6,"character":4},"end":{"line":6,"character":5}}}}`)
+}
+
+func labels(msg string) string {
+	var resp struct {
+		Result []completionItem
+	}
+	json.Unmarshal([]byte(msg), &resp)
+	names := []string{}
+	for _, item := range resp.Result {
+		names = append(names, item.Label)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestCompletion(t *testing.T) {
+
+	// each line is completed in turn
+	text := func(line string) string {
+		b, _ := json.Marshal(`let list = [1, 2];
+const s = struct { foo: 1, bar: 2 };
+fn f(p) {
+    let q = list;
+    ` + line + `
+}
+let after = 0;
+`)
+		return notif("textDocument/didChange", fmt.Sprintf(
+			`{"textDocument":{"uri":"file:///a.glm","version":2},"contentChanges":[{"text":%s}]}`, b))
+	}
+
+	msgs := serve(t,
+		open("file:///a.glm", ""),
+		text("pr"), at(1, "textDocument/completion", 4, 6),
+		text("q."), at(2, "textDocument/completion", 4, 6),
+		text("s.f"), at(3, "textDocument/completion", 4, 7),
+		text("dict {}."), at(4, "textDocument/completion", 4, 12),
+		text("chan().s"), at(5, "textDocument/completion", 4, 12),
+		text("q;"), at(6, "textDocument/completion", 3, 4))
+	assert(t, len(msgs) == 13)
+
+	okMessage(t, labels(msgs[2]), "print,println")
+	okMessage(t, labels(msgs[4]), "add,addAll,clear,isEmpty,contains,indexOf,join")
+	okMessage(t, labels(msgs[6]), "foo")
+	okMessage(t, labels(msgs[8]), "addAll,clear,isEmpty,containsKey")
+	okMessage(t, labels(msgs[10]), "send")
+
+	// the variables in scope, then the builtins
+	okMessage(t, labels(msgs[12]), "f,list,p,s,"+
+		"print,println,str,len,range,assert,merge,chan,assertEq,assertNe")
+}
+
+func TestInternalError(t *testing.T) {
+
+	// duplicate params are reported, and the server keeps going
+	msgs := serve(t,
+		open("file:///a.glm", "let f = fn(a, a) {};"),
+		at(1, "textDocument/hover", 0, 11))
+	assert(t, len(msgs) == 2)
+	okMessage(t, msgs[0], `{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///a.glm","diagnostics":[`+
+		`{"range":{"start":{"line":0,"character":14},"end":{"line":0,"character":15}},"severity":1,"code":"AlreadyDefined","source":"golem","message":"Symbol 'a' is already defined"}]}}`)
+
+	// a panic while handling a request is turned into an error response
+	var out bytes.Buffer
+	s := NewServer(g.StandardBuiltins)
+	s.out = &out
+	s.docs["file:///a.glm"] = nil
+	result, rerr := s.handle(&request{nil, "textDocument/hover", json.RawMessage(
+		`{"textDocument":{"uri":"file:///a.glm"},"position":{"line":0,"character":0}}`)})
+	assert(t, result == nil)
+	assert(t, rerr.Code == internalError)
+	assert(t, strings.HasPrefix(rerr.Message, "internal error: "))
+
+	// so is a result that cannot be encoded
+	id := json.RawMessage("7")
+	s.reply(&id, math.Inf(1), nil)
+	content, err := readMessage(bufio.NewReader(&out))
+	assert(t, err == nil)
+	okMessage(t, string(content), `{"jsonrpc":"2.0","id":7,"error":{"code":-32603,"message":"json: unsupported value: +Inf"}}`)
+}
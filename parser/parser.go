@@ -15,10 +15,13 @@
 package parser
 
 import (
+	"bytes"
 	"fmt"
 	"golem/ast"
 	"golem/scanner"
-	"runtime"
+	"sort"
+	"strings"
+	"unicode/utf8"
 )
 
 //--------------------------------------------------------------
@@ -29,25 +32,22 @@ type Parser struct {
 	cur       *ast.Token
 	next      *ast.Token
 	synthetic int
+	errors    ErrorList
+
+	// the number of open braces that have been consumed
+	braces int
 }
 
 func NewParser(scn *scanner.Scanner) *Parser {
-	return &Parser{scn, nil, nil, 0}
+	return &Parser{scn, nil, nil, 0, ErrorList{}, 0}
 }
 
-func (p *Parser) ParseModule() (fn *ast.FnExpr, err error) {
-
-	// In a recursive descent parser, errors can be generated deep
-	// in the call stack.  We are going to use panic-recover to handle them.
-	defer func() {
-		if r := recover(); r != nil {
-			if _, ok := r.(runtime.Error); ok {
-				panic(r)
-			}
-			fn = nil
-			err = r.(error)
-		}
-	}()
+// ParseModule parses an entire module.  The parser recovers from syntax
+// errors by skipping ahead to the next statement, so every syntax error in
+// the module is reported, as an ErrorList.  The module is returned even
+// if there are errors, but in that case it only contains the statements
+// that could be parsed.
+func (p *Parser) ParseModule() (*ast.FnExpr, error) {
 
 	// read the first two tokens
 	p.cur = p.advance()
@@ -55,11 +55,15 @@ func (p *Parser) ParseModule() (fn *ast.FnExpr, err error) {
 
 	// parse the module
 	nodes := p.nodeSequence(ast.EOF, true)
-	p.expect(ast.EOF)
 
 	params := []*ast.IdentExpr{}
 	block := &ast.Block{nil, nodes, nil}
-	return &ast.FnExpr{nil, params, block, 0, 0, nil}, err
+	fn := &ast.FnExpr{nil, params, nil, false, block, 0, 0, nil, nil, nil}
+
+	if len(p.errors) > 0 {
+		return fn, p.sortedErrors()
+	}
+	return fn, nil
 }
 
 func (p *Parser) parseExpression() (expr ast.Expr, err error) {
@@ -68,11 +72,9 @@ func (p *Parser) parseExpression() (expr ast.Expr, err error) {
 	// in the call stack.  We are going to use panic-recover to handle them.
 	defer func() {
 		if r := recover(); r != nil {
-			if _, ok := r.(runtime.Error); ok {
-				panic(r)
-			}
+			p.addError(toError(r))
 			expr = nil
-			err = r.(error)
+			err = p.sortedErrors()
 		}
 	}()
 
@@ -84,17 +86,28 @@ func (p *Parser) parseExpression() (expr ast.Expr, err error) {
 	expr = p.expression()
 	p.expect(ast.EOF)
 
-	return expr, err
+	if len(p.errors) > 0 {
+		return nil, p.sortedErrors()
+	}
+	return expr, nil
 }
 
 // Parse a statement, or return nil if there is no statement
-// waiting to be parsed.
-func (p *Parser) statement(allowPub bool) ast.Stmt {
+// waiting to be parsed.  'pub' and 'import' are only allowed
+// at the top level of a module.
+func (p *Parser) statement(isModule bool) ast.Stmt {
 
 	switch p.cur.Kind {
 
+	case ast.IMPORT:
+		if isModule {
+			return p.importStmt()
+		} else {
+			panic(p.unexpected())
+		}
+
 	case ast.PUB:
-		if allowPub {
+		if isModule {
 			p.consume()
 			switch p.cur.Kind {
 
@@ -109,10 +122,10 @@ func (p *Parser) statement(allowPub bool) ast.Stmt {
 					return p.namedFn(true)
 				} else {
 					p.expect(ast.FN)
-					panic(p.unexpected())
+					panic(p.unexpected(ast.IDENT))
 				}
 			default:
-				panic(p.unexpected())
+				panic(p.unexpected(ast.CONST, ast.LET, ast.FN))
 			}
 		} else {
 			panic(p.unexpected())
@@ -190,7 +203,7 @@ func (p *Parser) constStmt(isPub bool) *ast.Const {
 		case ast.SEMICOLON:
 			return &ast.Const{token, decls, p.consume(), isPub}
 		default:
-			panic(p.unexpected())
+			panic(p.unexpected(ast.COMMA, ast.SEMICOLON))
 		}
 	}
 }
@@ -208,7 +221,7 @@ func (p *Parser) letStmt(isPub bool) *ast.Let {
 		case ast.SEMICOLON:
 			return &ast.Let{token, decls, p.consume(), isPub}
 		default:
-			panic(p.unexpected())
+			panic(p.unexpected(ast.COMMA, ast.SEMICOLON))
 		}
 	}
 }
@@ -240,7 +253,7 @@ func (p *Parser) ifStmt() *ast.If {
 			return &ast.If{token, cond, then, p.ifStmt()}
 
 		default:
-			panic(p.unexpected())
+			panic(p.unexpected(ast.LBRACE, ast.IF))
 		}
 
 	} else {
@@ -268,7 +281,7 @@ func (p *Parser) forStmt() *ast.For {
 		idents = p.tupleIdents()
 
 	default:
-		panic(p.unexpected())
+		panic(p.unexpected(ast.IDENT, ast.LPAREN))
 	}
 
 	// parse 'in'
@@ -308,7 +321,7 @@ func (p *Parser) tupleIdents() []*ast.IdentExpr {
 				break loop
 
 			default:
-				panic(p.unexpected())
+				panic(p.unexpected(ast.COMMA, ast.RPAREN))
 			}
 		}
 
@@ -316,11 +329,11 @@ func (p *Parser) tupleIdents() []*ast.IdentExpr {
 		p.consume()
 
 	default:
-		panic(p.unexpected())
+		panic(p.unexpected(ast.IDENT, ast.RPAREN))
 	}
 
 	if len(idents) < 2 {
-		panic(&parserError{INVALID_FOR, lparen})
+		panic(newError(INVALID_FOR, lparen))
 	}
 
 	return idents
@@ -368,12 +381,12 @@ func (p *Parser) caseStmt() *ast.Case {
 			colon := p.expect(ast.COLON)
 			body := p.nodeSequenceAny(ast.CASE, ast.DEFAULT, ast.RBRACE)
 			if len(body) == 0 {
-				panic(&parserError{INVALID_SWITCH, colon})
+				panic(newError(INVALID_SWITCH, colon))
 			}
 			return &ast.Case{token, matches, body}
 
 		default:
-			panic(p.unexpected())
+			panic(p.unexpected(ast.COMMA, ast.COLON))
 		}
 	}
 }
@@ -385,7 +398,7 @@ func (p *Parser) defaultStmt() *ast.Default {
 
 	body := p.nodeSequence(ast.RBRACE, false)
 	if len(body) == 0 {
-		panic(&parserError{INVALID_SWITCH, colon})
+		panic(newError(INVALID_SWITCH, colon))
 	}
 
 	return &ast.Default{token, body}
@@ -450,7 +463,7 @@ func (p *Parser) tryStmt() *ast.Try {
 
 	// make sure we got at least one of try or catch
 	if catchToken == nil && finallyToken == nil {
-		panic(&parserError{INVALID_TRY, tryToken})
+		panic(newError(INVALID_TRY, tryToken))
 	}
 
 	// done
@@ -466,14 +479,29 @@ func (p *Parser) spawnStmt() *ast.Spawn {
 
 	prm := p.primary()
 	if p.cur.Kind != ast.LPAREN {
-		panic(p.unexpected())
+		panic(p.unexpected(ast.LPAREN))
 	}
-	lparen, actual, rparen := p.actualParams()
-	invocation := &ast.InvokeExpr{prm, lparen, actual, rparen}
+	lparen, actual, names, named, rparen := p.actualParams()
+	invocation := &ast.InvokeExpr{prm, lparen, actual, names, named, rparen}
 
 	return &ast.Spawn{token, invocation, p.expect(ast.SEMICOLON)}
 }
 
+func (p *Parser) importStmt() *ast.Import {
+
+	token := p.expect(ast.IMPORT)
+	name := p.expect(ast.IDENT)
+
+	// 'as' is only a keyword here, so it can still be used as a name
+	ident := &ast.IdentExpr{name, nil}
+	if p.cur.Kind == ast.IDENT && p.cur.Text == "as" {
+		p.consume()
+		ident = &ast.IdentExpr{p.expect(ast.IDENT), nil}
+	}
+
+	return &ast.Import{token, name, ident, p.expect(ast.SEMICOLON)}
+}
+
 // parse a sequence of nodes that are wrapped in curly braces
 func (p *Parser) block() *ast.Block {
 
@@ -484,25 +512,19 @@ func (p *Parser) block() *ast.Block {
 }
 
 // Parse a sequence of statements or expressions.
-func (p *Parser) nodeSequence(endKind ast.TokenKind, allowPub bool) []ast.Node {
+func (p *Parser) nodeSequence(endKind ast.TokenKind, isModule bool) []ast.Node {
 
 	nodes := []ast.Node{}
 
 	for {
-		if p.cur.Kind == endKind {
+		// if the input ends early, the caller will report it
+		if p.cur.Kind == endKind || p.cur.Kind == ast.EOF {
 			return nodes
 		}
 
-		// see if there is a statement on tap
-		var node ast.Node = p.statement(allowPub)
-
-		// if there isn't, read an expression instead
-		if node == nil {
-			node = p.expression()
-			p.expect(ast.SEMICOLON)
+		if node := p.node(isModule); node != nil {
+			nodes = append(nodes, node)
 		}
-
-		nodes = append(nodes, node)
 	}
 
 }
@@ -518,17 +540,86 @@ func (p *Parser) nodeSequenceAny(endKinds ...ast.TokenKind) []ast.Node {
 				return nodes
 			}
 		}
+		if p.cur.Kind == ast.EOF {
+			return nodes
+		}
 
-		// see if there is a statement on tap
-		var node ast.Node = p.statement(false)
+		if node := p.node(false); node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+}
 
-		// if there isn't, read an expression instead
-		if node == nil {
-			node = p.expression()
-			p.expect(ast.SEMICOLON)
+// Parse a statement or an expression.  If there is a syntax error, it is
+// recorded, the parser skips ahead to a point where it can resume,
+// and nil is returned.
+func (p *Parser) node(isModule bool) (node ast.Node) {
+
+	start, braces := p.cur, p.braces
+	defer func() {
+		if r := recover(); r != nil {
+			p.addError(toError(r))
+			p.synchronize(start, p.braces-braces)
+			node = nil
 		}
+	}()
+
+	// see if there is a statement on tap
+	node = p.statement(isModule)
 
-		nodes = append(nodes, node)
+	// if there isn't, read an expression instead
+	if node == nil {
+		node = p.expression()
+		p.expect(ast.SEMICOLON)
+	}
+
+	return node
+}
+
+// Skip tokens until we are at a statement boundary: just after a
+// semicolon, or at a closing brace or a keyword that begins a statement.
+// The blocks that the failed statement had opened are skipped over
+// entirely, as are any nested blocks.  At least one token is skipped if
+// the failed statement has not consumed anything yet, so that the
+// parser always makes progress.
+func (p *Parser) synchronize(start *ast.Token, depth int) {
+
+	if p.cur == start && p.cur.Kind != ast.EOF {
+		p.consume()
+	}
+
+	for {
+		switch p.cur.Kind {
+
+		case ast.EOF:
+			return
+
+		case ast.SEMICOLON:
+			p.consume()
+			if depth == 0 {
+				return
+			}
+
+		case ast.LBRACE:
+			p.consume()
+			depth++
+
+		case ast.RBRACE:
+			if depth == 0 {
+				return
+			}
+			p.consume()
+			depth--
+			if depth == 0 {
+				return
+			}
+
+		default:
+			if depth == 0 && isStatementKeyword(p.cur.Kind) {
+				return
+			}
+			p.consume()
+		}
 	}
 }
 
@@ -656,7 +747,7 @@ func (p *Parser) postfixExpr() ast.Expr {
 			p.consume()
 			exp = &ast.PostfixExpr{asn, tok}
 		} else {
-			panic(&parserError{INVALID_POSTFIX, p.cur})
+			panic(newError(INVALID_POSTFIX, p.cur))
 		}
 	}
 
@@ -671,8 +762,8 @@ func (p *Parser) primaryExpr() ast.Expr {
 		switch p.cur.Kind {
 
 		case ast.LPAREN:
-			lparen, actual, rparen := p.actualParams()
-			prm = &ast.InvokeExpr{prm, lparen, actual, rparen}
+			lparen, actual, names, named, rparen := p.actualParams()
+			prm = &ast.InvokeExpr{prm, lparen, actual, names, named, rparen}
 
 		case ast.LBRACKET:
 			lbracket := p.consume()
@@ -717,7 +808,7 @@ func (p *Parser) primaryExpr() ast.Expr {
 					}
 
 				default:
-					panic(p.unexpected())
+					panic(p.unexpected(ast.RBRACKET, ast.COLON))
 				}
 			}
 
@@ -749,7 +840,7 @@ func (p *Parser) primary() ast.Expr {
 			return p.tupleExpr(lparen, expr)
 
 		default:
-			panic(p.unexpected())
+			panic(p.unexpected(ast.RPAREN, ast.COMMA))
 		}
 
 	case p.cur.Kind == ast.IDENT:
@@ -759,9 +850,6 @@ func (p *Parser) primary() ast.Expr {
 			return p.identExpr()
 		}
 
-	case isBuiltIn(p.cur):
-		return &ast.BuiltinExpr{p.consume()}
-
 	case p.cur.Kind == ast.THIS:
 		return &ast.ThisExpr{p.consume(), nil}
 
@@ -802,24 +890,43 @@ func (p *Parser) fnExpr(token *ast.Token) *ast.FnExpr {
 	p.expect(ast.LPAREN)
 
 	params := []*ast.IdentExpr{}
+	defaults := []ast.Expr{}
+	variadic := false
 	switch p.cur.Kind {
 
 	case ast.IDENT:
-		params = append(params, p.identExpr())
 	loop:
 		for {
+			params = append(params, p.identExpr())
+
+			// optional params must follow the required ones,
+			// and the variadic param must be the last one
+			switch {
+			case p.cur.Kind == ast.EQ:
+				p.consume()
+				defaults = append(defaults, p.expression())
+
+			case p.cur.Kind == ast.ELLIPSIS:
+				p.consume()
+				variadic = true
+				p.expect(ast.RPAREN)
+				break loop
+
+			case len(defaults) > 0:
+				panic(p.unexpected(ast.EQ, ast.ELLIPSIS))
+			}
+
 			switch p.cur.Kind {
 
 			case ast.COMMA:
 				p.consume()
-				params = append(params, p.identExpr())
 
 			case ast.RPAREN:
 				p.consume()
 				break loop
 
 			default:
-				panic(p.unexpected())
+				panic(p.unexpected(ast.COMMA, ast.RPAREN))
 			}
 		}
 
@@ -827,10 +934,10 @@ func (p *Parser) fnExpr(token *ast.Token) *ast.FnExpr {
 		p.consume()
 
 	default:
-		panic(p.unexpected())
+		panic(p.unexpected(ast.IDENT, ast.RPAREN))
 	}
 
-	return &ast.FnExpr{token, params, p.block(), 0, 0, nil}
+	return &ast.FnExpr{token, params, defaults, variadic, p.block(), 0, 0, nil, nil, nil}
 }
 
 func (p *Parser) lambdaZero() *ast.FnExpr {
@@ -841,7 +948,7 @@ func (p *Parser) lambdaZero() *ast.FnExpr {
 	params := []*ast.IdentExpr{}
 	expr := p.expression()
 	block := &ast.Block{nil, []ast.Node{expr}, nil}
-	return &ast.FnExpr{token, params, block, 0, 0, nil}
+	return &ast.FnExpr{token, params, nil, false, block, 0, 0, nil, nil, nil}
 }
 
 func (p *Parser) lambdaOne() *ast.FnExpr {
@@ -850,7 +957,7 @@ func (p *Parser) lambdaOne() *ast.FnExpr {
 	params := []*ast.IdentExpr{&ast.IdentExpr{token, nil}}
 	expr := p.expression()
 	block := &ast.Block{nil, []ast.Node{expr}, nil}
-	return &ast.FnExpr{token, params, block, 0, 0, nil}
+	return &ast.FnExpr{token, params, nil, false, block, 0, 0, nil, nil, nil}
 }
 
 func (p *Parser) lambda() *ast.FnExpr {
@@ -875,7 +982,7 @@ func (p *Parser) lambda() *ast.FnExpr {
 				break loop
 
 			default:
-				panic(p.unexpected())
+				panic(p.unexpected(ast.COMMA, ast.PIPE))
 			}
 		}
 
@@ -883,14 +990,14 @@ func (p *Parser) lambda() *ast.FnExpr {
 		p.consume()
 
 	default:
-		panic(p.unexpected())
+		panic(p.unexpected(ast.IDENT, ast.PIPE))
 	}
 
 	p.expect(ast.EQ_GT)
 
 	expr := p.expression()
 	block := &ast.Block{nil, []ast.Node{expr}, nil}
-	return &ast.FnExpr{token, params, block, 0, 0, nil}
+	return &ast.FnExpr{token, params, nil, false, block, 0, 0, nil, nil, nil}
 }
 
 func (p *Parser) structExpr() ast.Expr {
@@ -926,7 +1033,7 @@ func (p *Parser) structExpr() ast.Expr {
 				break loop
 
 			default:
-				panic(p.unexpected())
+				panic(p.unexpected(ast.COMMA, ast.RBRACE))
 			}
 		}
 
@@ -934,7 +1041,7 @@ func (p *Parser) structExpr() ast.Expr {
 		rbrace = p.consume()
 
 	default:
-		panic(p.unexpected())
+		panic(p.unexpected(ast.IDENT, ast.RBRACE))
 	}
 
 	// done
@@ -978,7 +1085,7 @@ func (p *Parser) dictExpr() ast.Expr {
 				break loop
 
 			default:
-				panic(p.unexpected())
+				panic(p.unexpected(ast.COMMA, ast.RBRACE))
 			}
 		}
 	}
@@ -1004,7 +1111,7 @@ func (p *Parser) setExpr() ast.Expr {
 				p.consume()
 				elems = append(elems, p.expression())
 			default:
-				panic(p.unexpected())
+				panic(p.unexpected(ast.RBRACE, ast.COMMA))
 			}
 		}
 	}
@@ -1027,7 +1134,7 @@ func (p *Parser) listExpr() ast.Expr {
 				p.consume()
 				elems = append(elems, p.expression())
 			default:
-				panic(p.unexpected())
+				panic(p.unexpected(ast.RBRACKET, ast.COMMA))
 			}
 		}
 	}
@@ -1045,7 +1152,7 @@ func (p *Parser) tupleExpr(lparen *ast.Token, expr ast.Expr) ast.Expr {
 			p.consume()
 			elems = append(elems, p.expression())
 		default:
-			panic(p.unexpected())
+			panic(p.unexpected(ast.RPAREN, ast.COMMA))
 		}
 	}
 }
@@ -1065,32 +1172,49 @@ func (p *Parser) basicExpr() ast.Expr {
 	}
 }
 
-func (p *Parser) actualParams() (*ast.Token, []ast.Expr, *ast.Token) {
+// The positional params are followed by the named ones, e.g. 'f(x, timeout: 5)'.
+func (p *Parser) actualParams() (*ast.Token, []ast.Expr, []*ast.Token, []ast.Expr, *ast.Token) {
 
 	lparen := p.expect(ast.LPAREN)
 
 	params := []ast.Expr{}
-	switch p.cur.Kind {
+	names := []*ast.Token{}
+	named := []ast.Expr{}
+	if p.cur.Kind == ast.RPAREN {
+		return lparen, params, names, named, p.consume()
+	}
 
-	case ast.RPAREN:
-		return lparen, params, p.consume()
+	for {
+		switch {
 
-	default:
-		params = append(params, p.expression())
-		for {
-			switch p.cur.Kind {
+		case p.cur.Kind == ast.IDENT && p.next.Kind == ast.COLON:
+			for _, n := range names {
+				if n.Text == p.cur.Text {
+					panic(newError(DUPLICATE_PARAM, p.cur))
+				}
+			}
+			names = append(names, p.consume())
+			p.consume()
+			named = append(named, p.expression())
 
-			case ast.COMMA:
-				p.consume()
-				params = append(params, p.expression())
+		case len(names) > 0:
+			// a positional param cannot follow a named one
+			panic(p.unexpected(ast.IDENT))
 
-			case ast.RPAREN:
-				return lparen, params, p.consume()
+		default:
+			params = append(params, p.expression())
+		}
 
-			default:
-				panic(p.unexpected())
-			}
+		switch p.cur.Kind {
+
+		case ast.COMMA:
+			p.consume()
+
+		case ast.RPAREN:
+			return lparen, params, names, named, p.consume()
 
+		default:
+			panic(p.unexpected(ast.COMMA, ast.RPAREN))
 		}
 	}
 }
@@ -1112,17 +1236,25 @@ func (p *Parser) expect(kind ast.TokenKind) *ast.Token {
 		p.consume()
 		return result
 	} else {
-		panic(p.unexpected())
+		panic(p.unexpected(kind))
 	}
 }
 
 // consume the current token
 func (p *Parser) consume() *ast.Token {
 	result := p.cur
+	switch result.Kind {
+	case ast.LBRACE:
+		p.braces++
+	case ast.RBRACE:
+		p.braces--
+	}
 	p.cur, p.next = p.next, p.advance()
 	return result
 }
 
+// The scanner stops at the first bad token, so the error is recorded,
+// and the input is treated as if it ended there.
 func (p *Parser) advance() *ast.Token {
 
 	tok := p.scn.Next()
@@ -1130,27 +1262,61 @@ func (p *Parser) advance() *ast.Token {
 		switch tok.Kind {
 
 		case ast.UNEXPECTED_CHAR:
-			panic(&parserError{UNEXPECTED_CHAR, tok})
+			p.addError(newError(UNEXPECTED_CHAR, tok))
 
 		case ast.UNEXPECTED_EOF:
-			panic(&parserError{UNEXPECTED_EOF, tok})
+			p.addError(newError(UNEXPECTED_EOF, tok))
 
 		default:
 			panic("unreachable")
 		}
+		return &ast.Token{ast.EOF, "", tok.Position}
 	}
 	return tok
 }
 
 // create a error that we will panic with
-func (p *Parser) unexpected() error {
+func (p *Parser) unexpected(expected ...ast.TokenKind) *Error {
+
+	var e *Error
 	switch p.cur.Kind {
 	case ast.EOF:
-		return &parserError{UNEXPECTED_EOF, p.cur}
+		e = newError(UNEXPECTED_EOF, p.cur)
 
 	default:
-		return &parserError{UNEXPECTED_TOKEN, p.cur}
+		e = newError(UNEXPECTED_TOKEN, p.cur)
+	}
+	e.Expected = expected
+	return e
+}
+
+// Record an error.  Only the first error at a given position is kept,
+// since any others are usually caused by the first one.
+func (p *Parser) addError(e *Error) {
+	for _, other := range p.errors {
+		if other.Begin == e.Begin {
+			return
+		}
+	}
+	p.errors = append(p.errors, e)
+}
+
+// The errors are not necessarily recorded in order, since the
+// scanner runs one token ahead of the parser.
+func (p *Parser) sortedErrors() ErrorList {
+	sort.SliceStable(p.errors, func(i, j int) bool {
+		a, b := p.errors[i].Begin, p.errors[j].Begin
+		return a.Line < b.Line || (a.Line == b.Line && a.Col < b.Col)
+	})
+	return p.errors
+}
+
+// convert a recovered panic into an Error
+func toError(r interface{}) *Error {
+	if e, ok := r.(*Error); ok {
+		return e
 	}
+	panic(r)
 }
 
 // make a synthetic identifier
@@ -1209,6 +1375,17 @@ func isMultiplicative(t *ast.Token) bool {
 	}
 }
 
+func isStatementKeyword(kind ast.TokenKind) bool {
+	switch kind {
+	case ast.IMPORT, ast.PUB, ast.CONST, ast.LET,
+		ast.IF, ast.WHILE, ast.FOR, ast.SWITCH, ast.CASE, ast.DEFAULT,
+		ast.BREAK, ast.CONTINUE, ast.RETURN, ast.THROW, ast.TRY, ast.SPAWN:
+		return true
+	default:
+		return false
+	}
+}
+
 func isUnary(t *ast.Token) bool {
 
 	switch t.Kind {
@@ -1256,23 +1433,6 @@ func isAssignOp(t *ast.Token) bool {
 	}
 }
 
-func isBuiltIn(t *ast.Token) bool {
-	switch t.Kind {
-	case
-		ast.FN_PRINT,
-		ast.FN_PRINTLN,
-		ast.FN_STR,
-		ast.FN_LEN,
-		ast.FN_RANGE,
-		ast.FN_ASSERT,
-		ast.FN_MERGE,
-		ast.FN_CHAN:
-		return true
-	default:
-		return false
-	}
-}
-
 func fromAssignOp(t *ast.Token) *ast.Token {
 
 	switch t.Kind {
@@ -1303,51 +1463,131 @@ func fromAssignOp(t *ast.Token) *ast.Token {
 }
 
 //--------------------------------------------------------------
-// parserError
+// Error
 
-type parserErrorKind int
+type ErrorKind int
 
 const (
-	UNEXPECTED_CHAR parserErrorKind = iota
+	UNEXPECTED_CHAR ErrorKind = iota
 	UNEXPECTED_TOKEN
 	UNEXPECTED_EOF
 	INVALID_POSTFIX
 	INVALID_FOR
 	INVALID_SWITCH
 	INVALID_TRY
+	DUPLICATE_PARAM
 )
 
-type parserError struct {
-	kind  parserErrorKind
-	token *ast.Token
+func (k ErrorKind) String() string {
+
+	switch k {
+
+	case UNEXPECTED_CHAR:
+		return "UnexpectedChar"
+
+	case UNEXPECTED_TOKEN:
+		return "UnexpectedToken"
+
+	case UNEXPECTED_EOF:
+		return "UnexpectedEOF"
+
+	case INVALID_POSTFIX:
+		return "InvalidPostfix"
+
+	case INVALID_FOR:
+		return "InvalidFor"
+
+	case INVALID_SWITCH:
+		return "InvalidSwitch"
+
+	case INVALID_TRY:
+		return "InvalidTry"
+
+	case DUPLICATE_PARAM:
+		return "DuplicateParam"
+
+	default:
+		panic("unreachable")
+	}
+}
+
+// Error is a syntax error.  Begin and End are the positions of the first
+// and last characters of the offending token.  Expected contains the kinds
+// of token that would have been valid instead, if they are known.
+type Error struct {
+	Kind     ErrorKind
+	Token    *ast.Token
+	Begin    ast.Pos
+	End      ast.Pos
+	Expected []ast.TokenKind
 }
 
-func (e *parserError) Error() string {
+func newError(kind ErrorKind, token *ast.Token) *Error {
 
-	switch e.kind {
+	// find the position of the token's last character
+	end := token.Position
+	if n := utf8.RuneCountInString(token.Text); n > 0 {
+		lines := strings.Split(token.Text, "\n")
+		if len(lines) == 1 {
+			end.Col += n - 1
+		} else {
+			end.Line += len(lines) - 1
+			end.Col = utf8.RuneCountInString(lines[len(lines)-1])
+		}
+	}
+
+	return &Error{kind, token, token.Position, end, nil}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at %v", e.Message(), e.Token.Position)
+}
+
+// Message describes the error, without its position.
+func (e *Error) Message() string {
+
+	switch e.Kind {
 
 	case UNEXPECTED_CHAR:
-		return fmt.Sprintf("Unexpected Character '%v' at %v", e.token.Text, e.token.Position)
+		return fmt.Sprintf("Unexpected Character '%v'", e.Token.Text)
 
 	case UNEXPECTED_TOKEN:
-		return fmt.Sprintf("Unexpected Token '%v' at %v", e.token.Text, e.token.Position)
+		return fmt.Sprintf("Unexpected Token '%v'", e.Token.Text)
 
 	case UNEXPECTED_EOF:
-		return fmt.Sprintf("Unexpected EOF at %v", e.token.Position)
+		return "Unexpected EOF"
 
 	case INVALID_POSTFIX:
-		return fmt.Sprintf("Invalid Postfix Expression at %v", e.token.Position)
+		return "Invalid Postfix Expression"
 
 	case INVALID_FOR:
-		return fmt.Sprintf("Invalid For Expression at %v", e.token.Position)
+		return "Invalid For Expression"
 
 	case INVALID_SWITCH:
-		return fmt.Sprintf("Invalid Switch Expression at %v", e.token.Position)
+		return "Invalid Switch Expression"
 
 	case INVALID_TRY:
-		return fmt.Sprintf("Invalid TRY Expression at %v", e.token.Position)
+		return "Invalid TRY Expression"
+
+	case DUPLICATE_PARAM:
+		return fmt.Sprintf("Duplicate Param '%v'", e.Token.Text)
 
 	default:
 		panic("unreachable")
 	}
 }
+
+// ErrorList is the list of every syntax error in a module, in the
+// order in which they occur.
+type ErrorList []*Error
+
+func (list ErrorList) Error() string {
+	var buf bytes.Buffer
+	for i, e := range list {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		buf.WriteString(e.Error())
+	}
+	return buf.String()
+}
@@ -18,9 +18,16 @@ import (
 	//"fmt"
 	"golem/ast"
 	"golem/scanner"
+	"reflect"
 	"testing"
 )
 
+func assert(t *testing.T, flag bool) {
+	if !flag {
+		t.Error("assertion failure")
+	}
+}
+
 func ok(t *testing.T, p *Parser, expect string) {
 
 	mod, err := p.ParseModule()
@@ -33,12 +40,10 @@ func ok(t *testing.T, p *Parser, expect string) {
 
 func fail(t *testing.T, p *Parser, expect string) {
 
-	mod, err := p.ParseModule()
-	if mod != nil {
-		t.Error(mod, " != nil")
-	}
-
-	if err.Error() != expect {
+	_, err := p.ParseModule()
+	if err == nil {
+		t.Error("expected error: ", expect)
+	} else if err.Error() != expect {
 		t.Error(err, " != ", expect)
 	}
 }
@@ -371,6 +376,24 @@ func TestFn(t *testing.T) {
 
 	p = newParser("fn a(x) {return x*x; } fn b() { }")
 	ok(t, p, "fn() { fn a(x) { return (x * x); } fn b() {  } }")
+
+	p = newParser("fn(x, y = 2, z = x+y) { }")
+	ok_expr(t, p, "fn(x, y = 2, z = (x + y)) {  }")
+
+	p = newParser("fn(x, y = [], rest...) { }")
+	ok_expr(t, p, "fn(x, y = [  ], rest...) {  }")
+
+	p = newParser("fn f(rest...) { }")
+	ok(t, p, "fn() { fn f(rest...) {  } }")
+
+	p = newParser("fn(x = 1, y) { }")
+	fail(t, p, "Unexpected Token ')' at (1, 12)")
+
+	p = newParser("fn(x..., y) { }")
+	fail(t, p, "Unexpected Token ',' at (1, 8)")
+
+	p = newParser("fn(x = y...) { }")
+	fail(t, p, "Unexpected Token '...' at (1, 9)")
 }
 
 func TestTry(t *testing.T) {
@@ -406,6 +429,18 @@ func TestInvoke(t *testing.T) {
 
 	p = newParser("a(1, 2, 3)")
 	ok_expr(t, p, "a(1, 2, 3)")
+
+	p = newParser("a(1, b: 2, c: x ? y : z)")
+	ok_expr(t, p, "a(1, b: 2, c: (x ? y : z))")
+
+	p = newParser("a(b: 1)")
+	ok_expr(t, p, "a(b: 1)")
+
+	p = newParser("a(b: 1, 2)")
+	fail(t, p, "Unexpected Token '2' at (1, 9)")
+
+	p = newParser("a(b: 1, c: 2, b: 3)")
+	fail(t, p, "Duplicate Param 'b' at (1, 15)")
 }
 
 func TestStruct(t *testing.T) {
@@ -742,6 +777,9 @@ func TestSpawn(t *testing.T) {
 	p = newParser("spawn false(a,b,c);")
 	ok(t, p, "fn() { spawn false(a, b, c); }")
 
+	p = newParser("spawn foo(a, b: c);")
+	ok(t, p, "fn() { spawn foo(a, b: c); }")
+
 	p = newParser("spawn foo;")
 	fail(t, p, "Unexpected Token ';' at (1, 10)")
 }
@@ -766,3 +804,87 @@ func TestPub(t *testing.T) {
 	p = newParser("pub fn() {}")
 	fail(t, p, "Unexpected Token '(' at (1, 7)")
 }
+
+func TestImport(t *testing.T) {
+
+	p := newParser("import foo;")
+	ok(t, p, "fn() { import foo; }")
+
+	p = newParser("import foo as bar; import baz;")
+	ok(t, p, "fn() { import foo as bar; import baz; }")
+
+	p = newParser("fn a() { import foo; }")
+	fail(t, p, "Unexpected Token 'import' at (1, 10)")
+
+	p = newParser("import foo as;")
+	fail(t, p, "Unexpected Token ';' at (1, 14)")
+
+	// 'as' is an ordinary name everywhere else
+	p = newParser("import as as as; let as = as.as; fn f(as) { return as; }")
+	ok(t, p, "fn() { import as as as; let as = as.as; fn f(as) { return as; } }")
+
+	p = newParser("import foo bar;")
+	fail(t, p, "Unexpected Token 'bar' at (1, 12)")
+
+	p = newParser("import 'foo';")
+	fail(t, p, "Unexpected Token 'foo' at (1, 8)")
+}
+
+func TestRecovery(t *testing.T) {
+
+	// every error is reported, and the statements that
+	// could be parsed are kept
+	p := newParser(`
+let a = ;
+let b = 1;
+fn c() {
+    d(1,;
+    return 2;
+}
+e + ;
+}
+let f = 3`)
+	mod, err := p.ParseModule()
+	if err.Error() != "Unexpected Token ';' at (2, 9)\n"+
+		"Unexpected Token ';' at (5, 9)\n"+
+		"Unexpected Token ';' at (8, 5)\n"+
+		"Unexpected Token '}' at (9, 1)\n"+
+		"Unexpected EOF at (10, 10)" {
+		t.Error(err)
+	}
+	expect := "fn() { let b = 1; fn c() { return 2; } }"
+	if mod.String() != expect {
+		t.Error(mod, " != ", expect)
+	}
+
+	// errors carry a range and the kinds of token that were expected
+	errs := err.(ErrorList)
+	assert(t, errs[0].Kind == UNEXPECTED_TOKEN)
+	assert(t, errs[0].Begin == ast.Pos{2, 9} && errs[0].End == ast.Pos{2, 9})
+	assert(t, errs[0].Expected == nil)
+	assert(t, errs[4].Kind == UNEXPECTED_EOF)
+	assert(t, reflect.DeepEqual(errs[4].Expected,
+		[]ast.TokenKind{ast.COMMA, ast.SEMICOLON}))
+
+	p = newParser("let abc = 1 xyz;")
+	_, err = p.ParseModule()
+	errs = err.(ErrorList)
+	assert(t, len(errs) == 1)
+	assert(t, errs[0].Begin == ast.Pos{1, 13} && errs[0].End == ast.Pos{1, 15})
+
+	// blocks that were opened by a broken statement are skipped
+	p = newParser("switch { default: 1; } let a = 1;")
+	fail(t, p, "Unexpected Token 'default' at (1, 10)")
+
+	p = newParser("while x { if { let y = 2; } } let z = 3;")
+	mod, err = p.ParseModule()
+	assert(t, err.Error() == "Unexpected Token '{' at (1, 14)")
+	expect = "fn() { while x {  } let z = 3; }"
+	if mod.String() != expect {
+		t.Error(mod, " != ", expect)
+	}
+
+	// scanner errors stop the parse
+	p = newParser("let a = 1; let b = #; let c = 3;")
+	fail(t, p, "Unexpected Character '#' at (1, 20)")
+}
@@ -0,0 +1,280 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"compress/gzip"
+	g "golem/core"
+	"io"
+	"sort"
+	"strings"
+)
+
+//--------------------------------------------------------------
+// pprof
+//
+// A pprof profile is a gzipped protocol buffer, whose format is described
+// by https://github.com/google/pprof/blob/master/proto/profile.proto.
+// Every line of a function is a location, and every path through the
+// call tree is a sample, whose values are the opcodes that were executed
+// at the end of the path, and the time that they took.
+
+// the field numbers of the messages
+const (
+	profileSampleType    = 1
+	profileSample        = 2
+	profileLocation      = 4
+	profileFunction      = 5
+	profileStringTable   = 6
+	profileTimeNanos     = 9
+	profileDurationNanos = 10
+	profilePeriodType    = 11
+	profilePeriod        = 12
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+	functionStartLine  = 5
+)
+
+// WritePprof writes a profile that 'go tool pprof' can read.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pb := &pprofBuilder{&protobuf{}, map[string]int64{"": 0}, []string{""},
+		make(map[*g.Template]uint64), make(map[lineKey]uint64), &protobuf{}}
+
+	pb.valueType(profileSampleType, "opcodes", "count")
+	pb.valueType(profileSampleType, "time", "nanoseconds")
+	pb.samples(p.root)
+
+	b := pb.out
+	b.bytes(pb.locations.buf)
+	for _, s := range pb.strings {
+		b.str(profileStringTable, s)
+	}
+	if !p.start.IsZero() {
+		b.int64(profileTimeNanos, p.start.UnixNano())
+	}
+	b.int64(profileDurationNanos, int64(p.duration))
+	pb.valueType(profilePeriodType, "opcodes", "count")
+	b.int64(profilePeriod, 1)
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b.buf); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+type pprofBuilder struct {
+	out *protobuf
+
+	// the string table
+	strIndex map[string]int64
+	strings  []string
+
+	// the IDs of the functions and locations that have been written
+	funcs map[*g.Template]uint64
+	locs  map[lineKey]uint64
+
+	// the locations and functions, which are written after the samples
+	locations *protobuf
+}
+
+func (pb *pprofBuilder) str(s string) int64 {
+	if n, ok := pb.strIndex[s]; ok {
+		return n
+	}
+	n := int64(len(pb.strings))
+	pb.strIndex[s] = n
+	pb.strings = append(pb.strings, s)
+	return n
+}
+
+func (pb *pprofBuilder) valueType(field int, typ string, unit string) {
+	pb.out.message(field, func(b *protobuf) {
+		b.int64(valueTypeType, pb.str(typ))
+		b.int64(valueTypeUnit, pb.str(unit))
+	})
+}
+
+// Write a sample for every node that executed opcodes.
+func (pb *pprofBuilder) samples(nd *node) {
+
+	if nd.opcodes > 0 {
+		ids := []uint64{}
+		for n := nd; n.parent != nil; n = n.parent {
+			ids = append(ids, pb.location(n))
+		}
+		pb.out.message(profileSample, func(b *protobuf) {
+			b.packedUint64(sampleLocationID, ids)
+			b.packedInt64(sampleValue, []int64{nd.opcodes, int64(nd.time)})
+		})
+	}
+
+	for _, child := range sortedChildren(nd) {
+		pb.samples(child)
+	}
+}
+
+func (pb *pprofBuilder) location(nd *node) uint64 {
+
+	key := lineKey{nd.tpl, nd.line}
+	if id, ok := pb.locs[key]; ok {
+		return id
+	}
+	id := uint64(len(pb.locs) + 1)
+	pb.locs[key] = id
+
+	fn := pb.function(nd)
+	pb.locations.message(profileLocation, func(b *protobuf) {
+		b.uint64(locationID, id)
+		b.message(locationLine, func(b *protobuf) {
+			b.uint64(lineFunctionID, fn)
+			b.int64(lineLine, int64(nd.line))
+		})
+	})
+	return id
+}
+
+func (pb *pprofBuilder) function(nd *node) uint64 {
+
+	if id, ok := pb.funcs[nd.tpl]; ok {
+		return id
+	}
+	id := uint64(len(pb.funcs) + 1)
+	pb.funcs[nd.tpl] = id
+
+	pb.locations.message(profileFunction, func(b *protobuf) {
+		b.uint64(functionID, id)
+		b.int64(functionName, pb.str(pprofName(nd.tpl)))
+		b.int64(functionSystemName, pb.str(nd.tpl.Name))
+		b.int64(functionFilename, pb.str(nd.file))
+		b.int64(functionStartLine, int64(firstLine(nd.tpl)))
+	})
+	return id
+}
+
+// pprof removes anything in angle brackets from the names of functions,
+// as though they were C++ template arguments, so '<module>' and '<lambda>'
+// are written as 'module' and 'lambda' instead.
+func pprofName(tpl *g.Template) string {
+	return strings.TrimSuffix(strings.TrimPrefix(tpl.Name, "<"), ">")
+}
+
+// The children of a node, in a stable order.
+func sortedChildren(nd *node) []*node {
+	children := []*node{}
+	for _, child := range nd.children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(a, b int) bool {
+		if children[a].file != children[b].file {
+			return children[a].file < children[b].file
+		}
+		if children[a].line != children[b].line {
+			return children[a].line < children[b].line
+		}
+		return children[a].tpl.Name < children[b].tpl.Name
+	})
+	return children
+}
+
+//--------------------------------------------------------------
+// protocol buffers
+
+// protobuf encodes the fields of a message.
+type protobuf struct {
+	buf []byte
+}
+
+// wire types
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.buf = append(b.buf, byte(x)|0x80)
+		x >>= 7
+	}
+	b.buf = append(b.buf, byte(x))
+}
+
+func (b *protobuf) tag(field int, wire int) {
+	b.varint(uint64(field)<<3 | uint64(wire))
+}
+
+func (b *protobuf) uint64(field int, x uint64) {
+	b.tag(field, wireVarint)
+	b.varint(x)
+}
+
+func (b *protobuf) int64(field int, x int64) {
+	b.tag(field, wireVarint)
+	b.varint(uint64(x))
+}
+
+func (b *protobuf) str(field int, s string) {
+	b.tag(field, wireBytes)
+	b.varint(uint64(len(s)))
+	b.buf = append(b.buf, s...)
+}
+
+func (b *protobuf) packedUint64(field int, xs []uint64) {
+	b.message(field, func(b *protobuf) {
+		for _, x := range xs {
+			b.varint(x)
+		}
+	})
+}
+
+func (b *protobuf) packedInt64(field int, xs []int64) {
+	b.message(field, func(b *protobuf) {
+		for _, x := range xs {
+			b.varint(uint64(x))
+		}
+	})
+}
+
+// write a length-delimited field, whose contents are encoded by f
+func (b *protobuf) message(field int, f func(*protobuf)) {
+	inner := &protobuf{}
+	f(inner)
+	b.tag(field, wireBytes)
+	b.varint(uint64(len(inner.buf)))
+	b.buf = append(b.buf, inner.buf...)
+}
+
+// append fields that have already been encoded
+func (b *protobuf) bytes(buf []byte) {
+	b.buf = append(b.buf, buf...)
+}
@@ -0,0 +1,435 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package profile measures where Golem programs spend their time.
+package profile
+
+import (
+	"fmt"
+	g "golem/core"
+	"golem/interpreter"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+//--------------------------------------------------------------
+// Profiler
+
+// Profiler implements interpreter.Debugger, and counts the opcodes that
+// each function and each line executes, and how long they take.  The time
+// that an opcode takes is the wall-clock time until the next opcode starts,
+// so the time that is spent waiting, in a native function or on a channel,
+// is included.  The goroutines that are spawned are profiled too.
+type Profiler struct {
+	mu       sync.Mutex
+	start    time.Time
+	duration time.Duration
+	stopped  bool
+	main     *tracker
+	trackers []*tracker
+
+	funcs map[*g.Template]*FuncStats
+	lines map[fileLine]*LineStats
+
+	// the call tree, whose paths are the stacks that pprof shows
+	root *node
+
+	// the line of each opcode, by template
+	lineTables map[*g.Template][]int
+}
+
+// FuncStats are the costs of a function.  Inclusive time counts the time
+// spent in the functions that it calls, and exclusive time does not.
+type FuncStats struct {
+	Func      *g.Template
+	File      string
+	Calls     int64
+	Opcodes   int64
+	Inclusive time.Duration
+	Exclusive time.Duration
+}
+
+// LineStats are the costs of a line of source code.
+type LineStats struct {
+	File    string
+	Line    int
+	Opcodes int64
+	Time    time.Duration
+}
+
+type lineKey struct {
+	tpl  *g.Template
+	line int
+}
+
+type fileLine struct {
+	file string
+	line int
+}
+
+// A node of the call tree is a line of a function, reached via the
+// lines of the functions that called it.
+type node struct {
+	parent   *node
+	tpl      *g.Template
+	file     string
+	line     int
+	opcodes  int64
+	time     time.Duration
+	children map[lineKey]*node
+}
+
+// New creates a Profiler.  It starts profiling as soon as a program
+// runs with it.
+func New() *Profiler {
+	p := &Profiler{
+		sync.Mutex{}, time.Time{}, 0, false, nil, []*tracker{},
+		make(map[*g.Template]*FuncStats),
+		make(map[fileLine]*LineStats),
+		newNode(nil, nil, "", 0),
+		make(map[*g.Template][]int)}
+	p.main = p.newTracker()
+	return p
+}
+
+func newNode(parent *node, tpl *g.Template, file string, line int) *node {
+	return &node{parent, tpl, file, line, 0, 0, make(map[lineKey]*node)}
+}
+
+// Before implements interpreter.Debugger.
+func (p *Profiler) Before(intp *interpreter.Interpreter) g.Error {
+	return p.main.Before(intp)
+}
+
+// Spawn implements interpreter.Spawner.
+func (p *Profiler) Spawn() interpreter.Debugger {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.newTracker()
+}
+
+// Stop stops profiling.  The functions that have not returned yet are
+// treated as though they returned now.
+func (p *Profiler) Stop() {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return
+	}
+	for _, t := range p.trackers {
+		t.charge(now)
+		for len(t.frames) > 0 {
+			t.pop(now)
+		}
+	}
+	p.stopped = true
+	if !p.start.IsZero() {
+		p.duration = now.Sub(p.start)
+	}
+}
+
+// Functions returns the costs of the functions that ran, sorted by
+// exclusive time.
+func (p *Profiler) Functions() []*FuncStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	funcs := []*FuncStats{}
+	for _, fs := range p.funcs {
+		funcs = append(funcs, fs)
+	}
+	sort.Slice(funcs, func(a, b int) bool {
+		if funcs[a].Exclusive != funcs[b].Exclusive {
+			return funcs[a].Exclusive > funcs[b].Exclusive
+		}
+		return funcName(funcs[a].File, funcs[a].Func) < funcName(funcs[b].File, funcs[b].Func)
+	})
+	return funcs
+}
+
+// Lines returns the costs of the lines that ran, sorted by time.
+func (p *Profiler) Lines() []*LineStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lines := []*LineStats{}
+	for _, ls := range p.lines {
+		lines = append(lines, ls)
+	}
+	sort.Slice(lines, func(a, b int) bool {
+		if lines[a].Time != lines[b].Time {
+			return lines[a].Time > lines[b].Time
+		}
+		if lines[a].File != lines[b].File {
+			return lines[a].File < lines[b].File
+		}
+		return lines[a].Line < lines[b].Line
+	})
+	return lines
+}
+
+// WriteText writes a report of the costs of the functions and lines.
+func (p *Profiler) WriteText(w io.Writer) error {
+
+	funcs := p.Functions()
+	lines := p.Lines()
+
+	fmt.Fprintf(w, "Functions, by exclusive time:\n\n")
+	fmt.Fprintf(w, "%10s %12s %12s %12s  %s\n",
+		"calls", "opcodes", "inclusive", "exclusive", "function")
+	for _, fs := range funcs {
+		fmt.Fprintf(w, "%10d %12d %12s %12s  %s\n",
+			fs.Calls, fs.Opcodes, millis(fs.Inclusive), millis(fs.Exclusive),
+			funcName(fs.File, fs.Func))
+	}
+
+	fmt.Fprintf(w, "\nLines, by time:\n\n")
+	fmt.Fprintf(w, "%12s %12s  %s\n", "opcodes", "time", "line")
+	for _, ls := range lines {
+		_, err := fmt.Fprintf(w, "%12d %12s  %s:%d\n",
+			ls.Opcodes, millis(ls.Time), ls.File, ls.Line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func millis(d time.Duration) string {
+	return fmt.Sprintf("%.3fms", float64(d)/float64(time.Millisecond))
+}
+
+// The name of a function, and the line that its body starts at.
+func funcName(file string, tpl *g.Template) string {
+	return fmt.Sprintf("%s (%s:%d)", tpl.Name, file, firstLine(tpl))
+}
+
+// The first line of the body of a function.  The opcodes that the compiler adds to
+// the start and end of a function do not have a line.
+func firstLine(tpl *g.Template) int {
+	for _, e := range tpl.LineNumberTable {
+		if e.LineNum != 0 {
+			return e.LineNum
+		}
+	}
+	return 0
+}
+
+// The line of each opcode of a template.  The lock must be held.
+func (p *Profiler) lineTable(tpl *g.Template) []int {
+
+	if table, ok := p.lineTables[tpl]; ok {
+		return table
+	}
+
+	first := firstLine(tpl)
+	table := make([]int, len(tpl.OpCodes))
+	for ip := range table {
+		table[ip] = tpl.LineNumber(ip)
+		if table[ip] == 0 {
+			table[ip] = first
+		}
+	}
+	p.lineTables[tpl] = table
+	return table
+}
+
+func (p *Profiler) funcStats(file string, tpl *g.Template) *FuncStats {
+	fs, ok := p.funcs[tpl]
+	if !ok {
+		fs = &FuncStats{tpl, file, 0, 0, 0, 0}
+		p.funcs[tpl] = fs
+	}
+	return fs
+}
+
+func (p *Profiler) lineStats(file string, line int) *LineStats {
+	key := fileLine{file, line}
+	ls, ok := p.lines[key]
+	if !ok {
+		ls = &LineStats{file, line, 0, 0}
+		p.lines[key] = ls
+	}
+	return ls
+}
+
+//--------------------------------------------------------------
+// tracker
+
+// A tracker follows the frames of one goroutine.
+type tracker struct {
+	p *Profiler
+
+	// the nested interpreters that are running, outermost first
+	intps []*interpreter.Interpreter
+
+	// the frames that are running, outermost first, and the number
+	// of them that each function has
+	frames []*activation
+	active map[*FuncStats]int
+
+	// the opcode that started last, and when it started
+	last     time.Time
+	lastNode *node
+	lastFunc *FuncStats
+	lastLine *LineStats
+}
+
+// An activation is a call of a function that has not returned yet.
+type activation struct {
+	stats *FuncStats
+	start time.Time
+
+	// the node of the caller, and the node of the current line
+	parent *node
+	node   *node
+}
+
+// The lock must be held.
+func (p *Profiler) newTracker() *tracker {
+	t := &tracker{p, []*interpreter.Interpreter{}, []*activation{},
+		make(map[*FuncStats]int), time.Time{}, nil, nil, nil}
+	p.trackers = append(p.trackers, t)
+	return t
+}
+
+// Before implements interpreter.Debugger.
+func (t *tracker) Before(intp *interpreter.Interpreter) g.Error {
+	now := time.Now()
+	p := t.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return nil
+	}
+	if p.start.IsZero() {
+		p.start = now
+	}
+	t.charge(now)
+
+	// the frames that have returned are popped, and the frames
+	// that have been called are pushed
+	depth := t.track(intp)
+	top := intp.DebugFrame(0)
+	n := depth
+	if top.Func.Template().IsEntry(top.IP) {
+		// a function has just been called
+		n--
+	}
+	for len(t.frames) > n {
+		t.pop(now)
+	}
+	for len(t.frames) < depth {
+		t.push(t.frameAt(len(t.frames)).Func, now)
+	}
+
+	// count the opcode
+	a := t.frames[len(t.frames)-1]
+	tpl := a.stats.Func
+	line := p.lineTable(tpl)[top.IP]
+	if a.node == nil || a.node.line != line {
+		key := lineKey{tpl, line}
+		nd, ok := a.parent.children[key]
+		if !ok {
+			nd = newNode(a.parent, tpl, a.stats.File, line)
+			a.parent.children[key] = nd
+		}
+		a.node = nd
+	}
+
+	t.lastNode = a.node
+	t.lastFunc = a.stats
+	t.lastLine = p.lineStats(a.stats.File, line)
+	t.lastNode.opcodes++
+	t.lastFunc.Opcodes++
+	t.lastLine.Opcodes++
+	return nil
+}
+
+// Charge the time since the last opcode started to that opcode.
+func (t *tracker) charge(now time.Time) {
+	if t.lastNode != nil {
+		d := now.Sub(t.last)
+		t.lastNode.time += d
+		t.lastFunc.Exclusive += d
+		t.lastLine.Time += d
+		t.lastNode = nil
+	}
+	t.last = now
+}
+
+// Keep track of the interpreters that are running, and return the
+// number of frames on the stack.  Importing a module, or calling back
+// into Golem code via Runtime.Call, runs another interpreter on top of
+// the ones that are already running.
+func (t *tracker) track(intp *interpreter.Interpreter) int {
+
+	n := len(t.intps) - 1
+	for n >= 0 && t.intps[n] != intp {
+		n--
+	}
+	if n >= 0 {
+		t.intps = t.intps[:n+1]
+	} else {
+		t.intps = append(t.intps, intp)
+	}
+
+	depth := 0
+	for _, it := range t.intps {
+		depth += it.NumFrames()
+	}
+	return depth
+}
+
+// the frame at the given depth, where 0 is the outermost frame
+func (t *tracker) frameAt(depth int) interpreter.DebugFrame {
+	for _, intp := range t.intps {
+		if depth < intp.NumFrames() {
+			return intp.DebugFrame(intp.NumFrames() - 1 - depth)
+		}
+		depth -= intp.NumFrames()
+	}
+	panic("invalid depth")
+}
+
+func (t *tracker) push(fn g.BytecodeFunc, now time.Time) {
+
+	parent := t.p.root
+	if len(t.frames) > 0 && t.frames[len(t.frames)-1].node != nil {
+		parent = t.frames[len(t.frames)-1].node
+	}
+
+	fs := t.p.funcStats(fn.Module().Name, fn.Template())
+	fs.Calls++
+	t.active[fs]++
+	t.frames = append(t.frames, &activation{fs, now, parent, nil})
+}
+
+// The inclusive time of a recursive function is counted only once,
+// when its outermost call returns.
+func (t *tracker) pop(now time.Time) {
+
+	a := t.frames[len(t.frames)-1]
+	t.frames = t.frames[:len(t.frames)-1]
+
+	t.active[a.stats]--
+	if t.active[a.stats] == 0 {
+		a.stats.Inclusive += now.Sub(a.start)
+	}
+}
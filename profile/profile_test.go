@@ -0,0 +1,290 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"golem"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func assert(t *testing.T, flag bool) {
+	if !flag {
+		t.Error("assertion failure")
+	}
+}
+
+func ok(t *testing.T, result interface{}, expect interface{}) {
+	if !reflect.DeepEqual(result, expect) {
+		t.Error(result, " != ", expect)
+	}
+}
+
+const program = `fn fib(n) {
+    if n < 2 {
+        return n;
+    }
+    return fib(n - 1) + fib(n - 2);
+}
+fn send(c, n) {
+    c.send(fib(n));
+}
+pub fn main(args) {
+    let c = chan();
+    spawn send(c, 5);
+    return fib(6) + c.recv();
+}
+`
+
+func profileRun(t *testing.T) *Profiler {
+
+	p := New()
+	rt := golem.NewRuntime()
+	rt.Debugger = p
+
+	mod, err := rt.Compile("test.glm", program)
+	assert(t, err == nil)
+	assert(t, rt.RunMain(mod, nil) == nil)
+	p.Stop()
+	return p
+}
+
+func TestFunctions(t *testing.T) {
+
+	p := profileRun(t)
+
+	calls := map[string]int64{}
+	var opcodes int64
+	for _, fs := range p.Functions() {
+		calls[fs.Func.Name] = fs.Calls
+		opcodes += fs.Opcodes
+		assert(t, fs.File == "test.glm")
+		assert(t, fs.Inclusive >= fs.Exclusive)
+	}
+	ok(t, calls, map[string]int64{
+		"<module>": 1,
+		"main":     1,
+		"send":     1,
+		"fib":      40,
+	})
+
+	// every opcode is counted once by function, once by line,
+	// and once in the call tree
+	var lineOpcodes int64
+	for _, ls := range p.Lines() {
+		assert(t, ls.File == "test.glm")
+		assert(t, ls.Line >= 1 && ls.Line <= 14)
+		lineOpcodes += ls.Opcodes
+	}
+	ok(t, lineOpcodes, opcodes)
+	ok(t, treeOpcodes(p.root), opcodes)
+
+	// the opcodes are the same every time
+	ok(t, totalOpcodes(profileRun(t)), opcodes)
+}
+
+func totalOpcodes(p *Profiler) int64 {
+	var n int64
+	for _, fs := range p.Functions() {
+		n += fs.Opcodes
+	}
+	return n
+}
+
+func treeOpcodes(nd *node) int64 {
+	n := nd.opcodes
+	for _, child := range nd.children {
+		n += treeOpcodes(child)
+	}
+	return n
+}
+
+func TestCallTree(t *testing.T) {
+
+	p := profileRun(t)
+
+	// the deepest path through fib(6) is six calls of fib
+	var depth func(nd *node) int
+	depth = func(nd *node) int {
+		max := 0
+		for _, child := range nd.children {
+			if d := depth(child); d > max {
+				max = d
+			}
+		}
+		if nd.tpl != nil && nd.tpl.Name == "fib" && nd.line == 5 {
+			return max + 1
+		}
+		return max
+	}
+	ok(t, depth(p.root), 5)
+
+	// the spawned goroutine starts a new path from the root
+	found := false
+	for _, child := range p.root.children {
+		if child.tpl.Name == "send" {
+			found = true
+		}
+	}
+	assert(t, found)
+}
+
+func TestText(t *testing.T) {
+
+	p := profileRun(t)
+
+	var buf bytes.Buffer
+	assert(t, p.WriteText(&buf) == nil)
+	text := buf.String()
+
+	assert(t, strings.HasPrefix(text, "Functions, by exclusive time:\n"))
+	assert(t, strings.Contains(text, "\nLines, by time:\n"))
+	assert(t, strings.Contains(text, "fib (test.glm:2)"))
+	assert(t, strings.Contains(text, "main (test.glm:11)"))
+	assert(t, strings.Contains(text, "test.glm:5\n"))
+}
+
+func TestPprof(t *testing.T) {
+
+	p := profileRun(t)
+
+	var buf bytes.Buffer
+	assert(t, p.WritePprof(&buf) == nil)
+
+	zr, err := gzip.NewReader(&buf)
+	assert(t, err == nil)
+	data, err := ioutil.ReadAll(zr)
+	assert(t, err == nil)
+
+	// decode the fields that the samples refer to
+	strs := []string{}
+	funcs := map[uint64]uint64{}
+	locs := map[uint64]uint64{}
+	samples := [][]uint64{}
+	var opcodes uint64
+
+	for _, f := range decode(t, data) {
+		switch f.num {
+		case profileStringTable:
+			strs = append(strs, string(f.bytes))
+		case profileFunction:
+			fields := fieldMap(decode(t, f.bytes))
+			funcs[fields[functionID]] = fields[functionName]
+		case profileLocation:
+			fields := decode(t, f.bytes)
+			var id uint64
+			for _, lf := range fields {
+				if lf.num == locationID {
+					id = lf.varint
+				}
+				if lf.num == locationLine {
+					locs[id] = fieldMap(decode(t, lf.bytes))[lineFunctionID]
+				}
+			}
+		case profileSample:
+			fields := decode(t, f.bytes)
+			ok(t, len(fields), 2)
+			samples = append(samples, varints(t, fields[0].bytes))
+			values := varints(t, fields[1].bytes)
+			ok(t, len(values), 2)
+			opcodes += values[0]
+		}
+	}
+
+	ok(t, strs[0], "")
+	assert(t, len(samples) > 0)
+	ok(t, int64(opcodes), totalOpcodes(p))
+	for _, s := range samples {
+		for _, id := range s {
+			_, ok := locs[id]
+			assert(t, ok)
+		}
+	}
+	for _, fn := range locs {
+		_, ok := funcs[fn]
+		assert(t, ok)
+	}
+	names := []string{}
+	for _, name := range funcs {
+		names = append(names, strs[name])
+	}
+	sort.Strings(names)
+	ok(t, names, []string{"fib", "main", "module", "send"})
+}
+
+type field struct {
+	num    int
+	varint uint64
+	bytes  []byte
+}
+
+func fieldMap(fields []field) map[int]uint64 {
+	m := map[int]uint64{}
+	for _, f := range fields {
+		m[f.num] = f.varint
+	}
+	return m
+}
+
+func readVarint(t *testing.T, data []byte) (uint64, []byte) {
+	var x uint64
+	for shift := uint(0); len(data) > 0; shift += 7 {
+		b := data[0]
+		data = data[1:]
+		x |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return x, data
+		}
+	}
+	t.Fatal("truncated varint")
+	return 0, nil
+}
+
+func varints(t *testing.T, data []byte) []uint64 {
+	xs := []uint64{}
+	for len(data) > 0 {
+		var x uint64
+		x, data = readVarint(t, data)
+		xs = append(xs, x)
+	}
+	return xs
+}
+
+func decode(t *testing.T, data []byte) []field {
+	fields := []field{}
+	for len(data) > 0 {
+		var tag, x uint64
+		tag, data = readVarint(t, data)
+		x, data = readVarint(t, data)
+		f := field{int(tag >> 3), x, nil}
+		switch tag & 7 {
+		case wireVarint:
+		case wireBytes:
+			if uint64(len(data)) < x {
+				t.Fatal("truncated field")
+			}
+			f.bytes, data = data[:x], data[x:]
+		default:
+			t.Fatal("unexpected wire type")
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
@@ -0,0 +1,344 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package repl implements an interactive Read-Eval-Print Loop for Golem.
+package repl
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"golem"
+	"golem/ast"
+	g "golem/core"
+	"golem/parser"
+	"golem/scanner"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const help = `Enter statements or expressions to evaluate them.  The semicolon
+at the end of the last statement can be left out.  Input that is not
+complete is continued on the next line, and an empty line ends it.
+
+Commands:
+    :paste         enter several lines, ending with a line that is just ':end'
+    :load <file>   evaluate a source file
+    :dis [input]   show the bytecode of an input, or of the last input
+    :history       list the inputs that have been entered
+    :help          show this message
+    :quit          exit
+`
+
+const (
+	prompt     = "golem> "
+	contPrompt = "  ...> "
+)
+
+//--------------------------------------------------------------
+// REPL
+
+// REPL reads inputs, evaluates them in a golem.Session, and prints
+// their results.  The variables that an input defines are visible
+// to the inputs that follow it.
+type REPL struct {
+	rt      *golem.Runtime
+	session *golem.Session
+	in      *bufio.Reader
+	out     io.Writer
+	history []string
+	inputs  int
+
+	// Color enables ANSI colour escape codes in error messages.
+	Color bool
+
+	// HistoryFile is the file that inputs are saved to, so that they are
+	// kept from one run to the next.  If it is empty, the history is not saved.
+	HistoryFile string
+}
+
+// New creates a REPL that evaluates inputs with the given Runtime.
+func New(rt *golem.Runtime) *REPL {
+	return &REPL{rt, rt.NewSession(), nil, nil, []string{}, 0, false, ""}
+}
+
+// Run reads inputs from in, and writes prompts, results and errors to
+// out, until in is closed or ':quit' is entered.  An error is returned
+// only if the history file cannot be read or written.
+func (r *REPL) Run(in io.Reader, out io.Writer) error {
+
+	r.in = bufio.NewReader(in)
+	r.out = out
+	if err := r.loadHistory(); err != nil {
+		return err
+	}
+
+	for {
+		src, ok := r.read()
+		if !ok {
+			return nil
+		}
+		if strings.TrimSpace(src) == "" {
+			continue
+		}
+		if err := r.addHistory(src); err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(strings.TrimSpace(src), ":") {
+			if !r.command(strings.TrimSpace(src)) {
+				return nil
+			}
+		} else {
+			r.eval(r.nextName(), src)
+		}
+	}
+}
+
+// Read an input, which continues over as many lines as are needed
+// to complete it.  Commands are always a single line.
+func (r *REPL) read() (string, bool) {
+
+	fmt.Fprint(r.out, prompt)
+	line, ok := r.readLine()
+	if !ok {
+		fmt.Fprintln(r.out)
+		return "", false
+	}
+	if strings.HasPrefix(strings.TrimSpace(line), ":") {
+		return line, true
+	}
+
+	src := line
+	for strings.TrimSpace(src) != "" && !isComplete(src) {
+		fmt.Fprint(r.out, contPrompt)
+		line, ok := r.readLine()
+		if !ok || strings.TrimSpace(line) == "" {
+			break
+		}
+		src += "\n" + line
+	}
+	return src, true
+}
+
+func (r *REPL) readLine() (string, bool) {
+	line, err := r.in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", false
+	}
+	return strings.TrimRight(line, "\r\n"), true
+}
+
+func (r *REPL) nextName() string {
+	r.inputs++
+	return fmt.Sprintf("<input %d>", r.inputs)
+}
+
+// Evaluate an input, and print its result if it ends with an expression,
+// unless the result is null.
+func (r *REPL) eval(name string, src string) {
+
+	src = terminate(src)
+	val, err := r.session.Eval(name, src)
+	if err != nil {
+		r.printError(err)
+		return
+	}
+
+	if endsWithExpr(src) && val != g.NULL {
+		fmt.Fprintln(r.out, val.ToStr().String())
+	}
+}
+
+func (r *REPL) printError(err error) {
+	rnd := &golem.Renderer{r.Color, r.rt.Source}
+	for _, d := range golem.Diagnostics(err) {
+		rnd.Render(r.out, d)
+	}
+}
+
+//--------------------------------------------------------------
+// commands
+
+// Run a command, and return false if the REPL should exit.
+func (r *REPL) command(line string) bool {
+
+	name, arg := line, ""
+	if n := strings.IndexAny(line, " \t"); n != -1 {
+		name, arg = line[:n], strings.TrimSpace(line[n:])
+	}
+
+	switch name {
+
+	case ":paste":
+		fmt.Fprintln(r.out, "// paste mode: end with a line that is just ':end'")
+		lines := []string{}
+		for {
+			line, ok := r.readLine()
+			if !ok || strings.TrimSpace(line) == ":end" {
+				break
+			}
+			lines = append(lines, line)
+		}
+		src := strings.Join(lines, "\n")
+		if strings.TrimSpace(src) != "" {
+			r.eval(r.nextName(), src)
+		}
+
+	case ":load":
+		if arg == "" {
+			fmt.Fprintln(r.out, "usage: :load <file>")
+			break
+		}
+		buf, err := ioutil.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintln(r.out, err.Error())
+			break
+		}
+		r.eval(arg, string(buf))
+
+	case ":dis":
+		if arg == "" {
+			if mod := r.session.Module(); mod != nil {
+				fmt.Fprint(r.out, mod.String())
+			} else {
+				fmt.Fprintln(r.out, "nothing has been evaluated yet")
+			}
+			break
+		}
+		mod, err := r.session.Compile(r.nextName(), terminate(arg))
+		if err != nil {
+			r.printError(err)
+			break
+		}
+		fmt.Fprint(r.out, mod.String())
+
+	case ":history":
+		// the ':history' command itself is the last entry
+		for i, h := range r.history[:len(r.history)-1] {
+			fmt.Fprintf(r.out, "%4d  %s\n", i+1,
+				strings.Replace(h, "\n", "\n      ", -1))
+		}
+
+	case ":help":
+		fmt.Fprint(r.out, help)
+
+	case ":quit":
+		return false
+
+	default:
+		fmt.Fprintf(r.out, "unknown command '%s', enter :help for help\n", name)
+	}
+
+	return true
+}
+
+//--------------------------------------------------------------
+// history
+
+// Each line of the history file is an input, encoded as a JSON string.
+func (r *REPL) loadHistory() error {
+
+	if r.HistoryFile == "" {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(r.HistoryFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, line := range strings.Split(string(buf), "\n") {
+		var h string
+		if json.Unmarshal([]byte(line), &h) == nil {
+			r.history = append(r.history, h)
+		}
+	}
+	return nil
+}
+
+func (r *REPL) addHistory(src string) error {
+
+	r.history = append(r.history, src)
+	if r.HistoryFile == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(r.HistoryFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+//--------------------------------------------------------------
+// parsing
+
+func parse(src string) (*ast.FnExpr, error) {
+	return parser.NewParser(scanner.NewScanner(src)).ParseModule()
+}
+
+// An input is incomplete if it cannot be parsed, and every syntax error
+// is caused by reaching the end of the input too soon.  The semicolon at
+// the end of the last statement is optional, so it is never the only
+// thing missing.
+func isComplete(src string) bool {
+
+	if _, err := parse(terminate(src)); err == nil {
+		return true
+	}
+
+	_, err := parse(src)
+	for _, e := range err.(parser.ErrorList) {
+		if e.Kind != parser.UNEXPECTED_EOF {
+			return true
+		}
+	}
+	return false
+}
+
+// Add a semicolon to the end of an input that needs one.
+func terminate(src string) string {
+
+	if _, err := parse(src); err == nil {
+		return src
+	}
+	// the semicolon goes on a line of its own, in case the
+	// input ends with a comment
+	if _, err := parse(src + "\n;"); err == nil {
+		return src + "\n;"
+	}
+	return src
+}
+
+// whether the last statement of an input is an expression
+func endsWithExpr(src string) bool {
+
+	mod, err := parse(src)
+	if err != nil || len(mod.Body.Nodes) == 0 {
+		return false
+	}
+	_, ok := mod.Body.Nodes[len(mod.Body.Nodes)-1].(ast.Expr)
+	return ok
+}
@@ -0,0 +1,174 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repl
+
+import (
+	"bytes"
+	"golem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func assert(t *testing.T, flag bool) {
+	if !flag {
+		t.Error("assertion failure")
+	}
+}
+
+func run(t *testing.T, r *REPL, input string) string {
+	var out bytes.Buffer
+	err := r.Run(strings.NewReader(input), &out)
+	assert(t, err == nil)
+	return out.String()
+}
+
+func ok(t *testing.T, result string, expect string) {
+	if result != expect {
+		t.Error("\n"+result, " != \n", expect)
+	}
+}
+
+func TestEval(t *testing.T) {
+
+	result := run(t, New(golem.NewRuntime()), `let a = 1
+fn f(x) {
+return x + a;
+}
+f(2)
+a = 5; f(2);
+let c = 0;
+
+'abc'
+let b = [
+1,
+2 +
+
+b.len()
+`)
+
+	ok(t, result, `golem> golem>   ...>   ...> golem> 3
+golem> 7
+golem> golem> golem> abc
+golem>   ...>   ...>   ...> <input 7>:3:4: Unexpected EOF
+2 +
+   ^
+golem> <input 8>:1:1: Symbol 'b' is not defined
+b.len()
+^
+golem> 
+`)
+}
+
+func TestIncomplete(t *testing.T) {
+
+	assert(t, isComplete("1"))
+	assert(t, isComplete("let a = 1 // one"))
+	assert(t, isComplete("let a = ;"))
+	assert(t, isComplete("fn f() {}"))
+	assert(t, !isComplete("fn f() {"))
+	assert(t, !isComplete("1 +"))
+	assert(t, !isComplete("if a { b; } else"))
+	assert(t, !isComplete("let s = 'abc"))
+	assert(t, !isComplete("/* comment"))
+
+	assert(t, terminate("1") == "1\n;")
+	assert(t, terminate("1;") == "1;")
+	assert(t, terminate("1 +") == "1 +")
+}
+
+func TestCommands(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "golem")
+	assert(t, err == nil)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "util.glm")
+	err = ioutil.WriteFile(file, []byte("fn double(x) { return x * 2; }\n"), 0644)
+	assert(t, err == nil)
+
+	r := New(golem.NewRuntime())
+	r.HistoryFile = filepath.Join(dir, "history")
+
+	result := run(t, r, `:dis
+:paste
+let a = 1;
+
+a +
+  2
+:end
+:load `+file+`
+double(a)
+:load nope.glm
+:dis let b = 2
+:history
+:foo
+:quit
+a
+`)
+
+	ok(t, result, `golem> nothing has been evaluated yet
+golem> // paste mode: end with a line that is just ':end'
+3
+golem> golem> 2
+golem> open nope.glm: no such file or directory
+golem> ----------------------------
+BytecodeModule:
+    Name: <input 3>
+    Pool:
+        0: Int(2)
+    Refs:
+    StructDefs:
+    Template(0): Name: <module>, Arity: 0, NumCaptures: 0, NumLocals: 3
+        OpCodes:
+            0: LOAD_NULL
+            1: LOAD_CONST 0 0 (0)
+            4: STORE_LOCAL 0 2 (2)
+            7: RETURN
+        LineNumberTable:
+            {0 0 0}
+            {1 1 9}
+            {4 1 5}
+            {7 0 0}
+        ExceptionHandlers:
+golem>    1  :dis
+   2  :paste
+   3  :load `+file+`
+   4  double(a)
+   5  :load nope.glm
+   6  :dis let b = 2
+golem> unknown command ':foo', enter :help for help
+golem> `)
+
+	// the history is kept from one run to the next
+	r = New(golem.NewRuntime())
+	r.HistoryFile = filepath.Join(dir, "history")
+	result = run(t, r, "let x = [\n1]\n:history\n")
+	ok(t, result, `golem>   ...> golem>    1  :dis
+   2  :paste
+   3  :load `+file+`
+   4  double(a)
+   5  :load nope.glm
+   6  :dis let b = 2
+   7  :history
+   8  :foo
+   9  :quit
+  10  let x = [
+      1]
+golem> 
+`)
+}
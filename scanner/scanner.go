@@ -39,12 +39,13 @@ type (
 		pos       ast.Pos
 		isDone    bool
 		doneToken *ast.Token
+		comments  []*ast.Token
 	}
 )
 
 func NewScanner(source string) *Scanner {
 	reader := strings.NewReader(source)
-	s := &Scanner{source, reader, curRune{0, 1, -1}, ast.Pos{1, 0}, false, nil}
+	s := &Scanner{source, reader, curRune{0, 1, -1}, ast.Pos{1, 0}, false, nil, nil}
 	s.consume()
 	return s
 }
@@ -68,6 +69,7 @@ func (s *Scanner) Next() *ast.Token {
 			s.consume()
 
 		case r == '/':
+			_, begin := s.cur()
 			s.consume()
 			r, _ = s.cur()
 
@@ -81,6 +83,7 @@ func (s *Scanner) Next() *ast.Token {
 					s.consume()
 					r, _ = s.cur()
 				}
+				s.addComment(begin, pos)
 
 			// block comment
 			case '*':
@@ -97,6 +100,7 @@ func (s *Scanner) Next() *ast.Token {
 						switch r {
 						case '/':
 							s.consume()
+							s.addComment(begin, pos)
 							break loop
 						case eof:
 							return s.unexpectedChar(r, s.pos)
@@ -185,7 +189,16 @@ func (s *Scanner) Next() *ast.Token {
 			return &ast.Token{ast.COMMA, ",", pos}
 		case r == '.':
 			s.consume()
-			return &ast.Token{ast.DOT, ".", pos}
+			r, _ := s.cur()
+			if r == '.' {
+				s.consume()
+				if tok := s.expect(func(r rune) bool { return r == '.' }); tok != nil {
+					return tok
+				}
+				return &ast.Token{ast.ELLIPSIS, "...", pos}
+			} else {
+				return &ast.Token{ast.DOT, ".", pos}
+			}
 		case r == '?':
 			s.consume()
 			return &ast.Token{ast.HOOK, "?", pos}
@@ -327,6 +340,18 @@ func (s *Scanner) Next() *ast.Token {
 	}
 }
 
+// Comments returns the comments that have been skipped over so far,
+// in the order they appear in the source.  A comment's Text includes
+// its delimiters.
+func (s *Scanner) Comments() []*ast.Token {
+	return s.comments
+}
+
+func (s *Scanner) addComment(begin int, pos ast.Pos) {
+	s.comments = append(s.comments,
+		&ast.Token{ast.COMMENT, s.source[begin:s.cr.idx], pos})
+}
+
 func (s *Scanner) nextIdentOrKeyword() *ast.Token {
 
 	pos := s.pos
@@ -400,22 +425,6 @@ func (s *Scanner) nextIdentOrKeyword() *ast.Token {
 		return &ast.Token{ast.THIS, text, pos}
 	case "has":
 		return &ast.Token{ast.HAS, text, pos}
-	case "print":
-		return &ast.Token{ast.FN_PRINT, text, pos}
-	case "println":
-		return &ast.Token{ast.FN_PRINTLN, text, pos}
-	case "str":
-		return &ast.Token{ast.FN_STR, text, pos}
-	case "len":
-		return &ast.Token{ast.FN_LEN, text, pos}
-	case "range":
-		return &ast.Token{ast.FN_RANGE, text, pos}
-	case "assert":
-		return &ast.Token{ast.FN_ASSERT, text, pos}
-	case "merge":
-		return &ast.Token{ast.FN_MERGE, text, pos}
-	case "chan":
-		return &ast.Token{ast.FN_CHAN, text, pos}
 
 	default:
 		return &ast.Token{ast.IDENT, text, pos}
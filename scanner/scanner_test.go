@@ -125,6 +125,15 @@ func TestDelimiter(t *testing.T) {
 	ok(t, s, ast.DBL_GT_EQ, ">>=", 1, 25)
 	ok(t, s, ast.DBL_LT_EQ, "<<=", 1, 29)
 	ok(t, s, ast.EOF, "", 1, 33)
+
+	s = NewScanner("a... .")
+	ok(t, s, ast.IDENT, "a", 1, 1)
+	ok(t, s, ast.ELLIPSIS, "...", 1, 2)
+	ok(t, s, ast.DOT, ".", 1, 6)
+	ok(t, s, ast.EOF, "", 1, 7)
+
+	s = NewScanner("..")
+	ok(t, s, ast.UNEXPECTED_EOF, "", 1, 3)
 }
 
 func TestInt(t *testing.T) {
@@ -296,12 +305,13 @@ func TestIdentOrKeyword(t *testing.T) {
 	ok(t, s, ast.THROW, "throw", 1, 19)
 	ok(t, s, ast.EOF, "", 1, 24)
 
-	s = NewScanner("spawn pub module import")
+	s = NewScanner("spawn pub module import as")
 	ok(t, s, ast.SPAWN, "spawn", 1, 1)
 	ok(t, s, ast.PUB, "pub", 1, 7)
 	ok(t, s, ast.MODULE, "module", 1, 11)
 	ok(t, s, ast.IMPORT, "import", 1, 18)
-	ok(t, s, ast.EOF, "", 1, 24)
+	ok(t, s, ast.IDENT, "as", 1, 25)
+	ok(t, s, ast.EOF, "", 1, 27)
 
 	s = NewScanner("struct this has dict set")
 	ok(t, s, ast.STRUCT, "struct", 1, 1)
@@ -312,16 +322,16 @@ func TestIdentOrKeyword(t *testing.T) {
 	ok(t, s, ast.EOF, "", 1, 25)
 
 	s = NewScanner("print println str len range assert")
-	ok(t, s, ast.FN_PRINT, "print", 1, 1)
-	ok(t, s, ast.FN_PRINTLN, "println", 1, 7)
-	ok(t, s, ast.FN_STR, "str", 1, 15)
-	ok(t, s, ast.FN_LEN, "len", 1, 19)
-	ok(t, s, ast.FN_RANGE, "range", 1, 23)
-	ok(t, s, ast.FN_ASSERT, "assert", 1, 29)
+	ok(t, s, ast.IDENT, "print", 1, 1)
+	ok(t, s, ast.IDENT, "println", 1, 7)
+	ok(t, s, ast.IDENT, "str", 1, 15)
+	ok(t, s, ast.IDENT, "len", 1, 19)
+	ok(t, s, ast.IDENT, "range", 1, 23)
+	ok(t, s, ast.IDENT, "assert", 1, 29)
 	ok(t, s, ast.EOF, "", 1, 35)
 
 	s = NewScanner("chan")
-	ok(t, s, ast.FN_CHAN, "chan", 1, 1)
+	ok(t, s, ast.IDENT, "chan", 1, 1)
 	ok(t, s, ast.EOF, "", 1, 5)
 }
 
@@ -355,4 +365,15 @@ func TestComments(t *testing.T) {
 	ok(t, s, ast.INT, "1", 1, 1)
 	ok(t, s, ast.UNEXPECTED_EOF, "", 1, 7)
 
+	// the comments are kept
+	s = NewScanner("// a\n1 /* b\nc */ 2 //d")
+	ok(t, s, ast.INT, "1", 2, 1)
+	ok(t, s, ast.INT, "2", 3, 6)
+	ok(t, s, ast.EOF, "", 3, 11)
+	if !reflect.DeepEqual(s.Comments(), []*ast.Token{
+		{ast.COMMENT, "// a", ast.Pos{1, 1}},
+		{ast.COMMENT, "/* b\nc */", ast.Pos{2, 3}},
+		{ast.COMMENT, "//d", ast.Pos{3, 8}}}) {
+		t.Error(s.Comments())
+	}
 }
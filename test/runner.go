@@ -0,0 +1,268 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package test runs tests that are written in Golem.  A test is a
+// top-level function, in a file whose name ends in _test.glm, whose
+// name begins with 'test'.  A test fails if it throws an error.
+package test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"golem"
+	g "golem/core"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//--------------------------------------------------------------
+// Runner
+
+// Runner runs the tests in test files.
+type Runner struct {
+
+	// NewRuntime creates a Runtime for a test file.  Each test is run
+	// with a Runtime of its own, so that the state of the modules that
+	// one test changes is not seen by the others.
+	NewRuntime func(file string) *golem.Runtime
+
+	// Match, if it is not nil, selects the tests that are run by name.
+	Match *regexp.Regexp
+
+	// Timeout, if it is not zero, limits how long each test may run,
+	// including the initialization of its module.
+	Timeout time.Duration
+}
+
+// Result is the outcome of a test.  Err is nil if the test passed.
+type Result struct {
+	File     string
+	Name     string
+	Err      error
+	Duration time.Duration
+}
+
+// Passed returns whether the test passed.
+func (res *Result) Passed() bool {
+	return res.Err == nil
+}
+
+// TimeoutError is the error of a test that ran for too long.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("test timed out after %s", e.Timeout)
+}
+
+// NewRunner creates a Runner that runs every test.
+func NewRunner(newRuntime func(file string) *golem.Runtime) *Runner {
+	return &Runner{newRuntime, nil, 0}
+}
+
+// RunFile runs the tests in a file, in the order that they are defined,
+// and reports the result of each of them.  An error is returned if the
+// file cannot be compiled, or its module cannot be initialized.
+func (r *Runner) RunFile(file string, report func(*Result)) error {
+
+	names, err := r.Tests(file)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		report(r.runTest(file, name))
+	}
+	return nil
+}
+
+// Tests returns the names of the tests in a file that Match selects.
+func (r *Runner) Tests(file string) ([]string, error) {
+
+	rt := r.NewRuntime(file)
+	mod, err := load(rt, file)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := r.context()
+	defer cancel()
+	if _, err := r.await(ctx, func() (g.Value, error) { return rt.RunContext(ctx, mod) }); err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for j, name := range moduleNames(mod) {
+		if _, ok := mod.Refs[j].Val.(g.BytecodeFunc); ok &&
+			strings.HasPrefix(name, "test") &&
+			(r.Match == nil || r.Match.MatchString(name)) {
+
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// The names of the top-level variables of a module, by index.
+func moduleNames(mod *g.BytecodeModule) []string {
+	names := mod.Templates[0].LocalNames
+	if len(names) > len(mod.Refs) {
+		names = names[:len(mod.Refs)]
+	}
+	return names
+}
+
+// Run a test in a Runtime of its own.
+func (r *Runner) runTest(file string, name string) *Result {
+
+	start := time.Now()
+	res := &Result{file, name, nil, 0}
+
+	ctx, cancel := r.context()
+	defer cancel()
+	_, res.Err = r.await(ctx, func() (g.Value, error) {
+
+		rt := r.NewRuntime(file)
+		mod, err := load(rt, file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := rt.RunContext(ctx, mod); err != nil {
+			return nil, err
+		}
+
+		for j, n := range moduleNames(mod) {
+			if n == name {
+				fn := mod.Refs[j].Val.(g.BytecodeFunc)
+				if fn.Template().MinArity != 0 {
+					return nil, fmt.Errorf("test '%s' must not have any parameters", name)
+				}
+				return rt.CallContext(ctx, fn)
+			}
+		}
+		return nil, fmt.Errorf("test '%s' not found", name)
+	})
+
+	res.Duration = time.Since(start)
+	return res
+}
+
+func (r *Runner) context() (context.Context, context.CancelFunc) {
+	if r.Timeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), r.Timeout)
+}
+
+// Wait for a function to finish, or for the context to be done.  A test
+// that is blocked, for instance on a channel that is never sent to, does
+// not notice that it has been cancelled, so it is abandoned instead.
+func (r *Runner) await(ctx context.Context, fn func() (g.Value, error)) (g.Value, error) {
+
+	type result struct {
+		val g.Value
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		val, err := fn()
+		done <- result{val, err}
+	}()
+
+	select {
+	case res := <-done:
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, &TimeoutError{r.Timeout}
+		}
+		return res.val, res.err
+	case <-ctx.Done():
+		return nil, &TimeoutError{r.Timeout}
+	}
+}
+
+// Compile a source file, or load a module that has already been compiled.
+func load(rt *golem.Runtime, file string) (*g.BytecodeModule, error) {
+
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(buf, []byte(g.GlmcMagic)) {
+		return rt.Load(bytes.NewReader(buf))
+	}
+	return rt.Compile(file, string(buf))
+}
+
+//--------------------------------------------------------------
+// files
+
+// FindFiles returns the test files that the given paths name.  A path can
+// be a file, a directory, whose test files are returned, or a directory
+// followed by '/...', whose test files and those of all of its
+// subdirectories are returned.
+func FindFiles(paths []string) ([]string, error) {
+
+	files := []string{}
+	for _, path := range paths {
+
+		if strings.HasSuffix(path, "...") {
+			dir := filepath.Clean(strings.TrimSuffix(path, "..."))
+			err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.IsDir() && isTestFile(p) {
+					files = append(files, p)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		infos, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range infos {
+			if !fi.IsDir() && isTestFile(fi.Name()) {
+				files = append(files, filepath.Join(path, fi.Name()))
+			}
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+func isTestFile(name string) bool {
+	return strings.HasSuffix(name, "_test.glm")
+}
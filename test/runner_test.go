@@ -0,0 +1,206 @@
+// Copyright 2017 The Golem Project Developers
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"golem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func assert(t *testing.T, flag bool) {
+	if !flag {
+		t.Error("assertion failure")
+	}
+}
+
+func ok(t *testing.T, result interface{}, expect interface{}) {
+	if !reflect.DeepEqual(result, expect) {
+		t.Error(result, " != ", expect)
+	}
+}
+
+// Write files to a temporary directory.
+func tempFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "golem")
+	assert(t, err == nil)
+	for name, src := range files {
+		file := filepath.Join(dir, name)
+		assert(t, os.MkdirAll(filepath.Dir(file), 0755) == nil)
+		assert(t, ioutil.WriteFile(file, []byte(src), 0644) == nil)
+	}
+	return dir
+}
+
+func newRunner(dir string) *Runner {
+	return NewRunner(func(file string) *golem.Runtime {
+		rt := golem.NewRuntime()
+		rt.Path = []string{dir}
+		return rt
+	})
+}
+
+// Run the tests in a file, and describe their results.
+func runFile(t *testing.T, r *Runner, file string) []string {
+	results := []string{}
+	err := r.RunFile(file, func(res *Result) {
+		if res.Passed() {
+			results = append(results, "pass "+res.Name)
+		} else {
+			results = append(results, fmt.Sprintf("fail %s: %s", res.Name, res.Err.Error()))
+		}
+		ok(t, res.File, file)
+	})
+	assert(t, err == nil)
+	return results
+}
+
+const tests = `import util;
+let count = 0;
+fn helper() {
+    count += 1;
+    return count;
+}
+fn testFirst() {
+    assert(helper() == 1);
+}
+fn testSecond() {
+    assert(helper() == 1);
+    assert(util.bump() == 1);
+}
+pub fn testFails() {
+    assert(helper() == 2);
+}
+fn testThrows() {
+    throw struct { msg: 'oops' };
+}
+fn testParams(x) {
+}
+let testNotAFunc = 1;
+`
+
+func TestRunFile(t *testing.T) {
+
+	dir := tempFiles(t, map[string]string{
+		"a_test.glm": tests,
+		"util.glm":   "let n = 0;\npub fn bump() {\n    n += 1;\n    return n;\n}\n",
+	})
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "a_test.glm")
+
+	// each test has a module of its own
+	r := newRunner(dir)
+	ok(t, runFile(t, r, file), []string{
+		"pass testFirst",
+		"pass testSecond",
+		"fail testFails: AssertionFailed",
+		"fail testThrows: struct { msg: oops }",
+		"fail testParams: test 'testParams' must not have any parameters",
+	})
+
+	// filtering by name
+	r.Match = regexp.MustCompile("^test(First|Throws)$")
+	ok(t, runFile(t, r, file), []string{
+		"pass testFirst",
+		"fail testThrows: struct { msg: oops }",
+	})
+
+	// the failing line is in the stack trace
+	r.Match = regexp.MustCompile("Fails")
+	r.RunFile(file, func(res *Result) {
+		rte, isRte := res.Err.(*golem.RuntimeError)
+		assert(t, isRte)
+		ok(t, rte.Frames[0].Line, 15)
+		ok(t, len(rte.Frames), 1)
+	})
+}
+
+func TestTimeout(t *testing.T) {
+
+	dir := tempFiles(t, map[string]string{
+		"a_test.glm": `fn testLoop() {
+    while true {}
+}
+fn testBlocked() {
+    chan().recv();
+}
+fn testQuick() {
+}
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	r := newRunner(dir)
+	r.Timeout = 100 * time.Millisecond
+	ok(t, runFile(t, r, filepath.Join(dir, "a_test.glm")), []string{
+		"fail testLoop: test timed out after 100ms",
+		"fail testBlocked: test timed out after 100ms",
+		"pass testQuick",
+	})
+}
+
+func TestBadFiles(t *testing.T) {
+
+	dir := tempFiles(t, map[string]string{
+		"syntax_test.glm": "fn testA() {\n",
+		"init_test.glm":   "fn testA() {}\nthrow struct { msg: 'init' };\n",
+	})
+	defer os.RemoveAll(dir)
+
+	r := newRunner(dir)
+	err := r.RunFile(filepath.Join(dir, "syntax_test.glm"), func(*Result) { t.Error("ran a test") })
+	_, isSyntax := err.(*golem.SyntaxError)
+	assert(t, isSyntax)
+
+	err = r.RunFile(filepath.Join(dir, "init_test.glm"), func(*Result) { t.Error("ran a test") })
+	ok(t, err.Error(), "struct { msg: init }")
+}
+
+func TestFindFiles(t *testing.T) {
+
+	dir := tempFiles(t, map[string]string{
+		"a_test.glm":       "",
+		"b.glm":            "",
+		"sub/c_test.glm":   "",
+		"sub/d_test.glmc":  "",
+		"sub/x/e_test.glm": "",
+	})
+	defer os.RemoveAll(dir)
+
+	files, err := FindFiles([]string{dir})
+	assert(t, err == nil)
+	ok(t, files, []string{filepath.Join(dir, "a_test.glm")})
+
+	files, err = FindFiles([]string{filepath.Join(dir, "...")})
+	assert(t, err == nil)
+	ok(t, files, []string{
+		filepath.Join(dir, "a_test.glm"),
+		filepath.Join(dir, "sub", "c_test.glm"),
+		filepath.Join(dir, "sub", "x", "e_test.glm"),
+	})
+
+	files, err = FindFiles([]string{filepath.Join(dir, "b.glm")})
+	assert(t, err == nil)
+	ok(t, files, []string{filepath.Join(dir, "b.glm")})
+
+	_, err = FindFiles([]string{filepath.Join(dir, "missing")})
+	assert(t, err != nil)
+}